@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/build"
+	"github.com/mberwanger/dockerfiles/tool/internal/metrics"
+	"github.com/mberwanger/dockerfiles/tool/internal/workflow"
+)
+
+type buildCmd struct {
+	Cmd *cobra.Command
+}
+
+func newBuildCmd() *buildCmd {
+	root := &buildCmd{}
+	var buildAll, push, dryRun bool
+	var versionName, registry, metricsListen string
+	var concurrency int
+	cmd := &cobra.Command{
+		Use:   "build [image]",
+		Short: "Build images locally with docker buildx in dependency order",
+		Long:  "Build the generated Dockerfiles of an image, or of all images with --all, together with every image they build on, with docker buildx. Each image's workflow prepare commands run first in its version directory. Images are tagged <registry>/<image>:<version> so FROM ${REGISTRY}/... lines resolve to the images just built",
+		Example: `  # Build golang 1.25 and the core version it is built on
+  dockerfiles build golang --version 1.25
+
+  # Print the prepare and docker commands for everything without running them
+  dockerfiles build --all --dry-run
+
+  # Build all images four at a time and push them
+  dockerfiles build --all -j 4 --push
+
+  # Expose build counts and durations to Prometheus while building
+  dockerfiles build --all --metrics-listen :9090`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if buildAll && len(args) > 0 {
+				return fmt.Errorf("cannot specify image name with --all flag")
+			}
+			if buildAll && versionName != "" {
+				return fmt.Errorf("--version needs an image name, not --all")
+			}
+			return cobra.MaximumNArgs(1)(cmd, args)
+		},
+		ValidArgsFunction: completeImage,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !buildAll && len(args) == 0 {
+				return cmd.Help()
+			}
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if registry == "" {
+				registry = cfg.Defaults.Registry
+			}
+			if registry == "" {
+				return fmt.Errorf("no registry to tag images with: set defaults.registry or pass --registry")
+			}
+
+			jobs, err := workflow.JobsContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			if !buildAll {
+				imageName := args[0]
+				if err := checkImageName(cfg, imageName); err != nil {
+					return err
+				}
+				var targets []string
+				for _, output := range cfg.Images[imageName].OutputVersions() {
+					if versionName == "" || output.Name == versionName || output.Version == versionName {
+						targets = append(targets, imageName+":"+output.Name)
+					}
+				}
+				if len(targets) == 0 {
+					return fmt.Errorf("version %s not found for image %s", versionName, imageName)
+				}
+				if jobs, err = build.Select(jobs, targets); err != nil {
+					return err
+				}
+			}
+
+			opts := build.Options{
+				Registry:    registry,
+				Push:        push,
+				Concurrency: concurrency,
+				DryRun:      dryRun,
+				Out:         cmd.OutOrStdout(),
+				Exec:        build.ExecExecutor,
+				Reporter:    &build.Reporter{},
+			}
+			if metricsListen != "" {
+				opts.Reporter.Metrics = metrics.New()
+				stop, err := metrics.Serve(metricsListen, opts.Reporter.Metrics)
+				if err != nil {
+					return err
+				}
+				defer stop()
+				log.Infof("serving metrics on %s/metrics", metricsListen)
+			}
+			if err := build.Build(cmd.Context(), cfg, jobs, opts); err != nil {
+				return err
+			}
+			if !dryRun {
+				log.Infof("built %d images", len(jobs))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&buildAll, "all", "A", false, "Build all images")
+	cmd.Flags().StringVar(&versionName, "version", "", "Build one version of the image, with its variants, and what it builds on")
+	_ = cmd.RegisterFlagCompletionFunc("version", completeVersionFlag)
+	cmd.Flags().StringVar(&registry, "registry", "", "Registry to tag images with and pass as REGISTRY (default: defaults.registry)")
+	cmd.Flags().IntVarP(&concurrency, "concurrency", "j", runtime.NumCPU(), "Number of independent images to build at once")
+	cmd.Flags().BoolVar(&push, "push", false, "Push the images for all their platforms instead of loading them locally")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the prepare and docker commands without running them")
+	cmd.Flags().StringVar(&metricsListen, "metrics-listen", "", "Serve Prometheus metrics at /metrics on this address, e.g. :9090, while building")
+
+	root.Cmd = cmd
+	return root
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/bump"
+	"github.com/mberwanger/dockerfiles/tool/internal/ui"
+)
+
+type bumpCmd struct {
+	Cmd *cobra.Command
+}
+
+func newBumpCmd() *bumpCmd {
+	root := &bumpCmd{}
+	var filters []string
+	var format string
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "bump",
+		Short: "Update base images to their newest patch-level tags",
+		Long:  "Query the registries of Docker Hub base images, and of external ones on hosts listed under defaults.bump.registries, for newer patch-level tags of the same pattern, e.g. golang:1.22.3 to golang:1.22.5, and rewrite them in the manifest",
+		Example: `  # Report outdated base images without changing anything
+  dockerfiles bump --dry-run
+
+  # Bump the base images of core only
+  dockerfiles bump --filter image=core`,
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cobra.NoFileCompletions,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// Disable logging when writing JSON to stdout
+			if format == "json" {
+				log.SetLevel(log.FatalLevel)
+			}
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unsupported format %q (supported: text, json)", format)
+			}
+
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			var images []string
+			for _, filter := range filters {
+				key, value, _ := strings.Cut(filter, "=")
+				if key != "image" || value == "" {
+					return fmt.Errorf("unsupported filter %q (supported: image=<name>)", filter)
+				}
+				if err := checkImageName(cfg, value); err != nil {
+					return err
+				}
+				images = append(images, value)
+			}
+
+			registry := bump.NewRegistry(&http.Client{Timeout: 30 * time.Second})
+			updates, err := bump.Check(cmd.Context(), cfg, registry, images)
+			if err != nil {
+				return err
+			}
+			if !dryRun {
+				if err := bump.Apply(updates); err != nil {
+					return err
+				}
+			}
+
+			out := cmd.OutOrStdout()
+			if format == "json" {
+				if updates == nil {
+					updates = []bump.Update{}
+				}
+				return writeJSON(out, updates)
+			}
+			if len(updates) == 0 {
+				log.Info("all base images are up to date")
+				return nil
+			}
+
+			table := ui.Table{Headers: []string{"IMAGE", "VERSION", "CURRENT", "LATEST", "LOCATION"}}
+			for _, update := range updates {
+				version := update.Version
+				if version == "" {
+					version = "(defaults)"
+				}
+				location := fmt.Sprintf("%s:%d", displayPath(update.File), update.Line)
+				table.Rows = append(table.Rows, []string{update.Image, version, update.Current, update.Latest, location})
+			}
+			_, _ = fmt.Fprint(out, ui.NewRenderer(out).Table(table))
+
+			if dryRun {
+				log.Info("dry run: the manifest was not changed")
+			} else {
+				log.Infof("bumped %d base images; run generate to render them", len(updates))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringArrayVar(&filters, "filter", nil, "Limit the images checked, e.g. image=core (repeatable)")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format (text, json)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report newer tags without rewriting the manifest")
+
+	root.Cmd = cmd
+	return root
+}
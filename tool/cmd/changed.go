@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/workflow"
+)
+
+type changedCmd struct {
+	Cmd *cobra.Command
+}
+
+func newChangedCmd() *changedCmd {
+	root := &changedCmd{}
+	var since, format string
+	cmd := &cobra.Command{
+		Use:   "changed",
+		Short: "List the image versions affected by changes since a git ref",
+		Long:  "Map the files changed since the merge base of a git ref, including uncommitted changes, to the image versions whose inputs they are, and add every version built on those, in build order",
+		Example: `  # What a pull request needs to rebuild
+  dockerfiles changed --since origin/main
+
+  # The same, for tooling
+  dockerfiles changed --since origin/main --format json`,
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cobra.NoFileCompletions,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// Disable logging when writing JSON to stdout
+			if format == "json" {
+				log.SetLevel(log.FatalLevel)
+			}
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unsupported format %q (supported: text, json)", format)
+			}
+
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			paths, err := changedPaths(cmd.Context(), cfg.Defaults.BasePath, since)
+			if err != nil {
+				return err
+			}
+
+			jobs, err := workflow.JobsContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			changes := workflow.ChangedVersions(cfg, jobs, paths)
+
+			out := cmd.OutOrStdout()
+			if format == "json" {
+				if changes == nil {
+					changes = []workflow.Change{}
+				}
+				return writeJSON(out, changes)
+			}
+			for _, change := range changes {
+				_, _ = fmt.Fprintf(out, "%s:%s\n", change.Image, change.Version)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&since, "since", "origin/main", "Git ref to compare against; changes are taken from its merge base with HEAD")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format (text, json)")
+
+	root.Cmd = cmd
+	return root
+}
+
+// changedPaths lists the files, relative to the repository root, that
+// differ between the merge base of since and HEAD and the working tree of
+// the repository holding dir. Renames list both paths.
+func changedPaths(ctx context.Context, dir, since string) ([]string, error) {
+	base, err := git(ctx, dir, "merge-base", since, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	out, err := git(ctx, dir, "diff", "--name-only", "--no-renames", "-z", strings.TrimSpace(base))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, path := range strings.Split(out, "\x00") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// git runs a git command in dir and returns its standard output.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	command := exec.CommandContext(ctx, "git", args...)
+	command.Dir = dir
+	out, err := command.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return string(out), nil
+}
@@ -2,14 +2,21 @@ package cmd
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/spf13/cobra"
-	
+
 	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+	"github.com/mberwanger/dockerfiles/tool/internal/template"
+	"github.com/mberwanger/dockerfiles/tool/internal/ui"
+	"github.com/mberwanger/dockerfiles/tool/internal/workflow"
 )
 
 type cleanCmd struct {
@@ -18,65 +25,329 @@ type cleanCmd struct {
 
 func newCleanCmd() *cleanCmd {
 	root := &cleanCmd{}
+	var orphansOnly, artifactsOnly, all, dryRun bool
+	var workflowOutput string
 	cmd := &cobra.Command{
-		Use:   "clean",
+		Use:   "clean [image...]",
 		Short: "Remove generated Dockerfiles and directories",
-		Long:  "Remove all generated Dockerfiles and version directories, leaving only source directories intact",
+		Long:  "Remove all generated Dockerfiles and version directories, or those of the named images, leaving only source directories intact. With --artifacts or --all, also remove generated files outside the image directories, such as the workflow; files without the generated header are never removed, and read-only version directories are kept with a warning",
+		Example: `  # Remove every generated version directory
+  dockerfiles clean
+
+  # Show what cleaning core and redis would remove, and its size
+  dockerfiles clean core redis --dry-run
+
+  # Remove the generated workflow as well, showing what would go first
+  dockerfiles clean --all --dry-run
+  dockerfiles clean --all`,
+		ValidArgsFunction: completeImages,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			start := time.Now()
-			cfg, err := config.Load(configFile)
+			if len(args) > 0 && (artifactsOnly || all) {
+				return fmt.Errorf("--artifacts and --all clean every project, so they cannot be combined with image names")
+			}
+			cfgs, err := loadConfigs(cmd.Context())
 			if err != nil {
 				return err
 			}
+			if err := checkImageNames(cfgs, args); err != nil {
+				return err
+			}
 
-			totalRemoved := 0
-			for imageName, image := range cfg.Images {
-				log.Debugf("cleaning image: %s", imageName)
-
-				var imagePath string
-				if filepath.IsAbs(image.Path) {
-					imagePath = image.Path
-				} else {
-					basePath := cfg.Defaults.BasePath
-					if basePath == "" {
-						return fmt.Errorf("base path not set in config")
-					}
-					imagePath = filepath.Join(basePath, image.Path)
-				}
-
-				removedCount := 0
-				for versionName := range image.Versions {
-					versionDir := filepath.Join(imagePath, versionName)
-
-					if _, err := os.Stat(versionDir); os.IsNotExist(err) {
-						// Directory doesn't exist, skip
-						continue
-					}
-
-					if err := os.RemoveAll(versionDir); err != nil {
-						log.Warnf("failed to remove %s: %v", versionDir, err)
-					} else {
-						log.Debugf("Removed: %s", versionDir)
-						removedCount++
+			summary := cleanSummary{}
+			var size int64
+			if !artifactsOnly {
+				for _, cfg := range cfgs {
+					removed, removedSize, err := cleanProject(cfg, args, orphansOnly, dryRun)
+					if err != nil {
+						return err
 					}
+					summary.add("version directories", removed)
+					size += removedSize
 				}
+			}
+			if artifactsOnly || all {
+				size += cleanArtifacts(generatedArtifacts(cfgs, workflowOutput), dryRun, summary)
+			}
 
-				if removedCount > 0 {
-					log.Infof("cleaned %s (%d versions)", imageName, removedCount)
-				}
-				totalRemoved += removedCount
+			if summary.total() == 0 {
+				log.Info("no generated files found to clean")
+				return nil
 			}
 
-			if totalRemoved == 0 {
-				log.Info("no generated directories found to clean")
+			out := cmd.OutOrStdout()
+			_, _ = fmt.Fprint(out, ui.NewRenderer(out).Table(summary.table()))
+			if dryRun {
+				log.Infof("dry run: would remove %d paths totalling %s; nothing was changed", summary.removed(), formatBytes(size))
 			} else {
-				log.Infof("cleaned %d directories successfully after %s", totalRemoved, time.Since(start).Truncate(time.Millisecond))
+				logSummary(log.Fields{"action": "clean", "paths": summary.removed(), "bytes": size, "duration_ms": time.Since(start).Milliseconds()},
+					"cleaned %d paths successfully, freeing %s, after %s", summary.removed(), formatBytes(size), time.Since(start).Truncate(time.Millisecond))
 			}
 
 			return nil
 		},
 	}
 
+	cmd.Flags().BoolVar(&orphansOnly, "orphans", false, "Only remove version directories no longer in the manifest")
+	cmd.Flags().BoolVar(&artifactsOnly, "artifacts", false, "Only remove generated files outside the image directories, such as the workflow")
+	cmd.Flags().BoolVar(&all, "all", false, "Remove version directories and generated artifacts")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print what would be removed without removing anything")
+	cmd.Flags().StringVar(&workflowOutput, "workflow-output", workflow.DefaultOutputPath, "Path the workflow is generated to, as passed to generate workflow --output")
+	cmd.MarkFlagsMutuallyExclusive("orphans", "artifacts", "all")
+	_ = cmd.MarkFlagFilename("workflow-output", "yaml", "yml")
+
 	root.Cmd = cmd
 	return root
 }
+
+// skippedArtifacts is the summary group of artifacts kept because they lack
+// the generated header.
+const skippedArtifacts = "skipped, not generated"
+
+// cleanSummary counts the removed paths by artifact type.
+type cleanSummary map[string]int
+
+func (s cleanSummary) add(kind string, n int) {
+	if n > 0 {
+		s[kind] += n
+	}
+}
+
+func (s cleanSummary) total() int {
+	total := 0
+	for _, n := range s {
+		total += n
+	}
+	return total
+}
+
+func (s cleanSummary) removed() int {
+	return s.total() - s[skippedArtifacts]
+}
+
+func (s cleanSummary) table() ui.Table {
+	table := ui.Table{Headers: []string{"TYPE", "PATHS"}}
+	kinds := make([]string, 0, len(s))
+	for kind := range s {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		table.Rows = append(table.Rows, []string{kind, strconv.Itoa(s[kind])})
+	}
+	return table
+}
+
+// artifact is a generated file that lives outside the image directories.
+type artifact struct {
+	kind string
+	path string
+}
+
+// generatedArtifacts lists every generated file outside the image
+// directories that cfgs may have produced. New kinds of generated output
+// register their paths here so that clean --artifacts removes them.
+func generatedArtifacts(cfgs []*config.Config, workflowOutput string) []artifact {
+	var artifacts []artifact
+	for _, path := range workflow.OutputPaths(cfgs, workflowOutput) {
+		artifacts = append(artifacts, artifact{kind: "workflow files", path: path})
+	}
+	return artifacts
+}
+
+// cleanArtifacts removes the artifacts that exist and carry the generated
+// header, counting them in summary, and returns their total size.
+func cleanArtifacts(artifacts []artifact, dryRun bool, summary cleanSummary) int64 {
+	var size int64
+	for _, a := range artifacts {
+		content, err := os.ReadFile(a.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Warnf("failed to read %s: %v", a.path, err)
+			continue
+		}
+		if !template.IsGenerated(content) {
+			log.Warnf("keeping %s: it has no generated header", a.path)
+			summary.add(skippedArtifacts, 1)
+			continue
+		}
+
+		if dryRun {
+			log.Infof("would remove %s", a.path)
+		} else {
+			if err := os.Remove(a.path); err != nil {
+				log.Warnf("failed to remove %s: %v", a.path, err)
+				continue
+			}
+			log.Debugf("Removed: %s", a.path)
+		}
+		summary.add(a.kind, 1)
+		size += int64(len(content))
+	}
+	return size
+}
+
+// cleanProject removes the generated version directories of one manifest's
+// images, or of the named ones it defines, or with orphansOnly just those no
+// longer in it. It returns how many directories and bytes it removed, or
+// with dryRun would remove. Version directories that are not writable are
+// kept with a warning.
+func cleanProject(cfg *config.Config, imageNames []string, orphansOnly, dryRun bool) (int, int64, error) {
+	if len(imageNames) == 0 {
+		for imageName := range cfg.Images {
+			imageNames = append(imageNames, imageName)
+		}
+		sort.Strings(imageNames)
+	}
+
+	total := 0
+	var totalSize int64
+	for _, imageName := range imageNames {
+		image, exists := cfg.Images[imageName]
+		if !exists {
+			continue
+		}
+		log.Debugf("cleaning image: %s", imageName)
+
+		imagePath, err := cfg.ImagePath(image)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		var versions []string
+		if orphansOnly {
+			versions, err = generator.OrphanedVersions(cfg, imageName)
+			if err != nil {
+				return 0, 0, err
+			}
+		} else {
+			for _, output := range image.OutputVersions() {
+				versions = append(versions, output.Name)
+			}
+		}
+
+		removedCount := 0
+		for _, version := range versions {
+			versionDir := filepath.Join(imagePath, version)
+
+			if _, err := os.Stat(versionDir); os.IsNotExist(err) {
+				// Directory doesn't exist, skip
+				continue
+			}
+			size, err := dirSize(versionDir)
+			if err != nil {
+				log.Warnf("skipping %s: %v", versionDir, err)
+				continue
+			}
+			if dir := readOnlyDir(versionDir); dir == versionDir {
+				log.Warnf("skipping %s: it is not writable", versionDir)
+				continue
+			} else if dir != "" {
+				log.Warnf("skipping %s: %s is not writable", versionDir, dir)
+				continue
+			}
+
+			if dryRun {
+				log.Infof("would remove %s (%s)", versionDir, formatBytes(size))
+				removedCount++
+				totalSize += size
+				continue
+			}
+
+			if err := os.RemoveAll(versionDir); err != nil {
+				log.Warnf("failed to remove %s: %v", versionDir, err)
+				if remaining, err := dirSize(versionDir); err == nil {
+					totalSize += size - remaining
+				}
+			} else {
+				log.Debugf("Removed: %s", versionDir)
+				removedCount++
+				totalSize += size
+			}
+		}
+
+		if removedCount > 0 && !dryRun {
+			log.Infof("cleaned %s (%d versions)", imageName, removedCount)
+		}
+		total += removedCount
+	}
+
+	return total, totalSize, nil
+}
+
+// checkImageNames fails for a name that none of cfgs defines, suggesting
+// close matches when there is a single manifest.
+func checkImageNames(cfgs []*config.Config, imageNames []string) error {
+	for _, imageName := range imageNames {
+		found := false
+		for _, cfg := range cfgs {
+			if _, exists := cfg.Images[imageName]; exists {
+				found = true
+			}
+		}
+		if found {
+			continue
+		}
+		if len(cfgs) == 1 {
+			return checkImageName(cfgs[0], imageName)
+		}
+		return fmt.Errorf("image %s not found in any of the %d manifests", imageName, len(cfgs))
+	}
+	return nil
+}
+
+// dirSize returns the total size of the regular files below dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// readOnlyDir returns the first directory that removing dir would need to
+// write to but whose owner cannot: dir's parent, dir or a directory below
+// it. It returns "" when every one is writable.
+func readOnlyDir(dir string) string {
+	readOnly := ""
+	if info, err := os.Stat(filepath.Dir(dir)); err == nil && info.Mode().Perm()&0200 == 0 {
+		return filepath.Dir(dir)
+	}
+	_ = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && info.Mode().Perm()&0200 == 0 {
+			readOnly = path
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return readOnly
+}
+
+// formatBytes renders a size in bytes with a binary unit, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// completionConfigs loads the manifests named by --config once per
+// completion request. Completion must never fail, so a manifest that cannot
+// be loaded completes nothing, and presets are not fetched.
+var completionConfigs = sync.OnceValue(func() []*config.Config {
+	log.SetLevel(log.FatalLevel)
+	config.NoRemote = true
+	config.IgnoreRequires = true
+	cfgs, err := loadConfigs(context.Background())
+	if err != nil {
+		return nil
+	}
+	return cfgs
+})
+
+// imageNames returns the images of every manifest starting with prefix, in
+// name order.
+func imageNames(prefix string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, cfg := range completionConfigs() {
+		for name := range cfg.Images {
+			if !seen[name] && strings.HasPrefix(name, prefix) {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// versionNames returns the output versions, variants included, of
+// imageName starting with prefix, in manifest order.
+func versionNames(imageName, prefix string) []string {
+	var names []string
+	for _, cfg := range completionConfigs() {
+		image, ok := cfg.Images[imageName]
+		if !ok {
+			continue
+		}
+		for _, output := range image.OutputVersions() {
+			if strings.HasPrefix(output.Name, prefix) {
+				names = append(names, output.Name)
+			}
+		}
+	}
+	return names
+}
+
+// completeImage completes the single image argument of a command.
+func completeImage(_ *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return imageNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeImages completes any number of image arguments, leaving out those
+// already given.
+func completeImages(_ *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var names []string
+	for _, name := range imageNames(toComplete) {
+		if !slices.Contains(args, name) {
+			names = append(names, name)
+		}
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeImageVersion completes an <image>:<version> argument: image names
+// until a colon is typed, then that image's versions. With versionRequired
+// the image names end in a colon.
+func completeImageVersion(versionRequired bool) cobra.CompletionFunc {
+	return func(_ *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		imageName, version, found := strings.Cut(toComplete, ":")
+		if !found {
+			names := imageNames(toComplete)
+			if !versionRequired {
+				return names, cobra.ShellCompDirectiveNoFileComp
+			}
+			for i := range names {
+				names[i] += ":"
+			}
+			return names, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+		}
+		var refs []string
+		for _, name := range versionNames(imageName, version) {
+			refs = append(refs, imageName+":"+name)
+		}
+		return refs, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeVersionFlag completes a --version flag with the versions of the
+// image named by the command's first argument.
+func completeVersionFlag(_ *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return versionNames(args[0], toComplete), cobra.ShellCompDirectiveNoFileComp
+}
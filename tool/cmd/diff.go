@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+	"github.com/mberwanger/dockerfiles/tool/internal/ui"
+)
+
+type diffCmd struct {
+	Cmd *cobra.Command
+}
+
+func newDiffCmd() *diffCmd {
+	root := &diffCmd{}
+	cmd := &cobra.Command{
+		Use:   "diff [image]",
+		Short: "Show how generated files differ from what the templates render",
+		Long:  "Render every template in memory, or those of one image, and print a unified diff for each generated file that would change, and a line for each file or orphaned version directory that generate image --all would create or delete. Exits 0 when everything is up to date, 1 when there are differences and 2 on errors",
+		Example: `  # Review what generate image --all would change
+  dockerfiles diff
+
+  # Only python, without color
+  dockerfiles diff python | less`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeImage,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfgs, err := loadConfigs(cmd.Context())
+			if err != nil {
+				return &exitError{Code: 2, Err: err}
+			}
+			plans, err := planImages(cmd.Context(), cfgs, args, generator.CheckImageContext)
+			if err != nil {
+				return &exitError{Code: 2, Err: err}
+			}
+
+			out := cmd.OutOrStdout()
+			renderer := ui.NewRenderer(out)
+			changes := 0
+			for _, plan := range plans {
+				for _, action := range plan.Actions {
+					changes++
+					path := displayPath(filepath.Join(plan.Dir, filepath.FromSlash(action.Path)))
+					switch {
+					case action.Type == generator.CreateFile:
+						_, _ = fmt.Fprintf(out, "would create %s\n", path)
+					case action.Type == generator.DeleteFile:
+						_, _ = fmt.Fprintf(out, "would delete %s\n", path)
+					case action.Type == generator.DeleteDir:
+						_, _ = fmt.Fprintf(out, "would delete %s/ (%s)\n", path, driftStatus(action))
+					case action.Diff == "":
+						_, _ = fmt.Fprintf(out, "would update %s (binary or too large to diff)\n", path)
+					default:
+						_, _ = fmt.Fprint(out, renderer.Diff(relabelDiff(action.Diff, filepath.ToSlash(path))))
+					}
+				}
+			}
+
+			if changes == 0 {
+				log.Info("generated files are up to date")
+				return nil
+			}
+			log.Warnf("%d generated path(s) differ; run generate image to update them", changes)
+			return &exitError{Code: 1}
+		},
+	}
+
+	root.Cmd = cmd
+	return root
+}
+
+// relabelDiff replaces the file headers of a plan diff, which name the path
+// within the image directory, with path.
+func relabelDiff(diff, path string) string {
+	lines := strings.SplitAfterN(diff, "\n", 3)
+	if len(lines) < 3 || !strings.HasPrefix(lines[0], "--- ") || !strings.HasPrefix(lines[1], "+++ ") {
+		return diff
+	}
+	return fmt.Sprintf("--- a/%s\n+++ b/%s\n%s", path, path, lines[2])
+}
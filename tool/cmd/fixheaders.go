@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+	"github.com/mberwanger/dockerfiles/tool/internal/ui"
+)
+
+type fixHeadersCmd struct {
+	Cmd *cobra.Command
+}
+
+func newFixHeadersCmd() *fixHeadersCmd {
+	root := &fixHeadersCmd{}
+	var fix bool
+	cmd := &cobra.Command{
+		Use:   "fix-headers",
+		Short: "Find templates whose output lacks the generated-file header",
+		Long:  "Render the first version of every image and list the templates whose output lacks the generated-file header. With --fix, add a generation_message call to the top of each, after a syntax directive, shebang or XML declaration",
+		Example: `  # List templates without a header
+  dockerfiles fix-headers
+
+  # Add the header to them
+  dockerfiles fix-headers --fix`,
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cobra.NoFileCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			missing, err := generator.MissingHeaders(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			if len(missing) == 0 {
+				log.Info("every generated file has a header")
+				return nil
+			}
+
+			if fix {
+				for _, m := range missing {
+					if _, err := generator.FixHeader(m.Path); err != nil {
+						return fmt.Errorf("fixing %s: %w", m.Path, err)
+					}
+					log.Infof("added generation_message to %s", displayPath(m.Path))
+				}
+				log.Infof("fixed %d template(s); run generate image to update their output", len(missing))
+				return nil
+			}
+
+			table := ui.Table{Headers: []string{"IMAGE", "TEMPLATE"}}
+			for _, m := range missing {
+				table.Rows = append(table.Rows, []string{m.Image, m.Template})
+			}
+			out := cmd.OutOrStdout()
+			_, _ = fmt.Fprint(out, ui.NewRenderer(out).Table(table))
+			return &exitError{Code: exitCheck, Err: fmt.Errorf("%d template(s) render without the generated header; run fix-headers --fix", len(missing))}
+		},
+	}
+	cmd.Flags().BoolVar(&fix, "fix", false, "Add a generation_message call to the templates")
+
+	root.Cmd = cmd
+	return root
+}
@@ -1,8 +1,17 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/apex/log"
@@ -11,6 +20,7 @@ import (
 
 	"github.com/mberwanger/dockerfiles/tool/internal/config"
 	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+	"github.com/mberwanger/dockerfiles/tool/internal/ui"
 	"github.com/mberwanger/dockerfiles/tool/internal/workflow"
 )
 
@@ -32,7 +42,18 @@ func newGeneratorCmd() *generatorCmd {
 		ValidArgsFunction: cobra.NoFileCompletions,
 	}
 
-	var generateAll bool
+	var reproducible bool
+	cmd.PersistentFlags().BoolVar(&reproducible, "reproducible", true, "Fail on time, git or network derived template helpers unless pinned in the manifest")
+	applyReproducible := func(c *cobra.Command, cfg *config.Config) {
+		if c.Flags().Changed("reproducible") {
+			cfg.Defaults.Reproducible = &reproducible
+		}
+	}
+
+	var generateAll, incremental, resume, prune, noPrune, checkOutputs, dryRun, strictKeys bool
+	var concurrency int
+	var versionName string
+	var setValues, setStringValues, setFileValues []string
 	imageSubCmd := &cobra.Command{
 		Use:     "image [image-name]",
 		Aliases: []string{"img"},
@@ -41,91 +62,648 @@ func newGeneratorCmd() *generatorCmd {
 		Example: `  # Generate a specific image
   dockerfiles generate image core
 
+  # Generate one version, leaving the image's other versions untouched
+  dockerfiles generate image core --version noble
+
   # Generate all images
   dockerfiles generate image --all
-  dockerfiles generate image -A`,
+  dockerfiles generate image -A
+
+  # Generate four images at a time
+  dockerfiles generate image --all -j 4
+
+  # Show what would be written and removed without touching disk
+  dockerfiles generate image python --dry-run
+
+  # Fail when committed files differ from what the templates produce
+  dockerfiles generate image --all --check
+
+  # Only re-render versions whose inputs changed since the last run
+  dockerfiles generate image --all --incremental
+
+  # Continue an interrupted run, skipping versions it already completed
+  dockerfiles generate image --all --resume
+
+  # Keep version directories that were removed from the manifest
+  dockerfiles generate image --all --no-prune
+
+  # Fail on value keys that differ only by case or separator
+  dockerfiles generate image --all --strict
+
+  # Override values without editing the manifest
+  dockerfiles generate image python --set python_version=3.13.0rc1 --set registry=localhost:5000`,
 		Args: func(cmd *cobra.Command, args []string) error {
 			if generateAll && len(args) > 0 {
 				return fmt.Errorf("cannot specify image name with --all flag")
 			}
-			if !generateAll && len(args) != 1 {
-				_ = cmd.Help()
-				os.Exit(0)
-			}
-			return nil
+			return cobra.MaximumNArgs(1)(cmd, args)
 		},
+		ValidArgsFunction: completeImage,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if !generateAll && len(args) == 0 {
+				return cmd.Help()
+			}
 			start := time.Now()
-			cfg, err := config.Load(configFile)
+			cfgs, err := loadConfigs(cmd.Context())
 			if err != nil {
 				return err
 			}
 
-			if generateAll {
-				if err := generator.GenerateAll(cfg); err != nil {
-					log.Fatalf("Failed to generate all images: %v", err)
+			overrides, err := config.ParseOverrides(setValues, setStringValues, setFileValues)
+			if err != nil {
+				return err
+			}
+			if len(overrides) > 0 {
+				log.Warnf("applying CLI overrides: %s", strings.Join(config.OverrideKeys(overrides), ", "))
+			}
+
+			for _, cfg := range cfgs {
+				applyReproducible(cmd, cfg)
+				if len(overrides) > 0 {
+					cfg.Defaults.Overrides = overrides
+				}
+			}
+
+			options := func(cfg *config.Config) generator.Options {
+				opts := generator.DefaultOptions(cfg)
+				opts.Incremental = incremental
+				opts.Concurrency = concurrency
+				opts.Version = versionName
+				opts.StrictKeys = strictKeys
+				if cmd.Flags().Changed("prune") {
+					opts.Prune = prune
+				}
+				if cmd.Flags().Changed("no-prune") {
+					opts.Prune = !noPrune
+				}
+				return opts
+			}
+
+			if checkOutputs {
+				return checkImages(cmd, cfgs, args)
+			}
+			if dryRun {
+				return strictExit(dryRunImages(cmd, cfgs, args, options), nil)
+			}
+
+			var plans []*generator.Plan
+			imageCount, versionCount := 0, 0
+			for _, cfg := range cfgs {
+				opts := options(cfg)
+				opts.Resume = resume
+				if opts.Journal, err = generator.OpenJournal(cfg); err != nil {
+					if resume {
+						return err
+					}
+					log.Warnf("progress will not be recorded: %v", err)
+				}
+				if generateAll {
+					projectPlans, err := generator.GenerateAllContext(cmd.Context(), cfg, opts)
+					if err != nil {
+						failures := imageErrors(err)
+						for _, failure := range failures {
+							logFileError(failure)
+							log.Error(failure.Error())
+						}
+						return strictExit(err, fmt.Errorf("failed to generate %d of %d images", len(failures), len(cfg.Images)))
+					}
+					plans = append(plans, projectPlans...)
+					imageCount += len(cfg.Images)
+					continue
 				}
 
-				imageCount := len(cfg.Images)
-				log.Info(boldStyle.Render(fmt.Sprintf("generated %d images successfully after %s", imageCount, time.Since(start).Truncate(time.Second))))
-			} else {
 				imageName := args[0]
-				if err := generator.GenerateImage(cfg, imageName); err != nil {
-					log.Fatalf("Failed to generate image '%s': %v", imageName, err)
+				image, exists := cfg.Images[imageName]
+				if !exists && len(cfgs) > 1 {
+					continue
+				}
+				plan, err := generator.GenerateImageContext(cmd.Context(), cfg, imageName, opts)
+				if err != nil {
+					return strictExit(fmt.Errorf("generating image %s: %w", imageName, err), nil)
+				}
+				plans = append(plans, plan)
+				versionCount += len(image.OutputVersions())
+			}
+			if versionName != "" && len(plans) > 0 {
+				logSummary(log.Fields{"action": "generate", "image": args[0], "version": versionName, "duration_ms": time.Since(start).Milliseconds()},
+					"generated image '%s' version %s successfully after %s", args[0], versionName, time.Since(start).Truncate(time.Second))
+				return nil
+			}
+
+			if generateAll {
+				logSummary(log.Fields{"action": "generate", "images": imageCount, "duration_ms": time.Since(start).Milliseconds()},
+					"generated %d images successfully after %s", imageCount, time.Since(start).Truncate(time.Second))
+			} else {
+				if len(plans) == 0 {
+					return fmt.Errorf("image %s not found in any of the %d manifests", args[0], len(cfgs))
 				}
+				logSummary(log.Fields{"action": "generate", "image": args[0], "versions": versionCount, "duration_ms": time.Since(start).Milliseconds()},
+					"generated image '%s' (%d versions) successfully after %s", args[0], versionCount, time.Since(start).Truncate(time.Second))
+			}
 
-				image := cfg.Images[imageName]
-				versionCount := len(image.Versions)
-				log.Info(boldStyle.Render(fmt.Sprintf("generated image '%s' (%d versions) successfully after %s", imageName, versionCount, time.Since(start).Truncate(time.Second))))
+			orphans := 0
+			for _, plan := range plans {
+				for _, orphan := range plan.Orphans {
+					log.Debugf("orphaned version directory: %s", filepath.Join(plan.Dir, orphan))
+				}
+				orphans += len(plan.Orphans)
+			}
+			if orphans > 0 {
+				log.Warnf("%d orphaned version dirs present, run with --prune or use clean --orphans", orphans)
 			}
 			return nil
 		},
 	}
 	imageSubCmd.Flags().BoolVarP(&generateAll, "all", "A", false, "Generate all images")
+	imageSubCmd.Flags().IntVarP(&concurrency, "concurrency", "j", runtime.NumCPU(), "Number of images to generate at once with --all")
+	imageSubCmd.Flags().BoolVar(&incremental, "incremental", false, "Skip versions whose recorded inputs hash is unchanged")
+	imageSubCmd.Flags().BoolVar(&resume, "resume", false, "Skip versions an earlier, interrupted run completed with unchanged inputs")
+	imageSubCmd.Flags().BoolVar(&checkOutputs, "check", false, "Compare the generated files on disk with what would be generated, without writing, and fail on any difference")
+	imageSubCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the files that would be written and removed without changing anything")
+	imageSubCmd.MarkFlagsMutuallyExclusive("check", "incremental")
+	imageSubCmd.MarkFlagsMutuallyExclusive("check", "dry-run")
+	imageSubCmd.MarkFlagsMutuallyExclusive("resume", "check")
+	imageSubCmd.MarkFlagsMutuallyExclusive("resume", "dry-run")
+	imageSubCmd.Flags().BoolVar(&strictKeys, "strict", false, "Fail with exit code 3 on value keys that differ only by case or separator instead of warning")
+	imageSubCmd.Flags().StringVar(&versionName, "version", "", "Generate only this version and its variants, leaving other version directories untouched")
+	_ = imageSubCmd.RegisterFlagCompletionFunc("version", completeVersionFlag)
+	imageSubCmd.MarkFlagsMutuallyExclusive("version", "all")
+	imageSubCmd.MarkFlagsMutuallyExclusive("version", "check")
+	imageSubCmd.Flags().BoolVar(&prune, "prune", true, "Delete version directories no longer in the manifest (default from defaults.prune_orphans)")
+	imageSubCmd.Flags().BoolVar(&noPrune, "no-prune", false, "Keep version directories no longer in the manifest and report them instead")
+	imageSubCmd.MarkFlagsMutuallyExclusive("prune", "no-prune")
+	imageSubCmd.Flags().StringArrayVar(&setValues, "set", nil, "Override a value after merging (key=value, dotted keys create nested maps)")
+	imageSubCmd.Flags().StringArrayVar(&setStringValues, "set-string", nil, "Override a value, always as a string (key=value)")
+	imageSubCmd.Flags().StringArrayVar(&setFileValues, "set-file", nil, "Override a value with a file's contents (key=path)")
 
-	var outputFile string
+	var outputFile, workflowFormat, workflowTemplate string
+	var check, split, fromDockerfiles bool
+	var maxParallel int
 	workflowSubCmd := &cobra.Command{
 		Use:     "workflow",
 		Aliases: []string{"wf"},
 		Short:   "Generate GitHub Actions workflow (outputs to stdout by default)",
-		Long:    "Generate a GitHub Actions workflow file with dependency-ordered build jobs. Outputs to stdout by default, or to a file if specified with --output/-o",
+		Long:    "Generate a GitHub Actions workflow file, or with --format gitlab a GitLab CI pipeline, with dependency-ordered build jobs. Outputs to stdout by default, or to a file if specified with --output/-o",
 		Example: `  # Output to stdout
   dockerfiles generate workflow
 
   # Output to file
-  dockerfiles generate workflow -o .github/workflows/dockerfiles.yaml`,
+  dockerfiles generate workflow -o .github/workflows/dockerfiles.yaml
+
+  # Order jobs by the Dockerfiles on disk, e.g. hand-written ones
+  dockerfiles generate workflow --from-dockerfiles -o .github/workflows/dockerfiles.yaml
+
+  # At most 20 build jobs at a time
+  dockerfiles generate workflow --max-parallel 20 -o .github/workflows/dockerfiles.yaml
+
+  # GitLab CI pipeline
+  dockerfiles generate workflow --format gitlab -o .gitlab-ci.yml
+
+  # Render the jobs with your own template, e.g. to build with kaniko
+  dockerfiles generate workflow --template ci/workflow.tmpl -o .github/workflows/dockerfiles.yaml
+
+  # Check depends_on declarations and that the committed workflow is current
+  dockerfiles generate workflow --check -o .github/workflows/dockerfiles.yaml
+
+  # One workflow per project: dockerfiles-toolchains.yaml and dockerfiles-runtimes.yaml
+  dockerfiles generate workflow -c toolchains/manifest.yaml -c runtimes/manifest.yaml --split -o .github/workflows/dockerfiles.yaml`,
 		Args: cobra.NoArgs,
 		PersistentPreRun: func(cmd *cobra.Command, args []string) {
 			// Disable logging when writing to stdout
-			if outputFile == "" {
+			if outputFile == "" && !check {
 				log.SetLevel(log.FatalLevel)
 			}
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			cfg, err := config.Load(configFile)
+			format, err := workflow.FormatFor(workflowFormat)
+			if err != nil {
+				return err
+			}
+			if workflowTemplate != "" {
+				src, err := os.ReadFile(workflowTemplate)
+				if err != nil {
+					return fmt.Errorf("reading workflow template: %w", err)
+				}
+				format = format.WithTemplate(workflowTemplate, string(src))
+			}
+			cfgs, err := loadConfigs(cmd.Context())
 			if err != nil {
 				return fmt.Errorf("loading config: %w", err)
 			}
+			if split && outputFile == "" {
+				return fmt.Errorf("--split needs --output to derive each project's file name")
+			}
+			if maxParallel < 0 {
+				return fmt.Errorf("--max-parallel must not be negative")
+			}
+			for _, cfg := range cfgs {
+				applyReproducible(cmd, cfg)
+				if fromDockerfiles || cmd.Flags().Changed("max-parallel") {
+					if cfg.Defaults.Workflow == nil {
+						cfg.Defaults.Workflow = &config.Workflow{}
+					}
+				}
+				if fromDockerfiles {
+					cfg.Defaults.Workflow.Dependencies = workflow.DependenciesDockerfiles
+				}
+				if cmd.Flags().Changed("max-parallel") {
+					cfg.Defaults.Workflow.MaxParallel = maxParallel
+				}
+			}
+
+			outputs, err := renderWorkflows(cmd.Context(), cfgs, format, outputFile, split)
+			if err != nil {
+				return fmt.Errorf("generating workflow: %w", err)
+			}
 
-			if outputFile != "" {
-				if err := workflow.Generate(cfg, outputFile); err != nil {
-					return fmt.Errorf("generating workflow: %w", err)
+			if check {
+				return checkWorkflow(cmd.Context(), cfgs, outputs)
+			}
+
+			for _, output := range outputs {
+				if output.path == "" {
+					if _, err := os.Stdout.Write(output.content); err != nil {
+						return fmt.Errorf("writing workflow: %w", err)
+					}
+					continue
 				}
-				log.Infof("Generated workflow file: %s", outputFile)
-			} else {
-				if err := workflow.GenerateToWriter(cfg, os.Stdout); err != nil {
-					return fmt.Errorf("generating workflow: %w", err)
+				if err := workflow.WriteFile(output.path, output.content); err != nil {
+					return fmt.Errorf("writing workflow: %w", err)
 				}
+				log.Infof("Generated workflow file: %s", output.path)
 			}
 			return nil
 		},
 	}
 	workflowSubCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Output file path (defaults to stdout)")
+	workflowSubCmd.Flags().StringVar(&workflowFormat, "format", workflow.FormatGitHub, "CI system to render for ("+strings.Join(workflow.Formats(), ", ")+")")
+	workflowSubCmd.Flags().StringVar(&workflowTemplate, "template", "", "Render with this Go template instead of the embedded one for --format; it is executed with the same jobs")
+	_ = workflowSubCmd.MarkFlagFilename("template", "tmpl")
+	workflowSubCmd.Flags().BoolVar(&split, "split", false, "With several --config manifests, write one workflow per project next to --output instead of a combined one")
+	workflowSubCmd.Flags().BoolVar(&check, "check", false, "Verify declared depends_on against the Dockerfiles and, with --output, that the file is up to date, without writing")
+	workflowSubCmd.Flags().IntVar(&maxParallel, "max-parallel", 0, "Run at most this many build jobs at once, in waves that wait for each other; 0 for no limit (default from defaults.workflow.max_parallel)")
+	workflowSubCmd.Flags().BoolVar(&fromDockerfiles, "from-dockerfiles", false, "Parse dependencies from the Dockerfiles on disk instead of rendering the templates (default from defaults.workflow.dependencies)")
+
+	var checksFormat, checksDiffFile string
+	requiredChecksSubCmd := &cobra.Command{
+		Use:   "required-checks",
+		Short: "List the check names branch protection should require (outputs to stdout)",
+		Long:  "List the check names GitHub reports for the generated workflow's build jobs, derived from the same job data as the workflow. With --diff, show checks added or removed relative to a saved JSON list",
+		Example: `  # Print one check per line
+  dockerfiles generate required-checks
+
+  # Save as JSON and later compare against it
+  dockerfiles generate required-checks --format json > checks.json
+  dockerfiles generate required-checks --diff checks.json`,
+		Args: cobra.NoArgs,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// Disable logging when writing to stdout
+			log.SetLevel(log.FatalLevel)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if checksFormat != "text" && checksFormat != "json" {
+				return fmt.Errorf("unsupported format %q (supported: text, json)", checksFormat)
+			}
+
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("loading config: %w", err)
+			}
+
+			checks, err := workflow.RequiredChecks(cfg)
+			if err != nil {
+				return fmt.Errorf("computing required checks: %w", err)
+			}
+
+			out := cmd.OutOrStdout()
+			if checksDiffFile != "" {
+				content, err := os.ReadFile(checksDiffFile)
+				if err != nil {
+					return fmt.Errorf("reading saved checks: %w", err)
+				}
+				var previous []string
+				if err := json.Unmarshal(content, &previous); err != nil {
+					return fmt.Errorf("parsing saved checks %s: %w", checksDiffFile, err)
+				}
+
+				added, removed := workflow.DiffChecks(previous, checks)
+				if checksFormat == "json" {
+					return writeJSON(out, map[string][]string{"added": nonNil(added), "removed": nonNil(removed)})
+				}
+				_, _ = fmt.Fprint(out, ui.NewRenderer(out).Changes(added, removed))
+				return nil
+			}
+
+			if checksFormat == "json" {
+				return writeJSON(out, checks)
+			}
+			for _, check := range checks {
+				_, _ = fmt.Fprintln(out, check)
+			}
+			return nil
+		},
+	}
+	requiredChecksSubCmd.Flags().StringVar(&checksFormat, "format", "text", "Output format (text, json)")
+	requiredChecksSubCmd.Flags().StringVar(&checksDiffFile, "diff", "", "Compare against a saved JSON list of checks")
+	_ = requiredChecksSubCmd.MarkFlagFilename("diff", "json")
 
 	cmd.AddCommand(
 		imageSubCmd,
 		workflowSubCmd,
+		requiredChecksSubCmd,
 	)
 	root.Cmd = cmd
 	return root
 }
+
+// logSummary logs the line a command ends with, bold on a terminal. With
+// --log-format json it is a plain message carrying fields instead.
+func logSummary(fields log.Fields, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if jsonLogs {
+		log.WithFields(fields).Info(msg)
+		return
+	}
+	if ui.NewRenderer(os.Stderr).Color {
+		msg = boldStyle.Render(msg)
+	}
+	log.Info(msg)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// logFileError logs which file a generation error belongs to, as fields that
+// stay readable however deep the template error is.
+func logFileError(err error) {
+	var fileErr *generator.FileError
+	if !errors.As(err, &fileErr) {
+		return
+	}
+	log.WithFields(log.Fields{
+		"image":   fileErr.Image,
+		"version": fileErr.Version,
+		"source":  fileErr.Source,
+		"output":  fileErr.Output,
+	}).Errorf("%s failed: %v", fileErr.Op, fileErr.Err)
+}
+
+// planImages plans every image, or the one named in args, of each manifest
+// with planImage, in image name order.
+func planImages(ctx context.Context, cfgs []*config.Config, args []string, planImage func(context.Context, *config.Config, string) (*generator.Plan, error)) ([]*generator.Plan, error) {
+	var plans []*generator.Plan
+	found := false
+	for _, cfg := range cfgs {
+		imageNames := args
+		if len(args) == 0 {
+			imageNames = make([]string, 0, len(cfg.Images))
+			for imageName := range cfg.Images {
+				imageNames = append(imageNames, imageName)
+			}
+			sort.Strings(imageNames)
+		} else if _, exists := cfg.Images[args[0]]; !exists && len(cfgs) > 1 {
+			continue
+		}
+		found = true
+
+		for _, imageName := range imageNames {
+			plan, err := planImage(ctx, cfg, imageName)
+			if err != nil {
+				logFileError(err)
+				return nil, err
+			}
+			plans = append(plans, plan)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("image %s not found in any of the %d manifests", args[0], len(cfgs))
+	}
+	return plans, nil
+}
+
+// checkImages reports every generated file that differs from what the
+// templates produce, for all images or the one named in args.
+func checkImages(cmd *cobra.Command, cfgs []*config.Config, args []string) error {
+	plans, err := planImages(cmd.Context(), cfgs, args, generator.CheckImageContext)
+	if err != nil {
+		return err
+	}
+
+	table := ui.Table{Headers: []string{"IMAGE", "PATH", "STATUS", "CHANGES"}}
+	images := 0
+	for _, plan := range plans {
+		if plan.Empty() {
+			continue
+		}
+		images++
+		for _, action := range plan.Actions {
+			table.Rows = append(table.Rows, []string{plan.Image, action.Path, driftStatus(action), diffStat(action.Diff)})
+		}
+	}
+	if images == 0 {
+		log.Info("generated files are up to date")
+		return nil
+	}
+
+	out := cmd.OutOrStdout()
+	_, _ = fmt.Fprint(out, ui.NewRenderer(out).Table(table))
+	return &exitError{Code: exitCheck, Err: fmt.Errorf("%d generated path(s) out of date in %d image(s); run generate image to update them", len(table.Rows), images)}
+}
+
+// dryRunImages prints what generating all images, or the one named in args,
+// would write and remove, with paths relative to the working directory.
+func dryRunImages(cmd *cobra.Command, cfgs []*config.Config, args []string, options func(*config.Config) generator.Options) error {
+	plans, err := planImages(cmd.Context(), cfgs, args, func(ctx context.Context, cfg *config.Config, imageName string) (*generator.Plan, error) {
+		return generator.PlanImageContext(ctx, cfg, imageName, options(cfg))
+	})
+	if err != nil {
+		return err
+	}
+
+	table := ui.Table{Headers: []string{"ACTION", "PATH", "CHANGES"}}
+	writes, removals := 0, 0
+	for _, plan := range plans {
+		for _, action := range plan.Actions {
+			if action.Type == generator.CreateFile || action.Type == generator.UpdateFile {
+				writes++
+			} else {
+				removals++
+			}
+			table.Rows = append(table.Rows, []string{dryRunAction(action.Type), displayPath(filepath.Join(plan.Dir, filepath.FromSlash(action.Path))), diffStat(action.Diff)})
+		}
+		for _, orphan := range plan.Orphans {
+			table.Rows = append(table.Rows, []string{"keep orphan", displayPath(filepath.Join(plan.Dir, orphan)), ""})
+		}
+	}
+
+	if len(table.Rows) > 0 {
+		out := cmd.OutOrStdout()
+		_, _ = fmt.Fprint(out, ui.NewRenderer(out).Table(table))
+	}
+	log.Infof("dry run: %d file(s) to write and %d path(s) to remove; nothing was changed", writes, removals)
+	return nil
+}
+
+// dryRunAction names a plan action for --dry-run.
+func dryRunAction(actionType generator.ActionType) string {
+	switch actionType {
+	case generator.CreateFile:
+		return "create"
+	case generator.UpdateFile:
+		return "update"
+	case generator.DeleteDir:
+		return "remove dir"
+	default:
+		return "remove"
+	}
+}
+
+// displayPath returns path relative to the working directory when it lies
+// below it.
+func displayPath(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
+
+// driftStatus describes what a check action found on disk.
+func driftStatus(action generator.Action) string {
+	switch action.Type {
+	case generator.CreateFile:
+		return "missing"
+	case generator.UpdateFile:
+		return "modified"
+	case generator.DeleteDir:
+		if !strings.Contains(action.Path, "/") {
+			return "orphaned version"
+		}
+		return "unexpected directory"
+	default:
+		return "unexpected"
+	}
+}
+
+// diffStat counts the added and removed lines of a unified diff, e.g. "+2 -1".
+func diffStat(diff string) string {
+	if diff == "" {
+		return ""
+	}
+	added, removed := 0, 0
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	return fmt.Sprintf("+%d -%d", added, removed)
+}
+
+// imageErrors splits the joined per-image errors of GenerateAllContext.
+// strictExit returns report, or cause when report is nil, with the check
+// exit code when cause holds a key conflict rejected by --strict.
+func strictExit(cause, report error) error {
+	if report == nil {
+		report = cause
+	}
+	var conflictErr *generator.KeyConflictError
+	if errors.As(cause, &conflictErr) {
+		return &exitError{Code: exitCheck, Err: report}
+	}
+	return report
+}
+
+func imageErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// workflowOutput is a rendered workflow and the file it belongs in, empty
+// for stdout.
+type workflowOutput struct {
+	path    string
+	content []byte
+}
+
+// renderWorkflows renders one combined workflow in format for cfgs, or with
+// split one workflow per project next to outputFile.
+func renderWorkflows(ctx context.Context, cfgs []*config.Config, format workflow.Format, outputFile string, split bool) ([]workflowOutput, error) {
+	if !split {
+		var buf bytes.Buffer
+		if err := workflow.GenerateProjectsToWriterContext(ctx, cfgs, format, &buf); err != nil {
+			return nil, err
+		}
+		return []workflowOutput{{path: outputFile, content: buf.Bytes()}}, nil
+	}
+
+	outputs := make([]workflowOutput, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		project := cfg.ProjectName()
+		if project == "" {
+			return nil, fmt.Errorf("--split needs every manifest to have a project name")
+		}
+		var buf bytes.Buffer
+		if err := workflow.GenerateProjectToWriterContext(ctx, cfg, format, &buf); err != nil {
+			return nil, fmt.Errorf("project %s: %w", project, err)
+		}
+		outputs = append(outputs, workflowOutput{path: workflow.SplitWorkflowPath(outputFile, project), content: buf.Bytes()})
+	}
+	return outputs, nil
+}
+
+// checkWorkflow reports depends_on declarations that disagree with the parsed
+// Dockerfiles and rendered workflows that differ from their files.
+func checkWorkflow(ctx context.Context, cfgs []*config.Config, outputs []workflowOutput) error {
+	var mismatches []workflow.DependencyMismatch
+	for _, cfg := range cfgs {
+		projectMismatches, err := workflow.VerifyDependenciesContext(ctx, cfg)
+		if err != nil {
+			return fmt.Errorf("verifying dependencies: %w", err)
+		}
+		mismatches = append(mismatches, projectMismatches...)
+	}
+	for _, mismatch := range mismatches {
+		log.Error(mismatch.String())
+	}
+
+	stale := false
+	for _, output := range outputs {
+		if output.path == "" {
+			continue
+		}
+		got, err := os.ReadFile(output.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if !bytes.Equal(got, output.content) {
+			log.Errorf("%s is out of date; run generate workflow to update it", output.path)
+			stale = true
+		}
+	}
+
+	if len(mismatches) > 0 || stale {
+		return &exitError{Code: exitCheck, Err: fmt.Errorf("workflow check failed")}
+	}
+	log.Info("workflow check passed")
+	return nil
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateImage_Strict(t *testing.T) {
+	manifest := writeManifests(t, map[string]string{
+		"manifest.yaml": `version: 1
+defaults:
+  registry: test.io
+images:
+  app:
+    path: app
+    defaults:
+      python-version: "3.12"
+    versions:
+      v1:
+        python_version: "3.13"
+`,
+		"app/source/Dockerfile.tmpl": "FROM python:{{index .Values \"python_version\"}}\n",
+	})
+
+	runCommand(t, "generate", "image", "app", "-c", manifest)
+	if _, err := os.Stat(filepath.Join(filepath.Dir(manifest), "app", "v1", "Dockerfile")); err != nil {
+		t.Fatalf("generate image without --strict should warn and generate: %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"generate", "image", "app", "--strict", "-c", manifest},
+		{"generate", "image", "--all", "--strict", "-c", manifest},
+		{"generate", "image", "app", "--strict", "--dry-run", "-c", manifest},
+	} {
+		err := newRootCmd().Execute(context.Background(), args)
+		if got := exitCode(err); got != exitCheck {
+			t.Errorf("%v: exit code %d (%v), want %d", args, got, err, exitCheck)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/workflow"
+)
+
+type graphCmd struct {
+	Cmd *cobra.Command
+}
+
+func newGraphCmd() *graphCmd {
+	root := &graphCmd{}
+	var format, image string
+	cmd := &cobra.Command{
+		Use:   "graph",
+		Short: "Print the image dependency graph",
+		Long:  "Print the dependency graph of every image:version as Graphviz DOT or a Mermaid flowchart, with edges from each dependency to the images built on it",
+		Example: `  # Render the whole graph with Graphviz
+  dockerfiles graph | dot -Tsvg -o images.svg
+
+  # Everything built on core, as Mermaid for the docs
+  dockerfiles graph --image core --format mermaid`,
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cobra.NoFileCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "dot" && format != "mermaid" {
+				return fmt.Errorf("unsupported format %q (supported: dot, mermaid)", format)
+			}
+
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			graph, err := workflow.BuildGraph(cfg)
+			if err != nil {
+				return fmt.Errorf("building dependency graph: %w", err)
+			}
+
+			if image != "" {
+				targets, err := resolveGraphTargets(cfg, graph, image)
+				if err != nil {
+					return err
+				}
+				graph = graph.Subgraph(targets...)
+			}
+
+			out := cmd.OutOrStdout()
+			if format == "mermaid" {
+				_, _ = fmt.Fprint(out, graph.Mermaid())
+				return nil
+			}
+			_, _ = fmt.Fprint(out, graph.DOT())
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "dot", "Output format (dot, mermaid)")
+	cmd.Flags().StringVar(&image, "image", "", "Only print this image or image:version and what is built on it")
+
+	root.Cmd = cmd
+	return root
+}
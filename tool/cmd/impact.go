@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/suggest"
+	"github.com/mberwanger/dockerfiles/tool/internal/ui"
+	"github.com/mberwanger/dockerfiles/tool/internal/workflow"
+)
+
+type impactCmd struct {
+	Cmd *cobra.Command
+}
+
+type impactResult struct {
+	Targets   []string         `json:"targets"`
+	Direction string           `json:"direction"`
+	Affected  []workflow.Reach `json:"affected"`
+	CIJobs    int              `json:"ci_jobs"`
+}
+
+func newImpactCmd() *impactCmd {
+	root := &impactCmd{}
+	var format string
+	var reverse bool
+	cmd := &cobra.Command{
+		Use:   "impact <image>[:version]",
+		Short: "Show what rebuilds if an image changes",
+		Long:  "Print the transitive dependents of an image or image version with their depth in the dependency graph, or with --reverse, what the image depends on",
+		Example: `  # What rebuilds if core:noble changes
+  dockerfiles impact core:noble
+
+  # What does python depend on
+  dockerfiles impact python --reverse --format json`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeImageVersion(false),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unsupported format %q (supported: text, json)", format)
+			}
+
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			graph, err := workflow.BuildGraph(cfg)
+			if err != nil {
+				return fmt.Errorf("building dependency graph: %w", err)
+			}
+
+			targets, err := resolveGraphTargets(cfg, graph, args[0])
+			if err != nil {
+				return err
+			}
+
+			result := impactResult{Targets: targets, Direction: "dependents"}
+			if reverse {
+				result.Direction = "dependencies"
+				result.Affected = graph.TransitiveDependencies(targets...)
+			} else {
+				result.Affected = graph.TransitiveDependents(targets...)
+			}
+			if result.Affected == nil {
+				result.Affected = []workflow.Reach{}
+			}
+			result.CIJobs = len(targets) + len(result.Affected)
+
+			out := cmd.OutOrStdout()
+			if format == "json" {
+				return writeJSON(out, result)
+			}
+
+			_, _ = fmt.Fprintf(out, "%s of %s\n", strings.ToUpper(result.Direction[:1])+result.Direction[1:], strings.Join(targets, ", "))
+			if len(result.Affected) > 0 {
+				table := ui.Table{Headers: []string{"DEPTH", "IMAGE"}, Indent: "  "}
+				for _, reach := range result.Affected {
+					table.Rows = append(table.Rows, []string{strconv.Itoa(reach.Depth), reach.Node})
+				}
+				_, _ = fmt.Fprint(out, ui.NewRenderer(out).Table(table))
+			}
+			_, _ = fmt.Fprintf(out, "%d %s, %d CI jobs\n", len(result.Affected), result.Direction, result.CIJobs)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "text", "Output format (text, json)")
+	cmd.Flags().BoolVar(&reverse, "reverse", false, "Show what the target depends on instead of what depends on it")
+
+	root.Cmd = cmd
+	return root
+}
+
+// resolveGraphTargets expands an "image" or "image:version" argument into
+// graph nodes, suggesting close matches for unknown targets.
+func resolveGraphTargets(cfg *config.Config, graph *workflow.Graph, target string) ([]string, error) {
+	if strings.Contains(target, ":") {
+		if graph.Has(target) {
+			return []string{target}, nil
+		}
+	} else {
+		var targets []string
+		for _, node := range graph.Nodes {
+			if strings.HasPrefix(node, target+":") {
+				targets = append(targets, node)
+			}
+		}
+		if len(targets) > 0 {
+			return targets, nil
+		}
+	}
+
+	candidates := append([]string(nil), graph.Nodes...)
+	for imageName := range cfg.Images {
+		candidates = append(candidates, imageName)
+	}
+	sort.Strings(candidates)
+
+	if matches := suggest.Closest(target, candidates, 3); len(matches) > 0 {
+		return nil, fmt.Errorf("unknown image %q (did you mean %s?)", target, strings.Join(matches, ", "))
+	}
+	return nil, fmt.Errorf("unknown image %q", target)
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/scaffold"
+	"github.com/mberwanger/dockerfiles/tool/internal/ui"
+)
+
+type initCmd struct {
+	Cmd *cobra.Command
+}
+
+func newInitCmd() *initCmd {
+	root := &initCmd{}
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Scaffold new manifest entries",
+		Long:  "Create the source directory and manifest entry of a new image",
+		Args:  cobra.NoArgs,
+	}
+
+	var img scaffold.Image
+	var dryRun bool
+	imageSubCmd := &cobra.Command{
+		Use:   "image <name>",
+		Short: "Scaffold a new image",
+		Long:  "Create the image's source directory with a starter Dockerfile.tmpl and append the image to the manifest, keeping the manifest's existing formatting. An image that already exists is never overwritten",
+		Example: `  # Add an image built on Alpine
+  dockerfiles init image jq --version 1.7 --base alpine:3.20
+
+  # Show the manifest diff and files first
+  dockerfiles init image jq --version 1.7 --base core:noble --path util/jq --dry-run`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cobra.NoFileCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			img.Name = args[0]
+			plan, err := scaffold.NewPlan(cfg, img)
+			if err != nil {
+				return err
+			}
+
+			if dryRun {
+				out := cmd.OutOrStdout()
+				table := ui.Table{Headers: []string{"ACTION", "PATH"}}
+				for _, file := range plan.Files {
+					table.Rows = append(table.Rows, []string{"create", displayPath(file.Path)})
+				}
+				table.Rows = append(table.Rows, []string{"update", displayPath(plan.ManifestPath)})
+				renderer := ui.NewRenderer(out)
+				_, _ = fmt.Fprint(out, renderer.Table(table))
+				_, _ = fmt.Fprint(out, "\n"+renderer.Diff(plan.Diff()))
+				log.Info("dry run: nothing was changed")
+				return nil
+			}
+
+			if err := scaffold.Apply(plan); err != nil {
+				return err
+			}
+			log.Infof("added image %s; run generate image %s to render it", img.Name, img.Name)
+			return nil
+		},
+	}
+	imageSubCmd.Flags().StringVar(&img.Version, "version", "", "First version of the image")
+	imageSubCmd.Flags().StringVar(&img.Base, "base", "", "Base image, e.g. alpine:3.20 or an image of the manifest such as core:noble")
+	imageSubCmd.Flags().StringVar(&img.Path, "path", "", "Image directory relative to the manifest (default: the image name)")
+	imageSubCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the manifest diff and the files that would be created without changing anything")
+	_ = imageSubCmd.MarkFlagRequired("version")
+	_ = imageSubCmd.MarkFlagRequired("base")
+
+	cmd.AddCommand(imageSubCmd)
+	root.Cmd = cmd
+	return root
+}
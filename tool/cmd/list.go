@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+	"github.com/mberwanger/dockerfiles/tool/internal/suggest"
+	"github.com/mberwanger/dockerfiles/tool/internal/ui"
+)
+
+type listCmd struct {
+	Cmd *cobra.Command
+}
+
+// listedImage is one image of the list output. Images and versions are
+// sorted by name, so the JSON form is stable between runs.
+type listedImage struct {
+	Image    string `json:"image"`
+	Path     string `json:"path"`
+	Disabled bool   `json:"disabled,omitempty"`
+	// NoWorkflow is set when none of the image's versions gets a workflow
+	// job because of workflow.enabled: false.
+	NoWorkflow bool `json:"no_workflow,omitempty"`
+	// Origin is the manifest file and line the image is defined at, set
+	// with --origins.
+	Origin   string          `json:"origin,omitempty"`
+	Versions []listedVersion `json:"versions"`
+}
+
+type listedVersion struct {
+	Name       string `json:"name"`
+	Variant    string `json:"variant,omitempty"`
+	BaseImage  string `json:"base_image,omitempty"`
+	Disabled   bool   `json:"disabled,omitempty"`
+	NoWorkflow bool   `json:"no_workflow,omitempty"`
+	Origin     string `json:"origin,omitempty"`
+}
+
+func newListCmd() *listCmd {
+	root := &listCmd{}
+	var format string
+	var origins bool
+	cmd := &cobra.Command{
+		Use:   "list [image]",
+		Short: "List the images and versions in the manifest",
+		Long:  "Print every image of the manifest, or the one named, with its path, generated versions and base images. Images and versions excluded from workflow generation with workflow.enabled: false are marked no workflow",
+		Example: `  # List all images
+  dockerfiles list
+
+  # One image as JSON
+  dockerfiles list core --format json
+
+  # Where each image and version is defined, across included manifests
+  dockerfiles list --origins`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeImage,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unsupported format %q (supported: text, json)", format)
+			}
+
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			images, err := listImages(cfg, args, origins)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if format == "json" {
+				return writeJSON(out, images)
+			}
+
+			if origins {
+				table := ui.Table{Headers: []string{"IMAGE", "VERSION", "ORIGIN"}}
+				for _, image := range images {
+					table.Rows = append(table.Rows, []string{image.Image, "", image.Origin})
+					for _, version := range image.Versions {
+						table.Rows = append(table.Rows, []string{image.Image, version.Name, version.Origin})
+					}
+				}
+				_, _ = fmt.Fprint(out, ui.NewRenderer(out).Table(table))
+				return nil
+			}
+
+			table := ui.Table{Headers: []string{"IMAGE", "PATH", "VERSIONS", "BASE IMAGE"}}
+			for _, image := range images {
+				var versions, baseImages []string
+				seen := make(map[string]bool)
+				for _, version := range image.Versions {
+					switch {
+					case version.Disabled && !image.Disabled:
+						versions = append(versions, version.Name+" (disabled)")
+					case version.NoWorkflow && !image.Disabled && !image.NoWorkflow:
+						versions = append(versions, version.Name+" (no workflow)")
+					default:
+						versions = append(versions, version.Name)
+					}
+					if version.BaseImage != "" && !seen[version.BaseImage] {
+						seen[version.BaseImage] = true
+						baseImages = append(baseImages, version.BaseImage)
+					}
+				}
+				name := image.Image
+				switch {
+				case image.Disabled:
+					name += " (disabled)"
+				case image.NoWorkflow:
+					name += " (no workflow)"
+				}
+				table.Rows = append(table.Rows, []string{name, image.Path, strings.Join(versions, ", "), strings.Join(baseImages, ", ")})
+			}
+			_, _ = fmt.Fprint(out, ui.NewRenderer(out).Table(table))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "text", "Output format (text, json)")
+	cmd.Flags().BoolVar(&origins, "origins", false, "Print the manifest file and line each image and version is defined at")
+
+	root.Cmd = cmd
+	return root
+}
+
+// listImages describes every image of cfg, or the one named in args,
+// suggesting close matches for an unknown name. With origins, each image and
+// version records where it is defined.
+func listImages(cfg *config.Config, args []string, origins bool) ([]listedImage, error) {
+	imageNames := make([]string, 0, len(cfg.Images))
+	for imageName := range cfg.Images {
+		imageNames = append(imageNames, imageName)
+	}
+	sort.Strings(imageNames)
+
+	if len(args) == 1 {
+		if _, exists := cfg.Images[args[0]]; !exists {
+			return nil, unknownImage(args[0], imageNames)
+		}
+		imageNames = args
+	}
+
+	images := make([]listedImage, 0, len(imageNames))
+	for _, imageName := range imageNames {
+		image := cfg.Images[imageName]
+		listed := listedImage{Image: imageName, Path: image.Path, Disabled: !image.IsEnabled(), Versions: []listedVersion{}}
+
+		outputs := image.OutputVersions()
+		sort.Slice(outputs, func(i, j int) bool { return outputs[i].Name < outputs[j].Name })
+		for _, output := range outputs {
+			merged, err := generator.MergedConfig(cfg, imageName, output.Name)
+			if err != nil {
+				return nil, err
+			}
+			version := listedVersion{
+				Name:       output.Name,
+				Variant:    output.Variant,
+				Disabled:   !image.VersionEnabled(output.Name),
+				NoWorkflow: !image.WorkflowEnabled(output.Name),
+			}
+			if merged.BaseImage != nil {
+				version.BaseImage = merged.BaseImage.Name
+			}
+			if origins {
+				version.Origin = originString(image.VersionOrigin(output.Version))
+			}
+			listed.Versions = append(listed.Versions, version)
+		}
+		if origins {
+			listed.Origin = originString(image.Origin)
+		}
+		listed.NoWorkflow = len(listed.Versions) > 0
+		for _, version := range listed.Versions {
+			listed.NoWorkflow = listed.NoWorkflow && version.NoWorkflow
+		}
+		images = append(images, listed)
+	}
+	return images, nil
+}
+
+// originString formats origin for output, with the file relative to the
+// working directory when it is below it. Images not loaded from a manifest
+// have no origin.
+func originString(origin config.Origin) string {
+	if origin.IsZero() {
+		return ""
+	}
+	if origin.File != "" {
+		origin.File = displayPath(origin.File)
+	}
+	return origin.String()
+}
+
+// unknownImage reports an image name that is not in the manifest, suggesting
+// close matches among imageNames.
+func unknownImage(name string, imageNames []string) error {
+	if matches := suggest.Closest(name, imageNames, 3); len(matches) > 0 {
+		return fmt.Errorf("unknown image %q (did you mean %s?)", name, strings.Join(matches, ", "))
+	}
+	return fmt.Errorf("unknown image %q", name)
+}
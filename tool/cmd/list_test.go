@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeManifests writes files, keyed by path relative to a new directory,
+// and returns the path of manifest.yaml there.
+func writeManifests(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for path, content := range files {
+		full := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	return filepath.Join(dir, "manifest.yaml")
+}
+
+// runCommand runs the tool with args and returns its standard output.
+func runCommand(t *testing.T, args ...string) string {
+	t.Helper()
+	root := newRootCmd()
+	var out bytes.Buffer
+	root.cmd.SetOut(&out)
+	if err := root.Execute(context.Background(), args); err != nil {
+		t.Fatalf("%s: %v", strings.Join(args, " "), err)
+	}
+	return out.String()
+}
+
+func TestList_NoWorkflow(t *testing.T) {
+	manifest := writeManifests(t, map[string]string{"manifest.yaml": `version: 1
+images:
+  core:
+    path: core
+    versions:
+      v1: {}
+      v2:
+        workflow:
+          enabled: false
+  docs:
+    path: docs
+    workflow:
+      enabled: false
+    versions:
+      v1: {}
+`})
+
+	var images []listedImage
+	if err := json.Unmarshal([]byte(runCommand(t, "list", "-c", manifest, "--format", "json")), &images); err != nil {
+		t.Fatalf("list --format json printed invalid JSON: %v", err)
+	}
+	if len(images) != 2 || images[0].NoWorkflow || images[0].Versions[0].NoWorkflow || !images[0].Versions[1].NoWorkflow {
+		t.Errorf("list --format json = %+v, want only core:v2 without a workflow", images)
+	}
+	if !images[1].NoWorkflow || !images[1].Versions[0].NoWorkflow {
+		t.Errorf("list --format json = %+v, want docs without a workflow", images)
+	}
+
+	text := runCommand(t, "list", "-c", manifest)
+	for _, want := range []string{"v1, v2 (no workflow)", "docs (no workflow)"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("list printed\n%s\nwant it to contain %q", text, want)
+		}
+	}
+}
+
+func TestList_Origins(t *testing.T) {
+	manifest := writeManifests(t, map[string]string{
+		"manifest.yaml": `version: 1
+includes: ["lang/manifest.yaml"]
+images:
+  core:
+    path: core
+    versions:
+      bookworm: {}
+`,
+		"lang/manifest.yaml": `images:
+  python:
+    path: lang/python
+    versions:
+      "3.12": {}
+      "3.13":
+        python_version: "3.13"
+`,
+	})
+	dir := filepath.Dir(manifest)
+	included := filepath.Join(dir, "lang", "manifest.yaml")
+
+	var images []listedImage
+	if err := json.Unmarshal([]byte(runCommand(t, "list", "-c", manifest, "--origins", "--format", "json")), &images); err != nil {
+		t.Fatalf("list --origins --format json printed invalid JSON: %v", err)
+	}
+	var got []string
+	for _, image := range images {
+		got = append(got, image.Image+" "+image.Origin)
+		for _, version := range image.Versions {
+			got = append(got, image.Image+":"+version.Name+" "+version.Origin)
+		}
+	}
+	want := []string{
+		"core " + manifest + ":4",
+		"core:bookworm " + manifest + ":7",
+		"python " + included + ":2",
+		"python:3.12 " + included + ":5",
+		"python:3.13 " + included + ":6",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("list --origins =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	text := runCommand(t, "list", "-c", manifest, "--origins")
+	if !strings.Contains(text, included+":6") {
+		t.Errorf("list --origins printed\n%s\nwant python 3.13's origin", text)
+	}
+	if plain := runCommand(t, "list", "-c", manifest, "--format", "json"); strings.Contains(plain, "origin") {
+		t.Errorf("list without --origins printed origins:\n%s", plain)
+	}
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/promote"
+)
+
+type promoteCmd struct {
+	Cmd *cobra.Command
+}
+
+func newPromoteCmd() *promoteCmd {
+	root := &promoteCmd{}
+	var digest, tool, format string
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "promote <image>:<version>",
+		Short: "Copy a built image from the promotion source to its destination registry",
+		Long:  "Copy an image version between the registry namespaces configured under promotion, optionally pinned to a digest that the source tag must match",
+		Example: `  # Promote python:3.12 from staging to prod
+  dockerfiles promote python:3.12
+
+  # Pin the digest and only show what would be copied
+  dockerfiles promote python:3.12 --digest sha256:abc... --dry-run --format json`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeImageVersion(true),
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// Disable logging when writing JSON to stdout
+			if format == "json" {
+				log.SetLevel(log.FatalLevel)
+			}
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unsupported format %q (supported: text, json)", format)
+			}
+
+			imageName, version, ok := strings.Cut(args[0], ":")
+			if !ok || imageName == "" || version == "" {
+				return fmt.Errorf("expected <image>:<version>, got %q", args[0])
+			}
+
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			plan, err := promote.NewPlan(cfg, imageName, version, digest)
+			if err != nil {
+				return err
+			}
+
+			copier, err := promote.NewCopier(tool)
+			if err != nil {
+				return err
+			}
+
+			result, err := promote.Promote(cmd.Context(), copier, plan, dryRun)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if format == "json" {
+				return writeJSON(out, result)
+			}
+
+			verb := "promoted"
+			if result.DryRun {
+				verb = "would promote"
+			}
+			_, _ = fmt.Fprintf(out, "%s %s -> %s", verb, result.Source, result.Destination)
+			if result.Digest != "" {
+				_, _ = fmt.Fprintf(out, " (%s)", result.Digest)
+			}
+			_, _ = fmt.Fprintln(out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&digest, "digest", "", "Expected sha256 digest of the source image; the copy is pinned to it")
+	cmd.Flags().StringVar(&tool, "tool", promote.ToolCrane, "Tool used to copy images (crane, skopeo)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the promotion without contacting any registry")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format (text, json)")
+
+	root.Cmd = cmd
+	return root
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+)
+
+type renderCmd struct {
+	Cmd *cobra.Command
+}
+
+func newRenderCmd() *renderCmd {
+	root := &renderCmd{}
+	var imageName, versionName string
+	var setValues, setStringValues, setFileValues []string
+	cmd := &cobra.Command{
+		Use:   "render <template>",
+		Short: "Render one template to standard output",
+		Long:  "Render a template with the merged values of an image version, exactly as generate image would, and print the result without writing anything",
+		Example: `  # Print the Dockerfile core noble is generated from
+  dockerfiles render images/base/core/source/Dockerfile.tmpl --image core --version noble
+
+  # Try a value without editing the manifest
+  dockerfiles render images/lang/python/source/Dockerfile.tmpl --image python --version 3.13 --set python_version=3.13.0rc1`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if err := checkImageName(cfg, imageName); err != nil {
+				return err
+			}
+
+			overrides, err := config.ParseOverrides(setValues, setStringValues, setFileValues)
+			if err != nil {
+				return err
+			}
+			if len(overrides) > 0 {
+				log.Warnf("applying CLI overrides: %s", strings.Join(config.OverrideKeys(overrides), ", "))
+				cfg.Defaults.Overrides = overrides
+			}
+
+			content, err := generator.RenderTemplate(cmd.Context(), cfg, imageName, versionName, args[0])
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprint(cmd.OutOrStdout(), content)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&imageName, "image", "", "Image whose values the template is rendered with")
+	cmd.Flags().StringVar(&versionName, "version", "", "Version, or variant output, whose values the template is rendered with")
+	cmd.Flags().StringArrayVar(&setValues, "set", nil, "Override a value after merging (key=value, dotted keys create nested maps)")
+	cmd.Flags().StringArrayVar(&setStringValues, "set-string", nil, "Override a value, always as a string (key=value)")
+	cmd.Flags().StringArrayVar(&setFileValues, "set-file", nil, "Override a value with a file's contents (key=path)")
+	_ = cmd.MarkFlagRequired("image")
+	_ = cmd.RegisterFlagCompletionFunc("image", func(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return imageNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+	})
+	_ = cmd.RegisterFlagCompletionFunc("version", func(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return versionNames(imageName, toComplete), cobra.ShellCompDirectiveNoFileComp
+	})
+	_ = cmd.MarkFlagRequired("version")
+	_ = cmd.MarkFlagFilename("set-file")
+
+	root.Cmd = cmd
+	return root
+}
@@ -1,26 +1,92 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/apex/log"
+	"github.com/apex/log/handlers/json"
 	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
 )
 
 var (
-	configFile string
+	configFiles []string
+	// jsonLogs is set by --log-format json.
+	jsonLogs bool
+)
+
+// Log formats accepted by --log-format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
 )
 
 type rootCmd struct {
-	cmd   *cobra.Command
-	debug bool
+	cmd            *cobra.Command
+	debug          bool
+	quiet          bool
+	logFormat      string
+	ignoreRequires bool
+	noRemote       bool
 }
 
 func Execute(args []string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	cmd := newRootCmd()
-	if err := cmd.Execute(args); err != nil {
-		os.Exit(1)
+	if err := cmd.Execute(ctx, args); err != nil {
+		stop()
+		os.Exit(exitCode(err))
+	}
+}
+
+// Exit codes other than 0. Commands return an exitError to pick one; any
+// other error exits with exitFailure.
+const (
+	// exitFailure is a failure to generate, build or otherwise complete
+	// the command.
+	exitFailure = 1
+	// exitConfig is a manifest that cannot be loaded.
+	exitConfig = 2
+	// exitCheck is a validation problem, failed template test or drift
+	// found by a --check.
+	exitCheck = 3
+)
+
+// exitCode is the process exit code for err.
+func exitCode(err error) int {
+	var exit *exitError
+	if errors.As(err, &exit) {
+		return exit.Code
+	}
+	return exitFailure
+}
+
+// exitError ends the process with Code instead of exitFailure. Err, when
+// set, is logged first; without it the command has already reported its
+// result.
+type exitError struct {
+	Code int
+	Err  error
+}
+
+func (e *exitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
 	}
+	return e.Err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.Err
 }
 
 func newRootCmd() *rootCmd {
@@ -32,34 +98,133 @@ func newRootCmd() *rootCmd {
 		SilenceErrors:     true,
 		Args:              cobra.NoArgs,
 		ValidArgsFunction: cobra.NoFileCompletions,
-		PersistentPreRun: func(*cobra.Command, []string) {
+		Version:           toolVersion(),
+		Long:              "Generate and manage Docker base images from templates.\n\nExit codes: 0 on success, 1 when generating or another step fails, 2 when the manifest cannot be loaded and 3 when validation, template tests or a --check find problems",
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			config.IgnoreRequires = root.ignoreRequires
+			config.NoRemote = root.noRemote
+			switch root.logFormat {
+			case logFormatText:
+			case logFormatJSON:
+				log.SetHandler(json.New(os.Stderr))
+				jsonLogs = true
+			default:
+				return fmt.Errorf("unsupported log format %q (supported: %s, %s)", root.logFormat, logFormatText, logFormatJSON)
+			}
+			if root.quiet {
+				log.SetLevel(log.WarnLevel)
+			}
 			if root.debug {
 				log.SetLevel(log.DebugLevel)
 				log.Debug("verbose output enabled")
 			}
+			return nil
 		},
-		PersistentPostRun: func(*cobra.Command, []string) {
+		PersistentPostRun: func(cmd *cobra.Command, _ []string) {
+			// Completion scripts are usually sourced from a shell profile.
+			if cmd.HasParent() && cmd.Parent().Name() == "completion" {
+				return
+			}
 			log.Info("thanks for using Dockerfiles!")
 		},
 	}
-	cmd.CompletionOptions.DisableDefaultCmd = true
-	cmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "Load configuration from file")
+	// Run the persistent hooks of every parent, so the logging flags apply
+	// to subcommands with hooks of their own.
+	cobra.EnableTraverseRunHooks = true
+	cmd.PersistentFlags().StringArrayVarP(&configFiles, "config", "c", nil, "Load configuration from file (repeat for independent projects where supported)")
 	_ = cmd.MarkFlagFilename("config", "yaml", "yml")
 	cmd.PersistentFlags().BoolVar(&root.debug, "debug", false, "Enable debug logging and verbose output")
+	cmd.PersistentFlags().BoolVarP(&root.quiet, "quiet", "q", false, "Only log warnings and errors")
+	cmd.MarkFlagsMutuallyExclusive("debug", "quiet")
+	cmd.PersistentFlags().StringVar(&root.logFormat, "log-format", logFormatText, "Log format on standard error ("+logFormatText+", "+logFormatJSON+" for one JSON event per line)")
+	cmd.PersistentFlags().BoolVar(&root.ignoreRequires, "ignore-requires", false, "Warn instead of failing when the manifest requires a newer tool version")
+	cmd.PersistentFlags().BoolVar(&root.noRemote, "no-remote", false, "Ignore defaults.preset instead of fetching it")
 
 	cmd.AddCommand(
 		newGeneratorCmd().Cmd,
 		newCleanCmd().Cmd,
+		newValidateCmd().Cmd,
+		newFixHeadersCmd().Cmd,
+		newImpactCmd().Cmd,
+		newGraphCmd().Cmd,
+		newListCmd().Cmd,
+		newValuesCmd().Cmd,
+		newInitCmd().Cmd,
+		newBumpCmd().Cmd,
+		newChangedCmd().Cmd,
+		newBuildCmd().Cmd,
+		newDiffCmd().Cmd,
+		newRenderCmd().Cmd,
+		newTestCmd().Cmd,
+		newPromoteCmd().Cmd,
 	)
 	root.cmd = cmd
 	return root
 }
 
-func (cmd *rootCmd) Execute(args []string) error {
+// loadConfig loads the manifest for commands that work on a single project.
+func loadConfig(ctx context.Context) (*config.Config, error) {
+	if len(configFiles) > 1 {
+		return nil, fmt.Errorf("this command takes a single --config, got %d", len(configFiles))
+	}
+	path := ""
+	if len(configFiles) == 1 {
+		path = configFiles[0]
+	}
+	cfg, err := config.LoadContext(ctx, path)
+	if err != nil {
+		return nil, &exitError{Code: exitConfig, Err: err}
+	}
+	return cfg, nil
+}
+
+// loadConfigs loads every manifest given with --config, or the default one.
+// Each is an independent project with its own base path.
+func loadConfigs(ctx context.Context) ([]*config.Config, error) {
+	if len(configFiles) <= 1 {
+		cfg, err := loadConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []*config.Config{cfg}, nil
+	}
+
+	cfgs := make([]*config.Config, 0, len(configFiles))
+	for _, path := range configFiles {
+		cfg, err := config.LoadContext(ctx, path)
+		if err != nil {
+			return nil, &exitError{Code: exitConfig, Err: fmt.Errorf("loading %s: %w", path, err)}
+		}
+		cfgs = append(cfgs, cfg)
+	}
+	return cfgs, nil
+}
+
+// toolVersion is the version reported by --version.
+func toolVersion() string {
+	if version := config.ToolVersion(); version != "" {
+		return version
+	}
+	return "unknown"
+}
+
+func (cmd *rootCmd) Execute(ctx context.Context, args []string) error {
 	cmd.cmd.SetArgs(args)
 
-	if err := cmd.cmd.Execute(); err != nil {
-		log.WithError(err).Error("command failed")
+	if err := cmd.cmd.ExecuteContext(ctx); err != nil {
+		var exit *exitError
+		if errors.As(err, &exit) && exit.Err == nil {
+			return err
+		}
+		// Commands writing JSON to stdout silence logging; the error is
+		// still reported, once, on stderr.
+		log.SetLevel(log.ErrorLevel)
+		var fileErr *generator.FileError
+		if errors.As(err, &fileErr) {
+			logFileError(err)
+		} else {
+			log.WithError(err).Error("command failed")
+		}
 		return err
 	}
 
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/harness"
+)
+
+type testCmd struct {
+	Cmd *cobra.Command
+}
+
+func newTestCmd() *testCmd {
+	root := &testCmd{}
+	cmd := &cobra.Command{
+		Use:   "test [image-name]",
+		Short: "Run template test cases declared in source/tests.yaml",
+		Long:  "Render templates in memory for the cases declared in each image's source/tests.yaml and check the contains, not_contains and matches expectations",
+		Example: `  # Run every image's template tests
+  dockerfiles test
+
+  # Run the tests of a single image
+  dockerfiles test python`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeImage,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			var results []harness.Result
+			if len(args) == 1 {
+				results, err = harness.Run(cfg, args[0])
+			} else {
+				results, err = harness.RunAll(cfg)
+			}
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			failed := 0
+			for _, result := range results {
+				if result.Passed() {
+					_, _ = fmt.Fprintf(out, "PASS %s: %s\n", result.Image, result.Case)
+					continue
+				}
+				failed++
+				_, _ = fmt.Fprintf(out, "FAIL %s: %s\n", result.Image, result.Case)
+				for _, failure := range result.Failures {
+					_, _ = fmt.Fprintf(out, "  %s\n", failure)
+				}
+			}
+
+			if failed > 0 {
+				return &exitError{Code: exitCheck, Err: fmt.Errorf("%d of %d template tests failed", failed, len(results))}
+			}
+			_, _ = fmt.Fprintf(out, "%d template tests passed\n", len(results))
+			return nil
+		},
+	}
+
+	root.Cmd = cmd
+	return root
+}
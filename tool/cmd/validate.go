@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/apex/log"
+	"github.com/spf13/cobra"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+	"github.com/mberwanger/dockerfiles/tool/internal/harness"
+	"github.com/mberwanger/dockerfiles/tool/internal/ui"
+	"github.com/mberwanger/dockerfiles/tool/internal/workflow"
+)
+
+type validateCmd struct {
+	Cmd *cobra.Command
+}
+
+func newValidateCmd() *validateCmd {
+	root := &validateCmd{}
+	cmd := &cobra.Command{
+		Use:               "validate",
+		Short:             "Check the manifest for problems without generating anything",
+		Long:              "Load the manifest and run semantic checks and template tests, reporting every problem with the image and version it belongs to",
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: cobra.NoFileCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			problems := config.Validate(cfg)
+
+			results, err := harness.RunAll(cfg)
+			if err != nil {
+				return err
+			}
+			for _, result := range results {
+				for _, failure := range result.Failures {
+					problems = append(problems, config.Problem{
+						Image:   result.Image,
+						Version: result.Version,
+						Message: fmt.Sprintf("template test %q: %s", result.Case, failure),
+					})
+				}
+			}
+
+			mismatches, err := workflow.VerifyDependenciesContext(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			for _, mismatch := range mismatches {
+				image := cfg.Images[mismatch.Image]
+				version := mismatch.Version
+				if output, ok := image.OutputVersion(version); ok {
+					version = output.Version
+				}
+				problems = append(problems, config.Problem{
+					Image:   mismatch.Image,
+					Version: mismatch.Version,
+					Origin:  image.VersionOrigin(version),
+					Message: mismatch.Message(),
+				})
+			}
+
+			missing, err := generator.MissingHeaders(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			for _, m := range missing {
+				problems = append(problems, config.Problem{
+					Image:   m.Image,
+					Version: m.Version,
+					Origin:  cfg.Images[m.Image].VersionOrigin(m.Version),
+					Message: fmt.Sprintf("%s renders without the generated header; run fix-headers --fix", m.Template),
+				})
+			}
+
+			if len(problems) > 0 {
+				table := ui.Table{Headers: []string{"IMAGE", "VERSION", "ORIGIN", "PROBLEM"}}
+				for _, problem := range problems {
+					origin := ""
+					if !problem.Origin.IsZero() {
+						origin = problem.Origin.String()
+					}
+					table.Rows = append(table.Rows, []string{problem.Image, problem.Version, origin, problem.Message})
+				}
+				out := cmd.OutOrStdout()
+				_, _ = fmt.Fprint(out, ui.NewRenderer(out).Table(table))
+				return &exitError{Code: exitCheck, Err: fmt.Errorf("validation failed with %d problem(s)", len(problems))}
+			}
+
+			log.Infof("validated %d images successfully", len(cfg.Images))
+			return nil
+		},
+	}
+
+	root.Cmd = cmd
+	return root
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+)
+
+type valuesCmd struct {
+	Cmd *cobra.Command
+}
+
+// originValue is one value of the JSON output with --origin.
+type originValue struct {
+	Value  interface{} `json:"value"`
+	Origin string      `json:"origin"`
+}
+
+func newValuesCmd() *valuesCmd {
+	root := &valuesCmd{}
+	var format string
+	var origin bool
+	cmd := &cobra.Command{
+		Use:   "values <image> <version>",
+		Short: "Show the values an image version is rendered with",
+		Long:  "Print the values templates of an image version see: the global defaults, image defaults, version block and variant merged exactly as generate image merges them, with the injected version, registry and image_name",
+		Example: `  # The merged values of python 3.13
+  dockerfiles values python 3.13
+
+  # Where each value comes from, as JSON
+  dockerfiles values python 3.13-slim --origin --format json`,
+		Args: cobra.ExactArgs(2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 1 {
+				return versionNames(args[0], toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeImage(cmd, args, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "yaml" && format != "json" {
+				return fmt.Errorf("unsupported format %q (supported: yaml, json)", format)
+			}
+
+			cfg, err := loadConfig(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if err := checkImageName(cfg, args[0]); err != nil {
+				return err
+			}
+
+			merged, err := generator.MergedConfig(cfg, args[0], args[1])
+			if err != nil {
+				return err
+			}
+			values := generator.NewTemplateData(cfg, args[0], merged).Values
+
+			var origins map[string]string
+			if origin {
+				if origins, err = generator.ValueOrigins(cfg, args[0], args[1]); err != nil {
+					return err
+				}
+			}
+
+			out := cmd.OutOrStdout()
+			if format == "json" {
+				if origins == nil {
+					return writeJSON(out, values)
+				}
+				annotated := make(map[string]originValue, len(values))
+				for key, value := range values {
+					annotated[key] = originValue{Value: value, Origin: origins[key]}
+				}
+				return writeJSON(out, annotated)
+			}
+			return writeValuesYAML(out, values, origins)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "yaml", "Output format (yaml, json)")
+	cmd.Flags().BoolVar(&origin, "origin", false, "Annotate each value with the layer it came from")
+
+	root.Cmd = cmd
+	return root
+}
+
+// writeValuesYAML writes values with sorted keys, each followed by a comment
+// naming its origin when origins is set.
+func writeValuesYAML(w io.Writer, values map[string]interface{}, origins map[string]string) error {
+	var node yaml.Node
+	if err := node.Encode(values); err != nil {
+		return fmt.Errorf("encoding values: %w", err)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		node.Content[i].LineComment = origins[node.Content[i].Value]
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return fmt.Errorf("encoding values: %w", err)
+	}
+	return encoder.Close()
+}
+
+// checkImageName fails for an image not in cfg, suggesting close matches.
+func checkImageName(cfg *config.Config, name string) error {
+	if _, exists := cfg.Images[name]; exists {
+		return nil
+	}
+	imageNames := make([]string, 0, len(cfg.Images))
+	for imageName := range cfg.Images {
+		imageNames = append(imageNames, imageName)
+	}
+	sort.Strings(imageNames)
+	return unknownImage(name, imageNames)
+}
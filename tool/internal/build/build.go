@@ -0,0 +1,348 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/metrics"
+	"github.com/mberwanger/dockerfiles/tool/internal/workflow"
+)
+
+// Executor runs a command, streaming its output to out.
+type Executor func(ctx context.Context, out io.Writer, name string, args ...string) error
+
+// ExecExecutor runs commands as processes in the working directory.
+func ExecExecutor(ctx context.Context, out io.Writer, name string, args ...string) error {
+	command := exec.CommandContext(ctx, name, args...)
+	command.Stdout = out
+	command.Stderr = out
+	return command.Run()
+}
+
+// Options controls a build run.
+type Options struct {
+	// Registry prefixes every tag and is passed as the REGISTRY build
+	// argument, so FROM ${REGISTRY}/... lines resolve to the images built
+	// before.
+	Registry string
+	// Push pushes the images for every platform of the job; without it they
+	// are loaded into the local image store for the host platform.
+	Push bool
+	// Concurrency is how many independent jobs build at once. Values below
+	// one build them one at a time.
+	Concurrency int
+	// DryRun writes the commands to Out instead of running them.
+	DryRun bool
+	// Out receives build output, each line prefixed with the job ID.
+	Out  io.Writer
+	Exec Executor
+	// Reporter reports each job as it builds. Nil only logs.
+	Reporter *Reporter
+}
+
+// Reporter is the single path jobs are reported through: it logs them and,
+// with Metrics set, records them for the metrics endpoint. A nil Reporter
+// only logs.
+type Reporter struct {
+	Metrics *metrics.Metrics
+}
+
+// Started reports that job starts building.
+func (r *Reporter) Started(job workflow.Job) {
+	log.Infof("building %s:%s", job.ImageName, job.Version)
+}
+
+// Skipped reports that job is not built because cause, a job it needs,
+// did not build.
+func (r *Reporter) Skipped(job workflow.Job, cause string) {
+	log.Warnf("skipping %s: %s did not build", job.ID, cause)
+}
+
+// Finished reports that job finished building after duration, failing with
+// err. The failure itself is returned by Build and logged by the caller.
+func (r *Reporter) Finished(job workflow.Job, duration time.Duration, err error) {
+	log.WithField("duration_ms", duration.Milliseconds()).Debugf("built %s:%s", job.ImageName, job.Version)
+	if r != nil && r.Metrics != nil {
+		r.Metrics.ObserveBuild(job.ImageName, job.Version, duration, time.Now(), err)
+	}
+}
+
+// Select returns the jobs of targets, "image:version" names, together with
+// everything they transitively need, in the order of jobs.
+func Select(jobs []workflow.Job, targets []string) ([]workflow.Job, error) {
+	byNode := make(map[string]workflow.Job, len(jobs))
+	byID := make(map[string]workflow.Job, len(jobs))
+	for _, job := range jobs {
+		byNode[job.ImageName+":"+job.Version] = job
+		byID[job.ID] = job
+	}
+
+	selected := make(map[string]bool)
+	var visit func(job workflow.Job)
+	visit = func(job workflow.Job) {
+		if selected[job.ID] {
+			return
+		}
+		selected[job.ID] = true
+		for _, need := range job.Needs {
+			visit(byID[need])
+		}
+	}
+	for _, target := range targets {
+		job, exists := byNode[target]
+		if !exists {
+			return nil, fmt.Errorf("%s has no build job (is it disabled?)", target)
+		}
+		visit(job)
+	}
+
+	var ordered []workflow.Job
+	for _, job := range jobs {
+		if selected[job.ID] {
+			ordered = append(ordered, job)
+		}
+	}
+	return ordered, nil
+}
+
+// Command returns the docker arguments that build job from its version
+// directory.
+func Command(cfg *config.Config, job workflow.Job, opts Options) ([]string, error) {
+	imagePath, err := cfg.ImagePath(cfg.Images[job.ImageName])
+	if err != nil {
+		return nil, err
+	}
+	contextDir := relative(filepath.Join(imagePath, job.Version))
+
+	args := []string{"buildx", "build", "--file", filepath.Join(contextDir, "Dockerfile")}
+	for _, tag := range append([]string{job.Version}, job.Aliases...) {
+		args = append(args, "--tag", fmt.Sprintf("%s/%s:%s", opts.Registry, job.ImageName, tag))
+	}
+	args = append(args, "--build-arg", "REGISTRY="+opts.Registry)
+	if opts.Push {
+		if len(job.Platforms) > 0 {
+			args = append(args, "--platform", strings.Join(job.Platforms, ","))
+		}
+		args = append(args, "--push")
+	} else {
+		args = append(args, "--load")
+	}
+	return append(args, contextDir), nil
+}
+
+// PrepareCommand returns the bash arguments that run job's prepare commands
+// in its version directory, with IMAGE, VERSION and CONTEXT set as in the
+// workflow, or nil when it has none.
+func PrepareCommand(cfg *config.Config, job workflow.Job) ([]string, error) {
+	if len(job.Prepare) == 0 {
+		return nil, nil
+	}
+	imagePath, err := cfg.ImagePath(cfg.Images[job.ImageName])
+	if err != nil {
+		return nil, err
+	}
+	versionDir := filepath.Join(imagePath, job.Version)
+	absDir, err := filepath.Abs(versionDir)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []string{
+		"cd " + shellQuote(relative(versionDir)),
+		fmt.Sprintf("export IMAGE=%s VERSION=%s CONTEXT=%s", shellQuote(job.ImageName), shellQuote(job.Version), shellQuote(absDir)),
+	}
+	lines = append(lines, job.Prepare...)
+	return []string{"-eo", "pipefail", "-c", strings.Join(lines, "\n")}, nil
+}
+
+// Build builds jobs, which must be in dependency order, starting each once
+// everything it needs has built, up to opts.Concurrency at a time. A failed
+// job does not stop the jobs that do not need it; those that do are skipped.
+// A job's prepare commands run before it builds, and their failure fails the
+// job. Every failure is returned together.
+func Build(ctx context.Context, cfg *config.Config, jobs []workflow.Job, opts Options) error {
+	commands := make(map[string][]string, len(jobs))
+	prepares := make(map[string][]string, len(jobs))
+	for _, job := range jobs {
+		args, err := Command(cfg, job, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", job.ID, err)
+		}
+		commands[job.ID] = args
+		if prepares[job.ID], err = PrepareCommand(cfg, job); err != nil {
+			return fmt.Errorf("%s: %w", job.ID, err)
+		}
+	}
+
+	if opts.DryRun {
+		for _, job := range jobs {
+			if prepare := prepares[job.ID]; prepare != nil {
+				_, _ = fmt.Fprintln(opts.Out, shellCommand("bash", prepare))
+			}
+			_, _ = fmt.Fprintln(opts.Out, shellCommand("docker", commands[job.ID]))
+		}
+		return nil
+	}
+
+	output := &lockedWriter{w: opts.Out}
+	done := make(map[string]chan struct{}, len(jobs))
+	for _, job := range jobs {
+		done[job.ID] = make(chan struct{})
+	}
+	var mu sync.Mutex
+	failed := make(map[string]bool)
+	errs := make([]error, len(jobs))
+
+	slots := make(chan struct{}, max(opts.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[job.ID])
+
+			// Wait for the needed jobs that are part of this build.
+			for _, need := range job.Needs {
+				if ch, selected := done[need]; selected {
+					<-ch
+				}
+			}
+			mu.Lock()
+			var cause string
+			for _, need := range job.Needs {
+				if failed[need] {
+					cause = need
+					break
+				}
+			}
+			if cause != "" {
+				failed[job.ID] = true
+				mu.Unlock()
+				opts.Reporter.Skipped(job, cause)
+				return
+			}
+			mu.Unlock()
+
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				mu.Lock()
+				failed[job.ID] = true
+				mu.Unlock()
+				errs[i] = fmt.Errorf("%s: %w", job.ID, ctx.Err())
+				return
+			}
+			defer func() { <-slots }()
+
+			opts.Reporter.Started(job)
+			start := time.Now()
+			out := &prefixWriter{w: output, prefix: "[" + job.ID + "] "}
+			var err error
+			if prepare := prepares[job.ID]; prepare != nil {
+				if err = opts.Exec(ctx, out, "bash", prepare...); err != nil {
+					err = fmt.Errorf("preparing %s:%s: %w", job.ImageName, job.Version, err)
+				}
+			}
+			if err == nil {
+				if err = opts.Exec(ctx, out, "docker", commands[job.ID]...); err != nil {
+					err = fmt.Errorf("building %s:%s: %w", job.ImageName, job.Version, err)
+				}
+			}
+			out.Flush()
+			opts.Reporter.Finished(job, time.Since(start), err)
+			if err != nil {
+				mu.Lock()
+				failed[job.ID] = true
+				mu.Unlock()
+				errs[i] = err
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// relative returns path relative to the working directory when it lies
+// below it.
+func relative(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
+
+// shellCommand joins a command line, quoting the words that need it.
+func shellCommand(name string, args []string) string {
+	words := []string{name}
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote quotes word for the shell when it needs it.
+func shellQuote(word string) string {
+	if word == "" || strings.ContainsAny(word, " \t\n'\"\\$`*?[]{}()<>|&;#~") {
+		return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+	}
+	return word
+}
+
+// lockedWriter serializes writes from concurrent builds.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// prefixWriter writes each complete line with prefix, so that the output of
+// concurrent builds stays attributable.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  string
+	pending []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.pending = append(p.pending, b...)
+	for {
+		end := bytes.IndexByte(p.pending, '\n')
+		if end < 0 {
+			return len(b), nil
+		}
+		if _, err := p.w.Write(append([]byte(p.prefix), p.pending[:end+1]...)); err != nil {
+			return 0, err
+		}
+		p.pending = p.pending[end+1:]
+	}
+}
+
+// Flush writes a final line without a trailing newline.
+func (p *prefixWriter) Flush() {
+	if len(p.pending) > 0 {
+		_, _ = p.w.Write([]byte(p.prefix + string(p.pending) + "\n"))
+		p.pending = nil
+	}
+}
@@ -0,0 +1,180 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/metrics"
+	"github.com/mberwanger/dockerfiles/tool/internal/workflow"
+)
+
+func testJobs() []workflow.Job {
+	return []workflow.Job{
+		{ID: "core-v1", ImageName: "core", Version: "v1"},
+		{ID: "tool-v1", ImageName: "tool", Version: "v1"},
+		{ID: "app-v1", ImageName: "app", Version: "v1", Needs: []string{"core-v1"}, Aliases: []string{"latest"}, Platforms: []string{"linux/amd64", "linux/arm64"}},
+		{ID: "web-v1", ImageName: "web", Version: "v1", Needs: []string{"app-v1"}},
+	}
+}
+
+func testConfig() *config.Config {
+	cfg := &config.Config{Defaults: config.Defaults{BasePath: "/repo/images"}, Images: map[string]config.Image{}}
+	for _, name := range []string{"core", "tool", "app", "web"} {
+		cfg.Images[name] = config.Image{Path: name}
+	}
+	return cfg
+}
+
+func TestSelect(t *testing.T) {
+	got, err := Select(testJobs(), []string{"web:v1"})
+	if err != nil {
+		t.Fatalf("Select() error = %v", err)
+	}
+	var ids []string
+	for _, job := range got {
+		ids = append(ids, job.ID)
+	}
+	if want := "core-v1 app-v1 web-v1"; strings.Join(ids, " ") != want {
+		t.Errorf("Select() = %v, want %s", ids, want)
+	}
+
+	if _, err := Select(testJobs(), []string{"web:v2"}); err == nil {
+		t.Error("Select() of an unknown version should fail")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	opts := Options{Registry: "localhost:5000"}
+	args, err := Command(testConfig(), testJobs()[2], opts)
+	if err != nil {
+		t.Fatalf("Command() error = %v", err)
+	}
+	want := "buildx build --file /repo/images/app/v1/Dockerfile --tag localhost:5000/app:v1 --tag localhost:5000/app:latest --build-arg REGISTRY=localhost:5000 --load /repo/images/app/v1"
+	if got := strings.Join(args, " "); got != want {
+		t.Errorf("Command() = %s, want %s", got, want)
+	}
+
+	opts.Push = true
+	args, _ = Command(testConfig(), testJobs()[2], opts)
+	if got := strings.Join(args, " "); !strings.Contains(got, "--platform linux/amd64,linux/arm64 --push") {
+		t.Errorf("Command() = %s, want the platforms pushed", got)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	var mu sync.Mutex
+	var built []string
+	exec := func(_ context.Context, out io.Writer, name string, args ...string) error {
+		ref := args[len(args)-1]
+		mu.Lock()
+		built = append(built, ref)
+		mu.Unlock()
+		_, _ = fmt.Fprintf(out, "step 1\nstep 2")
+		if strings.Contains(ref, "core") {
+			return fmt.Errorf("exit status 1")
+		}
+		return nil
+	}
+
+	var out bytes.Buffer
+	err := Build(context.Background(), testConfig(), testJobs(), Options{Registry: "r", Concurrency: 2, Out: &out, Exec: exec})
+	if err == nil || !strings.Contains(err.Error(), "building core:v1") {
+		t.Fatalf("Build() error = %v, want the core failure", err)
+	}
+	if len(built) != 2 || !strings.Contains(strings.Join(built, " "), "tool") {
+		t.Errorf("built %v, want core and tool only: app and web need core", built)
+	}
+	if !strings.Contains(out.String(), "[tool-v1] step 1\n[tool-v1] step 2\n") {
+		t.Errorf("output = %q, want lines prefixed with the job ID", out.String())
+	}
+}
+
+func TestBuild_Metrics(t *testing.T) {
+	exec := func(_ context.Context, _ io.Writer, _ string, args ...string) error {
+		if strings.Contains(args[len(args)-1], "core") {
+			return fmt.Errorf("exit status 1")
+		}
+		return nil
+	}
+
+	reporter := &Reporter{Metrics: metrics.New()}
+	opts := Options{Registry: "r", Concurrency: 2, Out: io.Discard, Exec: exec, Reporter: reporter}
+	if err := Build(context.Background(), testConfig(), testJobs(), opts); err == nil {
+		t.Fatal("Build() should report the core failure")
+	}
+
+	var buf bytes.Buffer
+	if err := reporter.Metrics.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	output := buf.String()
+	// app and web are skipped, so only core and tool were built.
+	for _, want := range []string{"dockerfiles_builds_total 2\n", "dockerfiles_build_failures_total 1\n", `{image="tool",version="v1"}`} {
+		if !strings.Contains(output, want) {
+			t.Errorf("metrics are missing %q:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, `image="core"`) {
+		t.Errorf("core failed and should have no last success:\n%s", output)
+	}
+}
+
+func TestBuild_DryRun(t *testing.T) {
+	var out bytes.Buffer
+	exec := func(context.Context, io.Writer, string, ...string) error {
+		t.Error("a dry run should not run commands")
+		return nil
+	}
+	if err := Build(context.Background(), testConfig(), testJobs()[:1], Options{Registry: "r", DryRun: true, Out: &out, Exec: exec}); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	want := "docker buildx build --file /repo/images/core/v1/Dockerfile --tag r/core:v1 --build-arg REGISTRY=r --load /repo/images/core/v1\n"
+	if out.String() != want {
+		t.Errorf("Build() printed %q, want %q", out.String(), want)
+	}
+}
+
+func TestBuild_Prepare(t *testing.T) {
+	jobs := testJobs()
+	jobs[0].Prepare = []string{"curl -fsSLO https://example.com/jdk.tar.gz", "exit 1"}
+	jobs[1].Prepare = []string{"make vendor"}
+
+	var mu sync.Mutex
+	var ran []string
+	exec := func(_ context.Context, _ io.Writer, name string, args ...string) error {
+		mu.Lock()
+		ran = append(ran, name+" "+args[len(args)-1])
+		mu.Unlock()
+		if name == "bash" && strings.Contains(args[len(args)-1], "exit 1") {
+			return fmt.Errorf("exit status 1")
+		}
+		return nil
+	}
+
+	err := Build(context.Background(), testConfig(), jobs, Options{Registry: "r", Concurrency: 2, Out: io.Discard, Exec: exec})
+	if err == nil || !strings.Contains(err.Error(), "preparing core:v1") {
+		t.Fatalf("Build() error = %v, want the core prepare failure", err)
+	}
+	got := strings.Join(ran, "\n")
+	if strings.Contains(got, "docker /repo/images/core/v1") || strings.Contains(got, "app/v1") {
+		t.Errorf("ran\n%s\nwant neither core, whose prepare failed, nor app built", got)
+	}
+	want := "bash cd /repo/images/tool/v1\nexport IMAGE=tool VERSION=v1 CONTEXT=/repo/images/tool/v1\nmake vendor\ndocker /repo/images/tool/v1"
+	if !strings.Contains(got, want) {
+		t.Errorf("ran\n%s\nwant tool prepared in its version directory, then built", got)
+	}
+
+	var out bytes.Buffer
+	if err := Build(context.Background(), testConfig(), jobs[1:2], Options{Registry: "r", DryRun: true, Out: &out, Exec: exec}); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "bash -eo pipefail -c 'cd /repo/images/tool/v1\n") || !strings.Contains(out.String(), "make vendor'\ndocker buildx build") {
+		t.Errorf("Build() printed %q, want the prepare commands before the build", out.String())
+	}
+}
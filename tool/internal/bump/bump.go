@@ -0,0 +1,337 @@
+package bump
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// Update is a base image with a newer patch-level tag upstream.
+type Update struct {
+	Image string `json:"image"`
+	// Version is the manifest version setting the base image, or empty for
+	// the image defaults.
+	Version string `json:"version,omitempty"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Current string `json:"current"`
+	Latest  string `json:"latest"`
+
+	column int
+}
+
+// Check finds the base images of the named images, or of every image when
+// images is empty, that have a newer patch-level tag in their registry.
+// Docker Hub images are always checked and external ones when their host is
+// listed under defaults.bump.registries; images of the manifest are not.
+func Check(ctx context.Context, cfg *config.Config, registry Registry, images []string) ([]Update, error) {
+	if len(images) == 0 {
+		for imageName := range cfg.Images {
+			images = append(images, imageName)
+		}
+	}
+	sort.Strings(images)
+
+	var registries []string
+	if cfg.Defaults.Bump != nil {
+		registries = cfg.Defaults.Bump.Registries
+	}
+	checker := &checker{registry: registry, registries: registries, tags: make(map[string][]string)}
+	documents := make(map[string]*yaml.Node)
+
+	var updates []Update
+	for _, imageName := range images {
+		image, exists := cfg.Images[imageName]
+		if !exists {
+			return nil, fmt.Errorf("image %s not found in config", imageName)
+		}
+		file := image.Origin.File
+		if file == "" || file == "<stdin>" {
+			return nil, fmt.Errorf("bump needs a manifest file, not standard input")
+		}
+		document, cached := documents[file]
+		if !cached {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			document = &yaml.Node{}
+			if err := yaml.Unmarshal(content, document); err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			documents[file] = document
+		}
+
+		for _, site := range baseImages(document, imageName) {
+			latest, err := checker.latest(ctx, site)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", imageName, err)
+			}
+			if latest == "" {
+				continue
+			}
+			updates = append(updates, Update{
+				Image:   imageName,
+				Version: site.version,
+				File:    file,
+				Line:    site.name.Line,
+				Current: site.name.Value,
+				Latest:  latest,
+				column:  site.name.Column,
+			})
+		}
+	}
+	return updates, nil
+}
+
+// Apply rewrites the base image names of updates in place, leaving the rest
+// of each manifest untouched.
+func Apply(updates []Update) error {
+	byFile := make(map[string][]Update)
+	var files []string
+	for _, update := range updates {
+		if _, seen := byFile[update.File]; !seen {
+			files = append(files, update.File)
+		}
+		byFile[update.File] = append(byFile[update.File], update)
+	}
+
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		lines := strings.SplitAfter(string(content), "\n")
+		for _, update := range byFile[file] {
+			if update.Line < 1 || update.Line > len(lines) {
+				return fmt.Errorf("%s:%d: line out of range; was the manifest edited?", file, update.Line)
+			}
+			line := lines[update.Line-1]
+			start := update.column - 1
+			if start < 0 || start > len(line) {
+				start = 0
+			}
+			at := strings.Index(line[start:], update.Current)
+			if at < 0 {
+				return fmt.Errorf("%s:%d: %s not found; was the manifest edited?", file, update.Line, update.Current)
+			}
+			at += start
+			lines[update.Line-1] = line[:at] + update.Latest + line[at+len(update.Current):]
+		}
+		if err := os.WriteFile(file, []byte(strings.Join(lines, "")), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// site is one base_image block of an image in the manifest text.
+type site struct {
+	version string
+	name    *yaml.Node
+	source  string
+	digest  string
+}
+
+// baseImages returns the base_image blocks of the image defaults and
+// versions of imageName in a parsed manifest.
+func baseImages(document *yaml.Node, imageName string) []site {
+	image := mappingValue(mappingValue(documentMapping(document), "images"), imageName)
+
+	var sites []site
+	add := func(version string, block *yaml.Node) {
+		baseImage := mappingValue(block, "base_image")
+		name := mappingValue(baseImage, "name")
+		if name == nil || name.Kind != yaml.ScalarNode {
+			return
+		}
+		s := site{version: version, name: name}
+		if source := mappingValue(baseImage, "source"); source != nil {
+			s.source = source.Value
+		}
+		if digest := mappingValue(baseImage, "digest"); digest != nil {
+			s.digest = digest.Value
+		}
+		sites = append(sites, s)
+	}
+
+	add("", mappingValue(image, "defaults"))
+	if versions := mappingValue(image, "versions"); versions != nil {
+		for i := 0; i+1 < len(versions.Content); i += 2 {
+			add(versions.Content[i].Value, versions.Content[i+1])
+		}
+	}
+	return sites
+}
+
+// checker looks up newer tags, listing each repository once.
+type checker struct {
+	registry   Registry
+	registries []string
+	tags       map[string][]string
+}
+
+// latest returns the newest patch-level successor of the site's base image,
+// or "" if it is current or not checked.
+func (c *checker) latest(ctx context.Context, s site) (string, error) {
+	if strings.Contains(s.name.Value, "$") {
+		return "", nil
+	}
+	repository, tag, found := splitTag(s.name.Value)
+	if !found {
+		return "", nil
+	}
+
+	var host, path string
+	switch s.source {
+	case config.SourceDockerHub:
+		host, path = DockerHubHost, repository
+		if !strings.Contains(path, "/") {
+			path = "library/" + path
+		}
+	case config.SourceExternal:
+		var found bool
+		host, path, found = strings.Cut(repository, "/")
+		if !found || !contains(c.registries, host) {
+			return "", nil
+		}
+	default:
+		return "", nil
+	}
+	if _, ok := parseVersionTag(tag); !ok {
+		return "", nil
+	}
+
+	key := host + "/" + path
+	tags, listed := c.tags[key]
+	if !listed {
+		var err error
+		if tags, err = c.registry.Tags(ctx, host, path); err != nil {
+			return "", err
+		}
+		c.tags[key] = tags
+	}
+
+	newer, ok := newerPatch(tag, tags)
+	if !ok {
+		return "", nil
+	}
+	if s.digest != "" {
+		log.Warnf("%s is pinned by digest; not bumping it to %s", s.name.Value, newer)
+		return "", nil
+	}
+	return repository + ":" + newer, nil
+}
+
+// splitTag splits a reference into its repository and tag. A colon before
+// the last slash is a registry port, not a tag.
+func splitTag(ref string) (string, string, bool) {
+	at := strings.LastIndex(ref, ":")
+	if at < 0 || at < strings.LastIndex(ref, "/") {
+		return ref, "", false
+	}
+	return ref[:at], ref[at+1:], true
+}
+
+// versionTagPattern matches tags bump understands: an optional v, a version
+// of at least three numeric components, and any suffix, e.g. 1.22.3 or
+// 3.12.4-slim-bookworm. Tags with fewer components, such as 3.13 or noble,
+// already float to the newest patch upstream.
+var versionTagPattern = regexp.MustCompile(`^(v?)(\d+(?:\.\d+){2,})(.*)$`)
+
+// versionTag is a tag split by versionTagPattern.
+type versionTag struct {
+	prefix     string
+	components []int
+	suffix     string
+}
+
+func parseVersionTag(tag string) (versionTag, bool) {
+	match := versionTagPattern.FindStringSubmatch(tag)
+	if match == nil {
+		return versionTag{}, false
+	}
+	parsed := versionTag{prefix: match[1], suffix: match[3]}
+	for _, component := range strings.Split(match[2], ".") {
+		n, err := strconv.Atoi(component)
+		if err != nil {
+			return versionTag{}, false
+		}
+		parsed.components = append(parsed.components, n)
+	}
+	return parsed, true
+}
+
+// newerPatch returns the tag among tags with the same pattern as current, the
+// same version but for the last component, and the highest last component
+// above current's.
+func newerPatch(current string, tags []string) (string, bool) {
+	base, ok := parseVersionTag(current)
+	if !ok {
+		return "", false
+	}
+	last := len(base.components) - 1
+
+	best, bestPatch := "", base.components[last]
+	for _, tag := range tags {
+		candidate, ok := parseVersionTag(tag)
+		if !ok || candidate.prefix != base.prefix || candidate.suffix != base.suffix || len(candidate.components) != len(base.components) {
+			continue
+		}
+		sameMinor := true
+		for i := 0; i < last; i++ {
+			if candidate.components[i] != base.components[i] {
+				sameMinor = false
+				break
+			}
+		}
+		if sameMinor && candidate.components[last] > bestPatch {
+			best, bestPatch = tag, candidate.components[last]
+		}
+	}
+	return best, best != ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func documentMapping(root *yaml.Node) *yaml.Node {
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	return root
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package bump
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// stubRegistry serves tag lists keyed by host/repository and records the
+// repositories listed.
+type stubRegistry struct {
+	tags   map[string][]string
+	listed []string
+}
+
+func (r *stubRegistry) Tags(_ context.Context, host, repository string) ([]string, error) {
+	key := host + "/" + repository
+	r.listed = append(r.listed, key)
+	tags, exists := r.tags[key]
+	if !exists {
+		return nil, fmt.Errorf("%s not found", key)
+	}
+	return tags, nil
+}
+
+func TestNewerPatch(t *testing.T) {
+	tags := []string{"1.22", "1.22.3", "1.22.5", "1.22.10", "1.23.0", "1.22.11-alpine", "v1.22.12", "latest"}
+	tests := []struct {
+		current string
+		want    string
+	}{
+		{"1.22.3", "1.22.10"},
+		{"1.22.10", ""},
+		{"1.22.3-alpine", "1.22.11-alpine"},
+		{"v1.22.3", "v1.22.12"},
+		{"1.22", ""},
+		{"noble", ""},
+	}
+	for _, tt := range tests {
+		got, _ := newerPatch(tt.current, tags)
+		if got != tt.want {
+			t.Errorf("newerPatch(%s) = %q, want %q", tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTag(t *testing.T) {
+	tests := []struct {
+		ref, repository, tag string
+		found                bool
+	}{
+		{"golang:1.22.3", "golang", "1.22.3", true},
+		{"localhost:5000/app:1.0.0", "localhost:5000/app", "1.0.0", true},
+		{"localhost:5000/app", "localhost:5000/app", "", false},
+		{"golang", "golang", "", false},
+	}
+	for _, tt := range tests {
+		repository, tag, found := splitTag(tt.ref)
+		if repository != tt.repository || tag != tt.tag || found != tt.found {
+			t.Errorf("splitTag(%s) = %q, %q, %v", tt.ref, repository, tag, found)
+		}
+	}
+}
+
+func TestCheckAndApply(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "manifest.yaml")
+	manifest := `version: 1
+defaults:
+  bump:
+    registries: [ghcr.io]
+images:
+  core:
+    versions:
+      noble:
+        base_image:
+          name: ubuntu:noble
+          source: dockerhub
+  golang:
+    defaults:
+      base_image:
+        name: core:noble
+    versions:
+      "1.22":
+        base_image:
+          name: "golang:1.22.3"  # keep this comment
+          source: dockerhub
+      pinned:
+        base_image:
+          name: golang:1.22.3
+          source: dockerhub
+          digest: sha256:abc
+  tool:
+    versions:
+      "1":
+        base_image:
+          name: ghcr.io/acme/tool:1.0.0-slim
+          source: external
+      "2":
+        base_image:
+          name: quay.io/acme/tool:1.0.0
+          source: external
+`
+	if err := os.WriteFile(manifestPath, []byte(manifest), 0644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	cfg, err := config.Load(manifestPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	registry := &stubRegistry{tags: map[string][]string{
+		DockerHubHost + "/library/golang": {"1.22.3", "1.22.4", "1.23.0"},
+		"ghcr.io/acme/tool":               {"1.0.0-slim", "1.0.2-slim", "1.0.3"},
+	}}
+	updates, err := Check(context.Background(), cfg, registry, nil)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(registry.listed) != 2 {
+		t.Errorf("listed %v, want golang and the ghcr.io tool once each", registry.listed)
+	}
+
+	want := []Update{
+		{Image: "golang", Version: "1.22", File: manifestPath, Line: 19, Current: "golang:1.22.3", Latest: "golang:1.22.4"},
+		{Image: "tool", Version: "1", File: manifestPath, Line: 30, Current: "ghcr.io/acme/tool:1.0.0-slim", Latest: "ghcr.io/acme/tool:1.0.2-slim"},
+	}
+	if len(updates) != len(want) {
+		t.Fatalf("Check() = %+v, want %+v", updates, want)
+	}
+	for i := range want {
+		got := updates[i]
+		got.column = 0
+		if got != want[i] {
+			t.Errorf("Check()[%d] = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	filtered, err := Check(context.Background(), cfg, registry, []string{"core"})
+	if err != nil || len(filtered) != 0 {
+		t.Errorf("Check(core) = %+v, %v, want no updates", filtered, err)
+	}
+
+	if err := Apply(updates); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	got, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	expected := strings.NewReplacer(
+		`name: "golang:1.22.3"  # keep this comment`, `name: "golang:1.22.4"  # keep this comment`,
+		"name: ghcr.io/acme/tool:1.0.0-slim", "name: ghcr.io/acme/tool:1.0.2-slim",
+	).Replace(manifest)
+	if string(got) != expected {
+		t.Errorf("Apply() wrote\n%s\nwant\n%s", got, expected)
+	}
+}
@@ -0,0 +1,150 @@
+package bump
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// DockerHubHost is the registry Docker Hub images are listed from.
+const DockerHubHost = "registry-1.docker.io"
+
+// Registry lists the tags of image repositories.
+type Registry interface {
+	// Tags returns every tag of repository, e.g. library/golang, on host.
+	Tags(ctx context.Context, host, repository string) ([]string, error)
+}
+
+// NewRegistry returns a Registry speaking the distribution API over client,
+// authenticating anonymously when a registry asks for a bearer token.
+func NewRegistry(client *http.Client) Registry {
+	return httpRegistry{client: client}
+}
+
+type httpRegistry struct {
+	client *http.Client
+}
+
+func (r httpRegistry) Tags(ctx context.Context, host, repository string) ([]string, error) {
+	next := fmt.Sprintf("https://%s/v2/%s/tags/list?n=1000", host, repository)
+	var token string
+	var tags []string
+	for next != "" {
+		resp, err := r.get(ctx, next, token)
+		if err != nil {
+			return nil, fmt.Errorf("listing tags of %s/%s: %w", host, repository, err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && token == "" {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			_ = resp.Body.Close()
+			if token, err = r.token(ctx, challenge); err != nil {
+				return nil, fmt.Errorf("listing tags of %s/%s: %w", host, repository, err)
+			}
+			continue
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = decode(resp, &page)
+		if err != nil {
+			return nil, fmt.Errorf("listing tags of %s/%s: %w", host, repository, err)
+		}
+		tags = append(tags, page.Tags...)
+		if next, err = nextPage(next, resp.Header.Get("Link")); err != nil {
+			return nil, fmt.Errorf("listing tags of %s/%s: %w", host, repository, err)
+		}
+	}
+	return tags, nil
+}
+
+func (r httpRegistry) get(ctx context.Context, rawURL, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r.client.Do(req)
+}
+
+// challengeParam matches one key="value" parameter of a WWW-Authenticate
+// header.
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// token fetches an anonymous pull token from the realm of a Bearer
+// challenge.
+func (r httpRegistry) token(ctx context.Context, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	query := url.Values{}
+	var realm string
+	for _, match := range challengeParam.FindAllStringSubmatch(params, -1) {
+		if match[1] == "realm" {
+			realm = match[2]
+		} else {
+			query.Set(match[1], match[2])
+		}
+	}
+	if realm == "" {
+		return "", fmt.Errorf("authentication challenge %q has no realm", challenge)
+	}
+
+	resp, err := r.get(ctx, realm+"?"+query.Encode(), "")
+	if err != nil {
+		return "", err
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := decode(resp, &body); err != nil {
+		return "", fmt.Errorf("fetching token: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	if body.AccessToken != "" {
+		return body.AccessToken, nil
+	}
+	return "", fmt.Errorf("fetching token: response has no token")
+}
+
+// decode reads a successful JSON response into v and closes its body.
+func decode(resp *http.Response, v interface{}) error {
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// nextPage resolves the rel="next" target of a Link header against the
+// current page, or returns "" on the last page.
+func nextPage(current, link string) (string, error) {
+	target, rel, found := strings.Cut(link, ";")
+	if !found || !strings.Contains(rel, `rel="next"`) {
+		return "", nil
+	}
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	next, err := base.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+	if err != nil {
+		return "", err
+	}
+	return next.String(), nil
+}
@@ -0,0 +1,48 @@
+package bump
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRegistryTags(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if r.URL.Query().Get("scope") != "repository:library/golang:pull" {
+				t.Errorf("token scope = %q", r.URL.Query().Get("scope"))
+			}
+			_, _ = w.Write([]byte(`{"token": "secret"}`))
+		case r.Header.Get("Authorization") != "Bearer secret":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="repository:library/golang:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case strings.HasPrefix(r.URL.Path, "/v2/missing/"):
+			http.Error(w, `{"errors": [{"code": "NAME_UNKNOWN"}]}`, http.StatusNotFound)
+		case r.URL.Query().Get("last") == "":
+			w.Header().Set("Link", `</v2/library/golang/tags/list?last=1.22.3&n=1000>; rel="next"`)
+			_, _ = w.Write([]byte(`{"tags": ["1.22.2", "1.22.3"]}`))
+		default:
+			_, _ = w.Write([]byte(`{"tags": ["1.22.4"]}`))
+		}
+	}))
+	defer server.Close()
+
+	registry := NewRegistry(server.Client())
+	host := strings.TrimPrefix(server.URL, "https://")
+	tags, err := registry.Tags(context.Background(), host, "library/golang")
+	if err != nil {
+		t.Fatalf("Tags() error = %v", err)
+	}
+	if want := []string{"1.22.2", "1.22.3", "1.22.4"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("Tags() = %v, want %v", tags, want)
+	}
+
+	if _, err := registry.Tags(context.Background(), host, "missing"); err == nil {
+		t.Error("Tags() of an unknown repository should fail")
+	}
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// VersionAliases returns the sorted aliases, such as latest or lts, that
+// point at the given output version. Variant outputs are only aliased when an
+// alias names them.
+func (img Image) VersionAliases(version string) []string {
+	var aliases []string
+	for alias, target := range img.Aliases {
+		if target == version {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// aliasProblems reports aliases that point at no version of the image, and
+// aliases that would retag an existing output version.
+func (img Image) aliasProblems() []Problem {
+	var problems []Problem
+	for _, alias := range sortedKeys(img.Aliases) {
+		target := img.Aliases[alias]
+		if _, exists := img.OutputVersion(target); !exists {
+			problems = append(problems, Problem{
+				Origin:  img.Origin,
+				Message: fmt.Sprintf("alias %s points at version %q, which is not in versions", alias, target),
+			})
+		}
+		if _, exists := img.OutputVersion(alias); exists {
+			problems = append(problems, Problem{
+				Origin:  img.Origin,
+				Message: fmt.Sprintf("alias %s has the same name as a version", alias),
+			})
+		}
+	}
+	return problems
+}
@@ -0,0 +1,54 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImage_VersionAliases(t *testing.T) {
+	image := Image{
+		Aliases: map[string]string{"stable": "1.22", "latest": "1.22", "lts": "1.21"},
+		Versions: map[string]*ImageConfig{
+			"1.21": {},
+			"1.22": {Variants: map[string]*Variant{"slim": {TagSuffix: "-slim"}}},
+		},
+	}
+
+	tests := map[string][]string{
+		"1.22":      {"latest", "stable"},
+		"1.21":      {"lts"},
+		"1.22-slim": nil,
+	}
+	for version, want := range tests {
+		if got := image.VersionAliases(version); !reflect.DeepEqual(got, want) {
+			t.Errorf("VersionAliases(%s) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestValidate_Aliases(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]Image{
+			"golang": {
+				Aliases: map[string]string{"latest": "1.22", "lts": "1.20", "1.21": "1.22"},
+				Versions: map[string]*ImageConfig{
+					"1.21": {},
+					"1.22": {},
+				},
+			},
+		},
+	}
+
+	var got []string
+	for _, problem := range Validate(cfg) {
+		got = append(got, problem.String())
+	}
+	want := []string{
+		"golang: alias 1.21 has the same name as a version",
+		`golang: alias lts points at version "1.20", which is not in versions`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() = %q, want %q", got, want)
+	}
+}
@@ -1,53 +1,451 @@
 package config
 
+import (
+	"fmt"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
 type Config struct {
 	Version  int              `yaml:"version" json:"version"`
+	Requires string           `yaml:"requires,omitempty" json:"requires,omitempty"`
+	Project  string           `yaml:"project,omitempty" json:"project,omitempty"`
+	Includes []string         `yaml:"includes,omitempty" json:"includes,omitempty"`
 	Defaults Defaults         `yaml:"defaults" json:"defaults"`
 	Images   map[string]Image `yaml:"images" json:"images"`
+	// File is the path of the root manifest, as given when loading it.
+	File string `yaml:"-" json:"-"`
 }
 
 type Defaults struct {
-	BasePath string `yaml:"-" json:"-"`
-	Registry string `yaml:"registry,omitempty" json:"registry,omitempty"`
+	BasePath         string                 `yaml:"-" json:"-"`
+	Overrides        map[string]interface{} `yaml:"-" json:"-"`
+	Preset           string                 `yaml:"preset,omitempty" json:"preset,omitempty"`
+	PresetSHA256     string                 `yaml:"preset_sha256,omitempty" json:"preset_sha256,omitempty"`
+	Registry         string                 `yaml:"registry,omitempty" json:"registry,omitempty"`
+	Reproducible     *bool                  `yaml:"reproducible,omitempty" json:"reproducible,omitempty"`
+	SourceDateEpoch  *int64                 `yaml:"source_date_epoch,omitempty" json:"source_date_epoch,omitempty"`
+	DockerfileSyntax string                 `yaml:"dockerfile_syntax,omitempty" json:"dockerfile_syntax,omitempty"`
+	Headers          HeaderStyles           `yaml:"headers,omitempty" json:"headers,omitempty"`
+	PruneOrphans     *bool                  `yaml:"prune_orphans,omitempty" json:"prune_orphans,omitempty"`
+	StrictTemplates  *bool                  `yaml:"strict_templates,omitempty" json:"strict_templates,omitempty"`
+	Partials         string                 `yaml:"partials,omitempty" json:"partials,omitempty"`
+	Values           map[string]interface{} `yaml:"values,omitempty" json:"values,omitempty"`
+	Workflow         *Workflow              `yaml:"workflow,omitempty" json:"workflow,omitempty"`
+	Promotion        *Promotion             `yaml:"promotion,omitempty" json:"promotion,omitempty"`
+	Bump             *Bump                  `yaml:"bump,omitempty" json:"bump,omitempty"`
+	Push             *Push                  `yaml:"push,omitempty" json:"push,omitempty"`
+
+	// EmitValuesSnapshot writes the configuration every version is rendered
+	// with into its directory, for auditing.
+	EmitValuesSnapshot bool `yaml:"emit_values_snapshot,omitempty" json:"emit_values_snapshot,omitempty"`
+}
+
+// OrphanPruning reports whether generation deletes orphaned version
+// directories, which it does unless the manifest turns it off.
+func (d Defaults) OrphanPruning() bool {
+	return d.PruneOrphans == nil || *d.PruneOrphans
+}
+
+// StrictTemplating reports whether templates that call undefined values fail
+// to render, which they do unless the manifest turns it off.
+func (d Defaults) StrictTemplating() bool {
+	return d.StrictTemplates == nil || *d.StrictTemplates
+}
+
+// ReproducibilityMode controls helpers whose output would otherwise depend on
+// the time, git state or network. When Enabled, such helpers must take their
+// values from config (e.g. SourceDateEpoch) or fail.
+type ReproducibilityMode struct {
+	Enabled         bool
+	SourceDateEpoch *int64
+}
+
+// Reproducibility returns the effective mode, which is enabled unless the
+// manifest or CLI explicitly turns it off.
+func (d Defaults) Reproducibility() ReproducibilityMode {
+	return ReproducibilityMode{
+		Enabled:         d.Reproducible == nil || *d.Reproducible,
+		SourceDateEpoch: d.SourceDateEpoch,
+	}
+}
+
+type Workflow struct {
+	// Parser selects the Dockerfile dependency parser: "regex" (default) or "buildkit".
+	Parser string `yaml:"parser,omitempty" json:"parser,omitempty"`
+	// Dependencies selects where job dependencies are parsed from:
+	// "rendered" (default), the Dockerfiles rendered in memory from the
+	// templates, or "dockerfiles", the generated files on disk.
+	Dependencies string `yaml:"dependencies,omitempty" json:"dependencies,omitempty"`
+	// Auth maps registry hosts to the provider that logs jobs in to them.
+	Auth map[string]RegistryAuth `yaml:"auth,omitempty" json:"auth,omitempty"`
+	// JobWarningThreshold is the job count at which generation warns that
+	// the workflow is approaching the platform's job limit.
+	JobWarningThreshold int `yaml:"job_warning_threshold,omitempty" json:"job_warning_threshold,omitempty"`
+	// Environment is the deployment environment jobs push through, unless
+	// an image or version sets its own.
+	Environment *Environment `yaml:"environment,omitempty" json:"environment,omitempty"`
+	// Platforms are the platforms every job builds, e.g. linux/arm64,
+	// unless an image or version sets its own. Empty builds the runner's.
+	Platforms []string `yaml:"platforms,omitempty" json:"platforms,omitempty"`
+	// RunsOn are the runner labels of every job, unless an image or version
+	// sets its own. Empty runs on ubuntu-latest.
+	RunsOn RunnerLabels `yaml:"runs_on,omitempty" json:"runs_on,omitempty"`
+	// TimeoutMinutes bounds every job, unless an image or version sets its
+	// own. 0 keeps GitHub's default.
+	TimeoutMinutes int `yaml:"timeout_minutes,omitempty" json:"timeout_minutes,omitempty"`
+	// MaxParallel limits how many build jobs run at once by making waves of
+	// jobs wait for each other; 0 leaves it to the runners available.
+	MaxParallel int `yaml:"max_parallel,omitempty" json:"max_parallel,omitempty"`
+	// Cache is the build cache every job uses, unless an image or version
+	// sets its own. Nil keeps the build action's registry cache.
+	Cache *Cache `yaml:"cache,omitempty" json:"cache,omitempty"`
+}
+
+// Cache configures the buildx cache of workflow jobs. Type is "gha", the
+// GitHub Actions cache scoped to each job, or "registry", an image whose
+// reference Ref renders as a Go template over .Image and .Version.
+type Cache struct {
+	Type string `yaml:"type" json:"type"`
+	Ref  string `yaml:"ref,omitempty" json:"ref,omitempty"`
+}
+
+// AuthFor returns the auth entry of a registry host, if w has one. w may be
+// nil.
+func (w *Workflow) AuthFor(host string) (RegistryAuth, bool) {
+	if w == nil {
+		return RegistryAuth{}, false
+	}
+	auth, exists := w.Auth[host]
+	return auth, exists
+}
+
+// Environment is a GitHub deployment environment attached to the jobs that
+// push, e.g. to require reviewers. URLTemplate is a Go template over .Image
+// and .Version rendered into the environment's URL. An empty Name attaches
+// no environment, which lets an image opt out of the default.
+type Environment struct {
+	Name        string `yaml:"name" json:"name"`
+	URLTemplate string `yaml:"url_template,omitempty" json:"url_template,omitempty"`
+}
+
+// RegistryAuth configures the login steps rendered for one registry. Which
+// fields are required depends on the provider.
+type RegistryAuth struct {
+	Provider       string `yaml:"provider" json:"provider"`
+	UsernameSecret string `yaml:"username_secret,omitempty" json:"username_secret,omitempty"`
+	PasswordSecret string `yaml:"password_secret,omitempty" json:"password_secret,omitempty"`
+	Role           string `yaml:"role,omitempty" json:"role,omitempty"`
+	Region         string `yaml:"region,omitempty" json:"region,omitempty"`
 }
 
 type Image struct {
-	Path     string                  `yaml:"path,omitempty" json:"path,omitempty"`
-	Defaults *ImageConfig            `yaml:"defaults,omitempty" json:"defaults,omitempty"`
-	Versions map[string]*ImageConfig `yaml:"versions" json:"versions"`
+	Enabled      *bool                   `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	Path         string                  `yaml:"path,omitempty" json:"path,omitempty"`
+	Vendor       []string                `yaml:"vendor,omitempty" json:"vendor,omitempty"`
+	TemplateDirs []string                `yaml:"template_dirs,omitempty" json:"template_dirs,omitempty"`
+	Inline       []string                `yaml:"inline,omitempty" json:"inline,omitempty"`
+	Workflow     *ImageWorkflow          `yaml:"workflow,omitempty" json:"workflow,omitempty"`
+	Lint         *ImageLint              `yaml:"lint,omitempty" json:"lint,omitempty"`
+	Promotion    *Promotion              `yaml:"promotion,omitempty" json:"promotion,omitempty"`
+	Defaults     *ImageConfig            `yaml:"defaults,omitempty" json:"defaults,omitempty"`
+	Versions     map[string]*ImageConfig `yaml:"versions" json:"versions"`
+	Aliases      map[string]string       `yaml:"aliases,omitempty" json:"aliases,omitempty"`
+	Origin       Origin                  `yaml:"-" json:"-"`
 }
 
 type ImageConfig struct {
+	Enabled   *bool                  `yaml:"enabled,omitempty" json:"enabled,omitempty"`
 	BaseImage *BaseImage             `yaml:"base_image,omitempty" json:"base_image,omitempty"`
+	Workflow  *ImageWorkflow         `yaml:"workflow,omitempty" json:"workflow,omitempty"`
+	Variants  map[string]*Variant    `yaml:"variants,omitempty" json:"variants,omitempty"`
+	DependsOn []string               `yaml:"depends_on,omitempty" json:"depends_on,omitempty"`
 	Values    map[string]interface{} `yaml:"-" json:"-"`
+	Origin    Origin                 `yaml:"-" json:"-"`
+}
+
+// ImageWorkflow controls how an image or version is treated by workflow
+// generation. Images with Enabled set to false are built elsewhere and get no
+// CI jobs, though their Dockerfiles are still generated. Prepare lists shell
+// commands run in the version directory before the build, e.g. to download
+// artifacts too large to commit. Environment and Platforms override
+// defaults.workflow.
+type ImageWorkflow struct {
+	Enabled     *bool        `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	Prepare     []string     `yaml:"prepare,omitempty" json:"prepare,omitempty"`
+	Environment *Environment `yaml:"environment,omitempty" json:"environment,omitempty"`
+	Platforms   []string     `yaml:"platforms,omitempty" json:"platforms,omitempty"`
+	Cache       *Cache       `yaml:"cache,omitempty" json:"cache,omitempty"`
+	RunsOn      RunnerLabels `yaml:"runs_on,omitempty" json:"runs_on,omitempty"`
+	// TimeoutMinutes is nil when unset, so an image can inherit the
+	// default.
+	TimeoutMinutes *int `yaml:"timeout_minutes,omitempty" json:"timeout_minutes,omitempty"`
+}
+
+// RunnerLabels are the runs-on labels of a workflow job. Unlike a plain list
+// of strings, entries YAML reads as numbers, booleans or mappings are
+// rejected, so that a typo cannot produce an invalid workflow.
+type RunnerLabels []string
+
+func (l *RunnerLabels) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		return nodeError(node, fmt.Errorf("runs_on must be a list of runner labels"))
+	}
+	labels := make(RunnerLabels, 0, len(node.Content))
+	for _, entry := range node.Content {
+		if entry.Kind != yaml.ScalarNode || entry.Tag != "!!str" {
+			return nodeError(entry, fmt.Errorf("runs_on: %s is not a runner label; quote it if it is one", entry.Value))
+		}
+		labels = append(labels, entry.Value)
+	}
+	*l = labels
+	return nil
 }
 
 type BaseImage struct {
 	Name   string `yaml:"name" json:"name"`
 	Source string `yaml:"source,omitempty" json:"source,omitempty"`
+	// Digest pins the image, e.g. sha256:..., and is rendered after the name
+	// and tag as name:tag@digest.
+	Digest string `yaml:"digest,omitempty" json:"digest,omitempty"`
+}
+
+// Base image sources. Images without a recognized source are pulled from
+// the manifest's registry.
+const (
+	// SourceDockerHub images are referenced by name without a registry.
+	SourceDockerHub = "dockerhub"
+	// SourceScratch is the empty scratch image; its name must be "scratch"
+	// or empty.
+	SourceScratch = "scratch"
+	// SourceExternal images are fully qualified references used verbatim,
+	// e.g. mcr.microsoft.com/windows/nanoserver:ltsc2022.
+	SourceExternal = "external"
+)
+
+// ProjectName names the manifest's project when several are generated
+// together: the project key, or else the name of the manifest's directory.
+func (c *Config) ProjectName() string {
+	if c.Project != "" {
+		return c.Project
+	}
+	if c.Defaults.BasePath == "" {
+		return ""
+	}
+	return filepath.Base(c.Defaults.BasePath)
+}
+
+// ImagePath resolves the on-disk directory of image, anchoring relative paths
+// at the manifest's base path.
+func (c *Config) ImagePath(image Image) (string, error) {
+	if filepath.IsAbs(image.Path) {
+		return image.Path, nil
+	}
+	if c.Defaults.BasePath == "" {
+		return "", fmt.Errorf("base path not set in config")
+	}
+	return filepath.Join(c.Defaults.BasePath, image.Path), nil
+}
+
+// ImageDefaults returns the named image's defaults merged over the
+// manifest-wide defaults.values, which have the lowest precedence.
+func (c *Config) ImageDefaults(imageName string) *ImageConfig {
+	global := &ImageConfig{Values: c.Defaults.Values}
+	return c.Images[imageName].Defaults.Merge(global)
+}
+
+// DefaultPartialsDir is where include finds shared template partials,
+// relative to the manifest directory, unless defaults.partials moves them.
+const DefaultPartialsDir = "templates/partials"
+
+// PartialsDir resolves the directory of shared template partials, anchoring
+// relative paths at the manifest's base path.
+func (c *Config) PartialsDir() string {
+	dir := c.Defaults.Partials
+	if dir == "" {
+		dir = DefaultPartialsDir
+	}
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(c.Defaults.BasePath, dir)
+}
+
+// Promotion names the registry namespaces an image is promoted between, e.g.
+// from staging.internal/library to prod.internal/library.
+type Promotion struct {
+	From string `yaml:"from" json:"from"`
+	To   string `yaml:"to" json:"to"`
+}
+
+// PromotionFor returns the promotion settings of an image, preferring the
+// image's own block over the manifest defaults.
+func (c *Config) PromotionFor(imageName string) (*Promotion, error) {
+	image, exists := c.Images[imageName]
+	if !exists {
+		return nil, fmt.Errorf("image %s not found in config", imageName)
+	}
+
+	promotion := image.Promotion
+	if promotion == nil {
+		promotion = c.Defaults.Promotion
+	}
+	if promotion == nil || promotion.From == "" || promotion.To == "" {
+		return nil, fmt.Errorf("image %s has no promotion from/to configured (set promotion under defaults or the image)", imageName)
+	}
+	return promotion, nil
 }
 
-func (ic *ImageConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+// Bump configures which base images the bump command checks for newer
+// tags. Docker Hub images are always checked; Registries adds the hosts of
+// external base images, e.g. ghcr.io or mcr.microsoft.com.
+type Bump struct {
+	Registries []string `yaml:"registries,omitempty" json:"registries,omitempty"`
+}
+
+// Push makes every workflow job log in to Registry and push its image as
+// <registry>/<image>:<version>. Pull request runs build without pushing.
+// The login uses the repository secrets named by UsernameSecret and
+// PasswordSecret, unless defaults.workflow.auth covers the registry's host.
+type Push struct {
+	Enabled        bool   `yaml:"enabled" json:"enabled"`
+	Registry       string `yaml:"registry" json:"registry"`
+	UsernameSecret string `yaml:"username_secret,omitempty" json:"username_secret,omitempty"`
+	PasswordSecret string `yaml:"password_secret,omitempty" json:"password_secret,omitempty"`
+}
+
+// ImageLint disables individual manifest lints for an image.
+type ImageLint struct {
+	IgnoreKeyConflicts bool `yaml:"ignore_key_conflicts,omitempty" json:"ignore_key_conflicts,omitempty"`
+	AllowOnBuild       bool `yaml:"allow_onbuild,omitempty" json:"allow_onbuild,omitempty"`
+}
+
+func (ic *ImageConfig) UnmarshalYAML(node *yaml.Node) error {
 	// First unmarshal into a raw map
 	var raw map[string]interface{}
-	if err := unmarshal(&raw); err != nil {
+	if err := node.Decode(&raw); err != nil {
 		return err
 	}
 
+	// Keep the value nodes so that errors can point at the offending key;
+	// values pulled in by a merge key fall back to the mapping itself.
+	fields := make(map[string]*yaml.Node)
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			fields[node.Content[i].Value] = node.Content[i+1]
+		}
+	}
+	at := func(key string) *yaml.Node {
+		if field, ok := fields[key]; ok {
+			return field
+		}
+		return node
+	}
+
 	ic.Values = make(map[string]interface{})
 
+	// Extract enabled if present
+	if enabledRaw, ok := raw["enabled"]; ok {
+		enabled, ok := enabledRaw.(bool)
+		if !ok {
+			return nodeError(at("enabled"), fmt.Errorf("enabled must be true or false"))
+		}
+		ic.Enabled = &enabled
+		delete(raw, "enabled")
+	}
+
 	// Extract base_image if present
-	if baseImageRaw, ok := raw["base_image"]; ok {
-		if baseImageMap, ok := baseImageRaw.(map[string]interface{}); ok {
-			ic.BaseImage = &BaseImage{}
-			if name, ok := baseImageMap["name"].(string); ok {
-				ic.BaseImage.Name = name
+	if baseImageRaw, ok := raw["base_image"]; ok && baseImageRaw != nil {
+		baseImage, err := parseBaseImage(baseImageRaw)
+		if err != nil {
+			return nodeError(at("base_image"), err)
+		}
+		ic.BaseImage = baseImage
+	}
+	delete(raw, "base_image")
+
+	// Extract workflow if present
+	if workflowRaw, ok := raw["workflow"]; ok && workflowRaw != nil {
+		workflowMap, ok := workflowRaw.(map[string]interface{})
+		if !ok {
+			return nodeError(at("workflow"), fmt.Errorf("workflow must be a mapping"))
+		}
+		ic.Workflow = &ImageWorkflow{}
+		if enabledRaw, ok := workflowMap["enabled"]; ok {
+			enabled, ok := enabledRaw.(bool)
+			if !ok {
+				field := mappingValue(at("workflow"), "enabled")
+				if field == nil {
+					field = at("workflow")
+				}
+				return nodeError(field, fmt.Errorf("workflow.enabled must be true or false"))
+			}
+			ic.Workflow.Enabled = &enabled
+		}
+		if prepareRaw, ok := workflowMap["prepare"]; ok {
+			prepare, err := parsePrepare(prepareRaw)
+			if err != nil {
+				return nodeError(at("workflow"), err)
 			}
-			if source, ok := baseImageMap["source"].(string); ok {
-				ic.BaseImage.Source = source
+			ic.Workflow.Prepare = prepare
+		}
+		if environmentRaw, ok := workflowMap["environment"]; ok {
+			environment, err := parseEnvironment(environmentRaw)
+			if err != nil {
+				return nodeError(at("workflow"), err)
+			}
+			ic.Workflow.Environment = environment
+		}
+		if platformsRaw, ok := workflowMap["platforms"]; ok {
+			platforms, err := parsePlatforms(platformsRaw)
+			if err != nil {
+				return nodeError(at("workflow"), err)
+			}
+			ic.Workflow.Platforms = platforms
+		}
+		if runsOnRaw, ok := workflowMap["runs_on"]; ok {
+			runsOn, err := parseRunsOn(runsOnRaw)
+			if err != nil {
+				return nodeError(at("workflow"), err)
+			}
+			ic.Workflow.RunsOn = runsOn
+		}
+		if timeoutRaw, ok := workflowMap["timeout_minutes"]; ok {
+			timeout, ok := timeoutRaw.(int)
+			if !ok {
+				return nodeError(at("workflow"), fmt.Errorf("workflow.timeout_minutes: %v is not a number of minutes", timeoutRaw))
 			}
+			ic.Workflow.TimeoutMinutes = &timeout
 		}
-		delete(raw, "base_image")
+		if cacheRaw, ok := workflowMap["cache"]; ok {
+			cache, err := parseCache(cacheRaw)
+			if err != nil {
+				return nodeError(at("workflow"), err)
+			}
+			ic.Workflow.Cache = cache
+		}
+	}
+	delete(raw, "workflow")
+
+	// Extract depends_on if present
+	if dependsOnRaw, ok := raw["depends_on"]; ok {
+		dependsOn, err := parseDependsOn(dependsOnRaw)
+		if err != nil {
+			return nodeError(at("depends_on"), err)
+		}
+		ic.DependsOn = dependsOn
+		delete(raw, "depends_on")
+	}
+
+	// Extract variants if present
+	if variantsRaw, ok := raw["variants"]; ok {
+		variants, err := parseVariants(variantsRaw)
+		if err != nil {
+			return nodeError(at("variants"), err)
+		}
+		ic.Variants = variants
+		delete(raw, "variants")
 	}
 
 	for k, v := range raw {
@@ -57,6 +455,29 @@ func (ic *ImageConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+func parseBaseImage(raw interface{}) (*BaseImage, error) {
+	fields, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("base_image must be a mapping with name, and optionally source and digest")
+	}
+	baseImage := &BaseImage{}
+	for key, value := range fields {
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("base_image.%s: %v is not a string", key, value)
+		}
+		switch key {
+		case "name":
+			baseImage.Name = text
+		case "source":
+			baseImage.Source = text
+		case "digest":
+			baseImage.Digest = text
+		}
+	}
+	return baseImage, nil
+}
+
 func (ic *ImageConfig) MarshalYAML() (interface{}, error) {
 	result := make(map[string]interface{})
 
@@ -64,9 +485,21 @@ func (ic *ImageConfig) MarshalYAML() (interface{}, error) {
 		result[k] = v
 	}
 
+	if ic.Enabled != nil {
+		result["enabled"] = *ic.Enabled
+	}
 	if ic.BaseImage != nil {
 		result["base_image"] = ic.BaseImage
 	}
+	if ic.Workflow != nil {
+		result["workflow"] = ic.Workflow
+	}
+	if len(ic.Variants) > 0 {
+		result["variants"] = ic.Variants
+	}
+	if ic.DependsOn != nil {
+		result["depends_on"] = ic.DependsOn
+	}
 
 	return result, nil
 }
@@ -83,18 +516,40 @@ func (ic *ImageConfig) Merge(defaults *ImageConfig) *ImageConfig {
 		Values: make(map[string]interface{}),
 	}
 
+	if ic.Enabled != nil {
+		result.Enabled = copyBool(ic.Enabled)
+	} else {
+		result.Enabled = copyBool(defaults.Enabled)
+	}
+
 	if ic.BaseImage != nil {
 		result.BaseImage = &BaseImage{
 			Name:   ic.BaseImage.Name,
 			Source: ic.BaseImage.Source,
+			Digest: ic.BaseImage.Digest,
 		}
 	} else if defaults.BaseImage != nil {
 		result.BaseImage = &BaseImage{
 			Name:   defaults.BaseImage.Name,
 			Source: defaults.BaseImage.Source,
+			Digest: defaults.BaseImage.Digest,
 		}
 	}
 
+	if ic.Workflow != nil {
+		result.Workflow = ic.Workflow.deepCopy()
+	} else {
+		result.Workflow = defaults.Workflow.deepCopy()
+	}
+
+	result.Variants = mergeVariants(defaults.Variants, ic.Variants)
+
+	if ic.DependsOn != nil {
+		result.DependsOn = copyStrings(ic.DependsOn)
+	} else {
+		result.DependsOn = copyStrings(defaults.DependsOn)
+	}
+
 	for k, val := range defaults.Values {
 		result.Values[k] = deepCopyValue(val)
 	}
@@ -131,9 +586,15 @@ func (ic *ImageConfig) deepCopy() *ImageConfig {
 		result.BaseImage = &BaseImage{
 			Name:   ic.BaseImage.Name,
 			Source: ic.BaseImage.Source,
+			Digest: ic.BaseImage.Digest,
 		}
 	}
 
+	result.Enabled = copyBool(ic.Enabled)
+	result.Workflow = ic.Workflow.deepCopy()
+	result.Variants = mergeVariants(ic.Variants, nil)
+	result.DependsOn = copyStrings(ic.DependsOn)
+
 	for k, v := range ic.Values {
 		result.Values[k] = deepCopyValue(v)
 	}
@@ -141,6 +602,346 @@ func (ic *ImageConfig) deepCopy() *ImageConfig {
 	return result
 }
 
+func (w *ImageWorkflow) deepCopy() *ImageWorkflow {
+	if w == nil {
+		return nil
+	}
+
+	result := &ImageWorkflow{}
+	if w.Enabled != nil {
+		enabled := *w.Enabled
+		result.Enabled = &enabled
+	}
+	if w.Prepare != nil {
+		result.Prepare = append([]string(nil), w.Prepare...)
+	}
+	if w.Environment != nil {
+		environment := *w.Environment
+		result.Environment = &environment
+	}
+	result.Platforms = copyStrings(w.Platforms)
+	if w.Cache != nil {
+		cache := *w.Cache
+		result.Cache = &cache
+	}
+	if w.RunsOn != nil {
+		result.RunsOn = RunnerLabels(copyStrings(w.RunsOn))
+	}
+	if w.TimeoutMinutes != nil {
+		timeout := *w.TimeoutMinutes
+		result.TimeoutMinutes = &timeout
+	}
+	return result
+}
+
+// IsEnabled reports whether the image is generated and built at all, which it
+// is unless the image sets enabled: false.
+func (img Image) IsEnabled() bool {
+	return img.Enabled == nil || *img.Enabled
+}
+
+// VersionEnabled reports whether the given version, or variant output, is
+// generated and built. A disabled image disables all its versions; otherwise
+// the version block takes precedence over image defaults. Disabled versions
+// keep their existing directories.
+func (img Image) VersionEnabled(version string) bool {
+	if !img.IsEnabled() {
+		return false
+	}
+	if output, ok := img.OutputVersion(version); ok {
+		version = output.Version
+	}
+	for _, ic := range []*ImageConfig{img.Versions[version], img.Defaults} {
+		if ic != nil && ic.Enabled != nil {
+			return *ic.Enabled
+		}
+	}
+	return true
+}
+
+// WorkflowEnabled reports whether the given version, or variant output, should
+// get a CI job. The version block takes precedence over image defaults, which
+// take precedence over the image-level workflow setting.
+func (img Image) WorkflowEnabled(version string) bool {
+	if output, ok := img.OutputVersion(version); ok {
+		version = output.Version
+	}
+	for _, w := range []*ImageWorkflow{
+		img.Versions[version].workflow(),
+		img.Defaults.workflow(),
+		img.Workflow,
+	} {
+		if w != nil && w.Enabled != nil {
+			return *w.Enabled
+		}
+	}
+	return true
+}
+
+func parsePrepare(raw interface{}) ([]string, error) {
+	entries, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("workflow.prepare must be a list of commands")
+	}
+	prepare := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		command, ok := entry.(string)
+		if !ok {
+			return nil, fmt.Errorf("workflow.prepare: %v is not a command string", entry)
+		}
+		prepare = append(prepare, command)
+	}
+	return prepare, nil
+}
+
+// WorkflowPrepare returns the prepare commands for the given version, or
+// variant output, with the same precedence as WorkflowEnabled. The first layer
+// that sets prepare wins; lists are not concatenated.
+func (img Image) WorkflowPrepare(version string) []string {
+	if output, ok := img.OutputVersion(version); ok {
+		version = output.Version
+	}
+	for _, w := range []*ImageWorkflow{
+		img.Versions[version].workflow(),
+		img.Defaults.workflow(),
+		img.Workflow,
+	} {
+		if w != nil && w.Prepare != nil {
+			return w.Prepare
+		}
+	}
+	return nil
+}
+
+func parseEnvironment(raw interface{}) (*Environment, error) {
+	fields, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("workflow.environment must be a mapping with name and url_template")
+	}
+	environment := &Environment{}
+	for key, value := range fields {
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("workflow.environment.%s: %v is not a string", key, value)
+		}
+		switch key {
+		case "name":
+			environment.Name = text
+		case "url_template":
+			environment.URLTemplate = text
+		default:
+			return nil, fmt.Errorf("workflow.environment: unknown key %q", key)
+		}
+	}
+	return environment, nil
+}
+
+// WorkflowEnvironment returns the deployment environment set for the given
+// version, or variant output, with the same precedence as WorkflowEnabled, or
+// nil when the image leaves it to defaults.workflow.environment.
+func (img Image) WorkflowEnvironment(version string) *Environment {
+	if output, ok := img.OutputVersion(version); ok {
+		version = output.Version
+	}
+	for _, w := range []*ImageWorkflow{
+		img.Versions[version].workflow(),
+		img.Defaults.workflow(),
+		img.Workflow,
+	} {
+		if w != nil && w.Environment != nil {
+			return w.Environment
+		}
+	}
+	return nil
+}
+
+func parseCache(raw interface{}) (*Cache, error) {
+	fields, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("workflow.cache must be a mapping with type and ref")
+	}
+	cache := &Cache{}
+	for key, value := range fields {
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("workflow.cache.%s: %v is not a string", key, value)
+		}
+		switch key {
+		case "type":
+			cache.Type = text
+		case "ref":
+			cache.Ref = text
+		default:
+			return nil, fmt.Errorf("workflow.cache: unknown key %q", key)
+		}
+	}
+	return cache, nil
+}
+
+// WorkflowCache returns the build cache set for the given version, or
+// variant output, with the same precedence as WorkflowEnabled, or nil when
+// the image leaves it to defaults.workflow.cache.
+func (img Image) WorkflowCache(version string) *Cache {
+	if output, ok := img.OutputVersion(version); ok {
+		version = output.Version
+	}
+	for _, w := range []*ImageWorkflow{
+		img.Versions[version].workflow(),
+		img.Defaults.workflow(),
+		img.Workflow,
+	} {
+		if w != nil && w.Cache != nil {
+			return w.Cache
+		}
+	}
+	return nil
+}
+
+func parseRunsOn(raw interface{}) (RunnerLabels, error) {
+	entries, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("workflow.runs_on must be a list of runner labels")
+	}
+	labels := make(RunnerLabels, 0, len(entries))
+	for _, entry := range entries {
+		label, ok := entry.(string)
+		if !ok {
+			return nil, fmt.Errorf("workflow.runs_on: %v is not a runner label; quote it if it is one", entry)
+		}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+// WorkflowRunsOn returns the runner labels set for the given version, or
+// variant output, with the same precedence as WorkflowEnabled, or nil when
+// the image leaves them to defaults.workflow.runs_on.
+func (img Image) WorkflowRunsOn(version string) []string {
+	if output, ok := img.OutputVersion(version); ok {
+		version = output.Version
+	}
+	for _, w := range []*ImageWorkflow{
+		img.Versions[version].workflow(),
+		img.Defaults.workflow(),
+		img.Workflow,
+	} {
+		if w != nil && w.RunsOn != nil {
+			return w.RunsOn
+		}
+	}
+	return nil
+}
+
+// WorkflowTimeout returns the job timeout in minutes set for the given
+// version, or variant output, with the same precedence as WorkflowEnabled,
+// or nil when the image leaves it to defaults.workflow.timeout_minutes.
+func (img Image) WorkflowTimeout(version string) *int {
+	if output, ok := img.OutputVersion(version); ok {
+		version = output.Version
+	}
+	for _, w := range []*ImageWorkflow{
+		img.Versions[version].workflow(),
+		img.Defaults.workflow(),
+		img.Workflow,
+	} {
+		if w != nil && w.TimeoutMinutes != nil {
+			return w.TimeoutMinutes
+		}
+	}
+	return nil
+}
+
+func parsePlatforms(raw interface{}) ([]string, error) {
+	entries, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("workflow.platforms must be a list of platforms")
+	}
+	platforms := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		platform, ok := entry.(string)
+		if !ok {
+			return nil, fmt.Errorf("workflow.platforms: %v is not a platform", entry)
+		}
+		platforms = append(platforms, platform)
+	}
+	return platforms, nil
+}
+
+// WorkflowPlatforms returns the platforms set for the given version, or
+// variant output, with the same precedence as WorkflowEnabled, or nil when
+// the image leaves them to defaults.workflow.platforms.
+func (img Image) WorkflowPlatforms(version string) []string {
+	if output, ok := img.OutputVersion(version); ok {
+		version = output.Version
+	}
+	for _, w := range []*ImageWorkflow{
+		img.Versions[version].workflow(),
+		img.Defaults.workflow(),
+		img.Workflow,
+	} {
+		if w != nil && w.Platforms != nil {
+			return w.Platforms
+		}
+	}
+	return nil
+}
+
+func parseDependsOn(raw interface{}) ([]string, error) {
+	entries, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("depends_on must be a list of image:version references")
+	}
+	dependsOn := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ref, ok := entry.(string)
+		if !ok {
+			return nil, fmt.Errorf("depends_on: %v is not an image:version reference", entry)
+		}
+		dependsOn = append(dependsOn, ref)
+	}
+	return dependsOn, nil
+}
+
+// DeclaredDependencies returns the depends_on list for the given version, or
+// variant output, and whether one is declared at all. These are added to the
+// dependencies parsed from the Dockerfile, e.g. for images only pulled at
+// runtime. The version block takes precedence over image defaults, so an
+// empty list drops the defaults' declarations.
+func (img Image) DeclaredDependencies(version string) ([]string, bool) {
+	if output, ok := img.OutputVersion(version); ok {
+		version = output.Version
+	}
+	for _, ic := range []*ImageConfig{img.Versions[version], img.Defaults} {
+		if ic != nil && ic.DependsOn != nil {
+			return ic.DependsOn, true
+		}
+	}
+	return nil, false
+}
+
+// copyStrings copies s, keeping the distinction between nil and empty.
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
+
+func copyBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	value := *b
+	return &value
+}
+
+func (ic *ImageConfig) workflow() *ImageWorkflow {
+	if ic == nil {
+		return nil
+	}
+	return ic.Workflow
+}
+
 func deepCopyValue(val interface{}) interface{} {
 	switch v := val.(type) {
 	case map[string]interface{}:
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"gopkg.in/yaml.v3"
@@ -49,6 +51,24 @@ base_image:
 			},
 			wantErr: false,
 		},
+		{
+			name: "base image with digest",
+			yaml: `
+base_image:
+  name: alpine:3.21
+  source: dockerhub
+  digest: sha256:a8560b36e8b8210634f77d9f7f9efd7ffa463e380b75e2e74aff4511df3ef88c
+`,
+			want: &ImageConfig{
+				BaseImage: &BaseImage{
+					Name:   "alpine:3.21",
+					Source: "dockerhub",
+					Digest: "sha256:a8560b36e8b8210634f77d9f7f9efd7ffa463e380b75e2e74aff4511df3ef88c",
+				},
+				Values: map[string]interface{}{},
+			},
+			wantErr: false,
+		},
 		{
 			name: "without base image",
 			yaml: `
@@ -64,6 +84,21 @@ port: 8080
 			},
 			wantErr: false,
 		},
+		{
+			name: "with workflow disabled",
+			yaml: `
+workflow:
+  enabled: false
+key: value
+`,
+			want: &ImageConfig{
+				Workflow: &ImageWorkflow{Enabled: boolPtr(false)},
+				Values: map[string]interface{}{
+					"key": "value",
+				},
+			},
+			wantErr: false,
+		},
 		{
 			name: "empty config",
 			yaml: `{}`,
@@ -110,6 +145,18 @@ func TestImageConfig_MarshalYAML(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "base image with digest",
+			config: &ImageConfig{
+				BaseImage: &BaseImage{
+					Name:   "alpine:3.21",
+					Source: "dockerhub",
+					Digest: "sha256:a8560b36e8b8210634f77d9f7f9efd7ffa463e380b75e2e74aff4511df3ef88c",
+				},
+				Values: map[string]interface{}{},
+			},
+			wantErr: false,
+		},
 		{
 			name: "without base image",
 			config: &ImageConfig{
@@ -287,6 +334,35 @@ func TestImageConfig_Merge(t *testing.T) {
 	}
 }
 
+func TestImageConfig_MergeWorkflow(t *testing.T) {
+	var version, defaults ImageConfig
+	if err := yaml.Unmarshal([]byte("workflow:\n  cache: {type: registry, ref: \"cache/{{ .Image }}\"}\n  runs_on: [self-hosted, gpu]\n  timeout_minutes: 120\n"), &version); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if err := yaml.Unmarshal([]byte("workflow:\n  platforms: [linux/amd64]\n"), &defaults); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	for name, merged := range map[string]*ImageConfig{
+		"with defaults":    version.Merge(&defaults),
+		"without defaults": version.Merge(nil),
+	} {
+		if !reflect.DeepEqual(merged.Workflow, version.Workflow) {
+			t.Errorf("%s: Merge() workflow = %+v, want %+v", name, merged.Workflow, version.Workflow)
+			continue
+		}
+		merged.Workflow.Cache.Ref = "changed"
+		merged.Workflow.RunsOn[0] = "changed"
+		*merged.Workflow.TimeoutMinutes = 1
+		if version.Workflow.Cache.Ref == "changed" || version.Workflow.RunsOn[0] == "changed" || *version.Workflow.TimeoutMinutes == 1 {
+			t.Errorf("%s: Merge() workflow shares its settings with the original", name)
+		}
+		version.Workflow.Cache.Ref = "cache/{{ .Image }}"
+		version.Workflow.RunsOn[0] = "self-hosted"
+		*version.Workflow.TimeoutMinutes = 120
+	}
+}
+
 func TestImageConfig_deepCopy(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -392,6 +468,264 @@ func TestDeepCopyValue(t *testing.T) {
 	}
 }
 
+func TestImage_WorkflowEnabled(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   Image
+		version string
+		want    bool
+	}{
+		{
+			name: "enabled by default",
+			image: Image{
+				Versions: map[string]*ImageConfig{"v1": nil},
+			},
+			version: "v1",
+			want:    true,
+		},
+		{
+			name: "disabled at image level",
+			image: Image{
+				Workflow: &ImageWorkflow{Enabled: boolPtr(false)},
+				Versions: map[string]*ImageConfig{"v1": {}},
+			},
+			version: "v1",
+			want:    false,
+		},
+		{
+			name: "image defaults override image level",
+			image: Image{
+				Workflow: &ImageWorkflow{Enabled: boolPtr(false)},
+				Defaults: &ImageConfig{Workflow: &ImageWorkflow{Enabled: boolPtr(true)}},
+				Versions: map[string]*ImageConfig{"v1": {}},
+			},
+			version: "v1",
+			want:    true,
+		},
+		{
+			name: "version overrides image defaults",
+			image: Image{
+				Defaults: &ImageConfig{Workflow: &ImageWorkflow{Enabled: boolPtr(true)}},
+				Versions: map[string]*ImageConfig{
+					"v1": {Workflow: &ImageWorkflow{Enabled: boolPtr(false)}},
+				},
+			},
+			version: "v1",
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.image.WorkflowEnabled(tt.version); got != tt.want {
+				t.Errorf("WorkflowEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImage_WorkflowEnabledRejectsNonBool(t *testing.T) {
+	err := yaml.Unmarshal([]byte("workflow:\n  prepare: [make]\n  enabled: \"no\"\n"), &ImageConfig{})
+	if err == nil || !strings.Contains(err.Error(), "line 3, column 12: workflow.enabled must be true or false") {
+		t.Errorf("Unmarshal() error = %v, want a non-boolean workflow.enabled rejected at its value", err)
+	}
+}
+
+func TestImage_VersionEnabled(t *testing.T) {
+	var image Image
+	manifest := `
+defaults:
+  enabled: false
+versions:
+  "1.20": {}
+  "1.21":
+    enabled: true
+    variants:
+      slim: {}
+`
+	if err := yaml.Unmarshal([]byte(manifest), &image); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+
+	if _, exists := image.Versions["1.21"].Values["enabled"]; exists {
+		t.Error("enabled should not be left in Values")
+	}
+	for version, want := range map[string]bool{"1.20": false, "1.21": true, "1.21-slim": true} {
+		if got := image.VersionEnabled(version); got != want {
+			t.Errorf("VersionEnabled(%s) = %v, want %v", version, got, want)
+		}
+	}
+
+	image.Enabled = boolPtr(false)
+	if image.IsEnabled() || image.VersionEnabled("1.21") {
+		t.Error("a disabled image should disable every version")
+	}
+
+	if err := yaml.Unmarshal([]byte("enabled: \"no\"\n"), &ImageConfig{}); err == nil || !strings.Contains(err.Error(), "enabled must be true or false") {
+		t.Errorf("Unmarshal() error = %v, want a non-boolean enabled rejected", err)
+	}
+}
+
+func TestImage_WorkflowPrepare(t *testing.T) {
+	var image Image
+	manifest := `
+workflow:
+  prepare:
+    - curl -fsSLo jdk.tar.gz "$JDK_URL"
+defaults:
+  workflow:
+    enabled: true
+versions:
+  "21": {}
+  "22":
+    workflow:
+      prepare: []
+`
+	if err := yaml.Unmarshal([]byte(manifest), &image); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+
+	if got := image.WorkflowPrepare("21"); len(got) != 1 || got[0] != `curl -fsSLo jdk.tar.gz "$JDK_URL"` {
+		t.Errorf("WorkflowPrepare(21) = %q, want the image-level command", got)
+	}
+	if got := image.WorkflowPrepare("22"); got == nil || len(got) != 0 {
+		t.Errorf("WorkflowPrepare(22) = %#v, want an explicitly empty list", got)
+	}
+
+	var invalid ImageConfig
+	if err := yaml.Unmarshal([]byte("workflow:\n  prepare: [1]\n"), &invalid); err == nil {
+		t.Error("non-string prepare commands should be rejected")
+	}
+}
+
+func TestImage_WorkflowPlatforms(t *testing.T) {
+	var image Image
+	manifest := `
+defaults:
+  workflow:
+    platforms: [linux/amd64, linux/arm64]
+versions:
+  "21": {}
+  "22":
+    workflow:
+      platforms: [linux/amd64]
+`
+	if err := yaml.Unmarshal([]byte(manifest), &image); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+
+	if got := image.WorkflowPlatforms("21"); strings.Join(got, ",") != "linux/amd64,linux/arm64" {
+		t.Errorf("WorkflowPlatforms(21) = %q, want the image defaults", got)
+	}
+	if got := image.WorkflowPlatforms("22"); strings.Join(got, ",") != "linux/amd64" {
+		t.Errorf("WorkflowPlatforms(22) = %q, want the version's own", got)
+	}
+	if got := (Image{}).WorkflowPlatforms("1"); got != nil {
+		t.Errorf("WorkflowPlatforms() = %q, want nil when unset", got)
+	}
+
+	var invalid ImageConfig
+	if err := yaml.Unmarshal([]byte("workflow:\n  platforms: linux/amd64\n"), &invalid); err == nil {
+		t.Error("a platforms string should be rejected")
+	}
+}
+
+func TestImage_WorkflowRunsOn(t *testing.T) {
+	var image Image
+	manifest := `
+workflow:
+  runs_on: [self-hosted, gpu]
+  timeout_minutes: 120
+versions:
+  "12": {}
+  "11":
+    workflow:
+      runs_on: [ubuntu-24.04]
+      timeout_minutes: 30
+`
+	if err := yaml.Unmarshal([]byte(manifest), &image); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+
+	if got := image.WorkflowRunsOn("12"); strings.Join(got, ",") != "self-hosted,gpu" {
+		t.Errorf("WorkflowRunsOn(12) = %q, want the image's", got)
+	}
+	if got := image.WorkflowTimeout("12"); got == nil || *got != 120 {
+		t.Errorf("WorkflowTimeout(12) = %v, want 120", got)
+	}
+	if got := image.WorkflowRunsOn("11"); strings.Join(got, ",") != "ubuntu-24.04" {
+		t.Errorf("WorkflowRunsOn(11) = %q, want the version's own", got)
+	}
+	if got := image.WorkflowTimeout("11"); got == nil || *got != 30 {
+		t.Errorf("WorkflowTimeout(11) = %v, want 30", got)
+	}
+	if got := (Image{}).WorkflowTimeout("1"); got != nil {
+		t.Errorf("WorkflowTimeout() = %v, want nil when unset", *got)
+	}
+
+	for _, invalid := range []string{
+		"workflow:\n  runs_on: [self-hosted, 4]\nversions: {}\n",
+		"workflow:\n  runs_on: self-hosted\nversions: {}\n",
+		"defaults:\n  workflow:\n    runs_on: [true]\nversions: {}\n",
+		"versions:\n  v1:\n    workflow:\n      timeout_minutes: two hours\n",
+	} {
+		var img Image
+		if err := yaml.Unmarshal([]byte(invalid), &img); err == nil {
+			t.Errorf("yaml.Unmarshal(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestImage_DeclaredDependencies(t *testing.T) {
+	var image Image
+	manifest := `
+defaults:
+  depends_on: ["core:noble"]
+versions:
+  "3.12": {}
+  "3.13":
+    depends_on: ["core:noble", "python-builder:3.13"]
+    variants:
+      slim: {}
+  "scratch":
+    depends_on: []
+`
+	if err := yaml.Unmarshal([]byte(manifest), &image); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+
+	tests := []struct {
+		version      string
+		want         string
+		wantDeclared bool
+	}{
+		{"3.12", "core:noble", true},
+		{"3.13", "core:noble,python-builder:3.13", true},
+		{"3.13-slim", "core:noble,python-builder:3.13", true},
+		{"scratch", "", true},
+	}
+	for _, tt := range tests {
+		got, declared := image.DeclaredDependencies(tt.version)
+		if strings.Join(got, ",") != tt.want || declared != tt.wantDeclared {
+			t.Errorf("DeclaredDependencies(%s) = %v, %v, want %q, %v", tt.version, got, declared, tt.want, tt.wantDeclared)
+		}
+	}
+
+	if _, declared := (Image{Versions: map[string]*ImageConfig{"v1": {}}}).DeclaredDependencies("v1"); declared {
+		t.Error("DeclaredDependencies() without depends_on should be undeclared")
+	}
+
+	merged := image.Versions["scratch"].Merge(image.Defaults)
+	if merged.DependsOn == nil || len(merged.DependsOn) != 0 {
+		t.Errorf("Merge() DependsOn = %#v, want an explicitly empty list", merged.DependsOn)
+	}
+
+	var invalid ImageConfig
+	if err := yaml.Unmarshal([]byte("depends_on: core:noble\n"), &invalid); err == nil {
+		t.Error("depends_on that is not a list should be rejected")
+	}
+}
+
 // Helper functions for testing
 
 func imageConfigEqual(a, b *ImageConfig) bool {
@@ -407,7 +741,20 @@ func imageConfigEqual(a, b *ImageConfig) bool {
 		return false
 	}
 	if a.BaseImage != nil {
-		if a.BaseImage.Name != b.BaseImage.Name || a.BaseImage.Source != b.BaseImage.Source {
+		if a.BaseImage.Name != b.BaseImage.Name || a.BaseImage.Source != b.BaseImage.Source || a.BaseImage.Digest != b.BaseImage.Digest {
+			return false
+		}
+	}
+
+	// Compare Workflow
+	if (a.Workflow == nil) != (b.Workflow == nil) {
+		return false
+	}
+	if a.Workflow != nil {
+		if (a.Workflow.Enabled == nil) != (b.Workflow.Enabled == nil) {
+			return false
+		}
+		if a.Workflow.Enabled != nil && *a.Workflow.Enabled != *b.Workflow.Enabled {
 			return false
 		}
 	}
@@ -416,6 +763,10 @@ func imageConfigEqual(a, b *ImageConfig) bool {
 	return deepEqual(a.Values, b.Values)
 }
 
+func boolPtr(b bool) *bool {
+	return &b
+}
+
 func deepEqual(a, b interface{}) bool {
 	switch av := a.(type) {
 	case map[string]interface{}:
@@ -450,3 +801,45 @@ func deepEqual(a, b interface{}) bool {
 		return a == b
 	}
 }
+
+func TestConfig_PromotionFor(t *testing.T) {
+	cfg := &Config{
+		Defaults: Defaults{Promotion: &Promotion{From: "staging.internal/library", To: "prod.internal/library"}},
+		Images: map[string]Image{
+			"python": {},
+			"app":    {Promotion: &Promotion{From: "staging.internal/apps", To: "prod.internal/apps"}},
+		},
+	}
+
+	if got, err := cfg.PromotionFor("python"); err != nil || got.From != "staging.internal/library" {
+		t.Errorf("PromotionFor(python) = %+v, %v, want the defaults", got, err)
+	}
+	if got, err := cfg.PromotionFor("app"); err != nil || got.From != "staging.internal/apps" {
+		t.Errorf("PromotionFor(app) = %+v, %v, want the image block", got, err)
+	}
+	if _, err := cfg.PromotionFor("missing"); err == nil {
+		t.Error("PromotionFor() should fail for unknown images")
+	}
+
+	cfg.Defaults.Promotion = nil
+	if _, err := cfg.PromotionFor("python"); err == nil {
+		t.Error("PromotionFor() should fail without promotion settings")
+	}
+}
+
+func FuzzImageConfigUnmarshal(f *testing.F) {
+	f.Add([]byte("base_image:\n  name: ubuntu:noble\n  source: dockerhub\npython_version: 3.12.1\n"))
+	f.Add([]byte("workflow:\n  enabled: false\n  prepare: [echo hi]\ndepends_on: [core:noble]\n"))
+	f.Add([]byte("variants:\n  slim:\n    tag_suffix: -slim\n    base_image: {name: python}\n"))
+	f.Add([]byte("base_image: 42\nworkflow: [1]\nvariants: nope\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var ic ImageConfig
+		if err := yaml.Unmarshal(data, &ic); err != nil {
+			return
+		}
+		// A decoded config must survive the operations generation applies.
+		_ = ic.Merge(&ImageConfig{Values: map[string]interface{}{}})
+		_ = ic.deepCopy()
+	})
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPattern matches ${NAME} and ${NAME:-default} references, and the $${
+// escape that keeps a reference literal.
+var envPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandEnv replaces environment variable references in the scalar values of
+// a decoded manifest, so that e.g. `registry: ${DOCKER_REGISTRY}` differs per
+// environment. ${NAME:-default} falls back when NAME is unset or empty, $${
+// renders a literal ${, and a variable that is unset with no default is an
+// error. Keys are left alone, and expanded values are strings unless tagged,
+// e.g. `!!int ${PORT}`.
+func expandEnv(node *yaml.Node) error {
+	var unset []string
+	expandNode(node, &unset)
+	if len(unset) > 0 {
+		return fmt.Errorf("environment variables not set: %s", strings.Join(unset, ", "))
+	}
+	return nil
+}
+
+func expandNode(node *yaml.Node, unset *[]string) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			expandNode(child, unset)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			expandNode(node.Content[i+1], unset)
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return
+		}
+		node.Value = envPattern.ReplaceAllStringFunc(node.Value, func(reference string) string {
+			if reference == "$${" {
+				return "${"
+			}
+			match := envPattern.FindStringSubmatch(reference)
+			value, set := os.LookupEnv(match[1])
+			if value == "" && strings.Contains(reference, ":-") {
+				return match[2]
+			}
+			if !set {
+				*unset = append(*unset, fmt.Sprintf("%s (line %d)", match[1], node.Line))
+			}
+			return value
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLoadReader_ExpandsEnvironment(t *testing.T) {
+	t.Setenv("DOCKER_REGISTRY", "staging.example.com")
+	t.Setenv("VAULT_ADDR", "https://vault.example.com")
+	t.Setenv("EMPTY", "")
+	t.Setenv("PORT", "8080")
+
+	testConfig := `version: 1
+defaults:
+  registry: ${DOCKER_REGISTRY}
+images:
+  myapp:
+    versions:
+      v1:
+        token_url: ${VAULT_ADDR}/v1/auth
+        channel: ${CHANNEL:-stable}
+        flavor: ${EMPTY:-slim}
+        port: !!int ${PORT}
+        literal: $${DOCKER_REGISTRY}/app
+        shell: echo $HOME
+        packages: ["${DOCKER_REGISTRY}"]
+`
+	config, err := loadReader(context.Background(), strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("loadReader() error = %v", err)
+	}
+
+	if config.Defaults.Registry != "staging.example.com" {
+		t.Errorf("Registry = %s, want staging.example.com", config.Defaults.Registry)
+	}
+	values := config.Images["myapp"].Versions["v1"].Values
+	want := map[string]interface{}{
+		"token_url": "https://vault.example.com/v1/auth",
+		"channel":   "stable",
+		"flavor":    "slim",
+		"port":      8080,
+		"literal":   "${DOCKER_REGISTRY}/app",
+		"shell":     "echo $HOME",
+	}
+	for key, value := range want {
+		if values[key] != value {
+			t.Errorf("%s = %#v, want %#v", key, values[key], value)
+		}
+	}
+	if packages, ok := values["packages"].([]interface{}); !ok || len(packages) != 1 || packages[0] != "staging.example.com" {
+		t.Errorf("packages = %#v, want the expanded registry", values["packages"])
+	}
+}
+
+func TestLoadReader_UnsetEnvironment(t *testing.T) {
+	testConfig := `version: 1
+defaults:
+  registry: ${DOCKERFILES_TEST_UNSET}
+images:
+  myapp:
+    versions:
+      v1:
+        url: ${DOCKERFILES_TEST_ALSO_UNSET}/path
+`
+	_, err := loadReader(context.Background(), strings.NewReader(testConfig))
+	want := "environment variables not set: DOCKERFILES_TEST_UNSET (line 3), DOCKERFILES_TEST_ALSO_UNSET (line 8)"
+	if err == nil || err.Error() != want {
+		t.Errorf("loadReader() error = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// HeaderStyle is how the generated-file header is commented in one kind of
+// output file.
+type HeaderStyle string
+
+const (
+	HeaderHash  HeaderStyle = "#"
+	HeaderSlash HeaderStyle = "//"
+	HeaderBlock HeaderStyle = "/* */"
+	HeaderXML   HeaderStyle = "<!-- -->"
+	// HeaderNone omits the header, for formats without comments.
+	HeaderNone HeaderStyle = "none"
+)
+
+func (s *HeaderStyle) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw string
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	switch style := HeaderStyle(raw); style {
+	case HeaderHash, HeaderSlash, HeaderBlock, HeaderXML, HeaderNone:
+		*s = style
+		return nil
+	default:
+		return fmt.Errorf("unknown header style %q (supported: #, //, /* */, <!-- -->, none)", raw)
+	}
+}
+
+// HeaderStyles maps output file extensions, e.g. ".json", to the style of
+// their generated-file header.
+type HeaderStyles map[string]HeaderStyle
+
+// defaultHeaderStyles apply to extensions the manifest does not configure.
+// Every other file gets HeaderHash.
+var defaultHeaderStyles = HeaderStyles{
+	".json": HeaderNone,
+	".xml":  HeaderXML,
+}
+
+// For returns the header style of the output file or extension name.
+// Extensions match case-insensitively, with or without their leading dot.
+func (h HeaderStyles) For(name string) HeaderStyle {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return HeaderHash
+	}
+	for configured, style := range h {
+		if strings.EqualFold("."+strings.TrimPrefix(configured, "."), ext) {
+			return style
+		}
+	}
+	if style, exists := defaultHeaderStyles[ext]; exists {
+		return style
+	}
+	return HeaderHash
+}
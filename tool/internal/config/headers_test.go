@@ -0,0 +1,45 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestHeaderStyles_For(t *testing.T) {
+	styles := HeaderStyles{"js": HeaderSlash, ".JSON": HeaderBlock}
+
+	tests := map[string]HeaderStyle{
+		"Dockerfile":    HeaderHash,
+		"entrypoint.sh": HeaderHash,
+		"app.js":        HeaderSlash,
+		"config.json":   HeaderBlock,
+		".json":         HeaderBlock,
+		"logback.XML":   HeaderXML,
+	}
+	for name, want := range tests {
+		if got := styles.For(name); got != want {
+			t.Errorf("For(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	if got := HeaderStyles(nil).For("settings.json"); got != HeaderNone {
+		t.Errorf("For(settings.json) without configuration = %q, want none", got)
+	}
+}
+
+func TestHeaderStyle_Unmarshal(t *testing.T) {
+	var defaults Defaults
+	if err := yaml.Unmarshal([]byte("headers: {.js: //, .css: /* */, .html: <!-- -->, .json: none}\n"), &defaults); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if defaults.Headers[".css"] != HeaderBlock || defaults.Headers[".html"] != HeaderXML {
+		t.Errorf("Headers = %v", defaults.Headers)
+	}
+
+	err := yaml.Unmarshal([]byte("headers: {.ini: ';'}\n"), &defaults)
+	if err == nil || !strings.Contains(err.Error(), `unknown header style ";"`) {
+		t.Errorf("Unmarshal() error = %v, want an unknown style error", err)
+	}
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadIncludes merges the images of the manifests matched by cfg.Includes,
+// globs relative to dir, the root manifest's directory, into cfg. Included
+// manifests may only set images, and an image defined twice is an error.
+// Their origins are named like rootFile, the root manifest's path.
+func loadIncludes(cfg *Config, dir, rootFile string) error {
+	if len(cfg.Includes) == 0 {
+		return nil
+	}
+
+	rootPath, _ := filepath.Abs(rootFile)
+	seen := make(map[string]bool)
+	var files []string
+	for _, pattern := range cfg.Includes {
+		if filepath.IsAbs(pattern) {
+			return fmt.Errorf("includes: %s must be relative to the manifest directory", pattern)
+		}
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return fmt.Errorf("includes: %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("includes: %s matches no files", pattern)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if match == rootPath || seen[match] {
+				continue
+			}
+			seen[match] = true
+			files = append(files, match)
+		}
+	}
+
+	if cfg.Images == nil {
+		cfg.Images = make(map[string]Image)
+	}
+	for _, file := range files {
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return fmt.Errorf("includes: %w", err)
+		}
+		name := filepath.Join(filepath.Dir(rootFile), rel)
+
+		images, err := loadInclude(file, name)
+		if err != nil {
+			return err
+		}
+		for imageName, image := range images {
+			if existing, defined := cfg.Images[imageName]; defined {
+				return fmt.Errorf("image %q is defined in both %s and %s", imageName, existing.Origin, image.Origin)
+			}
+			cfg.Images[imageName] = image
+		}
+	}
+	return nil
+}
+
+// loadInclude reads the images of the included manifest file, recording
+// name as their origin file.
+func loadInclude(file, name string) (map[string]Image, error) {
+	f, err := os.Open(file) // #nosec
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	limited := &sizeLimitReader{r: f, n: MaxManifestSize}
+	var root yaml.Node
+	if err := yaml.NewDecoder(limited).Decode(&root); err != nil && !errors.Is(err, io.EOF) {
+		if limited.exceeded {
+			return nil, fmt.Errorf("%s: %w", name, errManifestTooLarge)
+		}
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	if err := expandEnv(&root); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := checkDuplicateKeys(&root); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	if mapping := documentMapping(&root); mapping != nil {
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			if key := mapping.Content[i]; key.Value != "images" {
+				return nil, fmt.Errorf("%s:%d: included manifests can only set images, not %s", name, key.Line, key.Value)
+			}
+		}
+	}
+
+	var included Config
+	if err := root.Decode(&included); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	recordOrigins(&root, &included)
+	setOriginFile(&included, name)
+	return included.Images, nil
+}
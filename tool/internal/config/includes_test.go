@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeManifests(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestLoad_Includes(t *testing.T) {
+	dir := t.TempDir()
+	writeManifests(t, dir, map[string]string{
+		"manifest.yaml": `version: 1
+includes: ["*/manifest.yaml"]
+defaults:
+  registry: test.io
+images:
+  core:
+    versions:
+      bookworm: {}
+`,
+		"lang/manifest.yaml": `images:
+  python:
+    path: lang/python
+    versions:
+      "3.13":
+        python_version: "3.13"
+`,
+		"util/manifest.yaml": `images:
+  yq:
+    versions:
+      "4": {}
+`,
+	})
+	rootFile := filepath.Join(dir, "manifest.yaml")
+
+	cfg, err := Load(rootFile)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Defaults.BasePath != dir {
+		t.Errorf("BasePath = %s, want %s", cfg.Defaults.BasePath, dir)
+	}
+	if cfg.File != rootFile {
+		t.Errorf("File = %s, want %s", cfg.File, rootFile)
+	}
+	for name, want := range map[string]Origin{
+		"core":   {File: rootFile, Line: 6},
+		"python": {File: filepath.Join(dir, "lang", "manifest.yaml"), Line: 2},
+		"yq":     {File: filepath.Join(dir, "util", "manifest.yaml"), Line: 2},
+	} {
+		image, exists := cfg.Images[name]
+		if !exists {
+			t.Fatalf("image %s not loaded", name)
+		}
+		if image.Origin != want {
+			t.Errorf("%s origin = %v, want %v", name, image.Origin, want)
+		}
+	}
+	if got := cfg.Images["python"].Versions["3.13"].Values["python_version"]; got != "3.13" {
+		t.Errorf("python_version = %v, want 3.13", got)
+	}
+}
+
+func TestLoad_IncludesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name: "duplicate image",
+			files: map[string]string{
+				"a/manifest.yaml": "images:\n  python:\n    versions: {}\n",
+				"b/manifest.yaml": "images:\n  python:\n    versions: {}\n",
+			},
+			wantErr: `image "python" is defined in both ` + filepath.Join("DIR", "a", "manifest.yaml") + ":2 and " + filepath.Join("DIR", "b", "manifest.yaml") + ":2",
+		},
+		{
+			name: "defaults outside the root",
+			files: map[string]string{
+				"a/manifest.yaml": "defaults:\n  registry: other.io\n",
+			},
+			wantErr: filepath.Join("DIR", "a", "manifest.yaml") + ":1: included manifests can only set images, not defaults",
+		},
+		{
+			name:    "no matches",
+			files:   map[string]string{},
+			wantErr: "includes: */manifest.yaml matches no files",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.files["manifest.yaml"] = "version: 1\nincludes: [\"*/manifest.yaml\"]\nimages: {}\n"
+			writeManifests(t, dir, tt.files)
+
+			_, err := Load(filepath.Join(dir, "manifest.yaml"))
+			want := strings.ReplaceAll(tt.wantErr, "DIR", dir)
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("Load() error = %v, want it to contain %q", err, want)
+			}
+		})
+	}
+}
@@ -1,21 +1,41 @@
 package config
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 func Load(path string) (*Config, error) {
+	return LoadContext(context.Background(), path)
+}
+
+// LoadContext is Load with ctx bounding the fetch of defaults.preset.
+func LoadContext(ctx context.Context, path string) (*Config, error) {
+	config, err := load(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRequires(config.Requires, ToolVersion(), IgnoreRequires); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func load(ctx context.Context, path string) (*Config, error) {
 	if path == "-" {
-		config, err := loadReader(os.Stdin)
+		config, err := loadReader(ctx, os.Stdin)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("<stdin>: %w", err)
 		}
 
 		cwd, err := os.Getwd()
@@ -23,10 +43,15 @@ func Load(path string) (*Config, error) {
 			return nil, fmt.Errorf("failed to get working directory: %w", err)
 		}
 		config.Defaults.BasePath = cwd
+		config.File = "<stdin>"
+		setOriginFile(config, config.File)
+		if err := loadIncludes(config, cwd, config.File); err != nil {
+			return nil, err
+		}
 		return config, nil
 	}
 	if path != "" {
-		return loadFile(path)
+		return loadFile(ctx, path)
 	}
 	for _, f := range [6]string{
 		"images/manifest.yml",
@@ -36,7 +61,7 @@ func Load(path string) (*Config, error) {
 		".manifest.yml",
 		".manifest.yaml",
 	} {
-		m, err := loadFile(f)
+		m, err := loadFile(ctx, f)
 		if err != nil && errors.Is(err, fs.ErrNotExist) {
 			continue
 		}
@@ -46,7 +71,7 @@ func Load(path string) (*Config, error) {
 	return nil, fmt.Errorf("no config file found in any of the default locations")
 }
 
-func loadFile(file string) (*Config, error) {
+func loadFile(ctx context.Context, file string) (*Config, error) {
 	f, err := os.Open(file) // #nosec
 	if err != nil {
 		return nil, err
@@ -55,9 +80,9 @@ func loadFile(file string) (*Config, error) {
 		_ = f.Close()
 	}()
 
-	config, err := loadReader(f)
+	config, err := loadReader(ctx, f)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w", file, err)
 	}
 
 	absPath, err := filepath.Abs(file)
@@ -65,35 +90,197 @@ func loadFile(file string) (*Config, error) {
 		return nil, fmt.Errorf("failed to get absolute path of config file: %w", err)
 	}
 	config.Defaults.BasePath = filepath.Dir(absPath)
+	config.File = file
+	setOriginFile(config, file)
+	if err := loadIncludes(config, config.Defaults.BasePath, file); err != nil {
+		return nil, err
+	}
 
 	return config, nil
 }
 
-func loadReader(fd io.Reader) (*Config, error) {
-	data, err := io.ReadAll(fd)
-	if err != nil {
-		return nil, err
-	}
+// MaxManifestSize bounds the bytes read from a manifest, so that an
+// unexpectedly large input fails with an explicit error.
+const MaxManifestSize = 8 << 20
+
+// errManifestTooLarge is returned by sizeLimitReader past MaxManifestSize.
+var errManifestTooLarge = fmt.Errorf("manifest is larger than %d bytes", MaxManifestSize)
 
-	var versioned struct {
-		Version int `yaml:"version"`
+// sizeLimitReader fails with errManifestTooLarge once more than n bytes have
+// been read.
+type sizeLimitReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		l.exceeded = true
+		return 0, errManifestTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
 	}
-	if err := yaml.Unmarshal(data, &versioned); err != nil {
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		l.exceeded = true
+		return n, errManifestTooLarge
+	}
+	return n, err
+}
+
+// loadReader parses a manifest from fd. The stream is decoded once into a
+// node tree, which is used both to sniff the schema version and to decode the
+// full config, so the raw bytes are never held in memory.
+func loadReader(ctx context.Context, fd io.Reader) (*Config, error) {
+	limited := &sizeLimitReader{r: fd, n: MaxManifestSize}
+	var root yaml.Node
+	if err := yaml.NewDecoder(limited).Decode(&root); err != nil && !errors.Is(err, io.EOF) {
+		if limited.exceeded {
+			return nil, errManifestTooLarge
+		}
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
-	if versioned.Version == 0 {
-		return nil, fmt.Errorf("config version is required")
+	if err := expandEnv(&root); err != nil {
+		return nil, err
 	}
 
-	switch versioned.Version {
+	if err := checkDuplicateKeys(&root); err != nil {
+		return nil, err
+	}
+
+	version, err := configVersion(&root)
+	if err != nil {
+		return nil, err
+	}
+
+	switch version {
 	case 1:
+		if err := applyPreset(ctx, &root); err != nil {
+			return nil, err
+		}
 		var config Config
-		if err := yaml.Unmarshal(data, &config); err != nil {
-			return nil, fmt.Errorf("failed to parse v1 config: %w", err)
+		if err := root.Decode(&config); err != nil {
+			return nil, fmt.Errorf("failed to parse v1 config: %w", locateTypeError(&root, err))
 		}
+		recordOrigins(&root, &config)
 		return &config, nil
 	default:
-		return nil, fmt.Errorf("unsupported config version %d (only version 1 is supported)", versioned.Version)
+		return nil, fmt.Errorf("unsupported config version %d (only version 1 is supported)", version)
+	}
+}
+
+// checkDuplicateKeys rejects an image defined twice under images, or a
+// version defined twice under an image's versions, naming both lines, before
+// decoding reports it as a plain mapping key error.
+func checkDuplicateKeys(root *yaml.Node) error {
+	images := mappingValue(documentMapping(root), "images")
+	if err := duplicateKey(images, "image"); err != nil {
+		return err
+	}
+	if images == nil || images.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(images.Content); i += 2 {
+		versions := mappingValue(images.Content[i+1], "versions")
+		if err := duplicateKey(versions, "version"); err != nil {
+			return fmt.Errorf("image %q: %w", images.Content[i].Value, err)
+		}
+	}
+	return nil
+}
+
+// duplicateKey reports the first key of a mapping node that repeats an
+// earlier one, described as a kind such as "image".
+func duplicateKey(node *yaml.Node, kind string) error {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	lines := make(map[string]int, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if key.Value == "<<" && key.Tag == "!!merge" {
+			continue
+		}
+		if first, seen := lines[key.Value]; seen {
+			return fmt.Errorf("duplicate %s %q at lines %d and %d", kind, key.Value, first, key.Line)
+		}
+		lines[key.Value] = key.Line
+	}
+	return nil
+}
+
+// nodeError prefixes err with the line and column of node.
+func nodeError(node *yaml.Node, err error) error {
+	return fmt.Errorf("line %d, column %d: %w", node.Line, node.Column, err)
+}
+
+// typeErrorPattern matches the location and source tag that yaml.v3 gives
+// each message of a *yaml.TypeError.
+var typeErrorPattern = regexp.MustCompile(`^line (\d+): cannot unmarshal (!!\w+)`)
+
+// locateTypeError adds a column to the messages of a *yaml.TypeError, which
+// only carry a line, by finding the value on that line with the reported tag.
+// Other errors are returned unchanged.
+func locateTypeError(root *yaml.Node, err error) error {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+	located := &yaml.TypeError{Errors: make([]string, len(typeErr.Errors))}
+	for i, message := range typeErr.Errors {
+		located.Errors[i] = message
+		match := typeErrorPattern.FindStringSubmatch(message)
+		if match == nil {
+			continue
+		}
+		line, _ := strconv.Atoi(match[1])
+		if node := findValue(root, line, match[2]); node != nil {
+			located.Errors[i] = fmt.Sprintf("line %d, column %d%s", line, node.Column, strings.TrimPrefix(message, "line "+match[1]))
+		}
+	}
+	return located
+}
+
+// findValue returns the first value node on line tagged tag, skipping
+// mapping keys so that `port: abc` resolves to abc rather than port.
+func findValue(node *yaml.Node, line int, tag string) *yaml.Node {
+	if node.Kind != yaml.DocumentNode && node.Line == line && node.ShortTag() == tag {
+		return node
+	}
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if found := findValue(child, line, tag); found != nil {
+				return found
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if found := findValue(node.Content[i+1], line, tag); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// configVersion reads the top-level version key from a decoded document.
+func configVersion(root *yaml.Node) (int, error) {
+	node := mappingValue(documentMapping(root), "version")
+	if node == nil {
+		return 0, fmt.Errorf("config version is required")
+	}
+
+	var version int
+	if err := node.Decode(&version); err != nil {
+		return 0, fmt.Errorf("failed to parse config: %w", locateTypeError(root, err))
+	}
+	if version == 0 {
+		return 0, fmt.Errorf("config version is required")
 	}
+	return version, nil
 }
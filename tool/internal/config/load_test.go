@@ -1,10 +1,17 @@
 package config
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestLoad_FromStdin(t *testing.T) {
@@ -229,7 +236,7 @@ images:
 		t.Fatalf("Failed to write test config: %v", err)
 	}
 
-	config, err := loadFile(configPath)
+	config, err := loadFile(context.Background(), configPath)
 	if err != nil {
 		t.Fatalf("loadFile() error = %v", err)
 	}
@@ -259,6 +266,60 @@ images:
 	}
 }
 
+func TestLoadFile_ErrorLocations(t *testing.T) {
+	tests := []struct {
+		name       string
+		testConfig string
+		wantErr    string
+	}{
+		{
+			name: "base image as a string",
+			testConfig: `version: 1
+images:
+  app:
+    versions:
+      "1.0":
+        base_image: ubuntu
+`,
+			wantErr: "line 6, column 21: base_image must be a mapping",
+		},
+		{
+			name: "type error",
+			testConfig: `version: 1
+images:
+  app:
+    path: [images, app]
+`,
+			wantErr: "line 4, column 11: cannot unmarshal !!seq into string",
+		},
+		{
+			name: "malformed workflow",
+			testConfig: `version: 1
+images:
+  app:
+    defaults:
+      workflow:
+        platforms: linux/amd64
+`,
+			wantErr: "line 6, column 9: workflow.platforms must be a list of platforms",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "manifest.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.testConfig), 0644); err != nil {
+				t.Fatalf("Failed to write test config: %v", err)
+			}
+
+			_, err := loadFile(context.Background(), configPath)
+			if err == nil || !strings.HasPrefix(err.Error(), configPath+": ") || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadFile() error = %v, want %s: ...%s", err, configPath, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestLoadReader_Version1(t *testing.T) {
 	testConfig := `version: 1
 defaults:
@@ -272,7 +333,7 @@ images:
         custom_key: custom_value
 `
 	reader := strings.NewReader(testConfig)
-	config, err := loadReader(reader)
+	config, err := loadReader(context.Background(), reader)
 	if err != nil {
 		t.Fatalf("loadReader() error = %v", err)
 	}
@@ -307,7 +368,7 @@ images:
   test: {}
 `
 	reader := strings.NewReader(testConfig)
-	_, err := loadReader(reader)
+	_, err := loadReader(context.Background(), reader)
 	if err == nil {
 		t.Error("loadReader() should return error for missing version")
 	}
@@ -322,7 +383,7 @@ images:
   test: {}
 `
 	reader := strings.NewReader(testConfig)
-	_, err := loadReader(reader)
+	_, err := loadReader(context.Background(), reader)
 	if err == nil {
 		t.Error("loadReader() should return error for unsupported version")
 	}
@@ -339,17 +400,202 @@ images:
 	  yaml: structure
 `
 	reader := strings.NewReader(testConfig)
-	_, err := loadReader(reader)
+	_, err := loadReader(context.Background(), reader)
 	if err == nil {
 		t.Error("loadReader() should return error for invalid YAML")
 	}
 }
 
+func TestLoadReader_DuplicateKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		testConfig string
+		wantErr    string
+	}{
+		{
+			name: "duplicate image",
+			testConfig: `version: 1
+images:
+  python:
+    versions: {}
+  "python":
+    versions: {}
+`,
+			wantErr: `duplicate image "python" at lines 3 and 5`,
+		},
+		{
+			name: "duplicate version",
+			testConfig: `version: 1
+images:
+  alpine:
+    versions:
+      "3.19":
+        patch: "3.19.1"
+      "3.20": {}
+      3.19:
+        patch: "3.19.4"
+`,
+			wantErr: `image "alpine": duplicate version "3.19" at lines 5 and 8`,
+		},
+		{
+			name: "merge keys are not duplicates",
+			testConfig: `version: 1
+common: &common
+  "3.19": {}
+images:
+  alpine:
+    versions:
+      <<: *common
+      "3.20": {}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadReader(context.Background(), strings.NewReader(tt.testConfig))
+			if tt.wantErr == "" {
+				if err != nil && strings.Contains(err.Error(), "duplicate") {
+					t.Errorf("loadReader() error = %v, want no duplicate", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("loadReader() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestLoadReader_EmptyConfig(t *testing.T) {
 	testConfig := ``
 	reader := strings.NewReader(testConfig)
-	_, err := loadReader(reader)
+	_, err := loadReader(context.Background(), reader)
 	if err == nil {
 		t.Error("loadReader() should return error for empty config")
 	}
 }
+
+func TestLoadReader_NonMappingDocument(t *testing.T) {
+	_, err := loadReader(context.Background(), strings.NewReader("- just\n- a list\n"))
+	if err == nil || !strings.Contains(err.Error(), "version is required") {
+		t.Errorf("loadReader() error = %v, want version is required", err)
+	}
+}
+
+func TestLoadReader_MatchesBufferedParse(t *testing.T) {
+	manifest := syntheticManifest(50, 5)
+
+	want, err := loadReaderBuffered(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("loadReaderBuffered() error = %v", err)
+	}
+	got, err := loadReader(context.Background(), strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("loadReader() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Error("loadReader() produced a different config than the buffered parse")
+	}
+}
+
+func BenchmarkLoadReader(b *testing.B) {
+	manifest := syntheticManifest(2000, 10)
+	b.SetBytes(int64(len(manifest)))
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if _, err := loadReader(context.Background(), strings.NewReader(manifest)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadReaderBuffered(b *testing.B) {
+	manifest := syntheticManifest(2000, 10)
+	b.SetBytes(int64(len(manifest)))
+	b.ReportAllocs()
+
+	for b.Loop() {
+		if _, err := loadReaderBuffered(strings.NewReader(manifest)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// loadReaderBuffered is the previous loading strategy: read everything, then
+// unmarshal once to sniff the version and again for the full config.
+func loadReaderBuffered(fd io.Reader) (*Config, error) {
+	data, err := io.ReadAll(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	var versioned struct {
+		Version int `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &versioned); err != nil {
+		return nil, err
+	}
+	if versioned.Version != 1 {
+		return nil, fmt.Errorf("unexpected version %d", versioned.Version)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := root.Decode(&config); err != nil {
+		return nil, err
+	}
+	recordOrigins(&root, &config)
+	return &config, nil
+}
+
+func syntheticManifest(images, versions int) string {
+	var b strings.Builder
+	b.WriteString("version: 1\ndefaults:\n  registry: registry.example.com\nimages:\n")
+	for i := 0; i < images; i++ {
+		fmt.Fprintf(&b, "  image-%d:\n", i)
+		fmt.Fprintf(&b, "    path: images/image-%d\n", i)
+		b.WriteString("    defaults:\n")
+		b.WriteString("      base_image:\n        name: core\n        tag: noble\n")
+		b.WriteString("      packages:\n        - curl\n        - git\n")
+		b.WriteString("    versions:\n")
+		for v := 0; v < versions; v++ {
+			fmt.Fprintf(&b, "      \"%d.%d\":\n", i, v)
+			fmt.Fprintf(&b, "        release: %d.%d.%d\n", i, v, i+v)
+			fmt.Fprintf(&b, "        checksum: sha256-%08x\n", i*versions+v)
+		}
+	}
+	return b.String()
+}
+
+func TestLoadReader_TooLarge(t *testing.T) {
+	manifest := "version: 1\nimages: {}\n# " + strings.Repeat("x", MaxManifestSize) + "\n"
+	_, err := loadReader(context.Background(), strings.NewReader(manifest))
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("loadReader() error = %v, want the size limit", err)
+	}
+}
+
+func FuzzLoadReader(f *testing.F) {
+	f.Add([]byte("version: 1\nimages:\n  core:\n    path: core\n    versions:\n      noble:\n        base_image: {name: ubuntu:noble, source: dockerhub}\n"))
+	f.Add([]byte("version: 1\ndefaults: &d {registry: test.io}\nimages:\n  a: {versions: {v1: *d}}\n"))
+	f.Add([]byte("version: 1\nimages:\n  a:\n    versions:\n      v1:\n        variants: {slim: {tag_suffix: -slim}}\n        depends_on: [b:v1]\n"))
+	f.Add([]byte("version: 2\n"))
+	f.Add([]byte("[[[[[[[[[[[[[[[["))
+
+	// Presets would be fetched over the network.
+	NoRemote = true
+	defer func() { NoRemote = false }()
+
+	f.Fuzz(func(t *testing.T, manifest []byte) {
+		cfg, err := loadReader(context.Background(), bytes.NewReader(manifest))
+		if err == nil && cfg == nil {
+			t.Error("loadReader() returned neither a config nor an error")
+		}
+	})
+}
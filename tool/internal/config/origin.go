@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Origin is the manifest location an image or version was defined at.
+type Origin struct {
+	File string
+	Line int
+}
+
+func (o Origin) IsZero() bool {
+	return o.Line == 0
+}
+
+func (o Origin) String() string {
+	if o.File == "" {
+		return fmt.Sprintf("line %d", o.Line)
+	}
+	return fmt.Sprintf("%s:%d", o.File, o.Line)
+}
+
+// recordOrigins walks the document node and stores the line of each image and
+// version key on the decoded config.
+func recordOrigins(root *yaml.Node, cfg *Config) {
+	images := mappingValue(documentMapping(root), "images")
+	if images == nil {
+		return
+	}
+
+	for i := 0; i+1 < len(images.Content); i += 2 {
+		imageKey, imageNode := images.Content[i], images.Content[i+1]
+		image, exists := cfg.Images[imageKey.Value]
+		if !exists {
+			continue
+		}
+		image.Origin = Origin{Line: imageKey.Line}
+
+		if versions := mappingValue(imageNode, "versions"); versions != nil {
+			for j := 0; j+1 < len(versions.Content); j += 2 {
+				versionKey := versions.Content[j]
+				if versionConfig := image.Versions[versionKey.Value]; versionConfig != nil {
+					versionConfig.Origin = Origin{Line: versionKey.Line}
+				}
+			}
+		}
+		cfg.Images[imageKey.Value] = image
+	}
+}
+
+// setOriginFile records file on every origin in cfg.
+func setOriginFile(cfg *Config, file string) {
+	for imageName, image := range cfg.Images {
+		image.Origin.File = file
+		for _, versionConfig := range image.Versions {
+			if versionConfig != nil {
+				versionConfig.Origin.File = file
+			}
+		}
+		cfg.Images[imageName] = image
+	}
+}
+
+func documentMapping(root *yaml.Node) *yaml.Node {
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	return root
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFile_RecordsOrigins(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "manifest.yaml")
+
+	testConfig := `version: 1
+images:
+  core:
+    path: images/core
+    versions:
+      noble: {}
+  python:
+    path: images/python
+    versions:
+      "3.12":
+        python_version: 3.12.1
+      "3.13":
+`
+	if err := os.WriteFile(configPath, []byte(testConfig), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+
+	cfg, err := loadFile(context.Background(), configPath)
+	if err != nil {
+		t.Fatalf("loadFile() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  Origin
+		want Origin
+	}{
+		{"core", cfg.Images["core"].Origin, Origin{File: configPath, Line: 3}},
+		{"core:noble", cfg.Images["core"].VersionOrigin("noble"), Origin{File: configPath, Line: 6}},
+		{"python", cfg.Images["python"].Origin, Origin{File: configPath, Line: 7}},
+		{"python:3.12", cfg.Images["python"].VersionOrigin("3.12"), Origin{File: configPath, Line: 10}},
+		// A version without a body decodes to nil and falls back to the image.
+		{"python:3.13", cfg.Images["python"].VersionOrigin("3.13"), Origin{File: configPath, Line: 7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("origin = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrigin_String(t *testing.T) {
+	if got := (Origin{File: "manifest.yaml", Line: 4}).String(); got != "manifest.yaml:4" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := (Origin{Line: 4}).String(); got != "line 4" {
+		t.Errorf("String() = %q", got)
+	}
+}
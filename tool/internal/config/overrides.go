@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParseOverrides turns --set, --set-string and --set-file arguments of the
+// form key=value into a nested values map. Dotted keys create nested maps.
+// Values from set are typed like YAML scalars (numbers, bools), values from
+// setString are always strings, and setFile values name a file whose contents
+// become the value. Setting the same key through two different flags is an
+// error; within one flag the last occurrence wins.
+func ParseOverrides(set, setString, setFile []string) (map[string]interface{}, error) {
+	overrides := make(map[string]interface{})
+	sources := make(map[string]string)
+
+	apply := func(flag string, args []string, convert func(string) (interface{}, error)) error {
+		for _, arg := range args {
+			key, raw, ok := strings.Cut(arg, "=")
+			if !ok || key == "" {
+				return fmt.Errorf("invalid --%s %q: expected key=value", flag, arg)
+			}
+			if previous, exists := sources[key]; exists && previous != flag {
+				return fmt.Errorf("key %q set by both --%s and --%s", key, previous, flag)
+			}
+			value, err := convert(raw)
+			if err != nil {
+				return fmt.Errorf("invalid --%s %q: %w", flag, arg, err)
+			}
+			if err := setPath(overrides, strings.Split(key, "."), value); err != nil {
+				return fmt.Errorf("invalid --%s %q: %w", flag, arg, err)
+			}
+			sources[key] = flag
+		}
+		return nil
+	}
+
+	if err := apply("set", set, func(raw string) (interface{}, error) {
+		return inferScalar(raw), nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := apply("set-string", setString, func(raw string) (interface{}, error) {
+		return raw, nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := apply("set-file", setFile, func(raw string) (interface{}, error) {
+		content, err := os.ReadFile(raw) // #nosec
+		if err != nil {
+			return nil, err
+		}
+		return string(content), nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return overrides, nil
+}
+
+// OverrideKeys returns the dotted paths of every leaf in overrides, sorted.
+func OverrideKeys(overrides map[string]interface{}) []string {
+	var keys []string
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+				walk(path, nested)
+				continue
+			}
+			keys = append(keys, path)
+		}
+	}
+	walk("", overrides)
+	sort.Strings(keys)
+	return keys
+}
+
+// ApplyOverrides merges overrides into the config's values, descending into
+// nested maps so sibling keys are preserved.
+func (ic *ImageConfig) ApplyOverrides(overrides map[string]interface{}) {
+	if ic.Values == nil {
+		ic.Values = make(map[string]interface{})
+	}
+	mergeInto(ic.Values, overrides)
+}
+
+func setPath(m map[string]interface{}, path []string, value interface{}) error {
+	for i, segment := range path {
+		if segment == "" {
+			return fmt.Errorf("empty key segment")
+		}
+		if i == len(path)-1 {
+			m[segment] = value
+			return nil
+		}
+		next, ok := m[segment].(map[string]interface{})
+		if !ok {
+			if _, exists := m[segment]; exists {
+				return fmt.Errorf("%s is not a map", strings.Join(path[:i+1], "."))
+			}
+			next = make(map[string]interface{})
+			m[segment] = next
+		}
+		m = next
+	}
+	return nil
+}
+
+// inferScalar types raw the way YAML would for plain numbers and bools, and
+// keeps everything else as a string.
+func inferScalar(raw string) interface{} {
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+		return raw
+	}
+	switch value.(type) {
+	case int, float64, bool:
+		return value
+	default:
+		return raw
+	}
+}
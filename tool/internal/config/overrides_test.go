@@ -0,0 +1,136 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseOverrides(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "motd.txt")
+	if err := os.WriteFile(filePath, []byte("hello\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		set       []string
+		setString []string
+		setFile   []string
+		want      map[string]interface{}
+		wantErr   bool
+	}{
+		{
+			name: "type inference",
+			set:  []string{"port=8080", "debug=true", "ratio=0.5", "python_version=3.13.0rc1", "registry=localhost:5000"},
+			want: map[string]interface{}{
+				"port":           8080,
+				"debug":          true,
+				"ratio":          0.5,
+				"python_version": "3.13.0rc1",
+				"registry":       "localhost:5000",
+			},
+		},
+		{
+			name: "structured yaml stays a string",
+			set:  []string{"list=[1, 2]", "empty="},
+			want: map[string]interface{}{"list": "[1, 2]", "empty": ""},
+		},
+		{
+			name: "dotted paths",
+			set:  []string{"build.flags=-O2", "build.jobs=4"},
+			want: map[string]interface{}{
+				"build": map[string]interface{}{"flags": "-O2", "jobs": 4},
+			},
+		},
+		{
+			name:      "set-string forces strings",
+			setString: []string{"version=1.20", "enabled=true"},
+			want:      map[string]interface{}{"version": "1.20", "enabled": "true"},
+		},
+		{
+			name:    "set-file reads contents",
+			setFile: []string{"motd=" + filePath},
+			want:    map[string]interface{}{"motd": "hello\n"},
+		},
+		{
+			name: "last occurrence wins",
+			set:  []string{"port=1", "port=2"},
+			want: map[string]interface{}{"port": 2},
+		},
+		{
+			name:      "conflict across flags",
+			set:       []string{"port=1"},
+			setString: []string{"port=2"},
+			wantErr:   true,
+		},
+		{
+			name:    "missing equals",
+			set:     []string{"port"},
+			wantErr: true,
+		},
+		{
+			name:    "empty segment",
+			set:     []string{"build..flags=1"},
+			wantErr: true,
+		},
+		{
+			name:    "scalar used as map",
+			set:     []string{"build=1", "build.flags=2"},
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			setFile: []string{"motd=" + filepath.Join(tmpDir, "missing")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOverrides(tt.set, tt.setString, tt.setFile)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOverrides() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOverrides() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverrideKeys(t *testing.T) {
+	overrides := map[string]interface{}{
+		"port":  1,
+		"build": map[string]interface{}{"flags": "-O2", "jobs": 4},
+	}
+
+	want := []string{"build.flags", "build.jobs", "port"}
+	if got := OverrideKeys(overrides); !reflect.DeepEqual(got, want) {
+		t.Errorf("OverrideKeys() = %v, want %v", got, want)
+	}
+}
+
+func TestImageConfig_ApplyOverrides(t *testing.T) {
+	ic := &ImageConfig{
+		Values: map[string]interface{}{
+			"port":  80,
+			"build": map[string]interface{}{"flags": "-O0", "target": "release"},
+		},
+	}
+
+	ic.ApplyOverrides(map[string]interface{}{
+		"port":  8080,
+		"build": map[string]interface{}{"flags": "-O2"},
+	})
+
+	want := map[string]interface{}{
+		"port":  8080,
+		"build": map[string]interface{}{"flags": "-O2", "target": "release"},
+	}
+	if !reflect.DeepEqual(ic.Values, want) {
+		t.Errorf("ApplyOverrides() values = %v, want %v", ic.Values, want)
+	}
+}
@@ -0,0 +1,254 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+	"gopkg.in/yaml.v3"
+)
+
+// NoRemote disables loading defaults.preset, leaving only the manifest's own
+// defaults. It is set by the --no-remote flag.
+var NoRemote bool
+
+// PresetTTL is how long a cached preset is used without asking the server
+// whether it has changed.
+const PresetTTL = time.Hour
+
+// presetTimeout bounds fetching a preset, after which the cached copy is used.
+const presetTimeout = 10 * time.Second
+
+// presetCacheDir returns the directory fetched presets are cached in.
+var presetCacheDir = func() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "dockerfiles", "presets"), nil
+}
+
+// presetMeta is stored alongside a cached preset.
+type presetMeta struct {
+	URL     string    `json:"url"`
+	ETag    string    `json:"etag,omitempty"`
+	Fetched time.Time `json:"fetched"`
+}
+
+// applyPreset merges the preset named by defaults.preset beneath the
+// manifest's defaults: mappings are merged key by key and any other value set
+// locally wins over the preset's. A preset is network-derived, so in
+// reproducible mode it must be pinned with defaults.preset_sha256.
+func applyPreset(ctx context.Context, root *yaml.Node) error {
+	defaults := mappingValue(documentMapping(root), "defaults")
+	presetURL := mappingValue(defaults, "preset")
+	if presetURL == nil || presetURL.Value == "" {
+		return nil
+	}
+	if NoRemote {
+		log.Warnf("not loading preset %s: remote presets are disabled by --no-remote", presetURL.Value)
+		return nil
+	}
+
+	var checksum string
+	if node := mappingValue(defaults, "preset_sha256"); node != nil {
+		checksum = strings.ToLower(node.Value)
+	}
+	if checksum == "" {
+		if reproducible := mappingValue(defaults, "reproducible"); reproducible == nil || reproducible.Value != "false" {
+			return nodeError(presetURL, fmt.Errorf("defaults.preset must be pinned with defaults.preset_sha256 in reproducible mode; set defaults.reproducible: false to load it unpinned"))
+		}
+	}
+
+	content, err := fetchPreset(ctx, presetURL.Value, checksum)
+	if err != nil {
+		return fmt.Errorf("loading preset %s: %w", presetURL.Value, err)
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("parsing preset %s: %w", presetURL.Value, err)
+	}
+	preset := documentMapping(&document)
+	if preset == nil {
+		return fmt.Errorf("preset %s is not a mapping of defaults", presetURL.Value)
+	}
+	for _, key := range []string{"preset", "preset_sha256"} {
+		if mappingValue(preset, key) != nil {
+			return fmt.Errorf("preset %s cannot set %s", presetURL.Value, key)
+		}
+	}
+
+	mergeMapping(defaults, preset)
+	return nil
+}
+
+// mergeMapping adds the keys of preset missing from local, recursing into
+// mappings present in both.
+func mergeMapping(local, preset *yaml.Node) {
+	for i := 0; i+1 < len(preset.Content); i += 2 {
+		key, value := preset.Content[i], preset.Content[i+1]
+		existing := mappingValue(local, key.Value)
+		switch {
+		case existing == nil:
+			local.Content = append(local.Content, key, value)
+		case existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeMapping(existing, value)
+		}
+	}
+}
+
+// fetchPreset returns the preset at rawURL, from the cache while it is fresh
+// and otherwise from the server, revalidating the cached copy with its ETag.
+// When the server cannot be reached a cached copy is used with a warning.
+func fetchPreset(ctx context.Context, rawURL, checksum string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("only http and https presets are supported")
+	}
+
+	cachePath, cacheErr := presetCachePath(rawURL)
+	var cached []byte
+	var meta presetMeta
+	if cacheErr == nil {
+		cached, meta, cacheErr = readCachedPreset(cachePath)
+	}
+	if cacheErr == nil && time.Since(meta.Fetched) < PresetTTL && verifyPreset(cached, checksum) == nil {
+		log.Debugf("using preset %s cached at %s", rawURL, cachePath)
+		return cached, nil
+	}
+
+	etag := ""
+	if cacheErr == nil {
+		etag = meta.ETag
+	}
+	content, newETag, notModified, err := downloadPreset(ctx, rawURL, etag)
+	switch {
+	case err != nil:
+		if cacheErr != nil {
+			return nil, err
+		}
+		log.Warnf("fetching preset %s: %v; using the copy cached %s", rawURL, err, meta.Fetched.Format(time.RFC3339))
+		content = cached
+	case notModified:
+		content = cached
+		meta.Fetched = time.Now()
+		writeCachedPreset(cachePath, nil, meta)
+	default:
+		if err := verifyPreset(content, checksum); err != nil {
+			return nil, err
+		}
+		if cachePath != "" {
+			writeCachedPreset(cachePath, content, presetMeta{URL: rawURL, ETag: newETag, Fetched: time.Now()})
+		}
+		return content, nil
+	}
+
+	if err := verifyPreset(content, checksum); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// downloadPreset fetches rawURL, sending etag so that the server can answer
+// that the cached copy is still current.
+func downloadPreset(ctx context.Context, rawURL, etag string) (content []byte, newETag string, notModified bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := (&http.Client{Timeout: presetTimeout}).Do(req)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, "", true, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	content, err = io.ReadAll(io.LimitReader(resp.Body, MaxManifestSize+1))
+	if err != nil {
+		return nil, "", false, err
+	}
+	if len(content) > MaxManifestSize {
+		return nil, "", false, fmt.Errorf("preset is larger than %d bytes", MaxManifestSize)
+	}
+	return content, resp.Header.Get("ETag"), false, nil
+}
+
+// verifyPreset checks content against defaults.preset_sha256, when set.
+func verifyPreset(content []byte, checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+	sum := sha256.Sum256(content)
+	if got := hex.EncodeToString(sum[:]); got != checksum {
+		return fmt.Errorf("preset sha256 is %s, but preset_sha256 is %s", got, checksum)
+	}
+	return nil
+}
+
+// presetCachePath returns where the preset at rawURL is cached; its metadata
+// is kept next to it with a .json extension.
+func presetCachePath(rawURL string) (string, error) {
+	dir, err := presetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(rawURL))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".yaml"), nil
+}
+
+func readCachedPreset(path string) ([]byte, presetMeta, error) {
+	var meta presetMeta
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, meta, err
+	}
+	encoded, err := os.ReadFile(strings.TrimSuffix(path, ".yaml") + ".json")
+	if err != nil {
+		return nil, meta, err
+	}
+	if err := json.Unmarshal(encoded, &meta); err != nil {
+		return nil, meta, err
+	}
+	return content, meta, nil
+}
+
+// writeCachedPreset stores content, unless nil, and meta. A cache that cannot
+// be written only costs a later fetch, so failures are logged.
+func writeCachedPreset(path string, content []byte, meta presetMeta) {
+	encoded, err := json.Marshal(meta)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil && content != nil {
+		err = os.WriteFile(path, content, 0644)
+	}
+	if err == nil {
+		err = os.WriteFile(strings.TrimSuffix(path, ".yaml")+".json", encoded, 0644)
+	}
+	if err != nil {
+		log.Warnf("caching preset %s: %v", meta.URL, err)
+	}
+}
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testPreset = `registry: ghcr.io/org
+dockerfile_syntax: docker/dockerfile:1
+workflow:
+  parser: buildkit
+  job_warning_threshold: 100
+`
+
+// presetServer serves testPreset with an ETag and counts full responses and
+// revalidations. The preset cache is redirected to a temporary directory.
+func presetServer(t *testing.T) (server *httptest.Server, fetches, revalidations *atomic.Int32) {
+	t.Helper()
+	cacheDir := t.TempDir()
+	oldCacheDir := presetCacheDir
+	presetCacheDir = func() (string, error) { return cacheDir, nil }
+	t.Cleanup(func() { presetCacheDir = oldCacheDir })
+
+	fetches, revalidations = new(atomic.Int32), new(atomic.Int32)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidations.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fetches.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte(testPreset))
+	}))
+	t.Cleanup(server.Close)
+	return server, fetches, revalidations
+}
+
+// presetPin pins testPreset with defaults.preset_sha256, as reproducible
+// mode requires.
+func presetPin() string {
+	sum := sha256.Sum256([]byte(testPreset))
+	return "  preset_sha256: " + hex.EncodeToString(sum[:]) + "\n"
+}
+
+func loadPresetManifest(t *testing.T, defaults string) (*Config, error) {
+	t.Helper()
+	return loadReader(context.Background(), strings.NewReader("version: 1\ndefaults:\n"+defaults+"images: {}\n"))
+}
+
+func TestLoad_Preset(t *testing.T) {
+	server, fetches, _ := presetServer(t)
+
+	cfg, err := loadPresetManifest(t, "  preset: "+server.URL+"\n"+presetPin()+"  registry: local.io\n  workflow:\n    parser: regex\n")
+	if err != nil {
+		t.Fatalf("loadReader() error = %v", err)
+	}
+
+	if cfg.Defaults.Registry != "local.io" {
+		t.Errorf("Registry = %q, want the local local.io", cfg.Defaults.Registry)
+	}
+	if cfg.Defaults.DockerfileSyntax != "docker/dockerfile:1" {
+		t.Errorf("DockerfileSyntax = %q, want it from the preset", cfg.Defaults.DockerfileSyntax)
+	}
+	if cfg.Defaults.Workflow.Parser != "regex" || cfg.Defaults.Workflow.JobWarningThreshold != 100 {
+		t.Errorf("Workflow = %+v, want the local parser and the preset threshold", cfg.Defaults.Workflow)
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("preset fetched %d times, want 1", got)
+	}
+}
+
+func TestLoad_PresetCache(t *testing.T) {
+	server, fetches, revalidations := presetServer(t)
+	manifest := "  preset: " + server.URL + "\n" + presetPin()
+
+	for range 2 {
+		if _, err := loadPresetManifest(t, manifest); err != nil {
+			t.Fatalf("loadReader() error = %v", err)
+		}
+	}
+	if fetches.Load() != 1 || revalidations.Load() != 0 {
+		t.Errorf("fetches = %d, revalidations = %d; want a fresh cache to be used", fetches.Load(), revalidations.Load())
+	}
+
+	// Past the TTL the cached copy is revalidated with its ETag.
+	cachePath, err := presetCachePath(server.URL)
+	if err != nil {
+		t.Fatalf("presetCachePath() error = %v", err)
+	}
+	_, meta, err := readCachedPreset(cachePath)
+	if err != nil {
+		t.Fatalf("readCachedPreset() error = %v", err)
+	}
+	meta.Fetched = time.Now().Add(-2 * PresetTTL)
+	writeCachedPreset(cachePath, nil, meta)
+
+	cfg, err := loadPresetManifest(t, manifest)
+	if err != nil {
+		t.Fatalf("loadReader() error = %v", err)
+	}
+	if fetches.Load() != 1 || revalidations.Load() != 1 {
+		t.Errorf("fetches = %d, revalidations = %d; want one revalidation", fetches.Load(), revalidations.Load())
+	}
+	if cfg.Defaults.Registry != "ghcr.io/org" {
+		t.Errorf("Registry = %q, want the cached preset's", cfg.Defaults.Registry)
+	}
+
+	// Offline, a stale cached copy is still used.
+	meta.Fetched = time.Now().Add(-2 * PresetTTL)
+	writeCachedPreset(cachePath, nil, meta)
+	server.Close()
+	cfg, err = loadPresetManifest(t, manifest)
+	if err != nil {
+		t.Fatalf("loadReader() offline error = %v", err)
+	}
+	if cfg.Defaults.Registry != "ghcr.io/org" {
+		t.Errorf("Registry = %q offline, want the cached preset's", cfg.Defaults.Registry)
+	}
+}
+
+func TestLoad_PresetErrors(t *testing.T) {
+	server, _, _ := presetServer(t)
+	sum := sha256.Sum256([]byte(testPreset))
+	checksum := hex.EncodeToString(sum[:])
+
+	if _, err := loadPresetManifest(t, "  preset: "+server.URL+"\n  preset_sha256: "+strings.ToUpper(checksum)+"\n"); err != nil {
+		t.Errorf("loadReader() with a matching preset_sha256 error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		defaults string
+		wantErr  string
+	}{
+		{"checksum mismatch", "  preset: " + server.URL + "/other\n  preset_sha256: " + strings.Repeat("0", 64) + "\n", "but preset_sha256 is 000"},
+		{"unpinned in reproducible mode", "  preset: " + server.URL + "\n", "line 3, column 11: defaults.preset must be pinned with defaults.preset_sha256"},
+		{"unreachable without cache", "  preset: http://127.0.0.1:1/preset.yaml\n  reproducible: false\n", "loading preset http://127.0.0.1:1/preset.yaml"},
+		{"unsupported scheme", "  preset: oci://ghcr.io/org/presets:v1\n  reproducible: false\n", "only http and https presets are supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := loadPresetManifest(t, tt.defaults); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadReader() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad_PresetNoRemote(t *testing.T) {
+	server, fetches, _ := presetServer(t)
+	NoRemote = true
+	t.Cleanup(func() { NoRemote = false })
+
+	cfg, err := loadPresetManifest(t, "  preset: "+server.URL+"\n")
+	if err != nil {
+		t.Fatalf("loadReader() error = %v", err)
+	}
+	if cfg.Defaults.Registry != "" || fetches.Load() != 0 {
+		t.Errorf("Registry = %q after %d fetches, want the preset ignored", cfg.Defaults.Registry, fetches.Load())
+	}
+}
+
+func TestLoad_PresetCanceled(t *testing.T) {
+	server, fetches, _ := presetServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := loadReader(ctx, strings.NewReader("version: 1\ndefaults:\n  preset: "+server.URL+"\n"+presetPin()+"images: {}\n"))
+	if err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Errorf("loadReader() error = %v, want the fetch canceled", err)
+	}
+	if got := fetches.Load(); got != 0 {
+		t.Errorf("preset fetched %d times with a canceled context, want 0", got)
+	}
+}
@@ -0,0 +1,241 @@
+package config
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/apex/log"
+)
+
+// IgnoreRequires downgrades an unsatisfied manifest requires constraint to a
+// warning. It is set by the --ignore-requires flag.
+var IgnoreRequires bool
+
+// ToolVersion returns the version of the running binary from its build info,
+// or "" when it cannot be determined (development builds, go run, or a
+// pseudo-version with no release tag behind it).
+func ToolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	version := info.Main.Version
+	if version == "" || version == "(devel)" || strings.HasPrefix(version, "v0.0.0-") {
+		return ""
+	}
+	return version
+}
+
+// checkRequires compares the running tool version against the manifest's
+// requires constraint.
+func checkRequires(requires, version string, ignore bool) error {
+	if requires == "" {
+		return nil
+	}
+
+	constraint, err := parseConstraint(requires)
+	if err != nil {
+		return fmt.Errorf("invalid requires %q: %w", requires, err)
+	}
+
+	if version == "" {
+		log.Warnf("cannot determine the dockerfiles version of this build; not checking requires %q", requires)
+		return nil
+	}
+	current, err := parseSemver(version)
+	if err != nil {
+		log.Warnf("cannot parse the dockerfiles version %q of this build; not checking requires %q", version, requires)
+		return nil
+	}
+
+	if constraint.allows(current) {
+		return nil
+	}
+	if ignore {
+		log.Warnf("manifest requires dockerfiles %s but this is %s; continuing because of --ignore-requires", requires, version)
+		return nil
+	}
+	return fmt.Errorf("manifest requires dockerfiles %s but this is %s; upgrade the tool (or pass --ignore-requires)", requires, version)
+}
+
+// semver is a parsed semantic version. Build metadata is dropped as it does
+// not take part in ordering.
+type semver struct {
+	major, minor, patch int
+	pre                 []string
+}
+
+// parseSemver parses MAJOR[.MINOR[.PATCH]][-PRERELEASE][+BUILD] with an
+// optional leading "v". Missing minor and patch numbers are zero.
+func parseSemver(s string) (semver, error) {
+	var v semver
+	rest := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	rest, _, _ = strings.Cut(rest, "+")
+	rest, pre, hasPre := strings.Cut(rest, "-")
+	if hasPre {
+		if pre == "" {
+			return v, fmt.Errorf("%q has an empty prerelease", s)
+		}
+		v.pre = strings.Split(pre, ".")
+	}
+
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("%q is not a semantic version", s)
+	}
+	numbers := []*int{&v.major, &v.minor, &v.patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("%q is not a semantic version", s)
+		}
+		*numbers[i] = n
+	}
+	return v, nil
+}
+
+// compare returns -1, 0 or 1 following semver precedence: a prerelease sorts
+// before its release, and prerelease identifiers compare numerically when
+// both are numbers.
+func (v semver) compare(o semver) int {
+	for _, pair := range [][2]int{{v.major, o.major}, {v.minor, o.minor}, {v.patch, o.patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(v.pre) == 0 && len(o.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(o.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.pre) && i < len(o.pre); i++ {
+		a, aErr := strconv.Atoi(v.pre[i])
+		b, bErr := strconv.Atoi(o.pre[i])
+		switch {
+		case aErr == nil && bErr == nil && a != b:
+			if a < b {
+				return -1
+			}
+			return 1
+		case aErr == nil && bErr != nil:
+			return -1
+		case aErr != nil && bErr == nil:
+			return 1
+		case v.pre[i] != o.pre[i]:
+			return strings.Compare(v.pre[i], o.pre[i])
+		}
+	}
+	switch {
+	case len(v.pre) < len(o.pre):
+		return -1
+	case len(v.pre) > len(o.pre):
+		return 1
+	}
+	return 0
+}
+
+// comparison is one operator and version from a constraint.
+type comparison struct {
+	op      string
+	version semver
+}
+
+func (c comparison) allows(v semver) bool {
+	cmp := v.compare(c.version)
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// constraint is a set of alternatives separated by "||", each of which
+// requires all of its comparisons to hold.
+type constraint [][]comparison
+
+func (c constraint) allows(v semver) bool {
+	for _, all := range c {
+		ok := true
+		for _, comparison := range all {
+			if !comparison.allows(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConstraint parses basic semver ranges: comparisons with =, !=, >, >=,
+// < or <= separated by spaces or commas, caret (^1.2) and tilde (~1.2)
+// ranges, and alternatives separated by "||". A bare version means "=".
+func parseConstraint(s string) (constraint, error) {
+	var c constraint
+	for _, alternative := range strings.Split(s, "||") {
+		var all []comparison
+		for _, term := range strings.FieldsFunc(alternative, func(r rune) bool { return r == ' ' || r == ',' }) {
+			comparisons, err := parseTerm(term)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, comparisons...)
+		}
+		if len(all) == 0 {
+			return nil, fmt.Errorf("empty constraint")
+		}
+		c = append(c, all)
+	}
+	return c, nil
+}
+
+// parseTerm parses one comparison, expanding caret and tilde ranges into a
+// lower and upper bound.
+func parseTerm(term string) ([]comparison, error) {
+	op := ""
+	for _, candidate := range []string{">=", "<=", "!=", "==", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, candidate) {
+			op = candidate
+			break
+		}
+	}
+	version, err := parseSemver(term[len(op):])
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case "^":
+		upper := semver{major: version.major + 1}
+		if version.major == 0 {
+			upper = semver{minor: version.minor + 1}
+		}
+		return []comparison{{">=", version}, {"<", upper}}, nil
+	case "~":
+		upper := semver{major: version.major, minor: version.minor + 1}
+		return []comparison{{">=", version}, {"<", upper}}, nil
+	case "", "==":
+		op = "="
+	}
+	return []comparison{{op, version}}, nil
+}
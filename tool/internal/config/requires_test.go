@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		allowed    []string
+		rejected   []string
+	}{
+		{">=0.5.0", []string{"0.5.0", "v0.5.1", "1.0.0"}, []string{"0.4.9", "0.5.0-rc.1"}},
+		{">0.5", []string{"0.5.1", "0.6.0"}, []string{"0.5.0"}},
+		{">=0.5.0, <1.0.0", []string{"0.9.9"}, []string{"1.0.0", "0.4.0"}},
+		{">=0.5.0 <1.0.0", []string{"0.5.0", "1.0.0-rc.1"}, []string{"1.2.0"}},
+		{"^1.2.0", []string{"1.2.0", "1.9.0"}, []string{"2.0.0", "1.1.0"}},
+		{"^0.5.0", []string{"0.5.3"}, []string{"0.6.0"}},
+		{"~1.2.3", []string{"1.2.9"}, []string{"1.3.0", "1.2.2"}},
+		{"1.2.3", []string{"1.2.3", "v1.2.3+dirty"}, []string{"1.2.4"}},
+		{"<0.3 || >=0.5", []string{"0.2.0", "0.5.0"}, []string{"0.4.0"}},
+		{"!=0.5.1", []string{"0.5.0"}, []string{"0.5.1"}},
+	}
+
+	for _, tt := range tests {
+		c, err := parseConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("parseConstraint(%q) error = %v", tt.constraint, err)
+			continue
+		}
+		for _, version := range tt.allowed {
+			if v, err := parseSemver(version); err != nil || !c.allows(v) {
+				t.Errorf("%q should allow %s (err %v)", tt.constraint, version, err)
+			}
+		}
+		for _, version := range tt.rejected {
+			if v, err := parseSemver(version); err != nil || c.allows(v) {
+				t.Errorf("%q should reject %s (err %v)", tt.constraint, version, err)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"", ">=", ">=a.b.c", "1.2.3.4", "0.5 ||"} {
+		if _, err := parseConstraint(invalid); err == nil {
+			t.Errorf("parseConstraint(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestSemverCompare(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1"}
+	for i := 0; i+1 < len(ordered); i++ {
+		a, _ := parseSemver(ordered[i])
+		b, _ := parseSemver(ordered[i+1])
+		if a.compare(b) != -1 || b.compare(a) != 1 {
+			t.Errorf("%s should sort before %s", ordered[i], ordered[i+1])
+		}
+	}
+}
+
+func TestCheckRequires(t *testing.T) {
+	tests := []struct {
+		name     string
+		requires string
+		version  string
+		ignore   bool
+		wantErr  string
+	}{
+		{name: "unset", version: "v0.1.0"},
+		{name: "satisfied", requires: ">=0.5.0", version: "v0.5.2"},
+		{name: "too old", requires: ">=0.5.0", version: "v0.4.0", wantErr: "manifest requires dockerfiles >=0.5.0 but this is v0.4.0; upgrade the tool"},
+		{name: "too old but ignored", requires: ">=0.5.0", version: "v0.4.0", ignore: true},
+		{name: "unknown version", requires: ">=0.5.0"},
+		{name: "invalid constraint", requires: ">=five", version: "v0.5.0", wantErr: `invalid requires ">=five"`},
+		{name: "invalid constraint with unknown version", requires: ">=five", ignore: true, wantErr: `invalid requires ">=five"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRequires(tt.requires, tt.version, tt.ignore)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkRequires() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkRequires() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func loadRequiresManifest(t *testing.T, manifest string) (*Config, error) {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "manifest.yml")
+	if err := os.WriteFile(configPath, []byte(manifest), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return Load(configPath)
+}
+
+func TestLoad_Requires(t *testing.T) {
+	// Test binaries carry no release version, so requires only warns.
+	cfg, err := loadRequiresManifest(t, "version: 1\nrequires: \">=99.0.0\"\nimages: {}\n")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Requires != ">=99.0.0" {
+		t.Errorf("Requires = %q, want >=99.0.0", cfg.Requires)
+	}
+
+	if _, err := loadRequiresManifest(t, "version: 1\nrequires: \"newer please\"\nimages: {}\n"); err == nil {
+		t.Error("Load() should reject an invalid requires constraint")
+	}
+}
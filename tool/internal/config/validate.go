@@ -0,0 +1,286 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Problem is a manifest issue attributed to an image and, when relevant, one
+// of its versions.
+type Problem struct {
+	Image   string
+	Version string
+	Origin  Origin
+	Message string
+}
+
+func (p Problem) String() string {
+	subject := p.Image
+	if p.Version != "" {
+		subject = fmt.Sprintf("%s/%s", p.Image, p.Version)
+	}
+	if !p.Origin.IsZero() {
+		subject = fmt.Sprintf("%s (%s)", subject, p.Origin)
+	}
+	return fmt.Sprintf("%s: %s", subject, p.Message)
+}
+
+// Validate runs the semantic checks over cfg and returns every problem found,
+// ordered by image and version.
+func Validate(cfg *Config) []Problem {
+	var problems []Problem
+
+	paths := make(map[string]string)
+	for _, imageName := range sortedKeys(cfg.Images) {
+		image := cfg.Images[imageName]
+		if len(image.Versions) == 0 {
+			problems = append(problems, Problem{
+				Image:   imageName,
+				Origin:  image.Origin,
+				Message: "image has no versions",
+			})
+		}
+		if image.Path != "" {
+			path := filepath.Clean(image.Path)
+			if other, taken := paths[path]; taken {
+				problems = append(problems, Problem{
+					Image:   imageName,
+					Origin:  image.Origin,
+					Message: fmt.Sprintf("path %s is also used by image %s", image.Path, other),
+				})
+			} else {
+				paths[path] = imageName
+			}
+		}
+		for _, version := range sortedKeys(image.Versions) {
+			for _, conflict := range image.KeyConflicts(cfg.Defaults.Values, version) {
+				problems = append(problems, Problem{
+					Image:   imageName,
+					Version: version,
+					Origin:  image.VersionOrigin(version),
+					Message: conflict.String(),
+				})
+			}
+		}
+		for _, problem := range image.variantConflicts() {
+			problem.Image = imageName
+			problems = append(problems, problem)
+		}
+		for _, problem := range image.baseImageProblems() {
+			problem.Image = imageName
+			problems = append(problems, problem)
+		}
+		for _, problem := range image.registryProblems(cfg.Defaults.Registry) {
+			problem.Image = imageName
+			problems = append(problems, problem)
+		}
+		for _, problem := range image.dependsOnProblems(cfg.Images) {
+			problem.Image = imageName
+			problems = append(problems, problem)
+		}
+		for _, problem := range image.aliasProblems() {
+			problem.Image = imageName
+			problems = append(problems, problem)
+		}
+	}
+
+	return problems
+}
+
+// digestPattern matches the image digests base_image.digest accepts.
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// baseImageProblems reports base images whose settings contradict their
+// source.
+func (img Image) baseImageProblems() []Problem {
+	var problems []Problem
+	check := func(ic *ImageConfig, version string, origin Origin) {
+		if ic == nil || ic.BaseImage == nil {
+			return
+		}
+		if ic.BaseImage.Source == SourceScratch && ic.BaseImage.Name != "" && ic.BaseImage.Name != "scratch" {
+			problems = append(problems, Problem{
+				Version: version,
+				Origin:  origin,
+				Message: fmt.Sprintf("base_image source scratch cannot name another image (%s)", ic.BaseImage.Name),
+			})
+		}
+		if ic.BaseImage.Source != SourceScratch && ic.BaseImage.Name == "" {
+			problems = append(problems, Problem{
+				Version: version,
+				Origin:  origin,
+				Message: "base_image.name is empty",
+			})
+		}
+		if ic.BaseImage.Source == SourceScratch && ic.BaseImage.Digest != "" {
+			problems = append(problems, Problem{
+				Version: version,
+				Origin:  origin,
+				Message: "base_image source scratch cannot have a digest",
+			})
+		}
+		if digest := ic.BaseImage.Digest; digest != "" && !digestPattern.MatchString(digest) {
+			problems = append(problems, Problem{
+				Version: version,
+				Origin:  origin,
+				Message: fmt.Sprintf("base_image.digest %q is not a sha256:<64 hex digits> digest", digest),
+			})
+		}
+	}
+
+	check(img.Defaults, "", img.Origin)
+	for _, version := range sortedKeys(img.Versions) {
+		check(img.Versions[version], version, img.VersionOrigin(version))
+	}
+	return problems
+}
+
+// registryProblems reports versions whose base image is pulled from the
+// manifest's registry when neither the manifest nor the version's values set
+// one.
+func (img Image) registryProblems(registry string) []Problem {
+	if registry != "" {
+		return nil
+	}
+
+	var problems []Problem
+	for _, version := range sortedKeys(img.Versions) {
+		merged := img.Versions[version].Merge(img.Defaults)
+		if merged == nil || merged.BaseImage == nil || merged.BaseImage.Name == "" {
+			continue
+		}
+		switch merged.BaseImage.Source {
+		case SourceDockerHub, SourceScratch, SourceExternal:
+			continue
+		}
+		if _, hasRegistry := merged.Values["registry"]; hasRegistry {
+			continue
+		}
+		problems = append(problems, Problem{
+			Version: version,
+			Origin:  img.VersionOrigin(version),
+			Message: fmt.Sprintf("base_image %s is pulled from the registry, but defaults.registry is not set", merged.BaseImage.Name),
+		})
+	}
+	return problems
+}
+
+// dependsOnProblems reports depends_on entries that do not name an output
+// version of an image in the manifest.
+func (img Image) dependsOnProblems(images map[string]Image) []Problem {
+	var problems []Problem
+	check := func(ic *ImageConfig, version string, origin Origin) {
+		if ic == nil {
+			return
+		}
+		for _, ref := range ic.DependsOn {
+			imageName, outputName, ok := strings.Cut(ref, ":")
+			if !ok || imageName == "" || outputName == "" {
+				problems = append(problems, Problem{
+					Version: version,
+					Origin:  origin,
+					Message: fmt.Sprintf("depends_on entry %q is not an image:version reference", ref),
+				})
+				continue
+			}
+			if _, exists := images[imageName].OutputVersion(outputName); !exists {
+				problems = append(problems, Problem{
+					Version: version,
+					Origin:  origin,
+					Message: fmt.Sprintf("depends_on entry %s is not a version in the manifest", ref),
+				})
+			}
+		}
+	}
+
+	check(img.Defaults, "", img.Origin)
+	for _, version := range sortedKeys(img.Versions) {
+		check(img.Versions[version], version, img.VersionOrigin(version))
+	}
+	return problems
+}
+
+// VersionOrigin returns where version was defined, falling back to the
+// image's own origin for versions declared without a body.
+func (img Image) VersionOrigin(version string) Origin {
+	if versionConfig := img.Versions[version]; versionConfig != nil && !versionConfig.Origin.IsZero() {
+		return versionConfig.Origin
+	}
+	return img.Origin
+}
+
+// KeyConflict records two value keys that differ only by case or by dash
+// versus underscore, which templates would treat as unrelated values.
+type KeyConflict struct {
+	Key        string
+	Layer      string
+	OtherKey   string
+	OtherLayer string
+}
+
+func (c KeyConflict) String() string {
+	return fmt.Sprintf("keys %q (%s) and %q (%s) differ only by case or separator", c.Key, c.Layer, c.OtherKey, c.OtherLayer)
+}
+
+// KeyConflicts reports near-duplicate value keys across the layers merged for
+// version, beneath which defaults are the manifest's defaults.values. Images
+// can opt out with lint.ignore_key_conflicts.
+func (img Image) KeyConflicts(defaults map[string]interface{}, version string) []KeyConflict {
+	if img.Lint != nil && img.Lint.IgnoreKeyConflicts {
+		return nil
+	}
+
+	type origin struct {
+		key   string
+		layer string
+	}
+
+	seen := make(map[string][]origin)
+	addLayer := func(values map[string]interface{}, layer string) {
+		for _, key := range sortedKeys(values) {
+			normalized := normalizeKey(key)
+			seen[normalized] = append(seen[normalized], origin{key: key, layer: layer})
+		}
+	}
+	addLayer(defaults, "defaults.values")
+	if img.Defaults != nil {
+		addLayer(img.Defaults.Values, "image defaults")
+	}
+	if versionConfig := img.Versions[version]; versionConfig != nil {
+		addLayer(versionConfig.Values, fmt.Sprintf("version %s", version))
+	}
+
+	var conflicts []KeyConflict
+	for _, normalized := range sortedKeys(seen) {
+		origins := seen[normalized]
+		for i := 1; i < len(origins); i++ {
+			if origins[i].key == origins[0].key {
+				continue
+			}
+			conflicts = append(conflicts, KeyConflict{
+				Key:        origins[0].key,
+				Layer:      origins[0].layer,
+				OtherKey:   origins[i].key,
+				OtherLayer: origins[i].layer,
+			})
+		}
+	}
+
+	return conflicts
+}
+
+func normalizeKey(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "-", "_")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,249 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImage_KeyConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults map[string]interface{}
+		image    Image
+		want     []string
+	}{
+		{
+			name: "no conflicts",
+			image: Image{
+				Defaults: &ImageConfig{Values: map[string]interface{}{"python_version": "3.12"}},
+				Versions: map[string]*ImageConfig{
+					"3.12": {Values: map[string]interface{}{"python_version": "3.12.1"}},
+				},
+			},
+			want: nil,
+		},
+		{
+			name: "dash versus underscore across layers",
+			image: Image{
+				Defaults: &ImageConfig{Values: map[string]interface{}{"python-version": "3.12"}},
+				Versions: map[string]*ImageConfig{
+					"3.12": {Values: map[string]interface{}{"python_version": "3.12.1"}},
+				},
+			},
+			want: []string{`keys "python-version" (image defaults) and "python_version" (version 3.12) differ only by case or separator`},
+		},
+		{
+			name: "case within a single layer",
+			image: Image{
+				Versions: map[string]*ImageConfig{
+					"3.12": {Values: map[string]interface{}{"PipVersion": "24", "pipversion": "25"}},
+				},
+			},
+			want: []string{`keys "PipVersion" (version 3.12) and "pipversion" (version 3.12) differ only by case or separator`},
+		},
+		{
+			name: "skipped via lint config",
+			image: Image{
+				Lint:     &ImageLint{IgnoreKeyConflicts: true},
+				Defaults: &ImageConfig{Values: map[string]interface{}{"python-version": "3.12"}},
+				Versions: map[string]*ImageConfig{
+					"3.12": {Values: map[string]interface{}{"python_version": "3.12.1"}},
+				},
+			},
+			want: nil,
+		},
+		{
+			name:     "manifest defaults versus version",
+			defaults: map[string]interface{}{"Registry-Mirror": "mirror.io"},
+			image: Image{
+				Versions: map[string]*ImageConfig{
+					"3.12": {Values: map[string]interface{}{"registry_mirror": "local.io"}},
+				},
+			},
+			want: []string{`keys "Registry-Mirror" (defaults.values) and "registry_mirror" (version 3.12) differ only by case or separator`},
+		},
+		{
+			name: "nil version config",
+			image: Image{
+				Defaults: &ImageConfig{Values: map[string]interface{}{"key": "value"}},
+				Versions: map[string]*ImageConfig{"3.12": nil},
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conflicts := tt.image.KeyConflicts(tt.defaults, "3.12")
+			if len(conflicts) != len(tt.want) {
+				t.Fatalf("KeyConflicts() = %v, want %v", conflicts, tt.want)
+			}
+			for i, conflict := range conflicts {
+				if conflict.String() != tt.want[i] {
+					t.Errorf("KeyConflicts()[%d] = %q, want %q", i, conflict.String(), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]Image{
+			"python": {
+				Defaults: &ImageConfig{Values: map[string]interface{}{"python-version": "3.12"}},
+				Versions: map[string]*ImageConfig{
+					"3.12": {Values: map[string]interface{}{"python_version": "3.12.1"}},
+					"3.13": {Values: map[string]interface{}{}},
+				},
+			},
+			"core": {
+				Versions: map[string]*ImageConfig{"noble": {}},
+			},
+		},
+	}
+
+	problems := Validate(cfg)
+	if len(problems) != 1 {
+		t.Fatalf("Validate() returned %d problems, want 1: %v", len(problems), problems)
+	}
+	if !strings.HasPrefix(problems[0].String(), "python/3.12: ") {
+		t.Errorf("Problem should be attributed to python/3.12, got %q", problems[0].String())
+	}
+}
+
+func TestValidate_BaseImageSources(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]Image{
+			"static": {
+				Defaults: &ImageConfig{BaseImage: &BaseImage{Name: "scratch", Source: SourceScratch}},
+				Versions: map[string]*ImageConfig{
+					"v1": {},
+					"v2": {BaseImage: &BaseImage{Source: SourceScratch}},
+					"v3": {BaseImage: &BaseImage{Name: "alpine:3.20", Source: SourceScratch}},
+				},
+			},
+			"windows": {
+				Versions: map[string]*ImageConfig{
+					"ltsc2022": {BaseImage: &BaseImage{Name: "mcr.microsoft.com/windows/nanoserver:ltsc2022", Source: SourceExternal}},
+				},
+			},
+		},
+	}
+
+	problems := Validate(cfg)
+	if len(problems) != 1 {
+		t.Fatalf("Validate() returned %d problems, want 1: %v", len(problems), problems)
+	}
+	if want := "static/v3: base_image source scratch cannot name another image (alpine:3.20)"; problems[0].String() != want {
+		t.Errorf("Problem = %q, want %q", problems[0].String(), want)
+	}
+}
+
+func TestValidate_BaseImageDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	cfg := &Config{
+		Images: map[string]Image{
+			"app": {
+				Versions: map[string]*ImageConfig{
+					"v1": {BaseImage: &BaseImage{Name: "alpine:3.21", Source: SourceDockerHub, Digest: digest}},
+					"v2": {BaseImage: &BaseImage{Name: "alpine:3.21", Source: SourceDockerHub, Digest: "sha256:abc"}},
+					"v3": {BaseImage: &BaseImage{Source: SourceScratch, Digest: digest}},
+				},
+			},
+		},
+	}
+
+	var got []string
+	for _, problem := range Validate(cfg) {
+		got = append(got, problem.String())
+	}
+	want := []string{
+		`app/v2: base_image.digest "sha256:abc" is not a sha256:<64 hex digits> digest`,
+		"app/v3: base_image source scratch cannot have a digest",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() = %q, want %q", got, want)
+	}
+}
+
+func TestValidate_DependsOn(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]Image{
+			"core": {Versions: map[string]*ImageConfig{"noble": {}}},
+			"python": {
+				Defaults: &ImageConfig{DependsOn: []string{"core"}},
+				Versions: map[string]*ImageConfig{
+					"3.13": {DependsOn: []string{"core:noble", "core:jammy"}},
+				},
+			},
+		},
+	}
+
+	var got []string
+	for _, problem := range Validate(cfg) {
+		got = append(got, problem.String())
+	}
+	want := []string{
+		`python: depends_on entry "core" is not an image:version reference`,
+		"python/3.13: depends_on entry core:jammy is not a version in the manifest",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() = %q, want %q", got, want)
+	}
+}
+
+func TestValidate_ManifestChecks(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]Image{
+			"empty": {Path: "lang/empty"},
+			"python": {
+				Path:     "lang/python",
+				Defaults: &ImageConfig{BaseImage: &BaseImage{Name: "core:noble"}},
+				Versions: map[string]*ImageConfig{
+					"3.12": {},
+					"3.13": {BaseImage: &BaseImage{Source: SourceDockerHub}},
+					"3.14": {Values: map[string]interface{}{"registry": "ghcr.io/example"}},
+				},
+			},
+			"python2": {
+				Path:     "lang/python/",
+				Versions: map[string]*ImageConfig{"2.7": {BaseImage: &BaseImage{Name: "debian:bookworm", Source: SourceDockerHub}}},
+			},
+		},
+	}
+
+	var got []string
+	for _, problem := range Validate(cfg) {
+		got = append(got, problem.String())
+	}
+	want := []string{
+		"empty: image has no versions",
+		"python/3.13: base_image.name is empty",
+		"python/3.12: base_image core:noble is pulled from the registry, but defaults.registry is not set",
+		"python2: path lang/python/ is also used by image python",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Validate() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	cfg.Defaults.Registry = "ghcr.io/example"
+	for _, problem := range Validate(cfg) {
+		if strings.Contains(problem.Message, "registry") {
+			t.Errorf("unexpected problem with a registry set: %s", problem)
+		}
+	}
+}
+
+func TestProblem_String(t *testing.T) {
+	if got := (Problem{Image: "core", Message: "broken"}).String(); got != "core: broken" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := (Problem{Image: "core", Version: "v1", Message: "broken"}).String(); got != "core/v1: broken" {
+		t.Errorf("String() = %q", got)
+	}
+	withOrigin := Problem{Image: "core", Version: "v1", Origin: Origin{File: "manifest.yaml", Line: 12}, Message: "broken"}
+	if got := withOrigin.String(); got != "core/v1 (manifest.yaml:12): broken" {
+		t.Errorf("String() = %q", got)
+	}
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Variant is an overlay rendered as a sibling output of a version, e.g.
+// "3.12-slim" next to "3.12", so near-identical tags share one version block.
+type Variant struct {
+	// TagSuffix is appended to the version to name the output directory and
+	// image tag. It defaults to "-<variant name>".
+	TagSuffix string                 `yaml:"tag_suffix,omitempty" json:"tag_suffix,omitempty"`
+	Values    map[string]interface{} `yaml:"values,omitempty" json:"values,omitempty"`
+}
+
+// OutputVersion is one rendered output directory of an image: either a
+// manifest version or a variant of one.
+type OutputVersion struct {
+	// Name is the output directory and image tag, e.g. "3.12-slim".
+	Name string
+	// Version is the manifest version key the output is rendered from.
+	Version string
+	// Variant is the variant name, empty for the version itself.
+	Variant string
+}
+
+func (v *Variant) suffix(name string) string {
+	if v != nil && v.TagSuffix != "" {
+		return v.TagSuffix
+	}
+	return "-" + name
+}
+
+// Apply overlays the variant's values onto a merged version config.
+func (v *Variant) Apply(ic *ImageConfig) {
+	if v == nil {
+		return
+	}
+	mergeInto(ic.Values, v.Values)
+}
+
+// VersionVariants returns the variants of version, with variants declared on
+// the version replacing same-named ones from the image defaults.
+func (img Image) VersionVariants(version string) map[string]*Variant {
+	var versionVariants map[string]*Variant
+	if versionConfig := img.Versions[version]; versionConfig != nil {
+		versionVariants = versionConfig.Variants
+	}
+	var defaultVariants map[string]*Variant
+	if img.Defaults != nil {
+		defaultVariants = img.Defaults.Variants
+	}
+	return mergeVariants(defaultVariants, versionVariants)
+}
+
+// OutputVersions returns every output the image renders, sorted by name. A
+// variant whose name collides with a version is skipped; Validate reports it.
+func (img Image) OutputVersions() []OutputVersion {
+	var outputs []OutputVersion
+	taken := make(map[string]bool)
+	for version := range img.Versions {
+		outputs = append(outputs, OutputVersion{Name: version, Version: version})
+		taken[version] = true
+	}
+
+	for _, version := range sortedKeys(img.Versions) {
+		variants := img.VersionVariants(version)
+		for _, name := range sortedKeys(variants) {
+			output := version + variants[name].suffix(name)
+			if taken[output] {
+				continue
+			}
+			outputs = append(outputs, OutputVersion{Name: output, Version: version, Variant: name})
+			taken[output] = true
+		}
+	}
+
+	sort.Slice(outputs, func(i, j int) bool { return outputs[i].Name < outputs[j].Name })
+	return outputs
+}
+
+// OutputVersion resolves an output name, such as "3.12" or "3.12-slim", to the
+// version and variant it is rendered from.
+func (img Image) OutputVersion(name string) (OutputVersion, bool) {
+	if _, exists := img.Versions[name]; exists {
+		return OutputVersion{Name: name, Version: name}, true
+	}
+	for _, output := range img.OutputVersions() {
+		if output.Name == name {
+			return output, true
+		}
+	}
+	return OutputVersion{}, false
+}
+
+// variantConflicts reports variants whose output name collides with a version
+// or with another variant's output.
+func (img Image) variantConflicts() []Problem {
+	var problems []Problem
+	owners := make(map[string]string)
+	for version := range img.Versions {
+		owners[version] = fmt.Sprintf("version %s", version)
+	}
+
+	for _, version := range sortedKeys(img.Versions) {
+		variants := img.VersionVariants(version)
+		for _, name := range sortedKeys(variants) {
+			output := version + variants[name].suffix(name)
+			if owner, exists := owners[output]; exists {
+				problems = append(problems, Problem{
+					Version: version,
+					Origin:  img.VersionOrigin(version),
+					Message: fmt.Sprintf("variant %s renders to %s, which is already used by %s", name, output, owner),
+				})
+				continue
+			}
+			owners[output] = fmt.Sprintf("variant %s of version %s", name, version)
+		}
+	}
+
+	return problems
+}
+
+func parseVariants(raw interface{}) (map[string]*Variant, error) {
+	variantsMap, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("variants must be a map of variant names to settings")
+	}
+
+	variants := make(map[string]*Variant, len(variantsMap))
+	for name, settingsRaw := range variantsMap {
+		variant := &Variant{}
+		if settingsRaw != nil {
+			settings, ok := settingsRaw.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("variant %s must be a map", name)
+			}
+			if suffix, ok := settings["tag_suffix"].(string); ok {
+				variant.TagSuffix = suffix
+			}
+			if values, ok := settings["values"].(map[string]interface{}); ok {
+				variant.Values = values
+			}
+		}
+		variants[name] = variant
+	}
+
+	return variants, nil
+}
+
+// mergeVariants returns a deep copy of defaults with overrides replacing
+// same-named variants, or nil when both are empty.
+func mergeVariants(defaults, overrides map[string]*Variant) map[string]*Variant {
+	if len(defaults) == 0 && len(overrides) == 0 {
+		return nil
+	}
+
+	result := make(map[string]*Variant, len(defaults)+len(overrides))
+	for _, variants := range []map[string]*Variant{defaults, overrides} {
+		for name, variant := range variants {
+			result[name] = variant.deepCopy()
+		}
+	}
+	return result
+}
+
+func (v *Variant) deepCopy() *Variant {
+	if v == nil {
+		return &Variant{}
+	}
+
+	result := &Variant{TagSuffix: v.TagSuffix}
+	if v.Values != nil {
+		result.Values = make(map[string]interface{}, len(v.Values))
+		for k, val := range v.Values {
+			result.Values[k] = deepCopyValue(val)
+		}
+	}
+	return result
+}
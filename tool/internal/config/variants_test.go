@@ -0,0 +1,139 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestImageConfig_UnmarshalYAML_Variants(t *testing.T) {
+	input := `
+python_version: 3.12.1
+variants:
+  slim:
+    tag_suffix: -slim
+    values:
+      base_flavor: slim
+  debug: {}
+`
+	var ic ImageConfig
+	if err := yaml.Unmarshal([]byte(input), &ic); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if _, exists := ic.Values["variants"]; exists {
+		t.Error("variants should not be left in Values")
+	}
+	want := map[string]*Variant{
+		"slim":  {TagSuffix: "-slim", Values: map[string]interface{}{"base_flavor": "slim"}},
+		"debug": {},
+	}
+	if !reflect.DeepEqual(ic.Variants, want) {
+		t.Errorf("Variants = %+v, want %+v", ic.Variants, want)
+	}
+
+	if err := yaml.Unmarshal([]byte("variants: [slim]\n"), &ic); err == nil {
+		t.Error("Unmarshal() should reject a non-map variants value")
+	}
+}
+
+func TestImage_OutputVersions(t *testing.T) {
+	image := Image{
+		Defaults: &ImageConfig{
+			Variants: map[string]*Variant{
+				"slim": {TagSuffix: "-slim"},
+			},
+		},
+		Versions: map[string]*ImageConfig{
+			"3.12": {
+				Variants: map[string]*Variant{
+					"alpine": {},
+				},
+			},
+			"3.13": nil,
+		},
+	}
+
+	want := []OutputVersion{
+		{Name: "3.12", Version: "3.12"},
+		{Name: "3.12-alpine", Version: "3.12", Variant: "alpine"},
+		{Name: "3.12-slim", Version: "3.12", Variant: "slim"},
+		{Name: "3.13", Version: "3.13"},
+		{Name: "3.13-slim", Version: "3.13", Variant: "slim"},
+	}
+	if got := image.OutputVersions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("OutputVersions() = %+v, want %+v", got, want)
+	}
+
+	if got, ok := image.OutputVersion("3.13-slim"); !ok || got.Version != "3.13" || got.Variant != "slim" {
+		t.Errorf("OutputVersion(3.13-slim) = %+v, %v", got, ok)
+	}
+	if _, ok := image.OutputVersion("3.14"); ok {
+		t.Error("OutputVersion(3.14) should not resolve")
+	}
+}
+
+func TestImage_VersionVariants_Override(t *testing.T) {
+	image := Image{
+		Defaults: &ImageConfig{
+			Variants: map[string]*Variant{"slim": {Values: map[string]interface{}{"flavor": "default"}}},
+		},
+		Versions: map[string]*ImageConfig{
+			"3.12": {Variants: map[string]*Variant{"slim": {Values: map[string]interface{}{"flavor": "version"}}}},
+		},
+	}
+
+	variants := image.VersionVariants("3.12")
+	if got := variants["slim"].Values["flavor"]; got != "version" {
+		t.Errorf("slim flavor = %v, want version", got)
+	}
+
+	variants["slim"].Values["flavor"] = "mutated"
+	if image.Versions["3.12"].Variants["slim"].Values["flavor"] != "version" {
+		t.Error("VersionVariants() should return a copy")
+	}
+}
+
+func TestValidate_VariantConflicts(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]Image{
+			"python": {
+				Versions: map[string]*ImageConfig{
+					"3.12":      {Variants: map[string]*Variant{"slim": {}}},
+					"3.12-slim": {},
+				},
+			},
+		},
+	}
+
+	problems := Validate(cfg)
+	if len(problems) != 1 {
+		t.Fatalf("Validate() returned %d problems, want 1: %v", len(problems), problems)
+	}
+	if !strings.Contains(problems[0].String(), "python/3.12: variant slim renders to 3.12-slim, which is already used by version 3.12-slim") {
+		t.Errorf("unexpected problem %q", problems[0].String())
+	}
+
+	outputs := cfg.Images["python"].OutputVersions()
+	if len(outputs) != 2 {
+		t.Errorf("colliding variant should not produce an extra output, got %+v", outputs)
+	}
+}
+
+func TestImage_WorkflowEnabled_Variant(t *testing.T) {
+	disabled := false
+	image := Image{
+		Versions: map[string]*ImageConfig{
+			"3.12": {
+				Workflow: &ImageWorkflow{Enabled: &disabled},
+				Variants: map[string]*Variant{"slim": {}},
+			},
+		},
+	}
+
+	if image.WorkflowEnabled("3.12-slim") {
+		t.Error("variants should inherit the workflow setting of their version")
+	}
+}
@@ -0,0 +1,193 @@
+// Package e2e runs the whole pipeline, from loading a manifest to generating
+// images and the workflow, over the example project in testdata/example.
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+	"github.com/mberwanger/dockerfiles/tool/internal/workflow"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// copyTree copies the directory src to dst, keeping file modes.
+func copyTree(t *testing.T, src, dst string) {
+	t.Helper()
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, info.Mode().Perm())
+	})
+	if err != nil {
+		t.Fatalf("Failed to copy %s: %v", src, err)
+	}
+}
+
+// generatedFile is the content and mode of one generated file.
+type generatedFile struct {
+	content string
+	mode    fs.FileMode
+}
+
+// generatedTree reads every generated file under imagesDir, i.e. everything
+// but the manifest and the source directories, keyed by slash-separated path.
+func generatedTree(t *testing.T, imagesDir string) map[string]generatedFile {
+	t.Helper()
+	tree := make(map[string]generatedFile)
+	err := filepath.WalkDir(imagesDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == "source" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(imagesDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "manifest.yaml" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		tree[filepath.ToSlash(rel)] = generatedFile{content: string(content), mode: info.Mode().Perm()}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", imagesDir, err)
+	}
+	return tree
+}
+
+// compareGolden compares got with the golden file at goldenPath, rewriting it
+// first when the test runs with -update.
+func compareGolden(t *testing.T, goldenPath string, got []byte, mode fs.FileMode) {
+	t.Helper()
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(goldenPath), 0755); err != nil {
+			t.Fatalf("Failed to create golden directory: %v", err)
+		}
+		if err := os.WriteFile(goldenPath, got, mode); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+		if err := os.Chmod(goldenPath, mode); err != nil {
+			t.Fatalf("Failed to update golden file mode: %v", err)
+		}
+	}
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("Failed to read golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output does not match %s (run with -update to accept)\ngot:\n%s", goldenPath, got)
+	}
+}
+
+func TestExampleProject(t *testing.T) {
+	ctx := context.Background()
+	imagesDir := filepath.Join(t.TempDir(), "images")
+	copyTree(t, filepath.Join("testdata", "example", "images"), imagesDir)
+
+	cfg, err := config.Load(filepath.Join(imagesDir, "manifest.yaml"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// The workflow needs nothing generated: dependencies come from the
+	// templates rendered in memory.
+	var wf bytes.Buffer
+	if err := workflow.GenerateToWriterContext(ctx, cfg, &wf); err != nil {
+		t.Fatalf("GenerateToWriterContext() error = %v", err)
+	}
+	compareGolden(t, filepath.Join("testdata", "golden", "workflow.yaml"), wf.Bytes(), 0644)
+
+	jobs, err := workflow.JobsContext(ctx, cfg)
+	if err != nil {
+		t.Fatalf("JobsContext() error = %v", err)
+	}
+	var needs []string
+	for _, job := range jobs {
+		needs = append(needs, job.ID+" ["+strings.Join(job.Needs, ",")+"]")
+	}
+	wantNeeds := "base-3-21 [] app-1-0 [base-3-21] app-1-1 [base-3-21] base-3-20 []"
+	if got := strings.Join(needs, " "); got != wantNeeds {
+		t.Errorf("jobs = %s, want %s", got, wantNeeds)
+	}
+
+	if _, err := generator.GenerateAllContext(ctx, cfg, generator.DefaultOptions(cfg)); err != nil {
+		t.Fatalf("GenerateAllContext() error = %v", err)
+	}
+	tree := generatedTree(t, imagesDir)
+	for name, file := range tree {
+		compareGolden(t, filepath.Join("testdata", "golden", "images", filepath.FromSlash(name)), []byte(file.content), file.mode)
+	}
+	for name, golden := range generatedTree(t, filepath.Join("testdata", "golden", "images")) {
+		if file, exists := tree[name]; !exists {
+			t.Errorf("golden %s was not generated", name)
+		} else if file.mode != golden.mode {
+			t.Errorf("%s has mode %v, golden has %v", name, file.mode, golden.mode)
+		}
+	}
+
+	// Generation is idempotent and check mode agrees.
+	if _, err := generator.GenerateAllContext(ctx, cfg, generator.DefaultOptions(cfg)); err != nil {
+		t.Fatalf("GenerateAllContext() error = %v", err)
+	}
+	regenerated := generatedTree(t, imagesDir)
+	for name, file := range tree {
+		if regenerated[name] != file {
+			t.Errorf("%s changed on regeneration", name)
+		}
+	}
+	if stale, err := generator.CheckContext(ctx, cfg); err != nil || len(stale) != 0 {
+		t.Fatalf("CheckContext() = %d stale, %v, want a clean tree", len(stale), err)
+	}
+
+	// A hand edit is caught by check mode.
+	edited := filepath.Join(imagesDir, "app", "1.0", "Dockerfile")
+	if err := os.WriteFile(edited, []byte("FROM scratch\n"), 0644); err != nil {
+		t.Fatalf("Failed to edit Dockerfile: %v", err)
+	}
+	stale, err := generator.CheckContext(ctx, cfg)
+	if err != nil {
+		t.Fatalf("CheckContext() error = %v", err)
+	}
+	if len(stale) != 1 || stale[0].Image != "app" || len(stale[0].Actions) != 1 || stale[0].Actions[0].Path != "1.0/Dockerfile" {
+		t.Errorf("CheckContext() = %+v, want only app's 1.0/Dockerfile out of date", stale)
+	}
+}
@@ -0,0 +1,43 @@
+package generator
+
+import (
+	"context"
+	"sort"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// Check renders every image in memory and returns the plans of the images
+// whose directories differ from what generation would write, without
+// touching disk.
+func Check(cfg *config.Config) ([]*Plan, error) {
+	return CheckContext(context.Background(), cfg)
+}
+
+// CheckContext is Check with cancellation. Images are checked in name order.
+func CheckContext(ctx context.Context, cfg *config.Config) ([]*Plan, error) {
+	imageNames := make([]string, 0, len(cfg.Images))
+	for imageName := range cfg.Images {
+		imageNames = append(imageNames, imageName)
+	}
+	sort.Strings(imageNames)
+
+	var stale []*Plan
+	for _, imageName := range imageNames {
+		plan, err := CheckImageContext(ctx, cfg, imageName)
+		if err != nil {
+			return nil, err
+		}
+		if !plan.Empty() {
+			stale = append(stale, plan)
+		}
+	}
+	return stale, nil
+}
+
+// CheckImageContext plans one image as a full, pruning generation would, so
+// that missing, changed and unexpected files as well as orphaned version
+// directories all show up as actions.
+func CheckImageContext(ctx context.Context, cfg *config.Config, imageName string) (*Plan, error) {
+	return PlanImageContext(ctx, cfg, imageName, Options{Prune: true})
+}
@@ -0,0 +1,57 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestCheck(t *testing.T) {
+	cfg, imageDir := testImageConfig(t, "python", map[string]*config.ImageConfig{"3.13": {}}, planSources)
+	f := false
+	cfg.Defaults.PruneOrphans = &f
+
+	stale, err := Check(cfg)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(stale) != 1 {
+		t.Fatalf("Check() = %d stale images, want python before generating", len(stale))
+	}
+
+	if err := GenerateImage(cfg, "python"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+	if stale, err := Check(cfg); err != nil || len(stale) != 0 {
+		t.Fatalf("Check() = %v, %v; want nothing stale after generating", stale, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(imageDir, "3.13", "Dockerfile"), []byte("FROM python:3.12\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(imageDir, "3.13", "entrypoint.sh")); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(imageDir, "3.11")
+	if err := os.MkdirAll(orphan, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err = Check(cfg)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(stale) != 1 {
+		t.Fatalf("Check() = %d stale images, want 1", len(stale))
+	}
+	want := []string{"delete_dir 3.11", "update_file 3.13/Dockerfile", "create_file 3.13/entrypoint.sh"}
+	if got := actionSummary(stale[0]); !reflect.DeepEqual(got, want) {
+		t.Errorf("Check() actions = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Errorf("Check() should leave the orphaned directory in place: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// FileError is a failure to render, copy or vendor one file of an image.
+type FileError struct {
+	Image string
+	// Version is the version or variant output being generated, empty when
+	// the file was read for the whole image.
+	Version string
+	// Op is what was being done: "rendering", "copying" or "vendoring".
+	Op string
+	// Source is the file's path relative to the image's source directory,
+	// or to the manifest directory for vendored files.
+	Source string
+	// Output is the intended path relative to the version directory.
+	Output string
+	Err    error
+}
+
+func (e *FileError) Error() string {
+	subject := e.Image
+	if e.Version != "" {
+		subject = fmt.Sprintf("%s/%s", e.Image, e.Version)
+	}
+	output := e.Output
+	if e.Version != "" {
+		output = path.Join(e.Version, e.Output)
+	}
+
+	message := fmt.Sprintf("%s %s to %s: %v", e.Op, e.Source, output, e.Err)
+	if subject == "" {
+		return message
+	}
+	return fmt.Sprintf("%s: %s", subject, message)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// KeyConflictError is a version whose merged values hold keys that differ
+// only by case or separator, under Options.StrictKeys.
+type KeyConflictError struct {
+	Image     string
+	Version   string
+	Conflicts []config.KeyConflict
+}
+
+func (e *KeyConflictError) Error() string {
+	conflicts := make([]string, len(e.Conflicts))
+	for i, conflict := range e.Conflicts {
+		conflicts[i] = conflict.String()
+	}
+	return fmt.Sprintf("%s/%s: %s", e.Image, e.Version, strings.Join(conflicts, "; "))
+}
+
+// attributeError attributes a *FileError in err's chain to the image and
+// version, which already name the file, and otherwise wraps err with what.
+func attributeError(err error, imageName, versionName, what string) error {
+	var fileErr *FileError
+	if errors.As(err, &fileErr) {
+		fileErr.Image = imageName
+		fileErr.Version = versionName
+		return fileErr
+	}
+	return fmt.Errorf("%s: %w", what, err)
+}
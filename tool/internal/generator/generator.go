@@ -1,10 +1,16 @@
 package generator
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/apex/log"
 
@@ -12,133 +18,401 @@ import (
 	"github.com/mberwanger/dockerfiles/tool/internal/template"
 )
 
+// TestsFile is the per-image template test suite kept in the source
+// directory. It is never copied into version directories.
+const TestsFile = "tests.yaml"
+
+// Options controls a generation run.
+type Options struct {
+	// Prune deletes version directories that no longer belong to any output.
+	// Without it they are left in place and listed in Plan.Orphans.
+	Prune bool
+	// Incremental skips versions whose generated files record the current
+	// inputs hash.
+	Incremental bool
+	// Concurrency is how many images GenerateAllContext generates at once.
+	// Values below one generate them one at a time.
+	Concurrency int
+	// Version restricts generation to one manifest version and its variant
+	// outputs, or to a single variant output by name. The other version
+	// directories are left alone: orphans are neither pruned nor reported.
+	Version string
+	// Journal, when set, records the versions each image completed.
+	Journal *Journal
+	// Resume skips versions that Journal records as completed with the
+	// current inputs hash.
+	Resume bool
+	// StrictKeys fails a version whose merged values hold keys that differ
+	// only by case or separator with a *KeyConflictError, instead of
+	// warning. Images with lint.ignore_key_conflicts are exempt.
+	StrictKeys bool
+}
+
+// DefaultOptions returns the options implied by the manifest, generating one
+// image per CPU.
+func DefaultOptions(cfg *config.Config) Options {
+	return Options{Prune: cfg.Defaults.OrphanPruning(), Concurrency: runtime.NumCPU()}
+}
+
 func GenerateAll(cfg *config.Config) error {
-	for imageName := range cfg.Images {
-		log.Debugf("generating image '%s'", imageName)
-		if err := GenerateImage(cfg, imageName); err != nil {
-			return fmt.Errorf("generating %s: %w", imageName, err)
+	_, err := GenerateAllContext(context.Background(), cfg, DefaultOptions(cfg))
+	return err
+}
+
+// GenerateAllContext generates every enabled image with opts, up to
+// opts.Concurrency at a time, and returns the applied plans ordered by image
+// name. A failing
+// image does not stop the others: every failure is returned together, joined
+// in image order, alongside the plans of the images that succeeded.
+// Cancellation is checked between images, versions and template files.
+func GenerateAllContext(ctx context.Context, cfg *config.Config, opts Options) ([]*Plan, error) {
+	imageNames := make([]string, 0, len(cfg.Images))
+	for imageName, image := range cfg.Images {
+		if !image.IsEnabled() {
+			log.Debugf("%s: skipping disabled image", imageName)
+			continue
 		}
+		imageNames = append(imageNames, imageName)
 	}
-	return nil
-}
+	sort.Strings(imageNames)
+
+	plans := make([]*Plan, len(imageNames))
+	errs := make([]error, len(imageNames))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(opts.Concurrency, 1), len(imageNames)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				plans[i], errs[i] = generateImage(ctx, cfg, imageNames[i], opts)
+			}
+		}()
+	}
+	for i := range imageNames {
+		if ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
 
-func GenerateImage(cfg *config.Config, imageName string) error {
-	image, exists := cfg.Images[imageName]
-	if !exists {
-		return fmt.Errorf("image %s not found in config", imageName)
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	var imagePath string
-	if filepath.IsAbs(image.Path) {
-		imagePath = image.Path
-	} else {
-		basePath := cfg.Defaults.BasePath
-		if basePath == "" {
-			return fmt.Errorf("base path not set in config")
+	var applied []*Plan
+	for _, plan := range plans {
+		if plan != nil {
+			applied = append(applied, plan)
 		}
-		imagePath = filepath.Join(basePath, image.Path)
 	}
+	return applied, errors.Join(errs...)
+}
 
-	sourceDir := filepath.Join(imagePath, "source")
-	if _, err := os.Stat(sourceDir); os.IsNotExist(err) {
-		return fmt.Errorf("source directory %s does not exist", sourceDir)
+// generateImage is one image of GenerateAllContext, with the error naming the
+// image and where it is defined.
+func generateImage(ctx context.Context, cfg *config.Config, imageName string, opts Options) (*Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	log.Debugf("%s: generating image", imageName)
+	plan, err := GenerateImageContext(ctx, cfg, imageName, opts)
+	if err != nil {
+		if origin := cfg.Images[imageName].Origin; !origin.IsZero() {
+			return nil, fmt.Errorf("generating %s (defined at %s): %w", imageName, origin, err)
+		}
+		return nil, fmt.Errorf("generating %s: %w", imageName, err)
 	}
+	return plan, nil
+}
 
-	if err := cleanupOrphanedVersions(imagePath, image.Versions); err != nil {
-		return fmt.Errorf("cleaning up orphaned versions: %w", err)
+func GenerateImage(cfg *config.Config, imageName string) error {
+	_, err := GenerateImageContext(context.Background(), cfg, imageName, DefaultOptions(cfg))
+	return err
+}
+
+// GenerateImageVersion generates one version of an image, with its variant
+// outputs, leaving the image's other version directories untouched.
+func GenerateImageVersion(cfg *config.Config, imageName, version string) error {
+	opts := DefaultOptions(cfg)
+	opts.Version = version
+	_, err := GenerateImageContext(context.Background(), cfg, imageName, opts)
+	return err
+}
+
+// GenerateImageContext generates one image with opts and returns the plan it
+// applied. Every output is rendered in memory before anything is written, so
+// a failed or cancelled run leaves the existing output untouched.
+func GenerateImageContext(ctx context.Context, cfg *config.Config, imageName string, opts Options) (*Plan, error) {
+	plan, err := PlanImageContext(ctx, cfg, imageName, opts)
+	if err != nil {
+		return nil, err
 	}
+	if err := Apply(plan); err != nil {
+		return nil, err
+	}
+	if opts.Journal != nil {
+		if err := opts.Journal.record(imageName, plan.inputs); err != nil {
+			log.Warnf("%s: recording progress: %v", imageName, err)
+		}
+	}
+	return plan, nil
+}
 
-	imageDefaults := image.Defaults
-	if imageDefaults == nil {
-		imageDefaults = &config.ImageConfig{
-			Values: make(map[string]interface{}),
+// renderVersion returns the rendered templates, copied files and vendored
+// files of one version or variant output, with inputsHash recorded in the
+// generation header.
+func renderVersion(ctx context.Context, cfg *config.Config, imageName, versionName string, sources sourceTree, templateFiles []string, inputsHash string) (fileSet, error) {
+	image := cfg.Images[imageName]
+	if _, isVersion := image.Versions[versionName]; isVersion {
+		for _, conflict := range image.KeyConflicts(cfg.Defaults.Values, versionName) {
+			log.Warnf("%s/%s: %s", imageName, versionName, conflict)
 		}
 	}
 
-	for versionName, versionConfig := range image.Versions {
-		log.Debugf("  → version %s", versionName)
+	mergedConfig, err := MergedConfig(cfg, imageName, versionName)
+	if err != nil {
+		return nil, err
+	}
 
-		if versionConfig == nil {
-			versionConfig = &config.ImageConfig{
-				Values: make(map[string]interface{}),
-			}
-		}
+	templateData := NewTemplateData(cfg, imageName, mergedConfig)
+	templateData.SetInputsHash(inputsHash)
+	digests := newOutputDigests(sources, templateFiles)
+	templateData.SetOutputDigests(digests.lookup)
 
-		mergedConfig := versionConfig.Merge(imageDefaults)
-		mergedConfig.Values["version"] = versionName
+	order, err := renderOrder(sources, templateFiles)
+	if err != nil {
+		return nil, err
+	}
 
-		if _, hasRegistry := mergedConfig.Values["registry"]; !hasRegistry {
-			mergedConfig.Values["registry"] = cfg.Defaults.Registry
+	files := make(fileSet)
+	inline := newInlineFiles(sources, image.Inline, templateFiles, files)
+	templateData.SetInlineFiles(inline.lookup)
+
+	// Process template files, rendering files referenced by output_sha256 or
+	// inline_file before the templates that reference them.
+	for _, templateFile := range order {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
 
-		outputDir := filepath.Join(imagePath, versionName)
-		if err := os.RemoveAll(outputDir); err != nil {
-			return fmt.Errorf("removing output directory %s: %w", outputDir, err)
+		templatePath := sources.path(templateFile)
+		name := outputName(templateFile)
+		content, err := template.Render(templatePath, templateData)
+		if err != nil {
+			return nil, &FileError{
+				Image:   imageName,
+				Version: versionName,
+				Op:      "rendering",
+				Source:  filepath.ToSlash(templateFile),
+				Output:  name,
+				Err:     err,
+			}
+		}
+		if isDockerfile(name) {
+			content = withSyntaxDirective(content, cfg.Defaults.DockerfileSyntax)
+			if features := syntaxFeatures(content); len(features) > 0 && !hasSyntaxDirective(content) {
+				log.Warnf("%s/%s: %s uses %s without a syntax directive; set defaults.dockerfile_syntax", imageName, versionName, name, strings.Join(features, " and "))
+			}
 		}
-		if err := os.MkdirAll(outputDir, 0755); err != nil {
-			return fmt.Errorf("creating output directory %s: %w", outputDir, err)
+		if inline.takePending() && !hasSyntaxDirective(content) {
+			return nil, &FileError{
+				Image:   imageName,
+				Version: versionName,
+				Op:      "rendering",
+				Source:  filepath.ToSlash(templateFile),
+				Output:  name,
+				Err:     fmt.Errorf("inline_file emits a heredoc, which needs a syntax directive; set defaults.dockerfile_syntax"),
+			}
 		}
+		files[name] = plannedFile{content: []byte(content), mode: 0644}
+		digests.record(name, []byte(content))
+	}
+
+	if unused := inline.unused(); len(unused) > 0 {
+		return nil, fmt.Errorf("%s/%s: inline lists %s, which no template embeds with inline_file", imageName, versionName, strings.Join(unused, ", "))
+	}
+	for name := range inline.names {
+		delete(files, name)
+	}
 
-		templateData := template.NewData(mergedConfig, imageName)
+	exclude := append([]string{TestsFile}, templateFiles...)
+	for name := range inline.names {
+		exclude = append(exclude, filepath.FromSlash(name))
+	}
+	if err := copyNonTemplateFiles(sources, files, exclude); err != nil {
+		return nil, attributeError(err, imageName, versionName, "copying non-template files")
+	}
+
+	if err := vendorFiles(cfg.Defaults.BasePath, image.Vendor, files); err != nil {
+		return nil, attributeError(err, imageName, versionName, "vendoring shared files")
+	}
 
-		templateFiles, err := discoverTemplateFiles(sourceDir)
+	if cfg.Defaults.EmitValuesSnapshot {
+		if _, exists := files[ValuesSnapshotFile]; exists {
+			return nil, fmt.Errorf("%s/%s: %s is written by emit_values_snapshot and cannot also come from the source directory", imageName, versionName, ValuesSnapshotFile)
+		}
+		snapshot, err := valuesSnapshot(templateData, mergedConfig)
 		if err != nil {
-			return fmt.Errorf("discovering template files: %w", err)
+			return nil, attributeError(err, imageName, versionName, "writing values snapshot")
 		}
+		files[ValuesSnapshotFile] = plannedFile{content: snapshot, mode: 0644}
+	}
 
-		// Process template files
-		for _, templateFile := range templateFiles {
-			templatePath := filepath.Join(sourceDir, templateFile)
-			outputFilename := strings.TrimSuffix(templateFile, ".tmpl")
+	if dockerfile, exists := files["Dockerfile"]; exists && usesOnBuild(dockerfile.content) && (image.Lint == nil || !image.Lint.AllowOnBuild) {
+		log.Warnf("%s/%s: Dockerfile uses ONBUILD, whose triggers run in every image built FROM it; set lint.allow_onbuild to acknowledge", imageName, versionName)
+	}
 
-			outputPath := filepath.Join(outputDir, outputFilename)
-			outputFileDir := filepath.Dir(outputPath)
-			if err := os.MkdirAll(outputFileDir, 0755); err != nil {
-				return fmt.Errorf("creating output directory %s: %w", outputFileDir, err)
-			}
+	return files, nil
+}
 
-			if err := template.WriteFile(templatePath, outputPath, templateData); err != nil {
-				return fmt.Errorf("processing template %s: %w", templateFile, err)
-			}
-		}
+// RenderTemplate renders one template for an image version in memory, with
+// the data generation uses, and returns the output. A template of the image's
+// source tree is rendered along with the version's other templates, so that
+// output_sha256, inline_file and the syntax directive come out exactly as
+// generated; any other template is rendered on its own with the version's
+// values.
+func RenderTemplate(ctx context.Context, cfg *config.Config, imageName, versionName, templatePath string) (string, error) {
+	image, exists := cfg.Images[imageName]
+	if !exists {
+		return "", fmt.Errorf("image %s not found in config", imageName)
+	}
+	imagePath, err := cfg.ImagePath(image)
+	if err != nil {
+		return "", err
+	}
+	sources, err := imageSources(cfg, image, imagePath)
+	if err != nil {
+		return "", err
+	}
+	templateFiles, err := discoverTemplateFiles(sources)
+	if err != nil {
+		return "", fmt.Errorf("discovering template files: %w", err)
+	}
+	digest, err := sourceDigest(cfg, image, sources)
+	if err != nil {
+		return "", attributeError(err, imageName, "", "hashing inputs")
+	}
+	hash, err := inputsHash(cfg, imageName, versionName, digest)
+	if err != nil {
+		return "", err
+	}
 
-		if err := copyNonTemplateFiles(sourceDir, outputDir, templateFiles); err != nil {
-			return fmt.Errorf("copying non-template files: %w", err)
+	target, err := filepath.Abs(templatePath)
+	if err != nil {
+		return "", err
+	}
+	for _, templateFile := range templateFiles {
+		if path, err := filepath.Abs(sources.path(templateFile)); err != nil || path != target {
+			continue
+		}
+		files, err := renderVersion(ctx, cfg, imageName, versionName, sources, templateFiles, hash)
+		if err != nil {
+			return "", err
 		}
+		file, rendered := files[outputName(templateFile)]
+		if !rendered {
+			return "", fmt.Errorf("%s is inlined into another template; render that one instead", templatePath)
+		}
+		return string(file.content), nil
 	}
 
-	return nil
+	mergedConfig, err := MergedConfig(cfg, imageName, versionName)
+	if err != nil {
+		return "", err
+	}
+	templateData := NewTemplateData(cfg, imageName, mergedConfig)
+	templateData.SetInputsHash(hash)
+	return template.Render(templatePath, templateData)
 }
 
-func discoverTemplateFiles(sourceDir string) ([]string, error) {
-	var templateFiles []string
+// MergedConfig returns the configuration a version is rendered with: the
+// version block merged over the image defaults and the manifest-wide
+// defaults.values, then the variant overlay for
+// variant outputs, then any CLI overrides, plus the injected version and
+// registry values. Variant outputs also get a "variant" value, and aliased
+// versions an "aliases" list.
+func MergedConfig(cfg *config.Config, imageName, versionName string) (*config.ImageConfig, error) {
+	image, exists := cfg.Images[imageName]
+	if !exists {
+		return nil, fmt.Errorf("image %s not found in config", imageName)
+	}
 
-	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	output, exists := image.OutputVersion(versionName)
+	if !exists {
+		return nil, fmt.Errorf("version %s not found for image %s", versionName, imageName)
+	}
+	versionConfig := image.Versions[output.Version]
 
-		if info.IsDir() {
-			return nil
+	imageDefaults := cfg.ImageDefaults(imageName)
+
+	if versionConfig == nil {
+		versionConfig = &config.ImageConfig{
+			Values: make(map[string]interface{}),
 		}
+	}
+
+	mergedConfig := versionConfig.Merge(imageDefaults)
+	mergedConfig.Values["version"] = output.Version
+	if output.Variant != "" {
+		mergedConfig.Variants[output.Variant].Apply(mergedConfig)
+		mergedConfig.Values["variant"] = output.Variant
+	}
+	if aliases := image.VersionAliases(output.Name); len(aliases) > 0 {
+		mergedConfig.Values["aliases"] = aliases
+	}
+	mergedConfig.ApplyOverrides(cfg.Defaults.Overrides)
+
+	if _, hasRegistry := mergedConfig.Values["registry"]; !hasRegistry {
+		mergedConfig.Values["registry"] = cfg.Defaults.Registry
+	}
+
+	return mergedConfig, nil
+}
+
+// NewTemplateData builds the template data for a merged configuration with
+// the manifest-wide settings applied.
+func NewTemplateData(cfg *config.Config, imageName string, mergedConfig *config.ImageConfig) *template.Data {
+	data := template.NewData(mergedConfig, imageName)
+	data.SetReproducibility(cfg.Defaults.Reproducibility())
+	data.SetHeaderStyles(cfg.Defaults.Headers)
+	data.SetStrict(cfg.Defaults.StrictTemplating())
+	data.SetPartialsDir(cfg.PartialsDir())
+	if len(cfg.Defaults.Overrides) > 0 {
+		data.SetOverrides(config.OverrideKeys(cfg.Defaults.Overrides))
+	}
+	return data
+}
 
+var onBuildPattern = regexp.MustCompile(`(?mi)^\s*ONBUILD\s`)
+
+// usesOnBuild reports whether a Dockerfile declares ONBUILD triggers.
+func usesOnBuild(dockerfile []byte) bool {
+	return onBuildPattern.Match(dockerfile)
+}
+
+func discoverTemplateFiles(sources sourceTree) ([]string, error) {
+	var templateFiles []string
+
+	err := sources.walk(func(relPath, _ string, info os.FileInfo) error {
 		if strings.HasSuffix(info.Name(), ".tmpl") {
-			relPath, err := filepath.Rel(sourceDir, path)
-			if err != nil {
-				return fmt.Errorf("getting relative path for %s: %w", path, err)
-			}
 			templateFiles = append(templateFiles, relPath)
 		}
-
 		return nil
 	})
 
 	return templateFiles, err
 }
 
-func copyNonTemplateFiles(sourceDir, outputDir string, exclude []string) error {
-	if sourceDir == "" || outputDir == "" {
-		return fmt.Errorf("source and output directories cannot be empty")
+// copyNonTemplateFiles adds the source files not listed in exclude to files,
+// keeping their permissions. Exclusions are paths relative to the source
+// tree, so a file with the same name in another directory is still copied.
+func copyNonTemplateFiles(sources sourceTree, files fileSet, exclude []string) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("source directory cannot be empty")
 	}
 
 	excludeSet := make(map[string]bool)
@@ -146,63 +420,17 @@ func copyNonTemplateFiles(sourceDir, outputDir string, exclude []string) error {
 		excludeSet[file] = true
 	}
 
-	return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if path == sourceDir {
-			return nil
-		}
-
-		relPath, err := filepath.Rel(sourceDir, path)
-		if err != nil {
-			return fmt.Errorf("getting relative path for %s: %w", path, err)
-		}
-
-		if excludeSet[relPath] || excludeSet[filepath.Base(path)] {
+	return sources.walk(func(relPath, path string, info os.FileInfo) error {
+		if excludeSet[relPath] {
 			return nil
 		}
 
-		destPath := filepath.Join(outputDir, relPath)
-
-		if info.IsDir() {
-			return os.MkdirAll(destPath, info.Mode())
-		}
-
 		content, err := os.ReadFile(path)
 		if err != nil {
-			return fmt.Errorf("reading file %s: %w", path, err)
-		}
-
-		if err := os.WriteFile(destPath, content, info.Mode()); err != nil {
-			return fmt.Errorf("writing file %s: %w", destPath, err)
+			return &FileError{Op: "copying", Source: filepath.ToSlash(relPath), Output: filepath.ToSlash(relPath), Err: err}
 		}
 
+		files[filepath.ToSlash(relPath)] = plannedFile{content: content, mode: info.Mode().Perm()}
 		return nil
 	})
 }
-
-func cleanupOrphanedVersions(imagePath string, versions map[string]*config.ImageConfig) error {
-	entries, err := os.ReadDir(imagePath)
-	if err != nil {
-		return fmt.Errorf("reading image directory: %w", err)
-	}
-
-	for _, entry := range entries {
-		// Skip non-directories and the source directory
-		if !entry.IsDir() || entry.Name() == "source" {
-			continue
-		}
-
-		if _, exists := versions[entry.Name()]; !exists {
-			orphanedPath := filepath.Join(imagePath, entry.Name())
-			log.Infof("removing orphaned version directory: %s", orphanedPath)
-			if err := os.RemoveAll(orphanedPath); err != nil {
-				return fmt.Errorf("removing orphaned directory %s: %w", orphanedPath, err)
-			}
-		}
-	}
-
-	return nil
-}
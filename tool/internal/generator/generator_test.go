@@ -1,8 +1,14 @@
 package generator
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/mberwanger/dockerfiles/tool/internal/config"
@@ -85,6 +91,58 @@ func TestGenerateAll_ImageNotFound(t *testing.T) {
 	}
 }
 
+func TestGenerateAllContext_CollectsErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Version:  1,
+		Defaults: config.Defaults{BasePath: tmpDir},
+		Images:   make(map[string]config.Image),
+	}
+	templates := map[string]string{
+		"app1": "FROM alpine\n",
+		"app2": "FROM {{ .Missing\n",
+		"app3": "FROM alpine\n",
+		"app4": "FROM {{ .Missing\n",
+	}
+	for imageName, content := range templates {
+		cfg.Images[imageName] = config.Image{
+			Path:     "images/" + imageName,
+			Versions: map[string]*config.ImageConfig{"v1": {Values: map[string]interface{}{}}},
+		}
+		sourceDir := filepath.Join(tmpDir, "images", imageName, "source")
+		if err := os.MkdirAll(sourceDir, 0755); err != nil {
+			t.Fatalf("Failed to create source directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(sourceDir, "Dockerfile.tmpl"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write template: %v", err)
+		}
+	}
+
+	plans, err := GenerateAllContext(context.Background(), cfg, Options{Concurrency: 3})
+	if err == nil {
+		t.Fatal("GenerateAllContext() should fail for the broken templates")
+	}
+
+	failures := err.(interface{ Unwrap() []error }).Unwrap()
+	if len(failures) != 2 || !strings.HasPrefix(failures[0].Error(), "generating app2") || !strings.HasPrefix(failures[1].Error(), "generating app4") {
+		t.Errorf("GenerateAllContext() error = %v, want app2 and app4 in order", err)
+	}
+
+	var generated []string
+	for _, plan := range plans {
+		generated = append(generated, plan.Image)
+	}
+	if strings.Join(generated, ",") != "app1,app3" {
+		t.Errorf("generated %v, want app1 and app3 despite the failures", generated)
+	}
+	for _, imageName := range []string{"app1", "app3"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, "images", imageName, "v1", "Dockerfile")); err != nil {
+			t.Errorf("%s was not written: %v", imageName, err)
+		}
+	}
+}
+
 func TestGenerateImage(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -243,6 +301,740 @@ func TestGenerateImage_AbsolutePath(t *testing.T) {
 	}
 }
 
+func TestGenerateAll_Reproducible(t *testing.T) {
+	tmpDir := t.TempDir()
+	epoch := int64(1700000000)
+
+	cfg := &config.Config{
+		Version: 1,
+		Defaults: config.Defaults{
+			BasePath:        tmpDir,
+			Registry:        "test.io",
+			SourceDateEpoch: &epoch,
+		},
+		Images: map[string]config.Image{
+			"base": {
+				Path: "base",
+				Versions: map[string]*config.ImageConfig{
+					"v1": {BaseImage: &config.BaseImage{Name: "alpine:3.20", Source: "dockerhub"}},
+				},
+			},
+			"app": {
+				Path: "app",
+				Defaults: &config.ImageConfig{
+					BaseImage: &config.BaseImage{Name: "base:v1"},
+				},
+				Versions: map[string]*config.ImageConfig{
+					"v1": {Values: map[string]interface{}{"port": 8080}},
+					"v2": {Values: map[string]interface{}{"port": 9090}},
+				},
+			},
+		},
+	}
+
+	files := map[string]string{
+		"base/source/Dockerfile.tmpl":   "{{generation_message}}\n{{from_image \"base_image\"}}\n",
+		"base/source/certs/ca.pem":      "certificate\n",
+		"app/source/Dockerfile.tmpl":    "{{generation_message}}\n{{from_image \"base_image\"}}\nLABEL created={{build_timestamp}}\nEXPOSE {{port}}\n",
+		"app/source/conf/app.conf.tmpl": "version={{version}}\n",
+	}
+	for path, content := range files {
+		fullPath := filepath.Join(tmpDir, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	if err := GenerateAll(cfg); err != nil {
+		t.Fatalf("GenerateAll() error = %v", err)
+	}
+	first := snapshotTree(t, tmpDir)
+
+	if err := GenerateAll(cfg); err != nil {
+		t.Fatalf("GenerateAll() second run error = %v", err)
+	}
+	second := snapshotTree(t, tmpDir)
+
+	if len(first) != len(second) {
+		t.Fatalf("Tree size changed between runs: %d vs %d files", len(first), len(second))
+	}
+	for path, content := range first {
+		if second[path] != content {
+			t.Errorf("File %s differs between runs", path)
+		}
+	}
+
+	// Without a pinned epoch, reproducible mode must refuse time-derived helpers
+	cfg.Defaults.SourceDateEpoch = nil
+	if err := GenerateImage(cfg, "app"); err == nil {
+		t.Error("GenerateImage() should fail for build_timestamp without source_date_epoch in reproducible mode")
+	}
+}
+
+// snapshotTree returns the content and mode of every file below root keyed by
+// relative path.
+func snapshotTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+
+	tree := make(map[string]string)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		tree[relPath] = info.Mode().String() + "\n" + string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to snapshot tree: %v", err)
+	}
+	return tree
+}
+
+func TestGenerateImage_SkipsTestsFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"myapp": {
+				Path: "myapp",
+				Versions: map[string]*config.ImageConfig{
+					"v1": {Values: map[string]interface{}{}},
+				},
+			},
+		},
+	}
+
+	sourceDir := filepath.Join(tmpDir, "myapp", "source")
+	if err := os.MkdirAll(sourceDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for name, content := range map[string]string{
+		"Dockerfile.tmpl": "FROM alpine\n",
+		TestsFile:         "cases: []\n",
+	} {
+		if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	if err := GenerateImage(cfg, "myapp"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, "myapp", "v1", TestsFile)); !os.IsNotExist(err) {
+		t.Errorf("%s should not be copied into version directories", TestsFile)
+	}
+}
+
+func TestGenerateImage_RenderFailureKeepsExistingOutput(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// "3.12" was renamed to "3.13" by mistake and the template fails to render
+	// for the new version.
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"python": {
+				Path: "python",
+				Versions: map[string]*config.ImageConfig{
+					"3.13": {Values: map[string]interface{}{}},
+				},
+			},
+		},
+	}
+
+	imageDir := filepath.Join(tmpDir, "python")
+	sourceDir := filepath.Join(imageDir, "source")
+	if err := os.MkdirAll(sourceDir, 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sourceDir, "Dockerfile.tmpl"), []byte("FROM python:{{python_version}}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+
+	existing := filepath.Join(imageDir, "3.12", "Dockerfile")
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatalf("Failed to create version directory: %v", err)
+	}
+	if err := os.WriteFile(existing, []byte("FROM python:3.12.1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write existing Dockerfile: %v", err)
+	}
+
+	if err := GenerateImage(cfg, "python"); err == nil {
+		t.Fatal("GenerateImage() should fail when a template does not render")
+	}
+
+	content, err := os.ReadFile(existing)
+	if err != nil {
+		t.Fatalf("existing version should survive a failed render: %v", err)
+	}
+	if string(content) != "FROM python:3.12.1\n" {
+		t.Errorf("existing Dockerfile was modified: %q", content)
+	}
+
+	entries, err := os.ReadDir(imageDir)
+	if err != nil {
+		t.Fatalf("Failed to read image directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "source" && entry.Name() != "3.12" {
+			t.Errorf("unexpected entry %s left after a failed render", entry.Name())
+		}
+	}
+}
+
+func TestGenerateImage_FileError(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"python": {Path: "python", Versions: map[string]*config.ImageConfig{"3.13": {Values: map[string]interface{}{}}}},
+		},
+	}
+	writeSourceFiles(t, filepath.Join(tmpDir, "python", "source"), map[string]string{
+		"Dockerfile.tmpl":               "FROM python:{{version}}\n",
+		"config/nested/thing.conf.tmpl": "{{undefined_helper}}\n",
+	})
+
+	_, err := GenerateAllContext(context.Background(), cfg, DefaultOptions(cfg))
+	var fileErr *FileError
+	if !errors.As(err, &fileErr) {
+		t.Fatalf("GenerateAllContext() error = %v, want a *FileError", err)
+	}
+	want := FileError{Image: "python", Version: "3.13", Op: "rendering", Source: "config/nested/thing.conf.tmpl", Output: "config/nested/thing.conf"}
+	if fileErr.Image != want.Image || fileErr.Version != want.Version || fileErr.Op != want.Op || fileErr.Source != want.Source || fileErr.Output != want.Output {
+		t.Errorf("FileError = %+v, want %+v", *fileErr, want)
+	}
+	if !strings.Contains(err.Error(), "python/3.13: rendering config/nested/thing.conf.tmpl to 3.13/config/nested/thing.conf: ") {
+		t.Errorf("error = %q, want the version and both paths", err)
+	}
+}
+
+func TestGenerateImage_RequiredValue(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"app": {Path: "app", Versions: map[string]*config.ImageConfig{"1.0": {Values: map[string]interface{}{}}}},
+		},
+	}
+	writeSourceFiles(t, filepath.Join(tmpDir, "app", "source"), map[string]string{
+		"Dockerfile.tmpl": "FROM alpine\nEXPOSE {{required \"port must be set\" (get \"port\")}}\n",
+	})
+
+	_, err := GenerateAllContext(context.Background(), cfg, DefaultOptions(cfg))
+	if err == nil {
+		t.Fatal("GenerateAllContext() should fail on a missing required value")
+	}
+	want := fmt.Sprintf("app/1.0: rendering Dockerfile.tmpl to 1.0/Dockerfile: template %s: port must be set", filepath.Join(tmpDir, "app", "source", "Dockerfile.tmpl"))
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %q, want it to contain %q", err, want)
+	}
+}
+
+func TestGenerateImage_UnknownValue(t *testing.T) {
+	tmpDir := t.TempDir()
+	strict := false
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"app": {Path: "app", Versions: map[string]*config.ImageConfig{"1.0": {Values: map[string]interface{}{"port": 8080}}}},
+		},
+	}
+	writeSourceFiles(t, filepath.Join(tmpDir, "app", "source"), map[string]string{
+		"Dockerfile.tmpl": "FROM alpine\nEXPOSE {{prot}}\n",
+	})
+
+	_, err := GenerateAllContext(context.Background(), cfg, DefaultOptions(cfg))
+	if err == nil {
+		t.Fatal("GenerateAllContext() should fail on an unknown value")
+	}
+	want := fmt.Sprintf("app/1.0: rendering Dockerfile.tmpl to 1.0/Dockerfile: template %s: unknown value prot (did you mean port?)", filepath.Join(tmpDir, "app", "source", "Dockerfile.tmpl"))
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %q, want it to contain %q", err, want)
+	}
+
+	cfg.Defaults.StrictTemplates = &strict
+	if _, err := GenerateAllContext(context.Background(), cfg, DefaultOptions(cfg)); err != nil {
+		t.Fatalf("GenerateAllContext() with strict_templates off error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tmpDir, "app", "1.0", "Dockerfile"))
+	if err != nil {
+		t.Fatalf("Failed to read Dockerfile: %v", err)
+	}
+	if !strings.HasSuffix(string(content), "EXPOSE \n") {
+		t.Errorf("Dockerfile = %q, want the unknown value rendered empty", content)
+	}
+}
+
+func TestGenerateImage_RemovesOrphansAfterSuccess(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"python": {
+				Path: "python",
+				Versions: map[string]*config.ImageConfig{
+					"3.13": {Values: map[string]interface{}{}},
+				},
+			},
+		},
+	}
+
+	imageDir := filepath.Join(tmpDir, "python")
+	if err := os.MkdirAll(filepath.Join(imageDir, "source"), 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(imageDir, "source", "Dockerfile.tmpl"), []byte("FROM python:{{version}}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+	for _, stale := range []string{"3.12/Dockerfile", "3.13/stale.txt"} {
+		path := filepath.Join(imageDir, stale)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", stale, err)
+		}
+	}
+
+	if err := GenerateImage(cfg, "python"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(imageDir, "3.12")); !os.IsNotExist(err) {
+		t.Error("orphaned version directory 3.12 should be removed")
+	}
+	if _, err := os.Stat(filepath.Join(imageDir, "3.13", "stale.txt")); !os.IsNotExist(err) {
+		t.Error("stale file in 3.13 should be removed")
+	}
+	content, err := os.ReadFile(filepath.Join(imageDir, "3.13", "Dockerfile"))
+	if err != nil || string(content) != "FROM python:3.13\n" {
+		t.Errorf("3.13/Dockerfile = %q, %v", content, err)
+	}
+}
+
+func TestGenerateAll_SkipsDisabled(t *testing.T) {
+	tmpDir := t.TempDir()
+	disabled := false
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"legacy": {
+				Path:     "legacy",
+				Enabled:  &disabled,
+				Versions: map[string]*config.ImageConfig{"1.0": {}},
+			},
+			"python": {
+				Path: "python",
+				Versions: map[string]*config.ImageConfig{
+					"3.12": {Enabled: &disabled},
+					"3.13": {},
+				},
+			},
+		},
+	}
+	writeSourceFiles(t, filepath.Join(tmpDir, "python", "source"), map[string]string{
+		"Dockerfile.tmpl": "FROM python:{{version}}\n",
+	})
+	writeSourceFiles(t, filepath.Join(tmpDir, "python", "3.12"), map[string]string{"Dockerfile": "kept\n"})
+	writeSourceFiles(t, filepath.Join(tmpDir, "legacy", "1.0"), map[string]string{"Dockerfile": "kept\n"})
+
+	// legacy has no source directory, which would fail were it generated.
+	if err := GenerateAll(cfg); err != nil {
+		t.Fatalf("GenerateAll() error = %v", err)
+	}
+	if err := GenerateImage(cfg, "legacy"); err != nil {
+		t.Fatalf("GenerateImage() error = %v for a disabled image", err)
+	}
+
+	for path, want := range map[string]string{
+		"python/3.12/Dockerfile": "kept\n",
+		"python/3.13/Dockerfile": "FROM python:3.13\n",
+		"legacy/1.0/Dockerfile":  "kept\n",
+	} {
+		content, err := os.ReadFile(filepath.Join(tmpDir, filepath.FromSlash(path)))
+		if err != nil || string(content) != want {
+			t.Errorf("%s = %q, %v, want %q", path, content, err, want)
+		}
+	}
+}
+
+func TestGenerateImageVersion(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"python": {
+				Path: "python",
+				Versions: map[string]*config.ImageConfig{
+					"3.12": {Values: map[string]interface{}{}},
+					"3.13": {
+						Values:   map[string]interface{}{},
+						Variants: map[string]*config.Variant{"slim": nil},
+					},
+				},
+			},
+		},
+	}
+
+	imageDir := filepath.Join(tmpDir, "python")
+	writeSourceFiles(t, filepath.Join(imageDir, "source"), map[string]string{"Dockerfile.tmpl": "FROM python:{{version}}\n"})
+	for _, stale := range []string{"3.11/Dockerfile", "3.12/Dockerfile", "3.13/Dockerfile"} {
+		writeSourceFiles(t, imageDir, map[string]string{stale: "old\n"})
+	}
+
+	if err := GenerateImageVersion(cfg, "python", "3.13"); err != nil {
+		t.Fatalf("GenerateImageVersion() error = %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"3.11/Dockerfile":      "old\n",
+		"3.12/Dockerfile":      "old\n",
+		"3.13/Dockerfile":      "FROM python:3.13\n",
+		"3.13-slim/Dockerfile": "FROM python:3.13\n",
+	} {
+		content, err := os.ReadFile(filepath.Join(imageDir, name))
+		if err != nil || string(content) != want {
+			t.Errorf("%s = %q, %v; want %q", name, content, err, want)
+		}
+	}
+
+	err := GenerateImageVersion(cfg, "python", "3.10")
+	if err == nil || !strings.Contains(err.Error(), "version 3.10 not found for image python") {
+		t.Errorf("GenerateImageVersion() error = %v, want an unknown version error", err)
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			BasePath:  tmpDir,
+			Registry:  "test.io",
+			Overrides: map[string]interface{}{"python_version": "3.13.0rc1"},
+		},
+		Images: map[string]config.Image{
+			"python": {
+				Path:     "python",
+				Versions: map[string]*config.ImageConfig{"3.13": {Values: map[string]interface{}{}}},
+			},
+		},
+	}
+
+	sourceDir := filepath.Join(tmpDir, "python", "source")
+	writeSourceFiles(t, sourceDir, map[string]string{
+		"Dockerfile.tmpl": "FROM python:{{python_version}}\nCOPY config.txt /\n",
+		"config.txt.tmpl": "version={{version}}\n",
+	})
+
+	got, err := RenderTemplate(context.Background(), cfg, "python", "3.13", filepath.Join(sourceDir, "Dockerfile.tmpl"))
+	if err != nil {
+		t.Fatalf("RenderTemplate() error = %v", err)
+	}
+	if want := "FROM python:3.13.0rc1\nCOPY config.txt /\n"; got != want {
+		t.Errorf("RenderTemplate() = %q, want %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "python", "3.13")); !os.IsNotExist(err) {
+		t.Errorf("RenderTemplate() should not write the version directory, stat error = %v", err)
+	}
+
+	scratch := filepath.Join(tmpDir, "scratch.tmpl")
+	writeSourceFiles(t, tmpDir, map[string]string{"scratch.tmpl": "{{registry}}/python:{{version}}\n{{ index .Values.missing 1 }}\n"})
+	_, err = RenderTemplate(context.Background(), cfg, "python", "3.13", scratch)
+	if err == nil || !strings.Contains(err.Error(), scratch) || !strings.Contains(err.Error(), "scratch.tmpl:2:") {
+		t.Errorf("RenderTemplate() error = %v, want the template path and line", err)
+	}
+}
+
+func TestGenerateImage_Variants(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"python": {
+				Path: "python",
+				Defaults: &config.ImageConfig{
+					Values: map[string]interface{}{"flavor": "full"},
+					Variants: map[string]*config.Variant{
+						"slim": {Values: map[string]interface{}{"flavor": "slim"}},
+					},
+				},
+				Versions: map[string]*config.ImageConfig{
+					"3.12": {Values: map[string]interface{}{}},
+				},
+			},
+		},
+	}
+
+	imageDir := filepath.Join(tmpDir, "python")
+	if err := os.MkdirAll(filepath.Join(imageDir, "source"), 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	tmpl := "FROM python:{{version}}-{{flavor}}{{with get \"variant\"}} # {{.}}{{end}}\n"
+	if err := os.WriteFile(filepath.Join(imageDir, "source", "Dockerfile.tmpl"), []byte(tmpl), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+
+	if err := GenerateImage(cfg, "python"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	for dir, want := range map[string]string{
+		"3.12":      "FROM python:3.12-full\n",
+		"3.12-slim": "FROM python:3.12-slim # slim\n",
+	} {
+		content, err := os.ReadFile(filepath.Join(imageDir, dir, "Dockerfile"))
+		if err != nil {
+			t.Fatalf("Failed to read %s/Dockerfile: %v", dir, err)
+		}
+		if string(content) != want {
+			t.Errorf("%s/Dockerfile = %q, want %q", dir, content, want)
+		}
+	}
+
+	// Dropping the variant removes its output on the next run.
+	cfg.Images["python"].Defaults.Variants = nil
+	if err := GenerateImage(cfg, "python"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(imageDir, "3.12-slim")); !os.IsNotExist(err) {
+		t.Error("removed variant output 3.12-slim should be cleaned up")
+	}
+}
+
+// cancelAfter reports cancellation once Err has been consulted n times, so a
+// test can cancel at a deterministic point in the middle of generation.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestGenerateImageContext_Cancelled(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	versions := make(map[string]*config.ImageConfig)
+	for _, version := range []string{"v1", "v2", "v3", "v4"} {
+		versions[version] = &config.ImageConfig{Values: map[string]interface{}{}}
+	}
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"myapp": {Path: "myapp", Versions: versions},
+		},
+	}
+
+	imageDir := filepath.Join(tmpDir, "myapp")
+	if err := os.MkdirAll(filepath.Join(imageDir, "source"), 0755); err != nil {
+		t.Fatalf("Failed to create source directory: %v", err)
+	}
+	for _, name := range []string{"Dockerfile.tmpl", "entrypoint.sh.tmpl"} {
+		if err := os.WriteFile(filepath.Join(imageDir, "source", name), []byte("# {{version}}\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	existing := filepath.Join(imageDir, "v1", "Dockerfile")
+	if err := os.MkdirAll(filepath.Dir(existing), 0755); err != nil {
+		t.Fatalf("Failed to create version directory: %v", err)
+	}
+	if err := os.WriteFile(existing, []byte("# previous\n"), 0644); err != nil {
+		t.Fatalf("Failed to write existing Dockerfile: %v", err)
+	}
+
+	// Cancel after three template files, i.e. partway through the second version.
+	ctx := &cancelAfter{Context: context.Background(), n: 3}
+	_, err := GenerateImageContext(ctx, cfg, "myapp", DefaultOptions(cfg))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("GenerateImageContext() error = %v, want context.Canceled", err)
+	}
+
+	content, err := os.ReadFile(existing)
+	if err != nil || string(content) != "# previous\n" {
+		t.Errorf("existing output should be untouched after cancellation, got %q, %v", content, err)
+	}
+	entries, err := os.ReadDir(imageDir)
+	if err != nil {
+		t.Fatalf("Failed to read image directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "source" && entry.Name() != "v1" {
+			t.Errorf("unexpected entry %s left after cancellation", entry.Name())
+		}
+	}
+}
+
+func TestGenerateAllContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cfg := &config.Config{
+		Images: map[string]config.Image{
+			"myapp": {Path: "myapp", Versions: map[string]*config.ImageConfig{"v1": {}}},
+		},
+	}
+	if _, err := GenerateAllContext(ctx, cfg, DefaultOptions(cfg)); !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateAllContext() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestMergedConfig(t *testing.T) {
+	cfg := &config.Config{
+		Defaults: config.Defaults{Registry: "test.io"},
+		Images: map[string]config.Image{
+			"myapp": {
+				Defaults: &config.ImageConfig{
+					Values: map[string]interface{}{"key": "default", "other": "default"},
+				},
+				Versions: map[string]*config.ImageConfig{
+					"v1": {Values: map[string]interface{}{"key": "v1"}},
+					"v2": nil,
+				},
+			},
+		},
+	}
+
+	merged, err := MergedConfig(cfg, "myapp", "v1")
+	if err != nil {
+		t.Fatalf("MergedConfig() error = %v", err)
+	}
+	want := map[string]interface{}{"key": "v1", "other": "default", "version": "v1", "registry": "test.io"}
+	for k, v := range want {
+		if merged.Values[k] != v {
+			t.Errorf("Values[%s] = %v, want %v", k, merged.Values[k], v)
+		}
+	}
+
+	cfg.Defaults.Overrides = map[string]interface{}{"key": "override", "registry": "localhost:5000"}
+	merged, err = MergedConfig(cfg, "myapp", "v1")
+	if err != nil {
+		t.Fatalf("MergedConfig() error = %v", err)
+	}
+	if merged.Values["key"] != "override" || merged.Values["registry"] != "localhost:5000" {
+		t.Errorf("Overrides should win over manifest values, got %v", merged.Values)
+	}
+	if data := NewTemplateData(cfg, "myapp", merged); !strings.Contains(data.Values["image_name"].(string), "myapp") {
+		t.Error("NewTemplateData() should carry the image name")
+	}
+	cfg.Defaults.Overrides = nil
+
+	image := cfg.Images["myapp"]
+	image.Aliases = map[string]string{"latest": "v1"}
+	cfg.Images["myapp"] = image
+	merged, err = MergedConfig(cfg, "myapp", "v1")
+	if err != nil {
+		t.Fatalf("MergedConfig() error = %v", err)
+	}
+	if aliases, _ := merged.Values["aliases"].([]string); len(aliases) != 1 || aliases[0] != "latest" {
+		t.Errorf("aliases = %v, want [latest]", merged.Values["aliases"])
+	}
+
+	if _, err := MergedConfig(cfg, "myapp", "v2"); err != nil {
+		t.Errorf("MergedConfig() should accept nil version config, got %v", err)
+	}
+	if _, err := MergedConfig(cfg, "myapp", "v3"); err == nil {
+		t.Error("MergedConfig() should return error for unknown version")
+	}
+	if _, err := MergedConfig(cfg, "other", "v1"); err == nil {
+		t.Error("MergedConfig() should return error for unknown image")
+	}
+}
+
+func TestMergedConfig_GlobalValues(t *testing.T) {
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Registry: "test.io",
+			Values: map[string]interface{}{
+				"maintainer": "platform@example.com",
+				"labels": map[string]interface{}{
+					"org": "example", "team": "platform", "tier": "base",
+				},
+			},
+		},
+		Images: map[string]config.Image{
+			"myapp": {
+				Defaults: &config.ImageConfig{
+					Values: map[string]interface{}{
+						"labels": map[string]interface{}{"team": "apps", "tier": "image"},
+					},
+				},
+				Versions: map[string]*config.ImageConfig{
+					"v1": {Values: map[string]interface{}{
+						"labels": map[string]interface{}{"tier": "version"},
+					}},
+					"v2": nil,
+				},
+			},
+			"bare": {
+				Versions: map[string]*config.ImageConfig{"v1": nil},
+			},
+		},
+	}
+
+	tests := []struct {
+		image, version string
+		want           map[string]interface{}
+	}{
+		{"bare", "v1", map[string]interface{}{"org": "example", "team": "platform", "tier": "base"}},
+		{"myapp", "v2", map[string]interface{}{"org": "example", "team": "apps", "tier": "image"}},
+		{"myapp", "v1", map[string]interface{}{"org": "example", "team": "apps", "tier": "version"}},
+	}
+	for _, tt := range tests {
+		merged, err := MergedConfig(cfg, tt.image, tt.version)
+		if err != nil {
+			t.Fatalf("MergedConfig(%s, %s) error = %v", tt.image, tt.version, err)
+		}
+		if merged.Values["maintainer"] != "platform@example.com" {
+			t.Errorf("%s:%s maintainer = %v, want the global value", tt.image, tt.version, merged.Values["maintainer"])
+		}
+		if labels := merged.Values["labels"]; !reflect.DeepEqual(labels, tt.want) {
+			t.Errorf("%s:%s labels = %v, want %v", tt.image, tt.version, labels, tt.want)
+		}
+	}
+
+	if tier := cfg.Defaults.Values["labels"].(map[string]interface{})["tier"]; tier != "base" {
+		t.Errorf("merging modified the global values, tier = %v", tier)
+	}
+}
+
+func TestUsesOnBuild(t *testing.T) {
+	tests := map[string]bool{
+		"FROM alpine\nONBUILD COPY . /app\n": true,
+		"FROM alpine\n  onbuild RUN true\n":  true,
+		"FROM alpine\n# ONBUILD RUN true\n":  false,
+		"FROM alpine\nRUN echo ONBUILD\n":    false,
+	}
+	for dockerfile, want := range tests {
+		if got := usesOnBuild([]byte(dockerfile)); got != want {
+			t.Errorf("usesOnBuild(%q) = %v, want %v", dockerfile, got, want)
+		}
+	}
+}
+
 func TestDiscoverTemplateFiles(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -266,7 +1058,7 @@ func TestDiscoverTemplateFiles(t *testing.T) {
 		}
 	}
 
-	templateFiles, err := discoverTemplateFiles(tmpDir)
+	templateFiles, err := discoverTemplateFiles(sourceTree{tmpDir})
 	if err != nil {
 		t.Fatalf("discoverTemplateFiles() error = %v", err)
 	}
@@ -297,7 +1089,7 @@ func TestDiscoverTemplateFiles(t *testing.T) {
 func TestDiscoverTemplateFiles_EmptyDir(t *testing.T) {
 	tmpDir := t.TempDir()
 
-	templateFiles, err := discoverTemplateFiles(tmpDir)
+	templateFiles, err := discoverTemplateFiles(sourceTree{tmpDir})
 	if err != nil {
 		t.Fatalf("discoverTemplateFiles() error = %v", err)
 	}
@@ -308,7 +1100,7 @@ func TestDiscoverTemplateFiles_EmptyDir(t *testing.T) {
 }
 
 func TestDiscoverTemplateFiles_NonexistentDir(t *testing.T) {
-	_, err := discoverTemplateFiles("/nonexistent/directory")
+	_, err := discoverTemplateFiles(sourceTree{"/nonexistent/directory"})
 	if err == nil {
 		t.Error("discoverTemplateFiles() should return error for nonexistent directory")
 	}
@@ -317,14 +1109,10 @@ func TestDiscoverTemplateFiles_NonexistentDir(t *testing.T) {
 func TestCopyNonTemplateFiles(t *testing.T) {
 	tmpDir := t.TempDir()
 	sourceDir := filepath.Join(tmpDir, "source")
-	outputDir := filepath.Join(tmpDir, "output")
 
 	if err := os.MkdirAll(sourceDir, 0755); err != nil {
 		t.Fatalf("Failed to create source directory: %v", err)
 	}
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		t.Fatalf("Failed to create output directory: %v", err)
-	}
 
 	// Create test files
 	files := map[string]string{
@@ -346,8 +1134,9 @@ func TestCopyNonTemplateFiles(t *testing.T) {
 		}
 	}
 
+	copied := make(fileSet)
 	exclude := []string{"template.tmpl"}
-	if err := copyNonTemplateFiles(sourceDir, outputDir, exclude); err != nil {
+	if err := copyNonTemplateFiles(sourceTree{sourceDir}, copied, exclude); err != nil {
 		t.Fatalf("copyNonTemplateFiles() error = %v", err)
 	}
 
@@ -360,51 +1149,59 @@ func TestCopyNonTemplateFiles(t *testing.T) {
 	}
 
 	for _, file := range copiedFiles {
-		destPath := filepath.Join(outputDir, file)
-		if _, err := os.Stat(destPath); os.IsNotExist(err) {
+		planned, exists := copied[file]
+		if !exists {
 			t.Errorf("Expected file %s was not copied", file)
-		} else {
-			// Verify content
-			content, err := os.ReadFile(destPath)
-			if err != nil {
-				t.Errorf("Failed to read copied file %s: %v", file, err)
-			}
-			if string(content) != files[file] {
-				t.Errorf("File %s content mismatch: got %s, want %s", file, content, files[file])
-			}
+		} else if string(planned.content) != files[file] {
+			t.Errorf("File %s content mismatch: got %s, want %s", file, planned.content, files[file])
 		}
 	}
 
 	// Verify excluded file was not copied
-	excludedPath := filepath.Join(outputDir, "template.tmpl")
-	if _, err := os.Stat(excludedPath); err == nil {
+	if _, exists := copied["template.tmpl"]; exists {
 		t.Error("Excluded file template.tmpl should not be copied")
 	}
 }
 
 func TestCopyNonTemplateFiles_EmptyDirs(t *testing.T) {
-	err := copyNonTemplateFiles("", "/tmp/output", nil)
+	err := copyNonTemplateFiles(nil, make(fileSet), nil)
 	if err == nil {
 		t.Error("copyNonTemplateFiles() should return error for empty source dir")
 	}
+}
 
-	err = copyNonTemplateFiles("/tmp/source", "", nil)
-	if err == nil {
-		t.Error("copyNonTemplateFiles() should return error for empty output dir")
+func TestCopyNonTemplateFiles_ExcludesByRelativePath(t *testing.T) {
+	sourceDir := t.TempDir()
+	writeSourceFiles(t, sourceDir, map[string]string{
+		"Dockerfile.tmpl":         "FROM alpine\n",
+		"scripts/Dockerfile.tmpl": "example\n",
+		TestsFile:                 "cases: []\n",
+		"fixtures/" + TestsFile:   "kept\n",
+	})
+
+	copied := make(fileSet)
+	if err := copyNonTemplateFiles(sourceTree{sourceDir}, copied, []string{"Dockerfile.tmpl", TestsFile}); err != nil {
+		t.Fatalf("copyNonTemplateFiles() error = %v", err)
+	}
+
+	var got []string
+	for name := range copied {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	want := []string{"fixtures/" + TestsFile, "scripts/Dockerfile.tmpl"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("copied = %v, want %v", got, want)
 	}
 }
 
 func TestCopyNonTemplateFiles_PreservesPermissions(t *testing.T) {
 	tmpDir := t.TempDir()
 	sourceDir := filepath.Join(tmpDir, "source")
-	outputDir := filepath.Join(tmpDir, "output")
 
 	if err := os.MkdirAll(sourceDir, 0755); err != nil {
 		t.Fatalf("Failed to create source directory: %v", err)
 	}
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		t.Fatalf("Failed to create output directory: %v", err)
-	}
 
 	// Create a file with specific permissions
 	executablePath := filepath.Join(sourceDir, "script.sh")
@@ -412,22 +1209,17 @@ func TestCopyNonTemplateFiles_PreservesPermissions(t *testing.T) {
 		t.Fatalf("Failed to write executable: %v", err)
 	}
 
-	if err := copyNonTemplateFiles(sourceDir, outputDir, nil); err != nil {
+	copied := make(fileSet)
+	if err := copyNonTemplateFiles(sourceTree{sourceDir}, copied, nil); err != nil {
 		t.Fatalf("copyNonTemplateFiles() error = %v", err)
 	}
 
-	destPath := filepath.Join(outputDir, "script.sh")
-	info, err := os.Stat(destPath)
-	if err != nil {
-		t.Fatalf("Failed to stat copied file: %v", err)
-	}
-
-	if info.Mode().Perm() != 0755 {
-		t.Errorf("File permissions not preserved: got %o, want %o", info.Mode().Perm(), 0755)
+	if mode := copied["script.sh"].mode; mode.Perm() != 0755 {
+		t.Errorf("File permissions not preserved: got %o, want %o", mode.Perm(), 0755)
 	}
 }
 
-func TestCleanupOrphanedVersions(t *testing.T) {
+func TestOrphanedVersions(t *testing.T) {
 	tmpDir := t.TempDir()
 	imagePath := filepath.Join(tmpDir, "myapp")
 
@@ -436,78 +1228,53 @@ func TestCleanupOrphanedVersions(t *testing.T) {
 		"source",      // Should be skipped
 		"v1.0",        // Valid version
 		"v2.0",        // Valid version
+		"v2.0-slim",   // Valid variant of v2.0
 		"v3.0-orphan", // Orphaned version
 		"old-version", // Orphaned version
 	}
 
 	for _, dir := range dirs {
-		path := filepath.Join(imagePath, dir)
-		if err := os.MkdirAll(path, 0755); err != nil {
+		if err := os.MkdirAll(filepath.Join(imagePath, dir), 0755); err != nil {
 			t.Fatalf("Failed to create directory: %v", err)
 		}
-		// Create a dummy file to ensure directory is not empty
-		dummyFile := filepath.Join(path, "dummy.txt")
-		if err := os.WriteFile(dummyFile, []byte("test"), 0644); err != nil {
-			t.Fatalf("Failed to write dummy file: %v", err)
-		}
 	}
 
 	// Create a regular file (not a directory)
-	regularFile := filepath.Join(imagePath, "README.md")
-	if err := os.WriteFile(regularFile, []byte("readme"), 0644); err != nil {
+	if err := os.WriteFile(filepath.Join(imagePath, "README.md"), []byte("readme"), 0644); err != nil {
 		t.Fatalf("Failed to write regular file: %v", err)
 	}
 
 	versions := map[string]*config.ImageConfig{
 		"v1.0": {Values: map[string]interface{}{}},
-		"v2.0": {Values: map[string]interface{}{}},
-	}
-
-	if err := cleanupOrphanedVersions(imagePath, versions); err != nil {
-		t.Fatalf("cleanupOrphanedVersions() error = %v", err)
-	}
-
-	// Verify valid versions still exist
-	for version := range versions {
-		path := filepath.Join(imagePath, version)
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			t.Errorf("Valid version directory %s was incorrectly removed", version)
-		}
-	}
-
-	// Verify source directory still exists
-	sourcePath := filepath.Join(imagePath, "source")
-	if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
-		t.Error("Source directory was incorrectly removed")
+		"v2.0": {
+			Variants: map[string]*config.Variant{"slim": {}},
+			Values:   map[string]interface{}{},
+		},
 	}
 
-	// Verify orphaned versions were removed
-	orphanedVersions := []string{"v3.0-orphan", "old-version"}
-	for _, orphan := range orphanedVersions {
-		path := filepath.Join(imagePath, orphan)
-		if _, err := os.Stat(path); err == nil {
-			t.Errorf("Orphaned version directory %s was not removed", orphan)
-		}
+	orphans, err := orphanedVersions(imagePath, config.Image{Versions: versions}.OutputVersions())
+	if err != nil {
+		t.Fatalf("orphanedVersions() error = %v", err)
 	}
 
-	// Verify regular file still exists
-	if _, err := os.Stat(regularFile); os.IsNotExist(err) {
-		t.Error("Regular file was incorrectly removed")
+	want := []string{"old-version", "v3.0-orphan"}
+	if !reflect.DeepEqual(orphans, want) {
+		t.Errorf("orphanedVersions() = %v, want %v", orphans, want)
 	}
 }
 
-func TestCleanupOrphanedVersions_NonexistentDir(t *testing.T) {
+func TestOrphanedVersions_NonexistentDir(t *testing.T) {
 	versions := map[string]*config.ImageConfig{
 		"v1": {},
 	}
 
-	err := cleanupOrphanedVersions("/nonexistent/path", versions)
+	_, err := orphanedVersions("/nonexistent/path", config.Image{Versions: versions}.OutputVersions())
 	if err == nil {
-		t.Error("cleanupOrphanedVersions() should return error for nonexistent directory")
+		t.Error("orphanedVersions() should return error for nonexistent directory")
 	}
 }
 
-func TestCleanupOrphanedVersions_EmptyImagePath(t *testing.T) {
+func TestOrphanedVersions_EmptyImagePath(t *testing.T) {
 	tmpDir := t.TempDir()
 
 	// Create an empty image path
@@ -520,8 +1287,11 @@ func TestCleanupOrphanedVersions_EmptyImagePath(t *testing.T) {
 		"v1": {},
 	}
 
-	// Should not error on empty directory
-	if err := cleanupOrphanedVersions(imagePath, versions); err != nil {
-		t.Fatalf("cleanupOrphanedVersions() error = %v", err)
+	orphans, err := orphanedVersions(imagePath, config.Image{Versions: versions}.OutputVersions())
+	if err != nil {
+		t.Fatalf("orphanedVersions() error = %v", err)
+	}
+	if len(orphans) != 0 {
+		t.Errorf("orphanedVersions() = %v, want none", orphans)
 	}
 }
@@ -0,0 +1,146 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/template"
+)
+
+var (
+	// generationMessageCall matches a template's header call in any form.
+	generationMessageCall = regexp.MustCompile(`\{\{-?\s*generation_message(_for)?\b`)
+	// headerPreambleLine matches first lines that must stay first: syntax
+	// directives, shebangs and XML declarations.
+	headerPreambleLine = regexp.MustCompile(`(?i)^(#\s*syntax\s*=|#!|<\?xml\b)`)
+)
+
+// MissingHeader is a template whose rendered output lacks the generated-file
+// header, so it cannot be told apart from a hand-written file.
+type MissingHeader struct {
+	Image string
+	// Version is the version the template was rendered for.
+	Version string
+	// Template is the template's path relative to the source directory.
+	Template string
+	// Path is the template file on disk.
+	Path string
+}
+
+// MissingHeaders renders the first version of every image and returns the
+// templates whose output lacks the generated-file header, ordered by image
+// and template. Outputs whose file type is configured with no header are
+// not reported.
+func MissingHeaders(ctx context.Context, cfg *config.Config) ([]MissingHeader, error) {
+	imageNames := make([]string, 0, len(cfg.Images))
+	for imageName := range cfg.Images {
+		imageNames = append(imageNames, imageName)
+	}
+	sort.Strings(imageNames)
+
+	marker := strings.TrimPrefix(template.GeneratedMarker, "# ")
+	var missing []MissingHeader
+	for _, imageName := range imageNames {
+		image := cfg.Images[imageName]
+		if len(image.Versions) == 0 {
+			continue
+		}
+		versions := make([]string, 0, len(image.Versions))
+		for version := range image.Versions {
+			versions = append(versions, version)
+		}
+		sort.Strings(versions)
+		version := versions[0]
+
+		imagePath, err := cfg.ImagePath(image)
+		if err != nil {
+			return nil, err
+		}
+		sources, err := imageSources(cfg, image, imagePath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", imageName, err)
+		}
+		if len(sources) == 0 {
+			return nil, fmt.Errorf("%s: source directory %s does not exist", imageName, filepath.Join(imagePath, "source"))
+		}
+		templateFiles, err := discoverTemplateFiles(sources)
+		if err != nil {
+			return nil, fmt.Errorf("%s: discovering template files: %w", imageName, err)
+		}
+		sort.Strings(templateFiles)
+
+		files, err := renderVersion(ctx, cfg, imageName, version, sources, templateFiles, "")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, templateFile := range templateFiles {
+			name := outputName(templateFile)
+			file, rendered := files[name]
+			if !rendered || cfg.Defaults.Headers.For(name) == config.HeaderNone {
+				continue
+			}
+			if !bytes.Contains(file.content, []byte(marker)) {
+				missing = append(missing, MissingHeader{
+					Image:    imageName,
+					Version:  version,
+					Template: filepath.ToSlash(templateFile),
+					Path:     sources.path(templateFile),
+				})
+			}
+		}
+	}
+	return missing, nil
+}
+
+// FixHeader adds a generation_message call to the top of the template at
+// path, after a syntax directive, shebang or XML declaration on its first
+// line. Templates that already call it are left alone. It reports whether
+// the file was changed.
+func FixHeader(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	fixed := insertGenerationMessage(content)
+	if bytes.Equal(fixed, content) {
+		return false, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(path, fixed, info.Mode().Perm()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// insertGenerationMessage returns content with a generation_message call
+// inserted as its first line, or its second when the first must stay first.
+// The rest of content is kept byte for byte.
+func insertGenerationMessage(content []byte) []byte {
+	if generationMessageCall.Match(content) {
+		return content
+	}
+
+	at := 0
+	if firstLine, _, found := bytes.Cut(content, []byte("\n")); headerPreambleLine.Match(firstLine) {
+		if !found {
+			return append(append(append([]byte{}, content...), '\n'), "{{generation_message}}\n"...)
+		}
+		at = len(firstLine) + 1
+	}
+
+	fixed := make([]byte, 0, len(content)+len("{{generation_message}}\n"))
+	fixed = append(fixed, content[:at]...)
+	fixed = append(fixed, "{{generation_message}}\n"...)
+	return append(fixed, content[at:]...)
+}
@@ -0,0 +1,96 @@
+package generator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestFixHeader(t *testing.T) {
+	befores, err := filepath.Glob(filepath.Join("testdata", "fix-headers", "*.before"))
+	if err != nil || len(befores) == 0 {
+		t.Fatalf("no fixtures found: %v", err)
+	}
+
+	for _, before := range befores {
+		name := strings.TrimSuffix(filepath.Base(before), ".before")
+		t.Run(name, func(t *testing.T) {
+			content, err := os.ReadFile(before)
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v", err)
+			}
+			want, err := os.ReadFile(strings.TrimSuffix(before, ".before") + ".after")
+			if err != nil {
+				t.Fatalf("Failed to read fixture: %v", err)
+			}
+
+			path := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				t.Fatalf("Failed to write template: %v", err)
+			}
+
+			changed, err := FixHeader(path)
+			if err != nil {
+				t.Fatalf("FixHeader() error = %v", err)
+			}
+			got, _ := os.ReadFile(path)
+			if string(got) != string(want) {
+				t.Errorf("FixHeader() wrote %q, want %q", got, want)
+			}
+			if changed != (string(content) != string(want)) {
+				t.Errorf("FixHeader() changed = %v", changed)
+			}
+
+			// Fixing again changes nothing.
+			if changed, err := FixHeader(path); err != nil || changed {
+				t.Errorf("second FixHeader() = %v, %v; want no change", changed, err)
+			}
+		})
+	}
+}
+
+func TestMissingHeaders(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeSourceFiles(t, filepath.Join(tmpDir, "app", "source"), map[string]string{
+		"Dockerfile.tmpl":    "{{generation_message}}\nFROM alpine\n",
+		"entrypoint.sh.tmpl": "#!/bin/sh\nexec \"$@\"\n",
+		"config.json.tmpl":   "{}\n",
+		"conf/app.ini.tmpl":  "[app]\n",
+	})
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"app": {Path: "app", Versions: map[string]*config.ImageConfig{
+				"v2": {Values: map[string]interface{}{}},
+				"v1": {Values: map[string]interface{}{}},
+			}},
+		},
+	}
+
+	missing, err := MissingHeaders(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("MissingHeaders() error = %v", err)
+	}
+	var got []string
+	for _, m := range missing {
+		got = append(got, m.Image+"/"+m.Version+":"+m.Template)
+	}
+	want := []string{"app/v1:conf/app.ini.tmpl", "app/v1:entrypoint.sh.tmpl"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MissingHeaders() = %v, want %v", got, want)
+	}
+
+	for _, m := range missing {
+		if _, err := FixHeader(m.Path); err != nil {
+			t.Fatalf("FixHeader() error = %v", err)
+		}
+	}
+	if missing, err := MissingHeaders(context.Background(), cfg); err != nil || len(missing) != 0 {
+		t.Errorf("MissingHeaders() after fixing = %v, %v; want none", missing, err)
+	}
+}
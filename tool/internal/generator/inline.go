@@ -0,0 +1,92 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+)
+
+// inlineFiles serves inline_file for one version: the files the image lists
+// under inline, taken from the rendered templates or read from the source
+// directory. Inlined files are embedded in the Dockerfile and never written.
+type inlineFiles struct {
+	sources   sourceTree
+	names     map[string]bool
+	templates map[string]bool
+	files     fileSet
+	used      map[string]bool
+	// pending is set when inline_file was called since the last takePending.
+	pending bool
+}
+
+func newInlineFiles(sources sourceTree, names, templateFiles []string, files fileSet) *inlineFiles {
+	inline := &inlineFiles{
+		sources:   sources,
+		names:     make(map[string]bool, len(names)),
+		templates: make(map[string]bool, len(templateFiles)),
+		files:     files,
+		used:      make(map[string]bool),
+	}
+	for _, name := range names {
+		inline.names[path.Clean(name)] = true
+	}
+	for _, templateFile := range templateFiles {
+		inline.templates[outputName(templateFile)] = true
+	}
+	return inline
+}
+
+// lookup returns the content of an inlined file and the permissions of its
+// source: the template for rendered files, the file itself otherwise.
+func (f *inlineFiles) lookup(name string) (string, os.FileMode, error) {
+	name = path.Clean(name)
+	if !f.names[name] {
+		return "", 0, fmt.Errorf("inline_file: %s is not listed in the image's inline files", name)
+	}
+	f.used[name] = true
+	f.pending = true
+
+	if f.templates[name] {
+		file, rendered := f.files[name]
+		if !rendered {
+			return "", 0, fmt.Errorf("inline_file: %s has not been rendered yet (reference it with a string literal so it renders first)", name)
+		}
+		info, err := os.Stat(f.sources.path(name + ".tmpl"))
+		if err != nil {
+			return "", 0, fmt.Errorf("inline_file: %w", err)
+		}
+		return string(file.content), info.Mode().Perm(), nil
+	}
+
+	source := f.sources.path(name)
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", 0, fmt.Errorf("inline_file: no generated file %s in this version", name)
+	}
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return "", 0, fmt.Errorf("inline_file: %w", err)
+	}
+	return string(content), info.Mode().Perm(), nil
+}
+
+// takePending reports whether inline_file was called since the last call.
+func (f *inlineFiles) takePending() bool {
+	pending := f.pending
+	f.pending = false
+	return pending
+}
+
+// unused returns the listed files that no template inlined, which would
+// otherwise silently disappear from the output.
+func (f *inlineFiles) unused() []string {
+	var unused []string
+	for name := range f.names {
+		if !f.used[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
@@ -0,0 +1,108 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// inlineTestConfig returns a manifest whose image myapp inlines the named
+// files, rendered with the given Dockerfile syntax.
+func inlineTestConfig(t *testing.T, syntax string, inline []string, files map[string]string) *config.Config {
+	t.Helper()
+	cfg, _ := testImageConfig(t, "myapp", map[string]*config.ImageConfig{"v1": {}}, files)
+	cfg.Defaults.DockerfileSyntax = syntax
+	image := cfg.Images["myapp"]
+	image.Inline = inline
+	cfg.Images["myapp"] = image
+	return cfg
+}
+
+func TestGenerateImage_InlineFile(t *testing.T) {
+	cfg := inlineTestConfig(t, "docker/dockerfile:1", []string{"docker-entrypoint.sh", "motd"}, map[string]string{
+		"Dockerfile.tmpl":           "FROM alpine\n{{inline_file \"docker-entrypoint.sh\"}}\n{{inline_file \"motd\" \"/etc/motd\"}}\n",
+		"docker-entrypoint.sh.tmpl": "#!/bin/sh\nexec \"$@\" # {{version}}\n",
+		"motd":                      "EOF\n",
+		"README.md":                 "kept\n",
+	})
+	sourceDir := filepath.Join(cfg.Defaults.BasePath, "myapp", "source")
+	if err := os.Chmod(filepath.Join(sourceDir, "docker-entrypoint.sh.tmpl"), 0755); err != nil {
+		t.Fatalf("Failed to chmod template: %v", err)
+	}
+
+	if err := GenerateImage(cfg, "myapp"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	versionDir := filepath.Join(cfg.Defaults.BasePath, "myapp", "v1")
+	dockerfile, err := os.ReadFile(filepath.Join(versionDir, "Dockerfile"))
+	if err != nil {
+		t.Fatalf("Failed to read Dockerfile: %v", err)
+	}
+	want := "# syntax=docker/dockerfile:1\nFROM alpine\n" +
+		"COPY <<'EOF' /usr/local/bin/docker-entrypoint.sh\n#!/bin/sh\nexec \"$@\" # v1\nEOF\n" +
+		"RUN chmod 755 /usr/local/bin/docker-entrypoint.sh\n" +
+		"COPY <<'EOF1' /etc/motd\nEOF\nEOF1\n"
+	if string(dockerfile) != want {
+		t.Errorf("Dockerfile =\n%s\nwant\n%s", dockerfile, want)
+	}
+
+	for _, name := range []string{"docker-entrypoint.sh", "motd"} {
+		if _, err := os.Stat(filepath.Join(versionDir, name)); !os.IsNotExist(err) {
+			t.Errorf("inlined %s should not be written to the version directory", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(versionDir, "README.md")); err != nil {
+		t.Errorf("README.md should still be copied: %v", err)
+	}
+}
+
+func TestGenerateImage_InlineFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		syntax  string
+		inline  []string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			name:    "no syntax directive",
+			inline:  []string{"entrypoint.sh"},
+			files:   map[string]string{"Dockerfile.tmpl": "FROM alpine\n{{inline_file \"entrypoint.sh\"}}\n", "entrypoint.sh": "exit 0\n"},
+			wantErr: "needs a syntax directive",
+		},
+		{
+			name:    "not listed",
+			syntax:  "docker/dockerfile:1",
+			files:   map[string]string{"Dockerfile.tmpl": "FROM alpine\n{{inline_file \"entrypoint.sh\"}}\n", "entrypoint.sh": "exit 0\n"},
+			wantErr: "entrypoint.sh is not listed in the image's inline files",
+		},
+		{
+			name:    "listed but unused",
+			syntax:  "docker/dockerfile:1",
+			inline:  []string{"entrypoint.sh"},
+			files:   map[string]string{"Dockerfile.tmpl": "FROM alpine\n", "entrypoint.sh": "exit 0\n"},
+			wantErr: "inline lists entrypoint.sh, which no template embeds with inline_file",
+		},
+		{
+			name:    "missing file",
+			syntax:  "docker/dockerfile:1",
+			inline:  []string{"entrypoint.sh"},
+			files:   map[string]string{"Dockerfile.tmpl": "FROM alpine\n{{inline_file \"entrypoint.sh\"}}\n"},
+			wantErr: "no generated file entrypoint.sh in this version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := inlineTestConfig(t, tt.syntax, tt.inline, tt.files)
+			err := GenerateImage(cfg, "myapp")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("GenerateImage() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/template"
+)
+
+// inputsVersion is mixed into every inputs hash. Bump it when a change to the
+// generator or template helpers alters the output for unchanged inputs, so
+// incremental generation re-renders everything once.
+const inputsVersion = "2"
+
+// sourceDigest hashes everything an image's versions are rendered from apart
+// from their configuration: the source files (names, executable bits and
+// bytes, never mtimes), the vendored shared files and the shared partials.
+// The rest of the mode depends on the umask of whoever cloned the repository,
+// and git does not track it, so it is left out to keep the digest stable
+// across clones.
+func sourceDigest(cfg *config.Config, image config.Image, sources sourceTree) (string, error) {
+	files := make(fileSet)
+	if err := copyNonTemplateFiles(sources, files, []string{TestsFile}); err != nil {
+		return "", fmt.Errorf("reading source files: %w", err)
+	}
+	if err := vendorFiles(cfg.Defaults.BasePath, image.Vendor, files); err != nil {
+		return "", fmt.Errorf("vendoring shared files: %w", err)
+	}
+	if err := partialFiles(cfg.PartialsDir(), files); err != nil {
+		return "", fmt.Errorf("reading partials: %w", err)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		file := files[name]
+		writeField(h, []byte(name))
+		writeField(h, []byte(executableField(file.mode)))
+		writeField(h, file.content)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// executableField describes the only mode bit git tracks: whether the file
+// is executable by its owner.
+func executableField(mode os.FileMode) string {
+	if mode&0o100 != 0 {
+		return "executable"
+	}
+	return "regular"
+}
+
+// partialFiles adds the files of the partials directory, if there is one, to
+// files under "<partials>/", keeping them apart from the source files.
+func partialFiles(dir string, files fileSet) error {
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	partials := make(fileSet)
+	if err := copyNonTemplateFiles(sourceTree{dir}, partials, nil); err != nil {
+		return err
+	}
+	for name, file := range partials {
+		files["<partials>/"+name] = file
+	}
+	return nil
+}
+
+// inputsHash returns the hash recorded in the generation header of one
+// version or variant output. It covers the merged configuration, the
+// manifest-wide rendering settings, the image's source digest and
+// inputsVersion.
+func inputsHash(cfg *config.Config, imageName, versionName, sources string) (string, error) {
+	mergedConfig, err := MergedConfig(cfg, imageName, versionName)
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := json.Marshal(struct {
+		BaseImage        *config.BaseImage
+		Values           map[string]interface{}
+		Reproducibility  config.ReproducibilityMode
+		DockerfileSyntax string
+		Inline           []string            `json:",omitempty"`
+		Headers          config.HeaderStyles `json:",omitempty"`
+		ValuesSnapshot   bool                `json:",omitempty"`
+		LenientTemplates bool                `json:",omitempty"`
+	}{mergedConfig.BaseImage, mergedConfig.Values, cfg.Defaults.Reproducibility(), cfg.Defaults.DockerfileSyntax, cfg.Images[imageName].Inline, cfg.Defaults.Headers, cfg.Defaults.EmitValuesSnapshot, !cfg.Defaults.StrictTemplating()})
+	if err != nil {
+		return "", fmt.Errorf("encoding configuration of %s: %w", versionName, err)
+	}
+
+	h := sha256.New()
+	for _, field := range []string{inputsVersion, imageName, versionName, sources} {
+		writeField(h, []byte(field))
+	}
+	writeField(h, encoded)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeField writes a length-prefixed field so that adjacent fields cannot
+// run into each other.
+func writeField(h hash.Hash, field []byte) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(field)))
+	_, _ = h.Write(size[:])
+	_, _ = h.Write(field)
+}
+
+// upToDate reports whether every template output of a version exists and the
+// ones carrying a generation header record hash as their inputs hash.
+func upToDate(versionDir string, templateFiles []string, hash string) bool {
+	headers := 0
+	for _, templateFile := range templateFiles {
+		content, err := os.ReadFile(filepath.Join(versionDir, filepath.FromSlash(outputName(templateFile))))
+		if err != nil {
+			return false
+		}
+		recorded, found := recordedInputsHash(content)
+		if !found {
+			continue
+		}
+		if recorded != hash {
+			return false
+		}
+		headers++
+	}
+	return headers > 0
+}
+
+// recordedInputsHash returns the inputs hash from a generated file's header,
+// in whichever comment style the header was written.
+func recordedInputsHash(content []byte) (string, bool) {
+	marker := strings.TrimPrefix(template.InputsMarker, "# ")
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		for _, leader := range []string{"#", "//", "*"} {
+			if trimmed, found := strings.CutPrefix(line, leader); found {
+				line = strings.TrimSpace(trimmed)
+				break
+			}
+		}
+		if hash, found := strings.CutPrefix(line, marker); found {
+			return strings.TrimSpace(hash), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,178 @@
+package generator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/template"
+)
+
+var inputsSources = map[string]string{
+	"Dockerfile.tmpl": "{{generation_message}}\nFROM python:{{patch}}\n",
+	"entrypoint.sh":   "#!/bin/sh\n",
+}
+
+func TestInputsHash(t *testing.T) {
+	cfg, imageDir := testImageConfig(t, "python", map[string]*config.ImageConfig{
+		"3.12": {Values: map[string]interface{}{"patch": "3.12.1"}},
+		"3.13": {Values: map[string]interface{}{"patch": "3.13.0"}},
+	}, inputsSources)
+	sourceDir := filepath.Join(imageDir, "source")
+	image := cfg.Images["python"]
+
+	hash := func() string {
+		t.Helper()
+		sources, err := sourceDigest(cfg, image, sourceTree{sourceDir})
+		if err != nil {
+			t.Fatalf("sourceDigest() error = %v", err)
+		}
+		h, err := inputsHash(cfg, "python", "3.12", sources)
+		if err != nil {
+			t.Fatalf("inputsHash() error = %v", err)
+		}
+		return h
+	}
+
+	original := hash()
+
+	// Modification times are not inputs.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(sourceDir, "entrypoint.sh"), future, future); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+	if got := hash(); got != original {
+		t.Errorf("inputs hash changed with mtime: %s -> %s", original, got)
+	}
+
+	// Another version's configuration is not an input.
+	image.Versions["3.13"].Values["patch"] = "3.13.1"
+	if got := hash(); got != original {
+		t.Errorf("inputs hash changed with another version's config: %s -> %s", original, got)
+	}
+
+	image.Versions["3.12"].Values["patch"] = "3.12.2"
+	changedConfig := hash()
+	if changedConfig == original {
+		t.Error("inputs hash should change with the version's config")
+	}
+
+	if err := os.Chmod(filepath.Join(sourceDir, "entrypoint.sh"), 0755); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	changedMode := hash()
+	if changedMode == changedConfig {
+		t.Error("inputs hash should change with a source file mode")
+	}
+
+	if err := os.WriteFile(filepath.Join(sourceDir, "entrypoint.sh"), []byte("#!/bin/bash\n"), 0755); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	changedContent := hash()
+	if changedContent == changedMode {
+		t.Error("inputs hash should change with source file content")
+	}
+
+	writeSourceFiles(t, cfg.PartialsDir(), map[string]string{"nonroot-user.tmpl": "USER app\n"})
+	if got := hash(); got == changedContent {
+		t.Error("inputs hash should change with a shared partial")
+	}
+}
+
+func TestSourceDigest_Umask(t *testing.T) {
+	cfg, imageDir := testImageConfig(t, "python", map[string]*config.ImageConfig{
+		"3.12": {Values: map[string]interface{}{"patch": "3.12.1"}},
+	}, inputsSources)
+	sourceDir := filepath.Join(imageDir, "source")
+	entrypoint := filepath.Join(sourceDir, "entrypoint.sh")
+
+	digest := func(mode os.FileMode) string {
+		t.Helper()
+		if err := os.Chmod(entrypoint, mode); err != nil {
+			t.Fatalf("Chmod() error = %v", err)
+		}
+		sources, err := sourceDigest(cfg, cfg.Images["python"], sourceTree{sourceDir})
+		if err != nil {
+			t.Fatalf("sourceDigest() error = %v", err)
+		}
+		return sources
+	}
+
+	// Clones made with umask 022 and 002 must agree; only the executable
+	// bit, which git tracks, is an input.
+	if digest(0644) != digest(0664) {
+		t.Error("source digest should not depend on the group write bit")
+	}
+	if digest(0755) != digest(0775) {
+		t.Error("source digest should not depend on the group write bit of executables")
+	}
+	if digest(0644) == digest(0755) {
+		t.Error("source digest should change with the executable bit")
+	}
+}
+
+func TestGenerateImage_Incremental(t *testing.T) {
+	cfg, imageDir := testImageConfig(t, "python", map[string]*config.ImageConfig{
+		"3.12": {Values: map[string]interface{}{"patch": "3.12.1"}},
+	}, inputsSources)
+	if err := GenerateImage(cfg, "python"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	dockerfile := filepath.Join(imageDir, "3.12", "Dockerfile")
+	content, err := os.ReadFile(dockerfile)
+	if err != nil {
+		t.Fatalf("Failed to read Dockerfile: %v", err)
+	}
+	if _, found := recordedInputsHash(content); !found {
+		t.Fatalf("generated Dockerfile has no %s line:\n%s", template.InputsMarker, content)
+	}
+
+	// A hand edit below the header is kept while the inputs are unchanged.
+	edited := strings.Replace(string(content), "FROM python:3.12.1", "FROM python:edited", 1)
+	if err := os.WriteFile(dockerfile, []byte(edited), 0644); err != nil {
+		t.Fatalf("Failed to edit Dockerfile: %v", err)
+	}
+
+	plan, err := PlanImageContext(context.Background(), cfg, "python", Options{Prune: true, Incremental: true})
+	if err != nil {
+		t.Fatalf("PlanImage() error = %v", err)
+	}
+	if !plan.Empty() {
+		t.Errorf("incremental plan with unchanged inputs = %v, want no actions", actionSummary(plan))
+	}
+
+	cfg.Images["python"].Versions["3.12"].Values["patch"] = "3.12.2"
+	if err := GenerateImage(cfg, "python"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+	content, err = os.ReadFile(dockerfile)
+	if err != nil || !strings.Contains(string(content), "FROM python:3.12.2\n") {
+		t.Errorf("changed version should be re-rendered, got %q, %v", content, err)
+	}
+
+	plan, err = PlanImage(cfg, "python")
+	if err != nil {
+		t.Fatalf("PlanImage() error = %v", err)
+	}
+	if !plan.Empty() {
+		t.Errorf("full plan after incremental run = %v, want no actions", actionSummary(plan))
+	}
+}
+
+func TestRecordedInputsHash_CommentStyles(t *testing.T) {
+	for _, content := range []string{
+		"# GENERATED FILE, DO NOT MODIFY!\n#\n# inputs-sha256: abc\nFROM alpine\n",
+		"// GENERATED FILE, DO NOT MODIFY!\n//\n// inputs-sha256: abc\n",
+		"/*\n * GENERATED FILE, DO NOT MODIFY!\n *\n * inputs-sha256: abc\n */\n",
+		"<!--\n  GENERATED FILE, DO NOT MODIFY!\n\n  inputs-sha256: abc\n-->\n",
+	} {
+		if hash, found := recordedInputsHash([]byte(content)); !found || hash != "abc" {
+			t.Errorf("recordedInputsHash(%q) = %q, %v; want abc", content, hash, found)
+		}
+	}
+}
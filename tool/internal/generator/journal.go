@@ -0,0 +1,146 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// journalDir returns the directory progress journals are kept in.
+var journalDir = func() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "dockerfiles", "journal"), nil
+}
+
+// Journal records the versions a manifest's generation has completed, so
+// that a run that was interrupted can be resumed with Options.Resume. It is
+// kept in the user cache directory, never in the repository, and starts
+// afresh whenever the manifest or the tool version changes. A Journal may be
+// shared by concurrently generated images.
+type Journal struct {
+	path  string
+	mu    sync.Mutex
+	state journalState
+}
+
+type journalState struct {
+	Manifest string `json:"manifest"`
+	Tool     string `json:"tool"`
+	// Completed maps image/version to the inputs hash it was generated with.
+	Completed map[string]string `json:"completed"`
+}
+
+// OpenJournal returns the journal of cfg's manifest, discarding a recorded
+// one that belongs to a different manifest hash or tool version.
+func OpenJournal(cfg *config.Config) (*Journal, error) {
+	dir, err := journalDir()
+	if err != nil {
+		return nil, fmt.Errorf("locating journal: %w", err)
+	}
+	manifest, err := manifestHash(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(cfg.Defaults.BasePath))
+	j := &Journal{
+		path: filepath.Join(dir, hex.EncodeToString(sum[:])+".json"),
+		state: journalState{
+			Manifest:  manifest,
+			Tool:      config.ToolVersion() + "+" + inputsVersion,
+			Completed: make(map[string]string),
+		},
+	}
+
+	content, err := os.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading journal: %w", err)
+	}
+	var recorded journalState
+	if err := json.Unmarshal(content, &recorded); err == nil && recorded.Manifest == j.state.Manifest && recorded.Tool == j.state.Tool && recorded.Completed != nil {
+		j.state.Completed = recorded.Completed
+	}
+	return j, nil
+}
+
+// manifestHash hashes the whole manifest: its settings and the configuration
+// every output is rendered with, but not the image source files, which the
+// per-version inputs hashes cover.
+func manifestHash(cfg *config.Config) (string, error) {
+	encoded, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("encoding manifest: %w", err)
+	}
+
+	h := sha256.New()
+	writeField(h, encoded)
+	imageNames := make([]string, 0, len(cfg.Images))
+	for imageName := range cfg.Images {
+		imageNames = append(imageNames, imageName)
+	}
+	sort.Strings(imageNames)
+	for _, imageName := range imageNames {
+		for _, output := range cfg.Images[imageName].OutputVersions() {
+			hash, err := inputsHash(cfg, imageName, output.Name, "")
+			if err != nil {
+				return "", err
+			}
+			writeField(h, []byte(hash))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// completed reports whether the output was generated with inputs hash.
+func (j *Journal) completed(imageName, output, hash string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state.Completed[path.Join(imageName, output)] == hash
+}
+
+// record marks the outputs of an image, by name, as generated with the given
+// inputs hashes and saves the journal.
+func (j *Journal) record(imageName string, inputs map[string]string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for output, hash := range inputs {
+		j.state.Completed[path.Join(imageName, output)] = hash
+	}
+
+	encoded, err := json.Marshal(j.state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), ".journal-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(encoded); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), j.path)
+}
@@ -0,0 +1,84 @@
+package generator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// stubJournalDir keeps journals in a temporary directory for the test.
+func stubJournalDir(t *testing.T) {
+	t.Helper()
+	cacheDir := t.TempDir()
+	oldJournalDir := journalDir
+	journalDir = func() (string, error) { return cacheDir, nil }
+	t.Cleanup(func() { journalDir = oldJournalDir })
+}
+
+// generateWithJournal generates every image recording progress in a freshly
+// opened journal, as a new run would.
+func generateWithJournal(t *testing.T, cfg *config.Config, resume bool) {
+	t.Helper()
+	journal, err := OpenJournal(cfg)
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+	opts := DefaultOptions(cfg)
+	opts.Journal = journal
+	opts.Resume = resume
+	if _, err := GenerateAllContext(context.Background(), cfg, opts); err != nil {
+		t.Fatalf("GenerateAllContext() error = %v", err)
+	}
+}
+
+func TestJournal_Resume(t *testing.T) {
+	stubJournalDir(t)
+	cfg, _ := testImageConfig(t, "app", map[string]*config.ImageConfig{"v1": {}, "v2": {}}, map[string]string{
+		"Dockerfile.tmpl": "FROM alpine:{{version}}\n",
+	})
+	generateWithJournal(t, cfg, false)
+
+	// Completed versions are skipped on resume, so a hand-edited file stays.
+	edited := filepath.Join(cfg.Defaults.BasePath, "app", "v1", "Dockerfile")
+	if err := os.WriteFile(edited, []byte("edited\n"), 0644); err != nil {
+		t.Fatalf("Failed to edit Dockerfile: %v", err)
+	}
+	generateWithJournal(t, cfg, true)
+	if content, _ := os.ReadFile(edited); string(content) != "edited\n" {
+		t.Errorf("v1/Dockerfile = %q, want the completed version skipped", content)
+	}
+
+	// Without --resume everything is generated as usual.
+	generateWithJournal(t, cfg, false)
+	if content, _ := os.ReadFile(edited); string(content) == "edited\n" {
+		t.Error("v1/Dockerfile should be regenerated without resume")
+	}
+}
+
+func TestJournal_InvalidatedByManifestChange(t *testing.T) {
+	stubJournalDir(t)
+	cfg, _ := testImageConfig(t, "app", map[string]*config.ImageConfig{"v1": {}, "v2": {}}, map[string]string{
+		"Dockerfile.tmpl": "FROM alpine:{{version}}\n",
+	})
+	generateWithJournal(t, cfg, false)
+
+	journal, err := OpenJournal(cfg)
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+	if len(journal.state.Completed) != 2 {
+		t.Fatalf("Completed = %v, want both versions", journal.state.Completed)
+	}
+
+	cfg.Images["app"].Versions["v1"].Values["extra"] = "changed"
+	journal, err = OpenJournal(cfg)
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+	if len(journal.state.Completed) != 0 {
+		t.Errorf("Completed = %v after a manifest change, want it discarded", journal.state.Completed)
+	}
+}
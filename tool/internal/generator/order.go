@@ -0,0 +1,130 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// outputReferencePattern matches output_sha256 and inline_file calls with a
+// literal file name, which is how a template declares that it must render
+// after that file.
+var outputReferencePattern = regexp.MustCompile(`(?:output_sha256|inline_file)\s+"([^"]+)"`)
+
+// outputName returns the version-relative, slash-separated name a template
+// renders to, as referenced by output_sha256.
+func outputName(templateFile string) string {
+	return filepath.ToSlash(strings.TrimSuffix(templateFile, ".tmpl"))
+}
+
+// renderOrder sorts templateFiles so that every template renders after the
+// outputs it references with output_sha256 or inline_file, and reports
+// reference cycles.
+func renderOrder(sources sourceTree, templateFiles []string) ([]string, error) {
+	byOutput := make(map[string]string, len(templateFiles))
+	for _, templateFile := range templateFiles {
+		byOutput[outputName(templateFile)] = templateFile
+	}
+
+	dependencies := make(map[string][]string, len(templateFiles))
+	for _, templateFile := range templateFiles {
+		content, err := os.ReadFile(sources.path(templateFile))
+		if err != nil {
+			return nil, fmt.Errorf("reading template file %s: %w", templateFile, err)
+		}
+		for _, match := range outputReferencePattern.FindAllStringSubmatch(string(content), -1) {
+			if dependency, exists := byOutput[path.Clean(match[1])]; exists {
+				dependencies[templateFile] = append(dependencies[templateFile], dependency)
+			}
+		}
+	}
+
+	var order []string
+	visited := make(map[string]bool)
+	var stack []string
+	onStack := make(map[string]bool)
+
+	var visit func(string) error
+	visit = func(templateFile string) error {
+		if visited[templateFile] {
+			return nil
+		}
+		if onStack[templateFile] {
+			cycle := []string{outputName(templateFile)}
+			for i := len(stack) - 1; stack[i] != templateFile; i-- {
+				cycle = append(cycle, outputName(stack[i]))
+			}
+			cycle = append(cycle, outputName(templateFile))
+			return fmt.Errorf("reference cycle between generated files: %s", strings.Join(cycle, " -> "))
+		}
+
+		onStack[templateFile] = true
+		stack = append(stack, templateFile)
+		for _, dependency := range dependencies[templateFile] {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		onStack[templateFile] = false
+
+		visited[templateFile] = true
+		order = append(order, templateFile)
+		return nil
+	}
+
+	for _, templateFile := range templateFiles {
+		if err := visit(templateFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// outputDigests tracks the SHA-256 of files generated into one version
+// directory, for output_sha256.
+type outputDigests struct {
+	sources   sourceTree
+	templates map[string]bool
+	digests   map[string]string
+}
+
+func newOutputDigests(sources sourceTree, templateFiles []string) *outputDigests {
+	templates := make(map[string]bool, len(templateFiles))
+	for _, templateFile := range templateFiles {
+		templates[outputName(templateFile)] = true
+	}
+	return &outputDigests{sources: sources, templates: templates, digests: make(map[string]string)}
+}
+
+// record stores the digest of the bytes written for a rendered output.
+func (o *outputDigests) record(name string, content []byte) {
+	sum := sha256.Sum256(content)
+	o.digests[name] = hex.EncodeToString(sum[:])
+}
+
+// lookup returns the digest of a rendered template output, or of a source
+// file that is copied verbatim into the version directory.
+func (o *outputDigests) lookup(name string) (string, error) {
+	name = path.Clean(name)
+	if digest, exists := o.digests[name]; exists {
+		return digest, nil
+	}
+	if o.templates[name] {
+		return "", fmt.Errorf("output_sha256: %s has not been rendered yet (reference it with a string literal so it renders first)", name)
+	}
+
+	if name != TestsFile && !strings.HasSuffix(name, ".tmpl") && name != ".." && !strings.HasPrefix(name, "../") {
+		if content, err := os.ReadFile(o.sources.path(name)); err == nil {
+			sum := sha256.Sum256(content)
+			return hex.EncodeToString(sum[:]), nil
+		}
+	}
+	return "", fmt.Errorf("output_sha256: no generated file %s in this version", name)
+}
@@ -0,0 +1,161 @@
+package generator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func writeSourceFiles(t *testing.T, sourceDir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(sourceDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+// testImageConfig returns a manifest with the single image name, kept under
+// a temporary base path with files in its source directory, and the image
+// directory. Versions without values get an empty map.
+func testImageConfig(t *testing.T, name string, versions map[string]*config.ImageConfig, files map[string]string) (*config.Config, string) {
+	t.Helper()
+	tmpDir := t.TempDir()
+
+	for _, version := range versions {
+		if version.Values == nil {
+			version.Values = map[string]interface{}{}
+		}
+	}
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			name: {Path: name, Versions: versions},
+		},
+	}
+
+	imageDir := filepath.Join(tmpDir, name)
+	writeSourceFiles(t, filepath.Join(imageDir, "source"), files)
+	return cfg, imageDir
+}
+
+func TestRenderOrder(t *testing.T) {
+	sourceDir := t.TempDir()
+	writeSourceFiles(t, sourceDir, map[string]string{
+		"Dockerfile.tmpl":          `LABEL sum={{output_sha256 "entrypoint.sh"}} conf={{ output_sha256 "conf/app.ini" }}`,
+		"conf/app.ini.tmpl":        "[app]\n",
+		"entrypoint.sh.tmpl":       `# config {{output_sha256 "conf/app.ini"}}`,
+		"healthcheck.sh.tmpl":      "exit 0\n",
+		"static-reference.sh.tmpl": `{{output_sha256 "static.txt"}}`,
+	})
+
+	templateFiles, err := discoverTemplateFiles(sourceTree{sourceDir})
+	if err != nil {
+		t.Fatalf("discoverTemplateFiles() error = %v", err)
+	}
+
+	order, err := renderOrder(sourceTree{sourceDir}, templateFiles)
+	if err != nil {
+		t.Fatalf("renderOrder() error = %v", err)
+	}
+
+	want := []string{
+		"conf/app.ini.tmpl",
+		"entrypoint.sh.tmpl",
+		"Dockerfile.tmpl",
+		"healthcheck.sh.tmpl",
+		"static-reference.sh.tmpl",
+	}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("renderOrder() = %v, want %v", order, want)
+	}
+}
+
+func TestRenderOrder_Cycle(t *testing.T) {
+	sourceDir := t.TempDir()
+	writeSourceFiles(t, sourceDir, map[string]string{
+		"a.sh.tmpl":       `{{output_sha256 "b.sh"}}`,
+		"b.sh.tmpl":       `{{output_sha256 "c.sh"}}`,
+		"c.sh.tmpl":       `{{output_sha256 "a.sh"}}`,
+		"Dockerfile.tmpl": "FROM alpine\n",
+	})
+
+	_, err := renderOrder(sourceTree{sourceDir}, []string{"Dockerfile.tmpl", "a.sh.tmpl", "b.sh.tmpl", "c.sh.tmpl"})
+	if err == nil || !strings.Contains(err.Error(), "cycle between generated files: a.sh -> c.sh -> b.sh -> a.sh") {
+		t.Errorf("renderOrder() error = %v, want a cycle report", err)
+	}
+}
+
+func TestGenerateImage_OutputSHA256(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"myapp": {
+				Path:     "myapp",
+				Versions: map[string]*config.ImageConfig{"v1": {Values: map[string]interface{}{}}},
+			},
+		},
+	}
+
+	sourceDir := filepath.Join(tmpDir, "myapp", "source")
+	writeSourceFiles(t, sourceDir, map[string]string{
+		"Dockerfile.tmpl":    "COPY entrypoint.sh /\nLABEL entrypoint.sh.sha256={{output_sha256 \"entrypoint.sh\"}}\nLABEL motd.sha256={{output_sha256 \"motd\"}}\n",
+		"entrypoint.sh.tmpl": "#!/bin/sh\necho {{version}}\n",
+		"motd":               "welcome\n",
+	})
+
+	if err := GenerateImage(cfg, "myapp"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	versionDir := filepath.Join(tmpDir, "myapp", "v1")
+	dockerfile, err := os.ReadFile(filepath.Join(versionDir, "Dockerfile"))
+	if err != nil {
+		t.Fatalf("Failed to read Dockerfile: %v", err)
+	}
+	for _, name := range []string{"entrypoint.sh", "motd"} {
+		written, err := os.ReadFile(filepath.Join(versionDir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		sum := sha256.Sum256(written)
+		want := name + ".sha256=" + hex.EncodeToString(sum[:])
+		if !strings.Contains(string(dockerfile), want) {
+			t.Errorf("Dockerfile should contain %s, got:\n%s", want, dockerfile)
+		}
+	}
+}
+
+func TestGenerateImage_OutputSHA256Unknown(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"myapp": {
+				Path:     "myapp",
+				Versions: map[string]*config.ImageConfig{"v1": {Values: map[string]interface{}{}}},
+			},
+		},
+	}
+	writeSourceFiles(t, filepath.Join(tmpDir, "myapp", "source"), map[string]string{
+		"Dockerfile.tmpl": `LABEL sum={{output_sha256 "missing.sh"}}`,
+	})
+
+	err := GenerateImage(cfg, "myapp")
+	if err == nil || !strings.Contains(err.Error(), "no generated file missing.sh") {
+		t.Errorf("GenerateImage() error = %v, want unknown output error", err)
+	}
+}
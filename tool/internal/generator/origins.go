@@ -0,0 +1,71 @@
+package generator
+
+import (
+	"fmt"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// Layers a merged value can come from, as reported by ValueOrigins.
+const (
+	OriginGlobalDefaults = "global defaults"
+	OriginImageDefaults  = "image defaults"
+	OriginVersion        = "version"
+	OriginVariant        = "variant"
+	OriginOverride       = "override"
+	OriginGenerated      = "generated"
+)
+
+// ValueOrigins returns, for every top-level key of the template data a
+// version is rendered with, the highest-precedence layer that set it, in the
+// order MergedConfig merges them. Nested maps merged from several layers are
+// attributed to the last one.
+func ValueOrigins(cfg *config.Config, imageName, versionName string) (map[string]string, error) {
+	image, exists := cfg.Images[imageName]
+	if !exists {
+		return nil, fmt.Errorf("image %s not found in config", imageName)
+	}
+	output, exists := image.OutputVersion(versionName)
+	if !exists {
+		return nil, fmt.Errorf("version %s not found for image %s", versionName, imageName)
+	}
+	versionConfig := image.Versions[output.Version]
+
+	origins := make(map[string]string)
+	set := func(origin string, values map[string]interface{}) {
+		for key := range values {
+			origins[key] = origin
+		}
+	}
+
+	set(OriginGlobalDefaults, cfg.Defaults.Values)
+	if image.Defaults != nil {
+		set(OriginImageDefaults, image.Defaults.Values)
+		if image.Defaults.BaseImage != nil {
+			origins["base_image"] = OriginImageDefaults
+		}
+	}
+	if versionConfig != nil {
+		set(OriginVersion, versionConfig.Values)
+		if versionConfig.BaseImage != nil {
+			origins["base_image"] = OriginVersion
+		}
+	}
+	origins["version"] = OriginGenerated
+	if output.Variant != "" {
+		if variant := image.VersionVariants(output.Version)[output.Variant]; variant != nil {
+			set(OriginVariant, variant.Values)
+		}
+		origins["variant"] = OriginGenerated
+	}
+	if len(image.VersionAliases(output.Name)) > 0 {
+		origins["aliases"] = OriginGenerated
+	}
+	set(OriginOverride, cfg.Defaults.Overrides)
+
+	if _, hasRegistry := origins["registry"]; !hasRegistry {
+		origins["registry"] = OriginGenerated
+	}
+	origins["image_name"] = OriginGenerated
+	return origins, nil
+}
@@ -0,0 +1,72 @@
+package generator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestValueOrigins(t *testing.T) {
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Registry: "test.io",
+			Values:   map[string]interface{}{"maintainer": "platform", "labels": map[string]interface{}{"org": "example"}},
+		},
+		Images: map[string]config.Image{
+			"python": {
+				Aliases: map[string]string{"latest": "3.13"},
+				Defaults: &config.ImageConfig{
+					BaseImage: &config.BaseImage{Name: "core:noble"},
+					Values:    map[string]interface{}{"labels": map[string]interface{}{"team": "lang"}, "pip": "25"},
+					Variants:  map[string]*config.Variant{"slim": {Values: map[string]interface{}{"flavor": "slim"}}},
+				},
+				Versions: map[string]*config.ImageConfig{
+					"3.13": {Values: map[string]interface{}{"pip": "25.2", "registry": "ghcr.io/example"}},
+				},
+			},
+		},
+	}
+
+	origins, err := ValueOrigins(cfg, "python", "3.13-slim")
+	if err != nil {
+		t.Fatalf("ValueOrigins() error = %v", err)
+	}
+	want := map[string]string{
+		"maintainer": OriginGlobalDefaults,
+		"labels":     OriginImageDefaults,
+		"base_image": OriginImageDefaults,
+		"pip":        OriginVersion,
+		"registry":   OriginVersion,
+		"flavor":     OriginVariant,
+		"version":    OriginGenerated,
+		"variant":    OriginGenerated,
+		"image_name": OriginGenerated,
+	}
+	if !reflect.DeepEqual(origins, want) {
+		t.Errorf("ValueOrigins() = %v, want %v", origins, want)
+	}
+
+	// Every key templates see has an origin.
+	merged, err := MergedConfig(cfg, "python", "3.13")
+	if err != nil {
+		t.Fatalf("MergedConfig() error = %v", err)
+	}
+	cfg.Defaults.Overrides = map[string]interface{}{"pip": "26"}
+	origins, err = ValueOrigins(cfg, "python", "3.13")
+	if err != nil {
+		t.Fatalf("ValueOrigins() error = %v", err)
+	}
+	for key := range NewTemplateData(cfg, "python", merged).Values {
+		if origins[key] == "" {
+			t.Errorf("%s has no origin", key)
+		}
+	}
+	if origins["pip"] != OriginOverride || origins["aliases"] != OriginGenerated {
+		t.Errorf("pip = %s, aliases = %s, want override and generated", origins["pip"], origins["aliases"])
+	}
+
+	if _, err := ValueOrigins(cfg, "python", "2.7"); err == nil {
+		t.Error("ValueOrigins() should return error for unknown version")
+	}
+}
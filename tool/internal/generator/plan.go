@@ -0,0 +1,589 @@
+package generator
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/apex/log"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// ActionType is the kind of filesystem change a plan action makes.
+type ActionType string
+
+const (
+	CreateFile ActionType = "create_file"
+	UpdateFile ActionType = "update_file"
+	DeleteFile ActionType = "delete_file"
+	DeleteDir  ActionType = "delete_dir"
+)
+
+// Action is one planned filesystem change. Path is slash-separated and
+// relative to the image directory, e.g. "3.12/Dockerfile".
+type Action struct {
+	Type    ActionType  `json:"type"`
+	Path    string      `json:"path"`
+	Mode    fs.FileMode `json:"mode,omitempty"`
+	OldHash string      `json:"old_sha256,omitempty"`
+	NewHash string      `json:"new_sha256,omitempty"`
+	Diff    string      `json:"diff,omitempty"`
+
+	content []byte
+}
+
+// Plan lists the changes generating an image would make to its directory,
+// sorted by path so that its JSON form is stable.
+type Plan struct {
+	Image   string   `json:"image"`
+	Dir     string   `json:"dir"`
+	Actions []Action `json:"actions"`
+	// Orphans are orphaned version directories left in place because
+	// pruning was disabled.
+	Orphans []string `json:"orphans,omitempty"`
+
+	// inputs maps the planned outputs to their inputs hashes.
+	inputs map[string]string
+}
+
+// Empty reports whether the image directory is already up to date.
+func (p *Plan) Empty() bool {
+	return len(p.Actions) == 0
+}
+
+// plannedFile is the desired content and mode of one generated file.
+type plannedFile struct {
+	content []byte
+	mode    fs.FileMode
+}
+
+// fileSet maps slash-separated paths relative to a version directory to the
+// files generated there.
+type fileSet map[string]plannedFile
+
+// maxDiffLines bounds the inputs to the line diff; larger updates are
+// reported by hash only.
+const maxDiffLines = 2000
+
+// PlanImage returns the changes GenerateImage would make, without touching
+// disk.
+func PlanImage(cfg *config.Config, imageName string) (*Plan, error) {
+	return PlanImageContext(context.Background(), cfg, imageName, DefaultOptions(cfg))
+}
+
+// PlanImageContext renders every output of an image in memory and compares the
+// result with the image directory, without writing anything.
+func PlanImageContext(ctx context.Context, cfg *config.Config, imageName string, opts Options) (*Plan, error) {
+	image, exists := cfg.Images[imageName]
+	if !exists {
+		return nil, fmt.Errorf("image %s not found in config", imageName)
+	}
+
+	imagePath, err := cfg.ImagePath(image)
+	if err != nil {
+		return nil, err
+	}
+
+	// A disabled image keeps its directory, whose source may be gone.
+	if !image.IsEnabled() {
+		log.Debugf("%s: skipping disabled image", imageName)
+		return &Plan{Image: imageName, Dir: imagePath, Actions: []Action{}, inputs: make(map[string]string)}, nil
+	}
+
+	sources, err := imageSources(cfg, image, imagePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("source directory %s does not exist", filepath.Join(imagePath, "source"))
+	}
+
+	templateFiles, err := discoverTemplateFiles(sources)
+	if err != nil {
+		return nil, fmt.Errorf("discovering template files: %w", err)
+	}
+
+	digest, err := sourceDigest(cfg, image, sources)
+	if err != nil {
+		return nil, attributeError(err, imageName, "", "hashing inputs")
+	}
+
+	plan := &Plan{Image: imageName, Dir: imagePath, Actions: []Action{}, inputs: make(map[string]string)}
+
+	outputs := image.OutputVersions()
+	selected, err := selectOutputs(imageName, outputs, opts.Version)
+	if err != nil {
+		return nil, err
+	}
+	for _, output := range selected {
+		if !image.VersionEnabled(output.Name) {
+			log.Debugf("%s/%s: skipping disabled version", imageName, output.Name)
+			continue
+		}
+		log.Debugf("%s/%s: planning", imageName, output.Name)
+		if _, isVersion := image.Versions[output.Name]; isVersion && opts.StrictKeys {
+			if conflicts := image.KeyConflicts(cfg.Defaults.Values, output.Name); len(conflicts) > 0 {
+				return nil, &KeyConflictError{Image: imageName, Version: output.Name, Conflicts: conflicts}
+			}
+		}
+
+		hash, err := inputsHash(cfg, imageName, output.Name, digest)
+		if err != nil {
+			return nil, err
+		}
+		plan.inputs[output.Name] = hash
+		if opts.Incremental && upToDate(filepath.Join(imagePath, output.Name), templateFiles, hash) {
+			log.Debugf("%s/%s: up to date, skipping", imageName, output.Name)
+			continue
+		}
+		if opts.Resume && opts.Journal != nil && opts.Journal.completed(imageName, output.Name, hash) {
+			log.Debugf("%s/%s: completed by an earlier run, skipping", imageName, output.Name)
+			continue
+		}
+
+		files, err := renderVersion(ctx, cfg, imageName, output.Name, sources, templateFiles, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		actions, err := planVersion(imagePath, output.Name, files)
+		if err != nil {
+			return nil, err
+		}
+		plan.Actions = append(plan.Actions, actions...)
+	}
+
+	// An image generated only from template_dirs may have no directory yet.
+	// Disabled versions are still outputs, so their directories are kept.
+	var orphans []string
+	if _, err := os.Stat(imagePath); err == nil {
+		if orphans, err = orphanedVersions(imagePath, outputs); err != nil {
+			return nil, fmt.Errorf("finding orphaned versions: %w", err)
+		}
+	}
+	if opts.Version != "" {
+		orphans = nil
+	}
+	if opts.Prune {
+		for _, orphan := range orphans {
+			plan.Actions = append(plan.Actions, Action{Type: DeleteDir, Path: orphan})
+		}
+	} else {
+		plan.Orphans = orphans
+	}
+
+	sort.Slice(plan.Actions, func(i, j int) bool {
+		return plan.Actions[i].Path < plan.Actions[j].Path
+	})
+	return plan, nil
+}
+
+// selectOutputs returns the outputs generated for version: every output when
+// it is empty, else those rendered from that manifest version or named by it.
+func selectOutputs(imageName string, outputs []config.OutputVersion, version string) ([]config.OutputVersion, error) {
+	if version == "" {
+		return outputs, nil
+	}
+	var selected []config.OutputVersion
+	for _, output := range outputs {
+		if output.Version == version || output.Name == version {
+			selected = append(selected, output)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("version %s not found for image %s", version, imageName)
+	}
+	return selected, nil
+}
+
+// RenderFile renders one output of an image in memory and returns its file
+// name, slash-separated and relative to the version directory. It reports
+// false when the output has no such file, including for images without a
+// source directory, whose version directories are maintained by hand.
+func RenderFile(ctx context.Context, cfg *config.Config, imageName, output, name string) ([]byte, bool, error) {
+	image, exists := cfg.Images[imageName]
+	if !exists {
+		return nil, false, fmt.Errorf("image %s not found in config", imageName)
+	}
+
+	imagePath, err := cfg.ImagePath(image)
+	if err != nil {
+		return nil, false, err
+	}
+	sources, err := imageSources(cfg, image, imagePath)
+	if err != nil || len(sources) == 0 {
+		return nil, false, err
+	}
+
+	templateFiles, err := discoverTemplateFiles(sources)
+	if err != nil {
+		return nil, false, fmt.Errorf("discovering template files: %w", err)
+	}
+	files, err := renderVersion(ctx, cfg, imageName, output, sources, templateFiles, "")
+	if err != nil {
+		return nil, false, err
+	}
+	file, exists := files[name]
+	return file.content, exists, nil
+}
+
+// planVersion compares the files rendered for one output with its directory.
+// Existing directories that hold no generated file are deleted as a whole;
+// other stale files are deleted one by one.
+func planVersion(imagePath, versionName string, files fileSet) ([]Action, error) {
+	versionDir := filepath.Join(imagePath, versionName)
+
+	// Every directory that will contain a generated file.
+	keepDirs := map[string]bool{".": true}
+	for name := range files {
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			keepDirs[dir] = true
+		}
+	}
+
+	var actions []Action
+	existing := make(map[string][]byte)
+	unchanged := make(map[string]bool)
+	err := filepath.WalkDir(versionDir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == versionDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		relPath, err := filepath.Rel(versionDir, p)
+		if err != nil {
+			return fmt.Errorf("getting relative path for %s: %w", p, err)
+		}
+		relPath = filepath.ToSlash(relPath)
+		target := path.Join(versionName, relPath)
+
+		if entry.IsDir() {
+			if !keepDirs[relPath] {
+				actions = append(actions, Action{Type: DeleteDir, Path: target})
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Symlinks and other special files are replaced, never updated in place.
+		if !entry.Type().IsRegular() {
+			actions = append(actions, Action{Type: DeleteFile, Path: target})
+			return nil
+		}
+
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("reading file %s: %w", p, err)
+		}
+		file, wanted := files[relPath]
+		if !wanted {
+			actions = append(actions, Action{Type: DeleteFile, Path: target, OldHash: hashOf(content)})
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(content, file.content) && info.Mode().Perm() == file.mode.Perm() {
+			unchanged[relPath] = true
+		} else {
+			existing[relPath] = content
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading version directory %s: %w", versionDir, err)
+	}
+
+	for name, file := range files {
+		if unchanged[name] {
+			continue
+		}
+		action := Action{
+			Type:    CreateFile,
+			Path:    path.Join(versionName, name),
+			Mode:    file.mode.Perm(),
+			NewHash: hashOf(file.content),
+			content: file.content,
+		}
+		if old, exists := existing[name]; exists {
+			action.Type = UpdateFile
+			action.OldHash = hashOf(old)
+			action.Diff = UnifiedDiff(action.Path, old, file.content)
+		}
+		actions = append(actions, action)
+	}
+
+	return actions, nil
+}
+
+// OrphanedVersions returns the version directories of an image that no longer
+// belong to any version or variant output.
+func OrphanedVersions(cfg *config.Config, imageName string) ([]string, error) {
+	image, exists := cfg.Images[imageName]
+	if !exists {
+		return nil, fmt.Errorf("image %s not found in config", imageName)
+	}
+	imagePath, err := cfg.ImagePath(image)
+	if err != nil {
+		return nil, err
+	}
+	return orphanedVersions(imagePath, image.OutputVersions())
+}
+
+// orphanedVersions returns the directories in the image directory that no
+// longer belong to any version or variant output. The source directory and
+// plain files are never orphaned.
+func orphanedVersions(imagePath string, outputs []config.OutputVersion) ([]string, error) {
+	entries, err := os.ReadDir(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading image directory: %w", err)
+	}
+
+	versions := make(map[string]bool, len(outputs))
+	for _, output := range outputs {
+		versions[output.Name] = true
+	}
+
+	var orphans []string
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == "source" || strings.HasPrefix(entry.Name(), stagingPrefix) {
+			continue
+		}
+		if !versions[entry.Name()] {
+			orphans = append(orphans, entry.Name())
+		}
+	}
+	return orphans, nil
+}
+
+// Apply carries out a plan returned by PlanImage. It does not re-render
+// anything, so applying a plan writes exactly what it describes.
+//
+// Every file is first written to a staging directory inside the image
+// directory. Only once all of them are staged are deletions made and the
+// staged files renamed into place, so a failed write leaves the existing
+// output untouched and readers never see a missing or partly written file.
+func Apply(plan *Plan) error {
+	staged, cleanup, err := stageWrites(plan)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+
+	for _, action := range plan.Actions {
+		target := filepath.Join(plan.Dir, filepath.FromSlash(action.Path))
+		switch action.Type {
+		case DeleteDir:
+			log.Infof("%s: removing directory %s", plan.Image, target)
+			if err := os.RemoveAll(target); err != nil {
+				return fmt.Errorf("removing directory %s: %w", target, err)
+			}
+		case DeleteFile:
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("removing file %s: %w", target, err)
+			}
+		}
+	}
+
+	for _, action := range plan.Actions {
+		stagedPath, ok := staged[action.Path]
+		if !ok {
+			continue
+		}
+		target := filepath.Join(plan.Dir, filepath.FromSlash(action.Path))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("creating directory for %s: %w", target, err)
+		}
+		if err := os.Rename(stagedPath, target); err != nil {
+			return fmt.Errorf("writing file %s: %w", target, err)
+		}
+	}
+
+	return nil
+}
+
+// stagingPrefix names the temporary directories Apply stages writes in.
+const stagingPrefix = ".generate-"
+
+// stageWrites writes the content of every create and update action of plan
+// below a new staging directory in the image directory, returning the staged
+// path of each action path and a function that removes whatever is left of
+// the staging directory.
+func stageWrites(plan *Plan) (map[string]string, func(), error) {
+	staged := make(map[string]string)
+	noop := func() {}
+
+	var writes []Action
+	for _, action := range plan.Actions {
+		if action.Type == CreateFile || action.Type == UpdateFile {
+			writes = append(writes, action)
+		}
+	}
+	if len(writes) == 0 {
+		return staged, noop, nil
+	}
+
+	if err := os.MkdirAll(plan.Dir, 0755); err != nil {
+		return nil, noop, fmt.Errorf("creating image directory: %w", err)
+	}
+	stagingDir, err := os.MkdirTemp(plan.Dir, stagingPrefix)
+	if err != nil {
+		return nil, noop, fmt.Errorf("creating staging directory: %w", err)
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(stagingDir); err != nil {
+			log.Warnf("failed to remove staging directory %s: %v", stagingDir, err)
+		}
+	}
+
+	for _, action := range writes {
+		stagedPath := filepath.Join(stagingDir, filepath.FromSlash(action.Path))
+		if err := os.MkdirAll(filepath.Dir(stagedPath), 0755); err != nil {
+			return nil, cleanup, fmt.Errorf("staging %s: %w", action.Path, err)
+		}
+		if err := os.WriteFile(stagedPath, action.content, action.Mode); err != nil {
+			return nil, cleanup, fmt.Errorf("staging %s: %w", action.Path, err)
+		}
+		if err := os.Chmod(stagedPath, action.Mode); err != nil {
+			return nil, cleanup, fmt.Errorf("setting mode of %s: %w", action.Path, err)
+		}
+		staged[action.Path] = stagedPath
+	}
+	return staged, cleanup, nil
+}
+
+func hashOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// UnifiedDiff renders a unified diff with three lines of context between two
+// versions of a text file. It returns "" for binary or very large files.
+func UnifiedDiff(name string, old, new []byte) string {
+	if !isText(old) || !isText(new) {
+		return ""
+	}
+	a, b := splitLines(old), splitLines(new)
+	if len(a) > maxDiffLines || len(b) > maxDiffLines {
+		return ""
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type line struct {
+		op   byte
+		text string
+		i, j int
+	}
+	var lines []line
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, line{' ', a[i], i, j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, line{'-', a[i], i, j})
+			i++
+		default:
+			lines = append(lines, line{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	const contextLines = 3
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", name, name)
+	for start := 0; start < len(lines); {
+		if lines[start].op == ' ' {
+			start++
+			continue
+		}
+
+		// Extend the hunk while changes are within 2*contextLines lines of each other.
+		end := start
+		for k := start; k < len(lines); k++ {
+			if lines[k].op != ' ' {
+				end = k
+			} else if k-end > 2*contextLines {
+				break
+			}
+		}
+		from := max(start-contextLines, 0)
+		to := min(end+contextLines+1, len(lines))
+
+		var oldCount, newCount int
+		for _, l := range lines[from:to] {
+			if l.op != '+' {
+				oldCount++
+			}
+			if l.op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(lines[from].i, oldCount), hunkRange(lines[from].j, newCount))
+		for _, l := range lines[from:to] {
+			out.WriteByte(l.op)
+			out.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = to
+	}
+	return out.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// splitLines splits content after each newline, keeping the newlines.
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func isText(content []byte) bool {
+	return utf8.Valid(content) && bytes.IndexByte(content, 0) < 0
+}
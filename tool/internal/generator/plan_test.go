@@ -0,0 +1,256 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+var planSources = map[string]string{
+	"Dockerfile.tmpl": "FROM python:{{version}}\nRUN true\n",
+	"entrypoint.sh":   "#!/bin/sh\n",
+}
+
+func actionSummary(plan *Plan) []string {
+	var summary []string
+	for _, action := range plan.Actions {
+		summary = append(summary, string(action.Type)+" "+action.Path)
+	}
+	return summary
+}
+
+func TestPlanImage_DoesNotTouchDisk(t *testing.T) {
+	cfg, imageDir := testImageConfig(t, "python", map[string]*config.ImageConfig{"3.13": {}}, planSources)
+
+	plan, err := PlanImage(cfg, "python")
+	if err != nil {
+		t.Fatalf("PlanImage() error = %v", err)
+	}
+
+	want := []string{"create_file 3.13/Dockerfile", "create_file 3.13/entrypoint.sh"}
+	if got := actionSummary(plan); !reflect.DeepEqual(got, want) {
+		t.Errorf("PlanImage() actions = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(imageDir, "3.13")); !os.IsNotExist(err) {
+		t.Error("PlanImage() should not create the version directory")
+	}
+
+	if err := Apply(plan); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(imageDir, "3.13", "Dockerfile"))
+	if err != nil || string(content) != "FROM python:3.13\nRUN true\n" {
+		t.Errorf("3.13/Dockerfile = %q, %v", content, err)
+	}
+
+	plan, err = PlanImage(cfg, "python")
+	if err != nil {
+		t.Fatalf("PlanImage() error = %v", err)
+	}
+	if !plan.Empty() {
+		t.Errorf("PlanImage() after Apply() = %v, want no actions", actionSummary(plan))
+	}
+}
+
+func TestPlanImage_Changes(t *testing.T) {
+	cfg, imageDir := testImageConfig(t, "python", map[string]*config.ImageConfig{"3.13": {}}, planSources)
+	if err := GenerateImage(cfg, "python"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	writeSourceFiles(t, imageDir, map[string]string{
+		"3.12/Dockerfile":      "FROM python:3.12\n",
+		"3.13/Dockerfile":      "FROM python:3.12\nRUN true\n",
+		"3.13/stale.txt":       "old\n",
+		"3.13/old/nested.txt":  "old\n",
+		"3.13/old/deeper/a.sh": "old\n",
+	})
+
+	plan, err := PlanImage(cfg, "python")
+	if err != nil {
+		t.Fatalf("PlanImage() error = %v", err)
+	}
+
+	want := []string{
+		"delete_dir 3.12",
+		"update_file 3.13/Dockerfile",
+		"delete_dir 3.13/old",
+		"delete_file 3.13/stale.txt",
+	}
+	if got := actionSummary(plan); !reflect.DeepEqual(got, want) {
+		t.Fatalf("PlanImage() actions = %v, want %v", got, want)
+	}
+
+	update := plan.Actions[1]
+	if update.OldHash == "" || update.NewHash == "" || update.OldHash == update.NewHash {
+		t.Errorf("update hashes = %q -> %q, want two different digests", update.OldHash, update.NewHash)
+	}
+	wantDiff := "--- a/3.13/Dockerfile\n+++ b/3.13/Dockerfile\n@@ -1,2 +1,2 @@\n-FROM python:3.12\n+FROM python:3.13\n RUN true\n"
+	if update.Diff != wantDiff {
+		t.Errorf("update diff =\n%s\nwant\n%s", update.Diff, wantDiff)
+	}
+
+	if err := Apply(plan); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	for _, removed := range []string{"3.12", "3.13/old", "3.13/stale.txt"} {
+		if _, err := os.Stat(filepath.Join(imageDir, removed)); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed", removed)
+		}
+	}
+}
+
+func TestPlanImage_NoPrune(t *testing.T) {
+	cfg, imageDir := testImageConfig(t, "python", map[string]*config.ImageConfig{"3.13": {}}, planSources)
+	if err := GenerateImage(cfg, "python"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+	writeSourceFiles(t, imageDir, map[string]string{"3.12/Dockerfile": "FROM python:3.12\n"})
+
+	plan, err := PlanImageContext(context.Background(), cfg, "python", Options{Prune: false})
+	if err != nil {
+		t.Fatalf("PlanImageContext() error = %v", err)
+	}
+	if !plan.Empty() {
+		t.Errorf("PlanImageContext() actions = %v, want none", actionSummary(plan))
+	}
+	if want := []string{"3.12"}; !reflect.DeepEqual(plan.Orphans, want) {
+		t.Errorf("PlanImageContext() orphans = %v, want %v", plan.Orphans, want)
+	}
+
+	disabled := false
+	cfg.Defaults.PruneOrphans = &disabled
+	plan, err = GenerateImageContext(context.Background(), cfg, "python", DefaultOptions(cfg))
+	if err != nil {
+		t.Fatalf("GenerateImageContext() error = %v", err)
+	}
+	if len(plan.Orphans) != 1 {
+		t.Errorf("GenerateImageContext() orphans = %v, want [3.12]", plan.Orphans)
+	}
+	if _, err := os.Stat(filepath.Join(imageDir, "3.12", "Dockerfile")); err != nil {
+		t.Errorf("orphaned version should be kept with prune_orphans: false: %v", err)
+	}
+}
+
+func TestPlanImage_StableJSON(t *testing.T) {
+	cfg, _ := testImageConfig(t, "python", map[string]*config.ImageConfig{"3.13": {}}, planSources)
+
+	var encoded []string
+	for i := 0; i < 2; i++ {
+		plan, err := PlanImage(cfg, "python")
+		if err != nil {
+			t.Fatalf("PlanImage() error = %v", err)
+		}
+		plan.Dir = "images/python"
+		out, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			t.Fatalf("json.MarshalIndent() error = %v", err)
+		}
+		encoded = append(encoded, string(out))
+	}
+
+	if encoded[0] != encoded[1] {
+		t.Errorf("plan JSON differs between runs:\n%s\n%s", encoded[0], encoded[1])
+	}
+
+	want := `{
+  "image": "python",
+  "dir": "images/python",
+  "actions": [
+    {
+      "type": "create_file",
+      "path": "3.13/Dockerfile",
+      "mode": 420,
+      "new_sha256": "` + hashOf([]byte("FROM python:3.13\nRUN true\n")) + `"
+    },
+    {
+      "type": "create_file",
+      "path": "3.13/entrypoint.sh",
+      "mode": 420,
+      "new_sha256": "` + hashOf([]byte("#!/bin/sh\n")) + `"
+    }
+  ]
+}`
+	if encoded[0] != want {
+		t.Errorf("plan JSON =\n%s\nwant\n%s", encoded[0], want)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "separate hunks",
+			old:  "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n",
+			new:  "A\nb\nc\nd\ne\nf\ng\nh\ni\nj\nK\n",
+			want: "--- a/f\n+++ b/f\n@@ -1,4 +1,4 @@\n-a\n+A\n b\n c\n d\n@@ -8,4 +8,4 @@\n h\n i\n j\n-k\n+K\n",
+		},
+		{
+			name: "append to empty",
+			old:  "",
+			new:  "x\n",
+			want: "--- a/f\n+++ b/f\n@@ -0,0 +1 @@\n+x\n",
+		},
+		{
+			name: "missing trailing newline",
+			old:  "x",
+			new:  "x\n",
+			want: "--- a/f\n+++ b/f\n@@ -1 +1 @@\n-x\n\\ No newline at end of file\n+x\n",
+		},
+		{
+			name: "binary",
+			old:  "a\x00",
+			new:  "b\x00",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := UnifiedDiff("f", []byte(tt.old), []byte(tt.new)); got != tt.want {
+				t.Errorf("UnifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply_FailedStagingLeavesOutputUntouched(t *testing.T) {
+	imageDir := t.TempDir()
+	writeSourceFiles(t, imageDir, map[string]string{
+		"v1/Dockerfile": "FROM alpine:3.19\n",
+		"v1/stale.txt":  "old\n",
+	})
+
+	// v1/conf cannot be staged both as a file and as a directory.
+	plan := &Plan{Image: "app", Dir: imageDir, Actions: []Action{
+		{Type: UpdateFile, Path: "v1/Dockerfile", Mode: 0644, content: []byte("FROM alpine:3.20\n")},
+		{Type: CreateFile, Path: "v1/conf", Mode: 0644, content: []byte("a\n")},
+		{Type: CreateFile, Path: "v1/conf/b", Mode: 0644, content: []byte("b\n")},
+		{Type: DeleteFile, Path: "v1/stale.txt"},
+	}}
+	if err := Apply(plan); err == nil {
+		t.Fatal("Apply() should fail to stage v1/conf/b")
+	}
+
+	if content, err := os.ReadFile(filepath.Join(imageDir, "v1", "Dockerfile")); err != nil || string(content) != "FROM alpine:3.19\n" {
+		t.Errorf("Dockerfile = %q, %v; want the previous content", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(imageDir, "v1", "stale.txt")); err != nil {
+		t.Errorf("stale.txt should not be deleted when staging fails: %v", err)
+	}
+	entries, err := os.ReadDir(imageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("image directory holds %v, want the staging directory removed", entries)
+	}
+}
@@ -0,0 +1,67 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/template"
+)
+
+// ValuesSnapshotFile is the file defaults.emit_values_snapshot writes into
+// every version directory.
+const ValuesSnapshotFile = "values.yaml"
+
+// sensitiveKey matches value keys whose values are left out of snapshots.
+var sensitiveKey = regexp.MustCompile(`(?i)(password|passwd|secret|token|credential|private_?key|api_?key)`)
+
+// redacted replaces the values of sensitive keys in snapshots.
+const redacted = "REDACTED"
+
+// valuesSnapshot returns the values snapshot of a version: the configuration
+// it was rendered with, keys sorted and sensitive values redacted, under the
+// generated-file header, which records the version's inputs hash.
+func valuesSnapshot(data *template.Data, mergedConfig *config.ImageConfig) ([]byte, error) {
+	encoded, err := yaml.Marshal(struct {
+		BaseImage *config.BaseImage `yaml:"base_image,omitempty"`
+		Values    interface{}       `yaml:"values"`
+	}{mergedConfig.BaseImage, redactValues(mergedConfig.Values)})
+	if err != nil {
+		return nil, fmt.Errorf("encoding values snapshot: %w", err)
+	}
+
+	var b bytes.Buffer
+	if header := data.GenerationMessageFor(ValuesSnapshotFile); header != "" {
+		b.WriteString(header + "\n\n")
+	}
+	b.Write(encoded)
+	return b.Bytes(), nil
+}
+
+// redactValues returns a copy of value with the values of sensitive keys, at
+// any depth, replaced by redacted.
+func redactValues(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if sensitiveKey.MatchString(key) {
+				out[key] = redacted
+				continue
+			}
+			out[key] = redactValues(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = redactValues(item)
+		}
+		return out
+	default:
+		return value
+	}
+}
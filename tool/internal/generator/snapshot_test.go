@@ -0,0 +1,81 @@
+package generator
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestGenerateImage_ValuesSnapshot(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeSourceFiles(t, filepath.Join(tmpDir, "myapp", "source"), map[string]string{"Dockerfile.tmpl": "{{generation_message}}\nFROM alpine\n"})
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io", EmitValuesSnapshot: true},
+		Images: map[string]config.Image{
+			"myapp": {
+				Path: "myapp",
+				Versions: map[string]*config.ImageConfig{
+					"v1": {
+						BaseImage: &config.BaseImage{Name: "alpine:3.20"},
+						Values: map[string]interface{}{
+							"zlib":         "1.3",
+							"apt":          map[string]interface{}{"packages": []interface{}{"curl"}, "proxy_password": "hunter2"},
+							"GITHUB_TOKEN": "ghp_123",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := GenerateImage(cfg, "myapp"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	versionDir := filepath.Join(tmpDir, "myapp", "v1")
+	snapshot, err := os.ReadFile(filepath.Join(versionDir, ValuesSnapshotFile))
+	if err != nil {
+		t.Fatalf("Failed to read values snapshot: %v", err)
+	}
+	dockerfile, err := os.ReadFile(filepath.Join(versionDir, "Dockerfile"))
+	if err != nil {
+		t.Fatalf("Failed to read Dockerfile: %v", err)
+	}
+
+	hash, found := recordedInputsHash(snapshot)
+	if want, _ := recordedInputsHash(dockerfile); !found || hash != want {
+		t.Errorf("snapshot inputs hash = %q, want the Dockerfile's %q", hash, want)
+	}
+
+	_, body, _ := strings.Cut(string(snapshot), "\n\n")
+	want := `base_image:
+    name: alpine:3.20
+values:
+    GITHUB_TOKEN: REDACTED
+    apt:
+        packages:
+            - curl
+        proxy_password: REDACTED
+    registry: test.io
+    version: v1
+    zlib: "1.3"
+`
+	if !strings.HasSuffix(string(snapshot), want) {
+		t.Errorf("snapshot body =\n%s\nwant\n%s", body, want)
+	}
+
+	// The snapshot takes part in incremental generation like any output.
+	opts := DefaultOptions(cfg)
+	opts.Incremental = true
+	plan, err := PlanImageContext(context.Background(), cfg, "myapp", opts)
+	if err != nil {
+		t.Fatalf("PlanImageContext() error = %v", err)
+	}
+	if !plan.Empty() {
+		t.Errorf("plan = %+v, want nothing to do", plan.Actions)
+	}
+}
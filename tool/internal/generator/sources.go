@@ -0,0 +1,114 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// sourceTree is the layered source of an image: directories whose files are
+// merged by relative path, a file in a later directory overriding the same
+// path in an earlier one.
+type sourceTree []string
+
+// imageSources returns the source layers of image: its template_dirs, anchored
+// at the manifest's base path, followed by its own source directory when that
+// exists. It is empty when the image has neither.
+func imageSources(cfg *config.Config, image config.Image, imagePath string) (sourceTree, error) {
+	var sources sourceTree
+	for _, dir := range image.TemplateDirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(cfg.Defaults.BasePath, dir)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("template directory %s does not exist", dir)
+		}
+		sources = append(sources, dir)
+	}
+
+	sourceDir := filepath.Join(imagePath, "source")
+	if _, err := os.Stat(sourceDir); err == nil {
+		sources = append(sources, sourceDir)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// SourceFile returns the path that name, relative to the image's source
+// directory, is read from once template_dirs are layered under it.
+func SourceFile(cfg *config.Config, imageName, name string) (string, error) {
+	image, exists := cfg.Images[imageName]
+	if !exists {
+		return "", fmt.Errorf("image %s not found in config", imageName)
+	}
+	imagePath, err := cfg.ImagePath(image)
+	if err != nil {
+		return "", err
+	}
+	sources, err := imageSources(cfg, image, imagePath)
+	if err != nil {
+		return "", err
+	}
+	if len(sources) == 0 {
+		return filepath.Join(imagePath, "source", name), nil
+	}
+	return sources.path(name), nil
+}
+
+// path returns the file the relative path name resolves to: the one in the
+// last layer that has it, or a path in the last layer when none does.
+func (t sourceTree) path(name string) string {
+	name = filepath.FromSlash(name)
+	for i := len(t) - 1; i >= 0; i-- {
+		candidate := filepath.Join(t[i], name)
+		if _, err := os.Lstat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return filepath.Join(t[len(t)-1], name)
+}
+
+// walk calls fn in path order for every file of the merged tree, with its
+// relative path and the path of the layer file it resolves to.
+func (t sourceTree) walk(fn func(relPath, path string, info os.FileInfo) error) error {
+	type layerFile struct {
+		path string
+		info os.FileInfo
+	}
+	files := make(map[string]layerFile)
+	for _, dir := range t {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			relPath, err := filepath.Rel(dir, path)
+			if err != nil {
+				return fmt.Errorf("getting relative path for %s: %w", path, err)
+			}
+			files[relPath] = layerFile{path: path, info: info}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := fn(name, files[name].path, files[name].info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestGenerateImage_TemplateDirs(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"app": {
+				Path:         "app",
+				TemplateDirs: []string{"templates/debian-common", "templates/debian-extra"},
+				Versions:     map[string]*config.ImageConfig{"1.0": {Values: map[string]interface{}{}}},
+			},
+		},
+	}
+	writeSourceFiles(t, filepath.Join(tmpDir, "templates", "debian-common"), map[string]string{
+		"Dockerfile.tmpl": "FROM debian:{{version}}\n",
+		"entrypoint.sh":   "#!/bin/sh\necho common\n",
+		"conf/app.conf":   "common\n",
+	})
+	writeSourceFiles(t, filepath.Join(tmpDir, "templates", "debian-extra"), map[string]string{
+		"conf/app.conf": "extra\n",
+	})
+	writeSourceFiles(t, filepath.Join(tmpDir, "app", "source"), map[string]string{
+		"entrypoint.sh": "#!/bin/sh\necho app\n",
+	})
+
+	if err := GenerateImage(cfg, "app"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"Dockerfile":    "FROM debian:1.0\n",
+		"entrypoint.sh": "#!/bin/sh\necho app\n",
+		"conf/app.conf": "extra\n",
+	} {
+		content, err := os.ReadFile(filepath.Join(tmpDir, "app", "1.0", filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(content) != want {
+			t.Errorf("%s = %q, want %q", name, content, want)
+		}
+	}
+}
+
+func TestGenerateImage_TemplateDirsWithoutSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"app": {
+				Path:         "app",
+				TemplateDirs: []string{"templates/common"},
+				Versions:     map[string]*config.ImageConfig{"1.0": {Values: map[string]interface{}{}}},
+			},
+		},
+	}
+	writeSourceFiles(t, filepath.Join(tmpDir, "templates", "common"), map[string]string{
+		"Dockerfile.tmpl": "FROM alpine\n",
+	})
+
+	if err := GenerateImage(cfg, "app"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "app", "1.0", "Dockerfile")); err != nil {
+		t.Errorf("Dockerfile not generated: %v", err)
+	}
+
+	image := cfg.Images["app"]
+	image.TemplateDirs = []string{"templates/missing"}
+	cfg.Images["app"] = image
+	err := GenerateImage(cfg, "app")
+	if err == nil || !strings.Contains(err.Error(), "template directory "+filepath.Join(tmpDir, "templates", "missing")+" does not exist") {
+		t.Errorf("GenerateImage() error = %v, want a missing template directory", err)
+	}
+}
+
+func TestSourceFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir},
+		Images: map[string]config.Image{
+			"app": {Path: "app", TemplateDirs: []string{"common"}},
+		},
+	}
+	writeSourceFiles(t, filepath.Join(tmpDir, "common"), map[string]string{TestsFile: "cases: []\n", "Dockerfile.tmpl": ""})
+	writeSourceFiles(t, filepath.Join(tmpDir, "app", "source"), map[string]string{"Dockerfile.tmpl": ""})
+
+	tests := map[string]string{
+		TestsFile:         filepath.Join(tmpDir, "common", TestsFile),
+		"Dockerfile.tmpl": filepath.Join(tmpDir, "app", "source", "Dockerfile.tmpl"),
+		"missing.tmpl":    filepath.Join(tmpDir, "app", "source", "missing.tmpl"),
+	}
+	for name, want := range tests {
+		got, err := SourceFile(cfg, "app", name)
+		if err != nil {
+			t.Fatalf("SourceFile(%s) error = %v", name, err)
+		}
+		if got != want {
+			t.Errorf("SourceFile(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
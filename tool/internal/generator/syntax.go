@@ -0,0 +1,64 @@
+package generator
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	syntaxDirectivePattern = regexp.MustCompile(`(?i)^#\s*syntax\s*=`)
+	heredocPattern         = regexp.MustCompile(`(?m)^\s*(RUN|COPY|ADD)\b.*<<-?["']?[A-Za-z_][A-Za-z0-9_]*`)
+	cacheMountPattern      = regexp.MustCompile(`--mount=(\S*,)?type=cache`)
+)
+
+// isDockerfile reports whether a generated file gets the syntax directive.
+func isDockerfile(name string) bool {
+	return strings.HasSuffix(name, "/Dockerfile") || name == "Dockerfile"
+}
+
+// withSyntaxDirective makes a syntax directive the first line of dockerfile,
+// as BuildKit ignores directives after any other line. A directive the
+// template already contains in its leading comment block is moved to the top
+// rather than duplicated; otherwise syntax, when set, is prepended.
+func withSyntaxDirective(dockerfile, syntax string) string {
+	lines := strings.SplitAfter(dockerfile, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+		if !syntaxDirectivePattern.MatchString(trimmed) {
+			continue
+		}
+		if i == 0 {
+			return dockerfile
+		}
+		directive := strings.TrimRight(line, "\n") + "\n"
+		rest := strings.Join(append(lines[:i:i], lines[i+1:]...), "")
+		return directive + rest
+	}
+
+	if syntax == "" {
+		return dockerfile
+	}
+	return "# syntax=" + syntax + "\n" + dockerfile
+}
+
+// hasSyntaxDirective reports whether dockerfile starts with a syntax directive.
+func hasSyntaxDirective(dockerfile string) bool {
+	firstLine, _, _ := strings.Cut(dockerfile, "\n")
+	return syntaxDirectivePattern.MatchString(strings.TrimSpace(firstLine))
+}
+
+// syntaxFeatures returns the Dockerfile features in use that need a recent
+// frontend selected with a syntax directive.
+func syntaxFeatures(dockerfile string) []string {
+	var features []string
+	if heredocPattern.MatchString(dockerfile) {
+		features = append(features, "heredocs")
+	}
+	if cacheMountPattern.MatchString(dockerfile) {
+		features = append(features, "--mount=type=cache")
+	}
+	return features
+}
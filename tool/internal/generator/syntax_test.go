@@ -0,0 +1,119 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestWithSyntaxDirective(t *testing.T) {
+	header := "# GENERATED FILE, DO NOT MODIFY!\n#\n"
+	tests := []struct {
+		name       string
+		dockerfile string
+		syntax     string
+		want       string
+	}{
+		{
+			name:       "prepended before the header",
+			dockerfile: header + "FROM alpine\n",
+			syntax:     "docker/dockerfile:1.7",
+			want:       "# syntax=docker/dockerfile:1.7\n" + header + "FROM alpine\n",
+		},
+		{
+			name:       "unset",
+			dockerfile: header + "FROM alpine\n",
+			want:       header + "FROM alpine\n",
+		},
+		{
+			name:       "template directive is not duplicated",
+			dockerfile: "# syntax=docker/dockerfile:1.4\n" + header + "FROM alpine\n",
+			syntax:     "docker/dockerfile:1.7",
+			want:       "# syntax=docker/dockerfile:1.4\n" + header + "FROM alpine\n",
+		},
+		{
+			name:       "template directive after the header moves to the top",
+			dockerfile: header + "# syntax=docker/dockerfile:1.4\nFROM alpine\n",
+			syntax:     "docker/dockerfile:1.7",
+			want:       "# syntax=docker/dockerfile:1.4\n" + header + "FROM alpine\n",
+		},
+		{
+			name:       "directive-like comment after an instruction is left alone",
+			dockerfile: "FROM alpine\n# syntax=docker/dockerfile:1.4\n",
+			syntax:     "docker/dockerfile:1.7",
+			want:       "# syntax=docker/dockerfile:1.7\nFROM alpine\n# syntax=docker/dockerfile:1.4\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withSyntaxDirective(tt.dockerfile, tt.syntax); got != tt.want {
+				t.Errorf("withSyntaxDirective() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyntaxFeatures(t *testing.T) {
+	tests := []struct {
+		dockerfile string
+		want       string
+	}{
+		{"FROM alpine\nRUN apk add curl\n", ""},
+		{"FROM alpine\nRUN <<EOF\necho hi\nEOF\n", "heredocs"},
+		{"FROM alpine\nCOPY <<-'CONF' /etc/app.conf\nkey=value\nCONF\n", "heredocs"},
+		{"FROM alpine\nRUN --mount=type=cache,target=/var/cache/apk apk add curl\n", "--mount=type=cache"},
+		{"FROM alpine\nRUN --mount=target=/root/.cache,type=cache <<EOF\nmake\nEOF\n", "heredocs,--mount=type=cache"},
+		{"FROM alpine\nRUN echo 'a << b'\n", ""},
+	}
+
+	for _, tt := range tests {
+		if got := strings.Join(syntaxFeatures(tt.dockerfile), ","); got != tt.want {
+			t.Errorf("syntaxFeatures(%q) = %q, want %q", tt.dockerfile, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateImage_DockerfileSyntax(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io", DockerfileSyntax: "docker/dockerfile:1.7"},
+		Images: map[string]config.Image{
+			"myapp": {Path: "myapp", Versions: map[string]*config.ImageConfig{"v1": {Values: map[string]interface{}{}}}},
+		},
+	}
+
+	imageDir := filepath.Join(tmpDir, "myapp")
+	writeSourceFiles(t, filepath.Join(imageDir, "source"), map[string]string{
+		"Dockerfile.tmpl":    "{{generation_message}}\nFROM alpine\n",
+		"nested/Dockerfile":  "FROM alpine\n",
+		"entrypoint.sh.tmpl": "#!/bin/sh\n",
+	})
+
+	if err := GenerateImage(cfg, "myapp"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(imageDir, "v1", "Dockerfile"))
+	if err != nil {
+		t.Fatalf("Failed to read Dockerfile: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "# syntax=docker/dockerfile:1.7\n# GENERATED FILE") {
+		t.Errorf("Dockerfile should start with the syntax directive, got:\n%s", content)
+	}
+
+	// Only rendered Dockerfiles get the directive.
+	for name, want := range map[string]string{
+		"entrypoint.sh":     "#!/bin/sh\n",
+		"nested/Dockerfile": "FROM alpine\n",
+	} {
+		content, err := os.ReadFile(filepath.Join(imageDir, "v1", name))
+		if err != nil || string(content) != want {
+			t.Errorf("%s = %q, %v, want %q", name, content, err, want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/template"
+)
+
+// vendorFiles adds the files matched by patterns (relative to basePath) to the
+// version's vendor directory. Each file keeps its path relative to the
+// parent of the pattern's static prefix, so "shared/certs/**" lands under
+// "_vendor/certs/".
+func vendorFiles(basePath string, patterns []string, files fileSet) error {
+	for _, pattern := range patterns {
+		if err := vendorPattern(basePath, pattern, files); err != nil {
+			return fmt.Errorf("vendoring %s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func vendorPattern(basePath, pattern string, files fileSet) error {
+	pattern = path.Clean(filepath.ToSlash(pattern))
+	if path.IsAbs(pattern) || pattern == ".." || strings.HasPrefix(pattern, "../") {
+		return fmt.Errorf("pattern must be relative to the manifest directory")
+	}
+
+	prefix := staticPrefix(pattern)
+	root := filepath.Join(basePath, filepath.FromSlash(prefix))
+	anchor := path.Dir(prefix)
+
+	matched := 0
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(basePath, p)
+		if err != nil {
+			return fmt.Errorf("getting relative path for %s: %w", p, err)
+		}
+		relPath = filepath.ToSlash(relPath)
+		if !matchGlob(pattern, relPath) {
+			return nil
+		}
+
+		destRel := relPath
+		if anchor != "." {
+			destRel = strings.TrimPrefix(relPath, anchor+"/")
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return &FileError{Op: "vendoring", Source: relPath, Output: path.Join(template.VendorDir, destRel), Err: err}
+		}
+		files[path.Join(template.VendorDir, destRel)] = plannedFile{content: content, mode: info.Mode().Perm()}
+
+		matched++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if matched == 0 {
+		return fmt.Errorf("pattern matched no files")
+	}
+	return nil
+}
+
+// staticPrefix returns the leading path segments of pattern that contain no
+// glob metacharacters.
+func staticPrefix(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	var static []string
+	for _, segment := range segments {
+		if strings.ContainsAny(segment, "*?[") {
+			break
+		}
+		static = append(static, segment)
+	}
+	if len(static) == 0 {
+		return "."
+	}
+	return strings.Join(static, "/")
+}
+
+// matchGlob reports whether name matches pattern, where "**" matches zero or
+// more path segments and other segments follow path.Match semantics.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
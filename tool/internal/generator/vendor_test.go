@@ -0,0 +1,139 @@
+package generator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestGenerateImage_Vendor(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := map[string]string{
+		"shared/certs/ca.pem":               "root\n",
+		"shared/certs/intermediate/ic.pem":  "intermediate\n",
+		"shared/scripts/setup.sh":           "#!/bin/sh\n",
+		"shared/scripts/README.md":          "docs\n",
+		"images/app/source/Dockerfile.tmpl": "FROM alpine\nCOPY {{vendor_path \"certs\"}}/ /etc/certs/\n",
+	}
+	for path, content := range files {
+		fullPath := filepath.Join(tmpDir, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: tmpDir, Registry: "test.io"},
+		Images: map[string]config.Image{
+			"app": {
+				Path:   "images/app",
+				Vendor: []string{"shared/certs/**", "shared/scripts/*.sh"},
+				Versions: map[string]*config.ImageConfig{
+					"v1": {Values: map[string]interface{}{}},
+				},
+			},
+		},
+	}
+
+	if err := GenerateImage(cfg, "app"); err != nil {
+		t.Fatalf("GenerateImage() error = %v", err)
+	}
+
+	versionDir := filepath.Join(tmpDir, "images/app/v1")
+	for _, want := range []string{
+		"_vendor/certs/ca.pem",
+		"_vendor/certs/intermediate/ic.pem",
+		"_vendor/scripts/setup.sh",
+	} {
+		if _, err := os.Stat(filepath.Join(versionDir, want)); err != nil {
+			t.Errorf("Expected vendored file %s: %v", want, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(versionDir, "_vendor/scripts/README.md")); !os.IsNotExist(err) {
+		t.Error("Files not matching the pattern should not be vendored")
+	}
+
+	content, err := os.ReadFile(filepath.Join(versionDir, "Dockerfile"))
+	if err != nil {
+		t.Fatalf("Failed to read Dockerfile: %v", err)
+	}
+	if string(content) != "FROM alpine\nCOPY _vendor/certs/ /etc/certs/\n" {
+		t.Errorf("Unexpected Dockerfile content: %q", content)
+	}
+
+	// Removing a shared file prunes it from the vendored copy on regeneration
+	if err := os.Remove(filepath.Join(tmpDir, "shared/certs/intermediate/ic.pem")); err != nil {
+		t.Fatalf("Failed to remove shared file: %v", err)
+	}
+	if err := GenerateImage(cfg, "app"); err != nil {
+		t.Fatalf("GenerateImage() second run error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(versionDir, "_vendor/certs/intermediate/ic.pem")); !os.IsNotExist(err) {
+		t.Error("Removed shared file should be pruned from the vendor directory")
+	}
+}
+
+func TestVendorFiles_Errors(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		pattern string
+	}{
+		{name: "escapes manifest directory", pattern: "../outside/**"},
+		{name: "absolute pattern", pattern: "/etc/**"},
+		{name: "matches nothing", pattern: "missing/**"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := vendorFiles(tmpDir, []string{tt.pattern}, make(fileSet)); err == nil {
+				t.Errorf("vendorFiles(%q) should return error", tt.pattern)
+			}
+		})
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"shared/certs/**", "shared/certs/ca.pem", true},
+		{"shared/certs/**", "shared/certs/a/b/c.pem", true},
+		{"shared/certs/**", "shared/other/ca.pem", false},
+		{"shared/*.sh", "shared/setup.sh", true},
+		{"shared/*.sh", "shared/nested/setup.sh", false},
+		{"shared/**/*.sh", "shared/setup.sh", true},
+		{"shared/**/*.sh", "shared/a/b/setup.sh", true},
+		{"shared/ca.pem", "shared/ca.pem", true},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStaticPrefix(t *testing.T) {
+	tests := map[string]string{
+		"shared/certs/**":     "shared/certs",
+		"shared/*.sh":         "shared",
+		"**/certs":            ".",
+		"shared/certs/ca.pem": "shared/certs/ca.pem",
+	}
+
+	for pattern, want := range tests {
+		if got := staticPrefix(pattern); got != want {
+			t.Errorf("staticPrefix(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
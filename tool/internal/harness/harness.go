@@ -0,0 +1,220 @@
+package harness
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+	"github.com/mberwanger/dockerfiles/tool/internal/template"
+)
+
+// Suite is the content of an image's source/tests.yaml.
+type Suite struct {
+	Cases []Case `yaml:"cases"`
+}
+
+// Case renders one template for a version, optionally with value overrides
+// applied after the merge, and checks the output against expectations.
+type Case struct {
+	Name        string                 `yaml:"name"`
+	Version     string                 `yaml:"version"`
+	Values      map[string]interface{} `yaml:"values,omitempty"`
+	Template    string                 `yaml:"template"`
+	Contains    []string               `yaml:"contains,omitempty"`
+	NotContains []string               `yaml:"not_contains,omitempty"`
+	Matches     []string               `yaml:"matches,omitempty"`
+}
+
+// Result is the outcome of a single case.
+type Result struct {
+	Image    string
+	Case     string
+	Version  string
+	Failures []string
+}
+
+func (r Result) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// RunAll runs the suites of every image that has one, ordered by image name.
+func RunAll(cfg *config.Config) ([]Result, error) {
+	imageNames := make([]string, 0, len(cfg.Images))
+	for imageName := range cfg.Images {
+		imageNames = append(imageNames, imageName)
+	}
+	sort.Strings(imageNames)
+
+	var results []Result
+	for _, imageName := range imageNames {
+		imageResults, err := Run(cfg, imageName)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, imageResults...)
+	}
+	return results, nil
+}
+
+// Run executes the image's suite using the in-memory render path. Images
+// without a tests.yaml yield no results.
+func Run(cfg *config.Config, imageName string) ([]Result, error) {
+	suitePath, err := generator.SourceFile(cfg, imageName, generator.TestsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	suite, err := loadSuite(suitePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("loading tests for %s: %w", imageName, err)
+	}
+
+	results := make([]Result, 0, len(suite.Cases))
+	for i, c := range suite.Cases {
+		name := c.Name
+		if name == "" {
+			name = fmt.Sprintf("case %d", i+1)
+		}
+		result := Result{Image: imageName, Case: name, Version: c.Version}
+		result.Failures = runCase(cfg, imageName, c)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func loadSuite(path string) (*Suite, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var suite Suite
+	if err := yaml.Unmarshal(content, &suite); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &suite, nil
+}
+
+func runCase(cfg *config.Config, imageName string, c Case) []string {
+	if c.Template == "" {
+		return []string{"template is required"}
+	}
+
+	var mergedConfig *config.ImageConfig
+	if c.Version != "" {
+		merged, err := generator.MergedConfig(cfg, imageName, c.Version)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		mergedConfig = merged
+	} else {
+		if len(c.Values) == 0 {
+			return []string{"either version or values is required"}
+		}
+		mergedConfig = cfg.ImageDefaults(imageName)
+		if _, hasRegistry := mergedConfig.Values["registry"]; !hasRegistry {
+			mergedConfig.Values["registry"] = cfg.Defaults.Registry
+		}
+	}
+	for k, v := range c.Values {
+		mergedConfig.Values[k] = v
+	}
+
+	data := generator.NewTemplateData(cfg, imageName, mergedConfig)
+	templatePath, err := generator.SourceFile(cfg, imageName, c.Template)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	output, err := template.Render(templatePath, data)
+	if err != nil {
+		return []string{fmt.Sprintf("render failed: %v", err)}
+	}
+
+	var failures []string
+	for _, want := range c.Contains {
+		if !strings.Contains(output, want) {
+			failures = append(failures, fmt.Sprintf("expected output to contain %q%s", want, closestLineDiff(output, want)))
+		}
+	}
+	for _, unwanted := range c.NotContains {
+		if strings.Contains(output, unwanted) {
+			failures = append(failures, fmt.Sprintf("expected output not to contain %q%s", unwanted, lineContaining(output, unwanted)))
+		}
+	}
+	for _, pattern := range c.Matches {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("invalid pattern %q: %v", pattern, err))
+			continue
+		}
+		if !re.MatchString(output) {
+			failures = append(failures, fmt.Sprintf("expected output to match %q", pattern))
+		}
+	}
+	return failures
+}
+
+// closestLineDiff renders the expected text against the most similar output
+// line so near misses such as version typos are obvious.
+func closestLineDiff(output, want string) string {
+	best, bestDistance := "", -1
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		distance := substringDistance(line, want)
+		if bestDistance == -1 || distance < bestDistance {
+			best, bestDistance = line, distance
+		}
+	}
+	if bestDistance == -1 {
+		return " (output is empty)"
+	}
+	return fmt.Sprintf("\n    - %s\n    + %s", want, best)
+}
+
+// substringDistance returns the edit distance between want and its best
+// matching substring of line.
+func substringDistance(line, want string) int {
+	rl, rw := []rune(line), []rune(want)
+	prev := make([]int, len(rl)+1)
+	curr := make([]int, len(rl)+1)
+
+	for i := 1; i <= len(rw); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rl); j++ {
+			cost := 1
+			if rw[i-1] == rl[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	best := len(rw)
+	for _, d := range prev {
+		best = min(best, d)
+	}
+	return best
+}
+
+func lineContaining(output, text string) string {
+	for i, line := range strings.Split(output, "\n") {
+		if strings.Contains(line, text) {
+			return fmt.Sprintf("\n    line %d: %s", i+1, line)
+		}
+	}
+	return ""
+}
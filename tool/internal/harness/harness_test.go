@@ -0,0 +1,89 @@
+package harness
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func loadFixture(t *testing.T) *config.Config {
+	t.Helper()
+
+	cfg, err := config.Load("testdata/manifest.yaml")
+	if err != nil {
+		t.Fatalf("Failed to load fixture manifest: %v", err)
+	}
+	return cfg
+}
+
+func TestRun(t *testing.T) {
+	cfg := loadFixture(t)
+
+	results, err := Run(cfg, "python")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if len(results) != 4 {
+		t.Fatalf("Run() returned %d results, want 4", len(results))
+	}
+
+	for _, passing := range results[:2] {
+		if !passing.Passed() {
+			t.Errorf("Case %q should pass, got failures: %v", passing.Case, passing.Failures)
+		}
+	}
+
+	failing := results[2]
+	if failing.Passed() || len(failing.Failures) != 3 {
+		t.Fatalf("Case %q should have 3 failures, got %v", failing.Case, failing.Failures)
+	}
+	if !strings.Contains(failing.Failures[0], `- pip==24.3.1`) || !strings.Contains(failing.Failures[0], `+ RUN pip install --no-cache-dir pip==25.2`) {
+		t.Errorf("contains failure should show a diff against the closest line, got %q", failing.Failures[0])
+	}
+	if !strings.Contains(failing.Failures[1], "line 12: ENV PYTHON_VERSION=3.13.7") {
+		t.Errorf("not_contains failure should point at the offending line, got %q", failing.Failures[1])
+	}
+	if !strings.Contains(failing.Failures[2], "expected output to match") {
+		t.Errorf("unexpected matches failure: %q", failing.Failures[2])
+	}
+
+	missing := results[3]
+	if missing.Passed() || !strings.Contains(missing.Failures[0], "render failed") {
+		t.Errorf("Missing template should fail to render, got %v", missing.Failures)
+	}
+}
+
+func TestRun_NoSuite(t *testing.T) {
+	cfg := loadFixture(t)
+	cfg.Images["other"] = config.Image{Path: "other"}
+
+	results, err := Run(cfg, "other")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Images without tests.yaml should yield no results, got %v", results)
+	}
+}
+
+func TestRun_UnknownImage(t *testing.T) {
+	cfg := loadFixture(t)
+
+	if _, err := Run(cfg, "nonexistent"); err == nil {
+		t.Error("Run() should return error for unknown image")
+	}
+}
+
+func TestRunAll(t *testing.T) {
+	cfg := loadFixture(t)
+
+	results, err := RunAll(cfg)
+	if err != nil {
+		t.Fatalf("RunAll() error = %v", err)
+	}
+	if len(results) != 4 {
+		t.Errorf("RunAll() returned %d results, want 4", len(results))
+	}
+}
@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the build duration
+// histogram. Image builds take from seconds to an hour.
+var durationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+// Metrics counts the image builds of a run and renders them in the
+// Prometheus text format. The zero value is not usable; use New.
+type Metrics struct {
+	mu       sync.Mutex
+	builds   int
+	failures int
+	// buckets counts the builds at most as long as the bound of the same
+	// index in durationBuckets.
+	buckets     []int
+	durationSum float64
+	lastSuccess map[imageVersion]time.Time
+}
+
+type imageVersion struct {
+	image, version string
+}
+
+// New returns Metrics with nothing recorded.
+func New() *Metrics {
+	return &Metrics{
+		buckets:     make([]int, len(durationBuckets)),
+		lastSuccess: make(map[imageVersion]time.Time),
+	}
+}
+
+// ObserveBuild records one build of image:version that took duration and
+// failed with err, or succeeded at end.
+func (m *Metrics) ObserveBuild(image, version string, duration time.Duration, end time.Time, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.builds++
+	if err != nil {
+		m.failures++
+	} else {
+		m.lastSuccess[imageVersion{image, version}] = end
+	}
+	seconds := duration.Seconds()
+	m.durationSum += seconds
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+		}
+	}
+}
+
+// WriteText writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteText(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# HELP dockerfiles_builds_total Image builds run.\n")
+	b.WriteString("# TYPE dockerfiles_builds_total counter\n")
+	fmt.Fprintf(&b, "dockerfiles_builds_total %d\n", m.builds)
+	b.WriteString("# HELP dockerfiles_build_failures_total Image builds that failed.\n")
+	b.WriteString("# TYPE dockerfiles_build_failures_total counter\n")
+	fmt.Fprintf(&b, "dockerfiles_build_failures_total %d\n", m.failures)
+
+	b.WriteString("# HELP dockerfiles_build_duration_seconds How long image builds took.\n")
+	b.WriteString("# TYPE dockerfiles_build_duration_seconds histogram\n")
+	for i, bound := range durationBuckets {
+		fmt.Fprintf(&b, "dockerfiles_build_duration_seconds_bucket{le=\"%s\"} %d\n", formatFloat(bound), m.buckets[i])
+	}
+	fmt.Fprintf(&b, "dockerfiles_build_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.builds)
+	fmt.Fprintf(&b, "dockerfiles_build_duration_seconds_sum %s\n", formatFloat(m.durationSum))
+	fmt.Fprintf(&b, "dockerfiles_build_duration_seconds_count %d\n", m.builds)
+
+	b.WriteString("# HELP dockerfiles_build_last_success_timestamp_seconds When each image version last built successfully.\n")
+	b.WriteString("# TYPE dockerfiles_build_last_success_timestamp_seconds gauge\n")
+	keys := make([]imageVersion, 0, len(m.lastSuccess))
+	for key := range m.lastSuccess {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].image != keys[j].image {
+			return keys[i].image < keys[j].image
+		}
+		return keys[i].version < keys[j].version
+	})
+	for _, key := range keys {
+		seconds := float64(m.lastSuccess[key].UnixNano()) / float64(time.Second)
+		fmt.Fprintf(&b, "dockerfiles_build_last_success_timestamp_seconds{image=\"%s\",version=\"%s\"} %s\n", escapeLabel(key.image), escapeLabel(key.version), formatFloat(seconds))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP serves the metrics in the text format, for a /metrics endpoint.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.WriteText(w)
+}
+
+// Serve listens on addr, e.g. ":9090", and serves m at /metrics until stop
+// is called. The listener is opened before Serve returns, so a bad or busy
+// address is reported as an error.
+func Serve(addr string, m *Metrics) (stop func(), err error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics listener: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Warn("metrics endpoint stopped")
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+		<-done
+	}, nil
+}
+
+// formatFloat formats v as the exposition format expects, without an
+// exponent for the values metrics hold.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// escapeLabel escapes a label value: backslashes, double quotes and
+// newlines.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
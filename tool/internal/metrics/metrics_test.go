@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics_WriteText(t *testing.T) {
+	m := New()
+	end := time.Unix(1700000000, 500000000)
+	m.ObserveBuild("core", "noble", 3*time.Second, end, nil)
+	m.ObserveBuild("app", `v"1`, 90*time.Second, end, errors.New("exit status 1"))
+	m.ObserveBuild("app", "v2", 2*time.Hour, end, nil)
+
+	var buf bytes.Buffer
+	if err := m.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	output := buf.String()
+
+	for _, want := range []string{
+		"# TYPE dockerfiles_builds_total counter\ndockerfiles_builds_total 3\n",
+		"dockerfiles_build_failures_total 1\n",
+		"# TYPE dockerfiles_build_duration_seconds histogram\n",
+		"dockerfiles_build_duration_seconds_bucket{le=\"1\"} 0\n",
+		"dockerfiles_build_duration_seconds_bucket{le=\"5\"} 1\n",
+		"dockerfiles_build_duration_seconds_bucket{le=\"120\"} 2\n",
+		"dockerfiles_build_duration_seconds_bucket{le=\"3600\"} 2\n",
+		"dockerfiles_build_duration_seconds_bucket{le=\"+Inf\"} 3\n",
+		"dockerfiles_build_duration_seconds_sum 7293\n",
+		"dockerfiles_build_duration_seconds_count 3\n",
+		"dockerfiles_build_last_success_timestamp_seconds{image=\"app\",version=\"v2\"} 1700000000.5\n" +
+			"dockerfiles_build_last_success_timestamp_seconds{image=\"core\",version=\"noble\"} 1700000000.5\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("WriteText() is missing %q:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, `v\"1`) {
+		t.Errorf("a failed build should have no last success:\n%s", output)
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got, want := escapeLabel("a\\b\"c\nd"), `a\\b\"c\nd`; got != want {
+		t.Errorf("escapeLabel() = %s, want %s", got, want)
+	}
+}
+
+func TestServe(t *testing.T) {
+	m := New()
+	m.ObserveBuild("core", "noble", time.Second, time.Now(), nil)
+
+	if _, err := Serve("not an address", m); err == nil {
+		t.Error("Serve() should fail on an invalid address")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen on loopback: %v", err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	stop, err := Serve(addr, m)
+	if err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+	defer stop()
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics error = %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading /metrics: %v", err)
+	}
+	if got := resp.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the text exposition format", got)
+	}
+	if !strings.Contains(string(body), "dockerfiles_builds_total 1\n") {
+		t.Errorf("GET /metrics =\n%s", body)
+	}
+}
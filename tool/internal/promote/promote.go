@@ -0,0 +1,165 @@
+package promote
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// Plan is a single image copy between registry namespaces.
+type Plan struct {
+	Image       string `json:"image"`
+	Version     string `json:"version"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// Digest pins the source image. When set, the source reference is
+	// digest-addressed and the tag's current digest must match it.
+	Digest string `json:"digest,omitempty"`
+}
+
+// Result reports the outcome of a promotion.
+type Result struct {
+	Plan
+	Tool     string `json:"tool"`
+	DryRun   bool   `json:"dry_run"`
+	Promoted bool   `json:"promoted"`
+}
+
+// NewPlan resolves the source and destination references for an image
+// version from the manifest's promotion settings.
+func NewPlan(cfg *config.Config, imageName, version, digest string) (Plan, error) {
+	promotion, err := cfg.PromotionFor(imageName)
+	if err != nil {
+		return Plan{}, err
+	}
+	if _, exists := cfg.Images[imageName].OutputVersion(version); !exists {
+		return Plan{}, fmt.Errorf("version %s not found for image %s", version, imageName)
+	}
+	if digest != "" && !strings.HasPrefix(digest, "sha256:") {
+		return Plan{}, fmt.Errorf("digest %q must be of the form sha256:<hex>", digest)
+	}
+
+	return Plan{
+		Image:       imageName,
+		Version:     version,
+		Source:      fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(promotion.From, "/"), imageName, version),
+		Destination: fmt.Sprintf("%s/%s:%s", strings.TrimSuffix(promotion.To, "/"), imageName, version),
+		Digest:      digest,
+	}, nil
+}
+
+// sourceRef is the reference the copy reads from: the tag, or the pinned
+// digest so a tag moving mid-promotion cannot change what is copied.
+func (p Plan) sourceRef() string {
+	if p.Digest == "" {
+		return p.Source
+	}
+	repository := p.Source[:strings.LastIndex(p.Source, ":")]
+	return repository + "@" + p.Digest
+}
+
+// Copier copies images between registries.
+type Copier interface {
+	Name() string
+	// Digest returns the manifest digest a reference currently points at.
+	Digest(ctx context.Context, ref string) (string, error)
+	Copy(ctx context.Context, src, dst string) error
+}
+
+// runner executes an external command and returns its standard output.
+type runner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+func execRunner(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return nil, fmt.Errorf("%s %s: %w: %s", name, args[0], err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", name, args[0], err)
+	}
+	return out, nil
+}
+
+const (
+	ToolCrane  = "crane"
+	ToolSkopeo = "skopeo"
+)
+
+// NewCopier returns the copier backed by the named tool.
+func NewCopier(tool string) (Copier, error) {
+	switch tool {
+	case ToolCrane:
+		return craneCopier{run: execRunner}, nil
+	case ToolSkopeo:
+		return skopeoCopier{run: execRunner}, nil
+	default:
+		return nil, fmt.Errorf("unknown promotion tool %q (supported: %s, %s)", tool, ToolCrane, ToolSkopeo)
+	}
+}
+
+type craneCopier struct {
+	run runner
+}
+
+func (craneCopier) Name() string { return ToolCrane }
+
+func (c craneCopier) Digest(ctx context.Context, ref string) (string, error) {
+	out, err := c.run(ctx, "crane", "digest", ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (c craneCopier) Copy(ctx context.Context, src, dst string) error {
+	_, err := c.run(ctx, "crane", "copy", src, dst)
+	return err
+}
+
+type skopeoCopier struct {
+	run runner
+}
+
+func (skopeoCopier) Name() string { return ToolSkopeo }
+
+func (c skopeoCopier) Digest(ctx context.Context, ref string) (string, error) {
+	out, err := c.run(ctx, "skopeo", "inspect", "--format", "{{.Digest}}", "docker://"+ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (c skopeoCopier) Copy(ctx context.Context, src, dst string) error {
+	_, err := c.run(ctx, "skopeo", "copy", "--all", "docker://"+src, "docker://"+dst)
+	return err
+}
+
+// Promote executes plan with copier. A pinned digest is verified against the
+// source tag before copying. In dry-run mode no registry is contacted.
+func Promote(ctx context.Context, copier Copier, plan Plan, dryRun bool) (Result, error) {
+	result := Result{Plan: plan, Tool: copier.Name(), DryRun: dryRun}
+	if dryRun {
+		return result, nil
+	}
+
+	if plan.Digest != "" {
+		current, err := copier.Digest(ctx, plan.Source)
+		if err != nil {
+			return result, fmt.Errorf("resolving digest of %s: %w", plan.Source, err)
+		}
+		if current != plan.Digest {
+			return result, fmt.Errorf("%s points at %s, not the expected %s", plan.Source, current, plan.Digest)
+		}
+	}
+
+	if err := copier.Copy(ctx, plan.sourceRef(), plan.Destination); err != nil {
+		return result, fmt.Errorf("copying %s to %s: %w", plan.sourceRef(), plan.Destination, err)
+	}
+
+	result.Promoted = true
+	return result, nil
+}
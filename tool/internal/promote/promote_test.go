@@ -0,0 +1,161 @@
+package promote
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Defaults: config.Defaults{
+			Promotion: &config.Promotion{From: "staging.internal/library", To: "prod.internal/library/"},
+		},
+		Images: map[string]config.Image{
+			"python": {
+				Versions: map[string]*config.ImageConfig{
+					"3.12": {Variants: map[string]*config.Variant{"slim": {}}},
+				},
+			},
+		},
+	}
+}
+
+func TestNewPlan(t *testing.T) {
+	cfg := testConfig()
+
+	plan, err := NewPlan(cfg, "python", "3.12-slim", "sha256:abc")
+	if err != nil {
+		t.Fatalf("NewPlan() error = %v", err)
+	}
+	want := Plan{
+		Image:       "python",
+		Version:     "3.12-slim",
+		Source:      "staging.internal/library/python:3.12-slim",
+		Destination: "prod.internal/library/python:3.12-slim",
+		Digest:      "sha256:abc",
+	}
+	if plan != want {
+		t.Errorf("NewPlan() = %+v, want %+v", plan, want)
+	}
+	if got := plan.sourceRef(); got != "staging.internal/library/python@sha256:abc" {
+		t.Errorf("sourceRef() = %s", got)
+	}
+
+	if _, err := NewPlan(cfg, "python", "3.13", ""); err == nil {
+		t.Error("NewPlan() should reject unknown versions")
+	}
+	if _, err := NewPlan(cfg, "python", "3.12", "abc"); err == nil {
+		t.Error("NewPlan() should reject malformed digests")
+	}
+	cfg.Defaults.Promotion = nil
+	if _, err := NewPlan(cfg, "python", "3.12", ""); err == nil {
+		t.Error("NewPlan() should fail without promotion settings")
+	}
+}
+
+// fakeRunner records invocations and answers digest lookups.
+type fakeRunner struct {
+	digest string
+	calls  []string
+}
+
+func (f *fakeRunner) run(_ context.Context, name string, args ...string) ([]byte, error) {
+	f.calls = append(f.calls, name+" "+strings.Join(args, " "))
+	if args[0] == "digest" || args[0] == "inspect" {
+		return []byte(f.digest + "\n"), nil
+	}
+	return nil, nil
+}
+
+func TestPromote(t *testing.T) {
+	plan, err := NewPlan(testConfig(), "python", "3.12", "sha256:abc")
+	if err != nil {
+		t.Fatalf("NewPlan() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		copier    func(*fakeRunner) Copier
+		wantCalls []string
+	}{
+		{
+			name:   "crane",
+			copier: func(f *fakeRunner) Copier { return craneCopier{run: f.run} },
+			wantCalls: []string{
+				"crane digest staging.internal/library/python:3.12",
+				"crane copy staging.internal/library/python@sha256:abc prod.internal/library/python:3.12",
+			},
+		},
+		{
+			name:   "skopeo",
+			copier: func(f *fakeRunner) Copier { return skopeoCopier{run: f.run} },
+			wantCalls: []string{
+				"skopeo inspect --format {{.Digest}} docker://staging.internal/library/python:3.12",
+				"skopeo copy --all docker://staging.internal/library/python@sha256:abc docker://prod.internal/library/python:3.12",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{digest: "sha256:abc"}
+			result, err := Promote(context.Background(), tt.copier(runner), plan, false)
+			if err != nil {
+				t.Fatalf("Promote() error = %v", err)
+			}
+			if !result.Promoted || result.Tool != tt.name {
+				t.Errorf("Promote() = %+v", result)
+			}
+			if !reflect.DeepEqual(runner.calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", runner.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPromote_DigestMismatch(t *testing.T) {
+	plan, err := NewPlan(testConfig(), "python", "3.12", "sha256:abc")
+	if err != nil {
+		t.Fatalf("NewPlan() error = %v", err)
+	}
+
+	runner := &fakeRunner{digest: "sha256:def"}
+	if _, err := Promote(context.Background(), craneCopier{run: runner.run}, plan, false); err == nil || !strings.Contains(err.Error(), "not the expected sha256:abc") {
+		t.Errorf("Promote() error = %v, want digest mismatch", err)
+	}
+	if len(runner.calls) != 1 {
+		t.Errorf("nothing should be copied after a digest mismatch, calls = %q", runner.calls)
+	}
+}
+
+func TestPromote_DryRun(t *testing.T) {
+	plan, err := NewPlan(testConfig(), "python", "3.12", "sha256:abc")
+	if err != nil {
+		t.Fatalf("NewPlan() error = %v", err)
+	}
+
+	runner := &fakeRunner{}
+	result, err := Promote(context.Background(), craneCopier{run: runner.run}, plan, true)
+	if err != nil {
+		t.Fatalf("Promote() error = %v", err)
+	}
+	if result.Promoted || !result.DryRun || len(runner.calls) != 0 {
+		t.Errorf("dry run should not contact registries, got %+v with calls %q", result, runner.calls)
+	}
+}
+
+func TestNewCopier(t *testing.T) {
+	for _, tool := range []string{ToolCrane, ToolSkopeo} {
+		copier, err := NewCopier(tool)
+		if err != nil || copier.Name() != tool {
+			t.Errorf("NewCopier(%s) = %v, %v", tool, copier, err)
+		}
+	}
+	if _, err := NewCopier("docker"); err == nil {
+		t.Error("NewCopier() should reject unknown tools")
+	}
+}
@@ -0,0 +1,243 @@
+package scaffold
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+)
+
+// Image describes a new image to scaffold.
+type Image struct {
+	Name string
+	// Path is the image directory relative to the manifest; it defaults to
+	// Name.
+	Path    string
+	Version string
+	// Base is the base image reference, e.g. alpine:3.20 or core:noble.
+	Base string
+}
+
+// File is a file the scaffold creates.
+type File struct {
+	Path    string
+	Content []byte
+}
+
+// Plan is the manifest edit and the files that scaffold an image, computed
+// without touching disk.
+type Plan struct {
+	ManifestPath string
+	Manifest     []byte
+	Updated      []byte
+	Files        []File
+}
+
+// Diff returns the manifest change as a unified diff.
+func (p *Plan) Diff() string {
+	return generator.UnifiedDiff(p.ManifestPath, p.Manifest, p.Updated)
+}
+
+// starterTemplate is the Dockerfile.tmpl of a new image.
+const starterTemplate = `{{ generation_message }}
+
+{{ from_image (index .Values "base_image") }}
+`
+
+// imageNamePattern matches the image names init accepts, which are also
+// valid registry repository names.
+var imageNamePattern = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// NewPlan plans scaffolding img into the manifest cfg was loaded from. It
+// refuses an image the manifest, or one of its includes, already defines.
+func NewPlan(cfg *config.Config, img Image) (*Plan, error) {
+	if !imageNamePattern.MatchString(img.Name) {
+		return nil, fmt.Errorf("invalid image name %q (use lowercase letters, digits and . _ - separators)", img.Name)
+	}
+	if _, exists := cfg.Images[img.Name]; exists {
+		return nil, fmt.Errorf("image %s already exists in the manifest", img.Name)
+	}
+	if img.Version == "" || img.Base == "" {
+		return nil, fmt.Errorf("a version and a base image are required")
+	}
+	if cfg.File == "" || cfg.File == "<stdin>" {
+		return nil, fmt.Errorf("init needs a manifest file, not standard input")
+	}
+	if img.Path == "" {
+		img.Path = img.Name
+	}
+
+	manifest, err := os.ReadFile(cfg.File)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := appendImage(manifest, stanza(cfg, img))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.File, err)
+	}
+
+	templatePath := filepath.Join(cfg.Defaults.BasePath, img.Path, "source", "Dockerfile.tmpl")
+	if _, err := os.Stat(templatePath); err == nil {
+		return nil, fmt.Errorf("%s already exists", templatePath)
+	}
+
+	return &Plan{
+		ManifestPath: cfg.File,
+		Manifest:     manifest,
+		Updated:      updated,
+		Files:        []File{{Path: templatePath, Content: []byte(starterTemplate)}},
+	}, nil
+}
+
+// Apply creates the plan's files and then writes the updated manifest.
+func Apply(plan *Plan) error {
+	for _, file := range plan.Files {
+		if err := os.MkdirAll(filepath.Dir(file.Path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file.Path, file.Content, 0644); err != nil {
+			return err
+		}
+	}
+	info, err := os.Stat(plan.ManifestPath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(plan.ManifestPath, plan.Updated, info.Mode().Perm())
+}
+
+// stanza returns the manifest lines of img, indented one level per unit
+// relative to the image key.
+func stanza(cfg *config.Config, img Image) func(unit string) []string {
+	return func(unit string) []string {
+		lines := []string{
+			img.Name + ":",
+			unit + "path: " + img.Path,
+			unit + "defaults:",
+			unit + unit + "base_image:",
+			unit + unit + unit + "name: " + img.Base,
+		}
+		if source := baseSource(cfg, img.Base); source != "" {
+			lines = append(lines, unit+unit+unit+"source: "+source)
+		}
+		return append(lines,
+			unit+"versions:",
+			unit+unit+strconv.Quote(img.Version)+": {}",
+		)
+	}
+}
+
+// baseSource returns the base_image.source of base: none for an image of the
+// manifest, external for a reference naming a registry host, and dockerhub
+// otherwise.
+func baseSource(cfg *config.Config, base string) string {
+	if base == config.SourceScratch {
+		return config.SourceScratch
+	}
+	name, _, _ := strings.Cut(base, ":")
+	if _, internal := cfg.Images[name]; internal {
+		return ""
+	}
+	if host, _, found := strings.Cut(base, "/"); found && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		return config.SourceExternal
+	}
+	return config.SourceDockerHub
+}
+
+// appendImage adds the lines of a new image at the end of the manifest's
+// images mapping, matching its indentation and blank-line spacing, and leaves
+// the rest of the text untouched.
+func appendImage(manifest []byte, stanza func(unit string) []string) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(manifest, &root); err != nil {
+		return nil, err
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("manifest is not a mapping")
+	}
+	mapping := root.Content[0]
+
+	lines := strings.SplitAfter(string(manifest), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		lines[len(lines)-1] += "\n"
+	}
+
+	images := -1
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == "images" {
+			images = i
+		}
+	}
+	if images < 0 {
+		return render(lines, len(lines), append([]string{"images:"}, indent(stanza("  "), "  ")...)), nil
+	}
+
+	key, value := mapping.Content[images], mapping.Content[images+1]
+	unit := "  "
+	switch {
+	case value.Kind == yaml.ScalarNode && value.Tag == "!!null":
+		return render(lines, key.Line, indent(stanza(unit), unit)), nil
+	case value.Kind != yaml.MappingNode || value.Style&yaml.FlowStyle != 0:
+		return nil, fmt.Errorf("images is not a block mapping; add the image by hand")
+	}
+	if len(value.Content) > 0 {
+		unit = strings.Repeat(" ", value.Content[0].Column-1)
+	}
+
+	// The images end before the next top-level key and the blank or comment
+	// lines leading up to it.
+	at := len(lines)
+	if images+2 < len(mapping.Content) {
+		at = mapping.Content[images+2].Line - 1
+		for at > key.Line && (strings.TrimSpace(lines[at-1]) == "" || strings.HasPrefix(lines[at-1], "#")) {
+			at--
+		}
+	} else {
+		for at > key.Line && strings.TrimSpace(lines[at-1]) == "" {
+			at--
+		}
+	}
+
+	added := indent(stanza(unit), unit)
+	if len(value.Content) >= 4 {
+		if second := value.Content[2].Line; second >= 2 && strings.TrimSpace(lines[second-2]) == "" {
+			added = append([]string{""}, added...)
+		}
+	}
+	return render(lines, at, added), nil
+}
+
+// indent prefixes every line with prefix.
+func indent(lines []string, prefix string) []string {
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = prefix + line
+	}
+	return out
+}
+
+// render inserts added after the first at lines.
+func render(lines []string, at int, added []string) []byte {
+	var b bytes.Buffer
+	for _, line := range lines[:at] {
+		b.WriteString(line)
+	}
+	for _, line := range added {
+		b.WriteString(line + "\n")
+	}
+	for _, line := range lines[at:] {
+		b.WriteString(line)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,166 @@
+package scaffold
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestAppendImage(t *testing.T) {
+	stanza := func(unit string) []string {
+		return []string{"jq:", unit + "versions:", unit + unit + `"1.7": {}`}
+	}
+
+	tests := []struct {
+		name     string
+		manifest string
+		want     string
+		wantErr  string
+	}{
+		{
+			name: "spaced images followed by a key",
+			manifest: `version: 1
+images:
+  core:
+    versions:
+      noble: {}
+
+  yq:
+    versions:
+      "4": {}
+
+# Split manifests
+includes: ["*/manifest.yaml"]
+`,
+			want: `version: 1
+images:
+  core:
+    versions:
+      noble: {}
+
+  yq:
+    versions:
+      "4": {}
+
+  jq:
+    versions:
+      "1.7": {}
+
+# Split manifests
+includes: ["*/manifest.yaml"]
+`,
+		},
+		{
+			name:     "four-space indent at end of file",
+			manifest: "version: 1\nimages:\n    core:\n        versions: {}\n",
+			want:     "version: 1\nimages:\n    core:\n        versions: {}\n    jq:\n        versions:\n            \"1.7\": {}\n",
+		},
+		{
+			name:     "no trailing newline",
+			manifest: "version: 1\nimages:\n  core: {}",
+			want:     "version: 1\nimages:\n  core: {}\n  jq:\n    versions:\n      \"1.7\": {}\n",
+		},
+		{
+			name:     "empty images",
+			manifest: "version: 1\nimages:\ndefaults: {}\n",
+			want:     "version: 1\nimages:\n  jq:\n    versions:\n      \"1.7\": {}\ndefaults: {}\n",
+		},
+		{
+			name:     "no images",
+			manifest: "version: 1\n",
+			want:     "version: 1\nimages:\n  jq:\n    versions:\n      \"1.7\": {}\n",
+		},
+		{
+			name:     "flow images",
+			manifest: "version: 1\nimages: {}\n",
+			wantErr:  "images is not a block mapping",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := appendImage([]byte(tt.manifest), stanza)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("appendImage() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("appendImage() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("appendImage() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPlan(t *testing.T) {
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "manifest.yaml")
+	manifest := "version: 1\nimages:\n  core:\n    path: base/core\n    versions:\n      noble: {}\n"
+	if err := os.WriteFile(manifestPath, []byte(manifest), 0644); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	cfg, err := config.Load(manifestPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	plan, err := NewPlan(cfg, Image{Name: "jq", Version: "1.7", Base: "core:noble", Path: "util/jq"})
+	if err != nil {
+		t.Fatalf("NewPlan() error = %v", err)
+	}
+	if got, _ := os.ReadFile(manifestPath); string(got) != manifest {
+		t.Error("NewPlan() should not change the manifest")
+	}
+	if !strings.Contains(plan.Diff(), "+  jq:\n+    path: util/jq\n") {
+		t.Errorf("Diff() = %s, want the new image", plan.Diff())
+	}
+
+	if err := Apply(plan); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	cfg, err = config.Load(manifestPath)
+	if err != nil {
+		t.Fatalf("Load() after Apply() error = %v", err)
+	}
+	jq := cfg.Images["jq"]
+	if jq.Path != "util/jq" || jq.Defaults.BaseImage.Name != "core:noble" || jq.Defaults.BaseImage.Source != "" || jq.Versions["1.7"] == nil {
+		t.Errorf("jq = %+v, want the scaffolded image", jq)
+	}
+	template, err := os.ReadFile(filepath.Join(dir, "util", "jq", "source", "Dockerfile.tmpl"))
+	if err != nil || !strings.Contains(string(template), "from_image") {
+		t.Errorf("Dockerfile.tmpl = %q, %v", template, err)
+	}
+
+	for _, img := range []Image{
+		{Name: "jq", Version: "1.7", Base: "alpine:3.20"},
+		{Name: "Bad Name", Version: "1", Base: "alpine"},
+	} {
+		if _, err := NewPlan(cfg, img); err == nil {
+			t.Errorf("NewPlan(%s) should fail", img.Name)
+		}
+	}
+}
+
+func TestBaseSource(t *testing.T) {
+	cfg := &config.Config{Images: map[string]config.Image{"core": {}}}
+	tests := map[string]string{
+		"core:noble":                   "",
+		"alpine:3.20":                  config.SourceDockerHub,
+		"library/alpine:3.20":          config.SourceDockerHub,
+		"mcr.microsoft.com/dotnet:8.0": config.SourceExternal,
+		"localhost:5000/app:1":         config.SourceExternal,
+		config.SourceScratch:           config.SourceScratch,
+	}
+	for base, want := range tests {
+		if got := baseSource(cfg, base); got != want {
+			t.Errorf("baseSource(%s) = %q, want %q", base, got, want)
+		}
+	}
+}
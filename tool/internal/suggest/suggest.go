@@ -0,0 +1,61 @@
+package suggest
+
+import (
+	"sort"
+	"strings"
+)
+
+// Closest returns up to limit candidates that are within a reasonable edit
+// distance of target, best match first. Ties are broken alphabetically.
+func Closest(target string, candidates []string, limit int) []string {
+	type scored struct {
+		candidate string
+		distance  int
+	}
+
+	threshold := len(target)/3 + 1
+	var matches []scored
+	for _, candidate := range candidates {
+		distance := Distance(strings.ToLower(target), strings.ToLower(candidate))
+		if distance <= threshold || strings.HasPrefix(candidate, target) {
+			matches = append(matches, scored{candidate: candidate, distance: distance})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].candidate < matches[j].candidate
+	})
+
+	var result []string
+	for i := 0; i < len(matches) && i < limit; i++ {
+		result = append(result, matches[i].candidate)
+	}
+	return result
+}
+
+// Distance returns the Levenshtein edit distance between a and b.
+func Distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
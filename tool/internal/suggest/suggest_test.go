@@ -0,0 +1,49 @@
+package suggest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClosest(t *testing.T) {
+	candidates := []string{"core", "corretto", "golang", "python", "python:3.12", "python:3.13"}
+
+	tests := []struct {
+		name   string
+		target string
+		limit  int
+		want   []string
+	}{
+		{name: "typo", target: "pyton", limit: 3, want: []string{"python"}},
+		{name: "prefix", target: "python:3", limit: 3, want: []string{"python", "python:3.12", "python:3.13"}},
+		{name: "limit", target: "cor", limit: 1, want: []string{"core"}},
+		{name: "no match", target: "kubernetes", limit: 3, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Closest(tt.target, candidates, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Closest(%q) = %v, want %v", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"port", "port", 0},
+		{"prot", "port", 2},
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+	}
+
+	for _, tt := range tests {
+		if got := Distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("Distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
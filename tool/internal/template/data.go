@@ -1,17 +1,55 @@
 package template
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
+	"path"
 	"strings"
 	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/apex/log"
 
 	"github.com/mberwanger/dockerfiles/tool/internal/config"
 )
 
+// Data is the immutable input to rendering a version's templates. Once
+// configured through its setters it is only read, so the same Data may be
+// rendered by multiple goroutines at once; state that changes while a
+// template executes lives in a renderState created for each Render call.
 type Data struct {
 	Values            map[string]interface{}
-	rootPathIncluded  bool
 	generationMessage string
+	reproducibility   config.ReproducibilityMode
+	outputDigest      func(name string) (string, error)
+	inlineLookup      func(name string) (string, os.FileMode, error)
+	headerStyles      config.HeaderStyles
+	// strict fails rendering on calls to undefined values instead of
+	// rendering them empty.
+	strict      bool
+	partialsDir string
+}
+
+// renderState holds the mutable state of a single template execution.
+type renderState struct {
+	data *Data
+	// output is the name of the file being rendered, which selects the
+	// comment style of generation_message.
+	output           string
+	rootPathIncluded bool
+	// guards are the names passed to once so far.
+	guards map[string]bool
+	// funcs are the functions of this render, shared with included partials.
+	funcs template.FuncMap
+	// templates are the paths of the template being rendered and the
+	// partials it is currently including, outermost first.
+	templates []string
+}
+
+func (d *Data) newRenderState() *renderState {
+	return &renderState{data: d, guards: make(map[string]bool)}
 }
 
 func NewData(mergedConfig *config.ImageConfig, imageName string) *Data {
@@ -29,43 +67,375 @@ func NewData(mergedConfig *config.ImageConfig, imageName string) *Data {
 
 	return &Data{
 		Values:            data,
-		rootPathIncluded:  false,
 		generationMessage: generateMessage(imageName),
+		reproducibility:   config.ReproducibilityMode{Enabled: true},
+		strict:            true,
+	}
+}
+
+// SetPartialsDir sets the directory include reads shared partials from.
+func (d *Data) SetPartialsDir(dir string) {
+	d.partialsDir = dir
+}
+
+// SetStrict configures whether templates calling undefined values fail to
+// render, suggesting the closest value names, or render them empty with a
+// warning. Data is strict unless told otherwise.
+func (d *Data) SetStrict(strict bool) {
+	d.strict = strict
+}
+
+// SetReproducibility configures how time-derived helpers such as
+// build_timestamp behave. Data is reproducible unless told otherwise.
+func (d *Data) SetReproducibility(mode config.ReproducibilityMode) {
+	d.reproducibility = mode
+}
+
+// SetOutputDigests provides the lookup behind output_sha256, which returns the
+// SHA-256 of another file generated into the same version directory.
+func (d *Data) SetOutputDigests(lookup func(name string) (string, error)) {
+	d.outputDigest = lookup
+}
+
+// SetInlineFiles provides the lookup behind inline_file, which returns the
+// content and permissions of a file generated into the same version directory
+// that is embedded in the Dockerfile instead of being written.
+func (d *Data) SetInlineFiles(lookup func(name string) (string, os.FileMode, error)) {
+	d.inlineLookup = lookup
+}
+
+// SetHeaderStyles configures the comment style of the generated-file header
+// per output file extension.
+func (d *Data) SetHeaderStyles(styles config.HeaderStyles) {
+	d.headerStyles = styles
+}
+
+// OverridesMarker prefixes the header line listing CLI overrides, so files
+// that cannot be reproduced from the manifest alone are easy to detect.
+const OverridesMarker = "# overrides-applied:"
+
+// InputsMarker prefixes the header line recording the hash of everything a
+// version was generated from, so unchanged versions can be detected without
+// rendering them again.
+const InputsMarker = "# inputs-sha256:"
+
+// SetInputsHash records the inputs hash of the version in the generation
+// header.
+func (d *Data) SetInputsHash(hash string) {
+	d.generationMessage = fmt.Sprintf("%s\n#\n%s %s", d.generationMessage, InputsMarker, hash)
+}
+
+// SetOverrides records that keys were overridden from the command line in the
+// generation header.
+func (d *Data) SetOverrides(keys []string) {
+	d.generationMessage = fmt.Sprintf("%s\n#\n%s %s", d.generationMessage, OverridesMarker, strings.Join(keys, ", "))
+}
+
+// functions builds the FuncMap for one render: the built-in helpers, the
+// string helpers and a zero-argument function for every value.
+func (s *renderState) functions() template.FuncMap {
+	d := s.data
+	fn := template.FuncMap{
+		"generation_message":     func() string { return d.GenerationMessageFor(s.output) },
+		"generation_message_for": d.GenerationMessageFor,
+		"from_image":             s.fromImage,
+		"copy_from":              s.copyFrom,
+		"include":                s.include,
+		"dict":                   dict,
+		"get":                    d.get,
+		"has":                    d.has,
+		"default":                defaultValue,
+		"required":               required,
+		"build_timestamp":        d.buildTimestamp,
+		"vendor_path":            vendorPath,
+		"output_sha256":          d.outputSHA256,
+		"once":                   s.once,
+		"inline_file":            d.inlineFile,
+		"apt_install":            aptInstall,
+		"apk_add":                apkAdd,
+	}
+	for name, helper := range stringFunctions {
+		fn[name] = helper
+	}
+
+	for key, value := range d.Values {
+		name := functionName(key)
+		if _, taken := d.Values[name]; taken && name != key {
+			// A key already spelled as that identifier keeps the name.
+			continue
+		}
+		if _, shadowed := stringFunctions[name]; shadowed {
+			log.Debugf("value %q shadows the %s template function", key, name)
+		}
+		fn[name] = valueFunction(value)
+	}
+
+	// Instruction helpers share their names with the values they read, so
+	// they are registered last; the raw values remain available through get.
+	fn["expose"] = d.expose
+	fn["volumes"] = d.volumes
+	fn["workdir"] = d.workdir
+
+	return fn
+}
+
+// once reports whether name is guarded for the first time in this output
+// file, so that {{if once "ca-certs"}}...{{end}} renders a shared partial only
+// on its first inclusion.
+func (s *renderState) once(name string) (bool, error) {
+	if name == "" {
+		return false, fmt.Errorf("once requires a non-empty name")
 	}
+	if s.guards[name] {
+		return false, nil
+	}
+	s.guards[name] = true
+	return true, nil
 }
 
-func (d *Data) functions() template.FuncMap {
-	return template.FuncMap{
-		"generation_message": func() string { return d.generationMessage },
-		"from_image": func(arg interface{}) string {
-			if argStr, ok := arg.(string); ok {
-				if val, exists := d.Values[argStr]; exists {
-					return d.fromImage(val)
-				}
+// valueFunction returns a zero-argument function returning value with its
+// concrete type, so that conditions, range and comparisons work on it.
+func valueFunction(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return func() string { return v }
+	case int:
+		return func() int { return v }
+	case int64:
+		return func() int64 { return v }
+	case uint64:
+		return func() uint64 { return v }
+	case float64:
+		return func() float64 { return v }
+	case bool:
+		return func() bool { return v }
+	case []interface{}:
+		return func() []interface{} { return v }
+	case map[string]interface{}:
+		return func() map[string]interface{} { return v }
+	default:
+		return func() interface{} { return v }
+	}
+}
+
+// functionName maps a value key to the template function it is available
+// as. Keys that are not valid identifiers, such as extra-packages, have every
+// other character replaced with an underscore (extra_packages) and gain a
+// leading underscore if they start with a digit; get reaches them by the
+// original key.
+func functionName(key string) string {
+	var name strings.Builder
+	for i, r := range key {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+			name.WriteRune(r)
+		case unicode.IsDigit(r):
+			if i == 0 {
+				name.WriteByte('_')
 			}
-			return d.fromImage(arg)
-		},
-		"get": d.get,
+			name.WriteRune(r)
+		default:
+			name.WriteByte('_')
+		}
 	}
+	if name.Len() == 0 {
+		return "_"
+	}
+	return name.String()
 }
 
+// get returns the value at key, or nil when there is none. A key is first
+// matched exactly, so keys containing dots stay reachable; otherwise a
+// dotted path such as "build.flags" walks nested maps.
 func (d *Data) get(key string) interface{} {
-	return d.Values[key]
+	value, _ := d.lookup(key)
+	return value
+}
+
+// has reports whether get finds a value at key, for conditionals such as
+// {{if has "build.flags"}}.
+func (d *Data) has(key string) bool {
+	_, found := d.lookup(key)
+	return found
+}
+
+func (d *Data) lookup(key string) (interface{}, bool) {
+	if value, exists := d.Values[key]; exists {
+		return value, true
+	}
+	if !strings.Contains(key, ".") {
+		return nil, false
+	}
+
+	var current interface{} = d.Values
+	for _, segment := range strings.Split(key, ".") {
+		m, isMap := current.(map[string]interface{})
+		if !isMap {
+			return nil, false
+		}
+		value, exists := m[segment]
+		if !exists {
+			return nil, false
+		}
+		current = value
+	}
+	return current, true
+}
+
+// missing reports whether a template argument has no value: nil, as get
+// returns for unset keys, or the empty string. Zero numbers and false are
+// values.
+func missing(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	s, isString := value.(string)
+	return isString && s == ""
+}
+
+// defaultValue returns value, or fallback when value is missing, e.g.
+// {{default "3.19" (get "alpine_version")}}.
+func defaultValue(fallback, value interface{}) interface{} {
+	if missing(value) {
+		return fallback
+	}
+	return value
+}
+
+// requiredError is returned by required; Render reports its message with the
+// template path instead of text/template's call site.
+type requiredError struct {
+	message string
+}
+
+func (e *requiredError) Error() string {
+	return e.message
 }
 
-func (d *Data) fromImage(baseImage interface{}) string {
-	var imageName, imageSource string
+// required returns value, or fails rendering with message when it is
+// missing, e.g. {{required "port must be set" (get "port")}}.
+func required(message string, value interface{}) (interface{}, error) {
+	if missing(value) {
+		return nil, &requiredError{message: message}
+	}
+	return value, nil
+}
+
+// dict builds a map from alternating keys and values, e.g. an image for
+// from_image: {{from_image (dict "name" "golang:1.22" "source" "dockerhub")}}.
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict takes pairs of keys and values, got %d arguments", len(pairs))
+	}
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is not a string", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
+
+// buildTimestamp formats the generation time using the optional Go time
+// layout (RFC 3339 by default). In reproducible mode the time comes from
+// defaults.source_date_epoch and rendering fails when it is unset.
+func (d *Data) buildTimestamp(layout ...string) (string, error) {
+	format := time.RFC3339
+	if len(layout) > 0 {
+		format = layout[0]
+	}
+
+	var ts time.Time
+	switch {
+	case d.reproducibility.SourceDateEpoch != nil:
+		ts = time.Unix(*d.reproducibility.SourceDateEpoch, 0)
+	case d.reproducibility.Enabled:
+		return "", fmt.Errorf("build_timestamp requires defaults.source_date_epoch in reproducible mode (or run with --reproducible=false)")
+	default:
+		ts = time.Now()
+	}
+
+	return ts.UTC().Format(format), nil
+}
+
+func (d *Data) outputSHA256(name string) (string, error) {
+	if d.outputDigest == nil {
+		return "", fmt.Errorf("output_sha256 is only available when generating a version")
+	}
+	return d.outputDigest(name)
+}
+
+// VendorDir is the version-relative directory that vendored shared files are
+// copied into so that COPY instructions can reach them inside the build context.
+const VendorDir = "_vendor"
+
+// vendorPath returns the in-context path of a vendored file or directory, e.g.
+// vendor_path "certs" for files vendored from "shared/certs/**".
+func vendorPath(name string) string {
+	return path.Join(VendorDir, name)
+}
+
+// fromImage renders the FROM line for an image as imageReference resolves
+// it. A "platform" key of a map image or a "platform=<platform>" option, such
+// as "platform=$BUILDPLATFORM", adds a --platform flag; the option wins.
+func (s *renderState) fromImage(baseImage interface{}, options ...string) (string, error) {
+	platform := s.data.imagePlatform(baseImage)
+	for _, option := range options {
+		key, value, ok := strings.Cut(option, "=")
+		if !ok || key != "platform" {
+			return "", fmt.Errorf("from_image: unsupported option %q (supported: platform=<platform>)", option)
+		}
+		platform = value
+	}
+	if platform != "" && !validShellWord(platform) {
+		return "", fmt.Errorf("from_image: invalid platform %q", platform)
+	}
+
+	ref, preamble := s.imageReference(baseImage)
+	if platform != "" {
+		ref = "--platform=" + platform + " " + ref
+	}
+	return preamble + "FROM " + ref, nil
+}
+
+// imagePlatform returns the "platform" key of a map image, following value
+// keys the way imageReference does.
+func (d *Data) imagePlatform(baseImage interface{}) string {
+	switch v := baseImage.(type) {
+	case string:
+		if val, exists := d.Values[v]; exists {
+			return d.imagePlatform(val)
+		}
+	case map[string]interface{}:
+		if platform, ok := v["platform"].(string); ok {
+			return platform
+		}
+	}
+	return ""
+}
+
+// imageReference resolves an image value, a value key naming one or a plain
+// image reference, to the reference to build from, pinned as name@digest
+// when the value has a digest. Images of the manifest's registry are
+// referenced through ${REGISTRY}, and preamble is then the ARG REGISTRY line
+// (or an error comment) when the file has not declared it.
+func (s *renderState) imageReference(baseImage interface{}) (ref, preamble string) {
+	d := s.data
+	var imageName, imageSource, digest string
 
 	switch v := baseImage.(type) {
 	case string:
 		if val, exists := d.Values[v]; exists {
-			return d.fromImage(val)
+			return s.imageReference(val)
 		}
 		imageName = v
 		imageSource = ""
 	case *config.BaseImage:
 		imageName = v.Name
 		imageSource = v.Source
+		digest = v.Digest
 	case map[string]interface{}:
 		if name, ok := v["name"].(string); ok {
 			imageName = name
@@ -73,50 +443,143 @@ func (d *Data) fromImage(baseImage interface{}) string {
 		if source, ok := v["source"].(string); ok {
 			imageSource = source
 		}
+		if pinned, ok := v["digest"].(string); ok {
+			digest = pinned
+		}
 	default:
 		imageName = fmt.Sprintf("%v", baseImage)
 	}
+	if digest != "" {
+		imageName += "@" + digest
+	}
 
-	if imageSource == "dockerhub" {
-		return fmt.Sprintf("FROM %s", imageName)
+	switch imageSource {
+	case config.SourceScratch:
+		return "scratch", ""
+	case config.SourceDockerHub, config.SourceExternal:
+		return imageName, ""
 	}
 
-	needsRegistryArg := imageSource != "dockerhub" && !d.rootPathIncluded
-	needsRegistryPath := imageSource != "dockerhub"
+	imagePath := fmt.Sprintf("${REGISTRY}/%s", imageName)
+	if s.rootPathIncluded {
+		return imagePath, ""
+	}
 
-	var imagePath string
-	if needsRegistryPath {
-		imagePath = fmt.Sprintf("${REGISTRY}/%s", imageName)
-	} else {
-		imagePath = imageName
+	registryVal, exists := d.Values["registry"]
+	if !exists {
+		return imagePath, "# ERROR: registry not set in config\n"
+	}
+	registry, ok := registryVal.(string)
+	if !ok {
+		return imagePath, "# ERROR: registry is not a string\n"
 	}
+	s.rootPathIncluded = true
+	return imagePath, fmt.Sprintf("ARG REGISTRY=%s\n", registry)
+}
 
-	var result strings.Builder
-	if needsRegistryArg {
-		registryVal, exists := d.Values["registry"]
-		if !exists {
-			return fmt.Sprintf("# ERROR: registry not set in config\nFROM %s", imagePath)
+// copyFrom renders a COPY --from instruction copying paths, the sources
+// followed by the destination, out of an image resolved as from_image does,
+// so that the workflow's dependency parser sees the image. An ARG REGISTRY
+// declared before the first FROM is only in scope for FROM lines, so when the
+// file has already declared it the instruction redeclares it for its stage.
+func (s *renderState) copyFrom(image interface{}, paths ...string) (string, error) {
+	if len(paths) < 2 {
+		return "", fmt.Errorf("copy_from takes an image, one or more sources and a destination")
+	}
+	jsonForm := false
+	for _, p := range paths {
+		if p == "" || strings.Contains(p, "\n") {
+			return "", fmt.Errorf("copy_from: invalid path %q", p)
 		}
+		jsonForm = jsonForm || strings.ContainsAny(p, " \t")
+	}
 
-		registry, ok := registryVal.(string)
-		if !ok {
-			return fmt.Sprintf("# ERROR: registry is not a string\nFROM %s", imagePath)
+	included := s.rootPathIncluded
+	ref, preamble := s.imageReference(image)
+	if ref == "scratch" {
+		return "", fmt.Errorf("copy_from: cannot copy from scratch")
+	}
+	if included && strings.HasPrefix(ref, "${REGISTRY}/") {
+		preamble = "ARG REGISTRY\n"
+	}
+
+	arguments := strings.Join(paths, " ")
+	if jsonForm {
+		encoded, err := json.Marshal(paths)
+		if err != nil {
+			return "", err
 		}
-		result.WriteString(fmt.Sprintf("ARG REGISTRY=%s\n", registry))
+		arguments = string(encoded)
+	}
+	return fmt.Sprintf("%sCOPY --from=%s %s", preamble, ref, arguments), nil
+}
+
+// GeneratedMarker opens the header of every file the tool generates.
+const GeneratedMarker = "# GENERATED FILE, DO NOT MODIFY!"
+
+// IsGenerated reports whether content carries GeneratedMarker in its leading
+// block of comments and blank lines, where a syntax directive may precede it.
+func IsGenerated(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == GeneratedMarker {
+			return true
+		}
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+	return false
+}
 
-		d.rootPathIncluded = true
+// GenerationMessageFor returns the generated-file header commented in the
+// style configured for the extension of name, which may be an extension
+// alone such as ".json".
+func (d *Data) GenerationMessageFor(name string) string {
+	style := d.headerStyles.For(name)
+	if style == config.HeaderHash {
+		return d.generationMessage
+	}
+	if style == config.HeaderNone {
+		return ""
+	}
+
+	lines := strings.Split(d.generationMessage, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(strings.TrimPrefix(line, "#"), " ")
 	}
-	result.WriteString(fmt.Sprintf("FROM %s", imagePath))
 
-	return result.String()
+	var b strings.Builder
+	switch style {
+	case config.HeaderSlash:
+		for i, line := range lines {
+			if i > 0 {
+				b.WriteByte('\n')
+			}
+			b.WriteString(strings.TrimRight("// "+line, " "))
+		}
+	case config.HeaderBlock:
+		b.WriteString("/*\n")
+		for _, line := range lines {
+			b.WriteString(strings.TrimRight(" * "+line, " ") + "\n")
+		}
+		b.WriteString(" */")
+	case config.HeaderXML:
+		b.WriteString("<!--\n")
+		for _, line := range lines {
+			b.WriteString(strings.TrimRight("  "+line, " ") + "\n")
+		}
+		b.WriteString("-->")
+	}
+	return b.String()
 }
 
 func generateMessage(imageName string) string {
-	return fmt.Sprintf(`# GENERATED FILE, DO NOT MODIFY!
+	return fmt.Sprintf(`%s
 #
 # To update this file please edit the relevant template file and run:
 #   go run tool/main.go generate image %s
 #
 # Or regenerate all images with:
-#   go run tool/main.go generate all`, imageName)
+#   go run tool/main.go generate all`, GeneratedMarker, imageName)
 }
@@ -101,11 +101,6 @@ func TestNewData(t *testing.T) {
 			if !strings.Contains(data.generationMessage, tt.imageName) {
 				t.Errorf("generationMessage should contain image name %s", tt.imageName)
 			}
-
-			// Check rootPathIncluded is initialized to false
-			if data.rootPathIncluded {
-				t.Error("rootPathIncluded should be false initially")
-			}
 		})
 	}
 }
@@ -116,6 +111,12 @@ func TestData_get(t *testing.T) {
 			"string_key": "string_value",
 			"int_key":    42,
 			"bool_key":   true,
+			"build": map[string]interface{}{
+				"flags":  "-O2",
+				"target": map[string]interface{}{"arch": "arm64"},
+			},
+			"build.flags": "-O3",
+			"release":     "1.0",
 		},
 	}
 
@@ -124,6 +125,26 @@ func TestData_get(t *testing.T) {
 		key  string
 		want interface{}
 	}{
+		{
+			name: "nested value",
+			key:  "build.target.arch",
+			want: "arm64",
+		},
+		{
+			name: "dotted key matched exactly first",
+			key:  "build.flags",
+			want: "-O3",
+		},
+		{
+			name: "missing nested segment",
+			key:  "build.missing.arch",
+			want: nil,
+		},
+		{
+			name: "path through a non-map",
+			key:  "release.major",
+			want: nil,
+		},
 		{
 			name: "string value",
 			key:  "string_key",
@@ -152,22 +173,25 @@ func TestData_get(t *testing.T) {
 			if got != tt.want {
 				t.Errorf("get(%s) = %v, want %v", tt.key, got, tt.want)
 			}
+			if has := data.has(tt.key); has != (tt.want != nil) {
+				t.Errorf("has(%s) = %v, want %v", tt.key, has, tt.want != nil)
+			}
 		})
 	}
 }
 
-func TestData_fromImage(t *testing.T) {
+func TestRenderState_fromImage(t *testing.T) {
 	tests := []struct {
-		name      string
-		data      *Data
-		baseImage interface{}
-		want      string
+		name             string
+		data             *Data
+		rootPathIncluded bool
+		baseImage        interface{}
+		want             string
 	}{
 		{
 			name: "BaseImage with dockerhub source",
 			data: &Data{
-				Values:           map[string]interface{}{},
-				rootPathIncluded: false,
+				Values: map[string]interface{}{},
 			},
 			baseImage: &config.BaseImage{
 				Name:   "ubuntu:20.04",
@@ -181,7 +205,6 @@ func TestData_fromImage(t *testing.T) {
 				Values: map[string]interface{}{
 					"registry": "my-registry.io",
 				},
-				rootPathIncluded: false,
 			},
 			baseImage: &config.BaseImage{
 				Name:   "myimage",
@@ -195,8 +218,8 @@ func TestData_fromImage(t *testing.T) {
 				Values: map[string]interface{}{
 					"registry": "my-registry.io",
 				},
-				rootPathIncluded: true,
 			},
+			rootPathIncluded: true,
 			baseImage: &config.BaseImage{
 				Name:   "anotherimage",
 				Source: "custom",
@@ -209,7 +232,6 @@ func TestData_fromImage(t *testing.T) {
 				Values: map[string]interface{}{
 					"registry": "test.io",
 				},
-				rootPathIncluded: false,
 			},
 			baseImage: "alpine:latest",
 			want:      "ARG REGISTRY=test.io\nFROM ${REGISTRY}/alpine:latest",
@@ -220,7 +242,6 @@ func TestData_fromImage(t *testing.T) {
 				Values: map[string]interface{}{
 					"registry": "test.io",
 				},
-				rootPathIncluded: false,
 			},
 			baseImage: map[string]interface{}{
 				"name":   "testimage",
@@ -237,16 +258,75 @@ func TestData_fromImage(t *testing.T) {
 						Source: "dockerhub",
 					},
 				},
-				rootPathIncluded: false,
 			},
 			baseImage: "my_base",
 			want:      "FROM ubuntu",
 		},
+		{
+			name: "digest after the tag",
+			data: &Data{
+				Values: map[string]interface{}{
+					"registry": "test.io",
+				},
+			},
+			baseImage: &config.BaseImage{
+				Name:   "core:noble",
+				Digest: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			},
+			want: "ARG REGISTRY=test.io\nFROM ${REGISTRY}/core:noble@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		},
+		{
+			name: "digest without a tag",
+			data: &Data{
+				Values: map[string]interface{}{},
+			},
+			baseImage: map[string]interface{}{
+				"name":   "alpine",
+				"source": "dockerhub",
+				"digest": "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			},
+			want: "FROM alpine@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		},
+		{
+			name: "scratch source",
+			data: &Data{
+				Values: map[string]interface{}{
+					"registry": "test.io",
+				},
+			},
+			baseImage: &config.BaseImage{
+				Name:   "scratch",
+				Source: "scratch",
+			},
+			want: "FROM scratch",
+		},
+		{
+			name: "scratch source without a name",
+			data: &Data{
+				Values: map[string]interface{}{},
+			},
+			baseImage: map[string]interface{}{
+				"source": "scratch",
+			},
+			want: "FROM scratch",
+		},
+		{
+			name: "external source is used verbatim",
+			data: &Data{
+				Values: map[string]interface{}{
+					"registry": "test.io",
+				},
+			},
+			baseImage: &config.BaseImage{
+				Name:   "mcr.microsoft.com/windows/nanoserver:ltsc2022",
+				Source: "external",
+			},
+			want: "FROM mcr.microsoft.com/windows/nanoserver:ltsc2022",
+		},
 		{
 			name: "no registry set when needed",
 			data: &Data{
-				Values:           map[string]interface{}{},
-				rootPathIncluded: false,
+				Values: map[string]interface{}{},
 			},
 			baseImage: &config.BaseImage{
 				Name:   "myimage",
@@ -260,7 +340,6 @@ func TestData_fromImage(t *testing.T) {
 				Values: map[string]interface{}{
 					"registry": 12345,
 				},
-				rootPathIncluded: false,
 			},
 			baseImage: &config.BaseImage{
 				Name:   "myimage",
@@ -272,7 +351,11 @@ func TestData_fromImage(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := tt.data.fromImage(tt.baseImage)
+			state := &renderState{data: tt.data, rootPathIncluded: tt.rootPathIncluded}
+			got, err := state.fromImage(tt.baseImage)
+			if err != nil {
+				t.Fatalf("fromImage() error = %v", err)
+			}
 			if got != tt.want {
 				t.Errorf("fromImage() = %q, want %q", got, tt.want)
 			}
@@ -280,7 +363,7 @@ func TestData_fromImage(t *testing.T) {
 	}
 }
 
-func TestData_functions(t *testing.T) {
+func TestRenderState_functions(t *testing.T) {
 	mergedConfig := &config.ImageConfig{
 		BaseImage: &config.BaseImage{
 			Name:   "ubuntu",
@@ -293,7 +376,7 @@ func TestData_functions(t *testing.T) {
 	}
 
 	data := NewData(mergedConfig, "testapp")
-	funcMap := data.functions()
+	funcMap := data.newRenderState().functions()
 
 	// Test generation_message function
 	if genMsgFunc, ok := funcMap["generation_message"]; ok {
@@ -314,20 +397,20 @@ func TestData_functions(t *testing.T) {
 
 	// Test from_image function
 	if fromImageFunc, ok := funcMap["from_image"]; ok {
-		if fn, ok := fromImageFunc.(func(interface{}) string); ok {
+		if fn, ok := fromImageFunc.(func(interface{}, ...string) (string, error)); ok {
 			// Test with base_image key
-			result := fn("base_image")
+			result, _ := fn("base_image")
 			if !strings.Contains(result, "FROM") {
 				t.Errorf("from_image(\"base_image\") should contain FROM, got: %s", result)
 			}
 
 			// Test with direct BaseImage
-			result2 := fn(&config.BaseImage{Name: "alpine", Source: "dockerhub"})
+			result2, _ := fn(&config.BaseImage{Name: "alpine", Source: "dockerhub"})
 			if result2 != "FROM alpine" {
 				t.Errorf("from_image(BaseImage) = %s, want FROM alpine", result2)
 			}
 		} else {
-			t.Error("from_image is not a func(interface{}) string")
+			t.Error("from_image is not a func(interface{}, ...string) (string, error)")
 		}
 	} else {
 		t.Error("from_image function not found")
@@ -342,6 +425,91 @@ func TestData_functions(t *testing.T) {
 	}
 }
 
+func TestData_buildTimestamp(t *testing.T) {
+	epoch := int64(1700000000)
+
+	tests := []struct {
+		name    string
+		mode    config.ReproducibilityMode
+		layout  []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "reproducible without epoch fails",
+			mode:    config.ReproducibilityMode{Enabled: true},
+			wantErr: true,
+		},
+		{
+			name: "reproducible with epoch",
+			mode: config.ReproducibilityMode{Enabled: true, SourceDateEpoch: &epoch},
+			want: "2023-11-14T22:13:20Z",
+		},
+		{
+			name:   "custom layout",
+			mode:   config.ReproducibilityMode{Enabled: true, SourceDateEpoch: &epoch},
+			layout: []string{"20060102"},
+			want:   "20231114",
+		},
+		{
+			name: "epoch wins when not reproducible",
+			mode: config.ReproducibilityMode{Enabled: false, SourceDateEpoch: &epoch},
+			want: "2023-11-14T22:13:20Z",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := NewData(&config.ImageConfig{Values: map[string]interface{}{}}, "testapp")
+			data.SetReproducibility(tt.mode)
+
+			got, err := data.buildTimestamp(tt.layout...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildTimestamp() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("buildTimestamp() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("uses current time when not reproducible", func(t *testing.T) {
+		data := NewData(&config.ImageConfig{Values: map[string]interface{}{}}, "testapp")
+		data.SetReproducibility(config.ReproducibilityMode{Enabled: false})
+
+		got, err := data.buildTimestamp()
+		if err != nil {
+			t.Fatalf("buildTimestamp() error = %v", err)
+		}
+		if got == "" {
+			t.Error("buildTimestamp() should not return empty string")
+		}
+	})
+}
+
+func TestData_SetOverrides(t *testing.T) {
+	data := NewData(&config.ImageConfig{Values: map[string]interface{}{}}, "testapp")
+	data.SetOverrides([]string{"build.flags", "registry"})
+
+	msg := data.newRenderState().functions()["generation_message"].(func() string)()
+	if !strings.HasPrefix(msg, "# GENERATED FILE, DO NOT MODIFY!") {
+		t.Errorf("generation message should keep the standard header, got %q", msg)
+	}
+	if !strings.HasSuffix(msg, OverridesMarker+" build.flags, registry") {
+		t.Errorf("generation message should list the overridden keys, got %q", msg)
+	}
+}
+
+func TestData_SetInputsHash(t *testing.T) {
+	data := NewData(&config.ImageConfig{Values: map[string]interface{}{}}, "testapp")
+	data.SetInputsHash("abc123")
+
+	msg := data.newRenderState().functions()["generation_message"].(func() string)()
+	if !strings.HasSuffix(msg, "#\n"+InputsMarker+" abc123") {
+		t.Errorf("generation message should end with the inputs hash, got %q", msg)
+	}
+}
+
 func TestGenerateMessage(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -393,16 +561,50 @@ func TestGenerateMessage(t *testing.T) {
 	}
 }
 
-func TestData_fromImage_RootPathIncluded(t *testing.T) {
+func TestIsGenerated(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"dockerfile header", generateMessage("python") + "\nFROM alpine\n", true},
+		{"after syntax directive", "# syntax=docker/dockerfile:1.7\n" + generateMessage("python") + "\n", true},
+		{"workflow header", GeneratedMarker + "\n#\nname: Build\n", true},
+		{"hand written", "name: Build\n", false},
+		{"marker after content", "name: Build\n" + GeneratedMarker + "\n", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsGenerated([]byte(tt.content)); got != tt.want {
+				t.Errorf("IsGenerated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderState_fromImage_ScratchKeepsRegistryArg(t *testing.T) {
+	state := &renderState{data: &Data{Values: map[string]interface{}{"registry": "test.io"}}}
+
+	if got, _ := state.fromImage(&config.BaseImage{Source: "scratch"}); got != "FROM scratch" {
+		t.Errorf("fromImage(scratch) = %q, want FROM scratch", got)
+	}
+	// A later registry stage still needs the REGISTRY argument.
+	if got, _ := state.fromImage("base:v1"); got != "ARG REGISTRY=test.io\nFROM ${REGISTRY}/base:v1" {
+		t.Errorf("fromImage(base:v1) after scratch = %q", got)
+	}
+}
+
+func TestRenderState_fromImage_RootPathIncluded(t *testing.T) {
 	data := &Data{
 		Values: map[string]interface{}{
 			"registry": "my-registry.io",
 		},
-		rootPathIncluded: false,
 	}
+	state := data.newRenderState()
 
 	// First call should include ARG
-	result1 := data.fromImage(&config.BaseImage{
+	result1, _ := state.fromImage(&config.BaseImage{
 		Name:   "image1",
 		Source: "custom",
 	})
@@ -411,12 +613,12 @@ func TestData_fromImage_RootPathIncluded(t *testing.T) {
 		t.Error("First call should include ARG REGISTRY")
 	}
 
-	if !data.rootPathIncluded {
+	if !state.rootPathIncluded {
 		t.Error("rootPathIncluded should be true after first call")
 	}
 
 	// Second call should not include ARG
-	result2 := data.fromImage(&config.BaseImage{
+	result2, _ := state.fromImage(&config.BaseImage{
 		Name:   "image2",
 		Source: "custom",
 	})
@@ -426,24 +628,23 @@ func TestData_fromImage_RootPathIncluded(t *testing.T) {
 	}
 }
 
-func TestData_fromImage_RecursiveReference(t *testing.T) {
+func TestRenderState_fromImage_RecursiveReference(t *testing.T) {
 	data := &Data{
 		Values: map[string]interface{}{
 			"my_base":  "ubuntu:20.04",
 			"registry": "test.io",
 		},
-		rootPathIncluded: false,
 	}
 
 	// Test referencing a string value - it resolves to a string which still needs registry
-	result := data.fromImage("my_base")
+	result, _ := data.newRenderState().fromImage("my_base")
 	expected := "ARG REGISTRY=test.io\nFROM ${REGISTRY}/ubuntu:20.04"
 	if result != expected {
 		t.Errorf("fromImage(\"my_base\") = %s, want %s", result, expected)
 	}
 }
 
-func TestData_fromImage_NestedReference(t *testing.T) {
+func TestRenderState_fromImage_NestedReference(t *testing.T) {
 	data := &Data{
 		Values: map[string]interface{}{
 			"level1": "level2",
@@ -452,13 +653,181 @@ func TestData_fromImage_NestedReference(t *testing.T) {
 				Source: "dockerhub",
 			},
 		},
-		rootPathIncluded: false,
 	}
 
 	// Test nested reference
-	result := data.fromImage("level1")
+	result, _ := data.newRenderState().fromImage("level1")
 	expected := "FROM alpine"
 	if result != expected {
 		t.Errorf("fromImage(\"level1\") = %s, want %s", result, expected)
 	}
 }
+
+func TestRenderState_fromImage_Platform(t *testing.T) {
+	data := &Data{
+		Values: map[string]interface{}{
+			"registry":   "test.io",
+			"base_image": &config.BaseImage{Name: "core:bookworm"},
+			"build_image": map[string]interface{}{
+				"name":     "golang:1.22",
+				"source":   "dockerhub",
+				"platform": "$BUILDPLATFORM",
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		baseImage interface{}
+		options   []string
+		want      string
+	}{
+		{
+			name:      "option",
+			baseImage: "base_image",
+			options:   []string{"platform=$BUILDPLATFORM"},
+			want:      "ARG REGISTRY=test.io\nFROM --platform=$BUILDPLATFORM ${REGISTRY}/core:bookworm",
+		},
+		{
+			name:      "map key",
+			baseImage: "build_image",
+			want:      "FROM --platform=$BUILDPLATFORM golang:1.22",
+		},
+		{
+			name:      "option overrides map key",
+			baseImage: "build_image",
+			options:   []string{"platform=linux/amd64"},
+			want:      "FROM --platform=linux/amd64 golang:1.22",
+		},
+		{
+			name:      "empty platform",
+			baseImage: "build_image",
+			options:   []string{"platform="},
+			want:      "FROM golang:1.22",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := data.newRenderState().fromImage(tt.baseImage, tt.options...)
+			if err != nil {
+				t.Fatalf("fromImage() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("fromImage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, options := range [][]string{{"arch=amd64"}, {"platform"}, {"platform=linux/amd64 linux/arm64"}} {
+		if _, err := data.newRenderState().fromImage("base_image", options...); err == nil {
+			t.Errorf("fromImage(%q) succeeded, want an error", options)
+		}
+	}
+}
+
+func TestDict(t *testing.T) {
+	got, err := dict("name", "golang:1.22", "source", "dockerhub")
+	if err != nil {
+		t.Fatalf("dict() error = %v", err)
+	}
+	if got["name"] != "golang:1.22" || got["source"] != "dockerhub" || len(got) != 2 {
+		t.Errorf("dict() = %v", got)
+	}
+	if _, err := dict("name"); err == nil {
+		t.Error("dict() with an odd number of arguments succeeded")
+	}
+	if _, err := dict(1, "x"); err == nil {
+		t.Error("dict() with a non-string key succeeded")
+	}
+}
+
+func TestData_outputSHA256(t *testing.T) {
+	data := NewData(&config.ImageConfig{Values: map[string]interface{}{}}, "testapp")
+	if _, err := data.outputSHA256("entrypoint.sh"); err == nil {
+		t.Error("outputSHA256() should fail outside of generation")
+	}
+
+	data.SetOutputDigests(func(name string) (string, error) { return "digest-of-" + name, nil })
+	got, err := data.outputSHA256("entrypoint.sh")
+	if err != nil || got != "digest-of-entrypoint.sh" {
+		t.Errorf("outputSHA256() = %q, %v", got, err)
+	}
+}
+
+func TestRenderState_copyFrom(t *testing.T) {
+	values := map[string]interface{}{
+		"registry": "test.io",
+		"builder_image": &config.BaseImage{
+			Name:   "builder:1.22",
+			Source: "custom",
+		},
+		"upstream": &config.BaseImage{
+			Name:   "golang:1.22",
+			Source: "dockerhub",
+		},
+	}
+
+	tests := []struct {
+		name             string
+		rootPathIncluded bool
+		image            interface{}
+		paths            []string
+		want             string
+	}{
+		{
+			name:  "value key, ARG not yet declared",
+			image: "builder_image",
+			paths: []string{"/out", "/usr/local/bin/"},
+			want:  "ARG REGISTRY=test.io\nCOPY --from=${REGISTRY}/builder:1.22 /out /usr/local/bin/",
+		},
+		{
+			name:             "ARG declared before the first FROM is redeclared",
+			rootPathIncluded: true,
+			image:            "builder_image",
+			paths:            []string{"/out", "/usr/local/bin/"},
+			want:             "ARG REGISTRY\nCOPY --from=${REGISTRY}/builder:1.22 /out /usr/local/bin/",
+		},
+		{
+			name:  "plain reference",
+			image: "tools:2.0",
+			paths: []string{"/bin/a", "/bin/b", "/usr/local/bin/"},
+			want:  "ARG REGISTRY=test.io\nCOPY --from=${REGISTRY}/tools:2.0 /bin/a /bin/b /usr/local/bin/",
+		},
+		{
+			name:  "docker hub image",
+			image: "upstream",
+			paths: []string{"/usr/local/go", "/usr/local/go"},
+			want:  "COPY --from=golang:1.22 /usr/local/go /usr/local/go",
+		},
+		{
+			name:  "paths with spaces use the JSON form",
+			image: "upstream",
+			paths: []string{"/opt/my app", "/opt/"},
+			want:  `COPY --from=golang:1.22 ["/opt/my app","/opt/"]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &renderState{data: &Data{Values: values}, rootPathIncluded: tt.rootPathIncluded}
+			got, err := state.copyFrom(tt.image, tt.paths...)
+			if err != nil {
+				t.Fatalf("copyFrom() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("copyFrom() = %q, want %q", got, tt.want)
+			}
+			if !state.rootPathIncluded && strings.Contains(got, "${REGISTRY}") {
+				t.Error("copyFrom() should record that the ARG was declared")
+			}
+		})
+	}
+
+	state := (&Data{Values: values}).newRenderState()
+	if _, err := state.copyFrom("builder_image", "/out"); err == nil {
+		t.Error("copyFrom() should require a destination")
+	}
+	if _, err := state.copyFrom(&config.BaseImage{Source: "scratch"}, "/out", "/"); err == nil {
+		t.Error("copyFrom() should reject scratch")
+	}
+}
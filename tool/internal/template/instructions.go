@@ -0,0 +1,303 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var portPattern = regexp.MustCompile(`^(\d+)(?:/(tcp|udp))?$`)
+
+// expose renders an EXPOSE instruction for the "ports" value, a list of port
+// numbers or "port/proto" strings. It renders nothing when ports is unset.
+func (d *Data) expose() (string, error) {
+	entries, err := d.list("ports")
+	if err != nil || len(entries) == 0 {
+		return "", err
+	}
+
+	ports := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		port, err := formatPort(entry)
+		if err != nil {
+			return "", fmt.Errorf("ports: %w", err)
+		}
+		ports = append(ports, port)
+	}
+	return "EXPOSE " + strings.Join(ports, " "), nil
+}
+
+func formatPort(entry interface{}) (string, error) {
+	var port string
+	switch v := entry.(type) {
+	case int:
+		port = strconv.Itoa(v)
+	case string:
+		port = v
+	default:
+		return "", fmt.Errorf("%v is not a port number or port/proto string", entry)
+	}
+
+	match := portPattern.FindStringSubmatch(port)
+	if match == nil {
+		return "", fmt.Errorf("%q is not a port number or port/proto string", port)
+	}
+	if number, err := strconv.Atoi(match[1]); err != nil || number < 1 || number > 65535 {
+		return "", fmt.Errorf("port %s is out of range", match[1])
+	}
+	return port, nil
+}
+
+// volumes renders a VOLUME instruction in JSON form for the "volumes" value,
+// a list of paths. It renders nothing when volumes is unset.
+func (d *Data) volumes() (string, error) {
+	entries, err := d.list("volumes")
+	if err != nil || len(entries) == 0 {
+		return "", err
+	}
+
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		path, ok := entry.(string)
+		if !ok || path == "" {
+			return "", fmt.Errorf("volumes: %v is not a path", entry)
+		}
+		paths = append(paths, path)
+	}
+	encoded, err := json.Marshal(paths)
+	if err != nil {
+		return "", err
+	}
+	return "VOLUME " + string(encoded), nil
+}
+
+// workdir renders a WORKDIR instruction for the "workdir" value, or nothing
+// when it is unset.
+func (d *Data) workdir() (string, error) {
+	value, exists := d.Values["workdir"]
+	if !exists || value == nil {
+		return "", nil
+	}
+	dir, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("workdir: %v is not a path", value)
+	}
+	if dir == "" {
+		return "", nil
+	}
+	return "WORKDIR " + dir, nil
+}
+
+// list returns the value under key as a list, accepting the []interface{}
+// produced by YAML decoding as well as []string.
+func (d *Data) list(key string) ([]interface{}, error) {
+	switch v := d.Values[key].(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return v, nil
+	case []string:
+		entries := make([]interface{}, len(v))
+		for i, entry := range v {
+			entries[i] = entry
+		}
+		return entries, nil
+	default:
+		return nil, fmt.Errorf("%s must be a list, got %v", key, v)
+	}
+}
+
+// inlineFile renders a COPY heredoc that writes the generated file name to
+// dest, /usr/local/bin/<name> by default, so the file needs no separate COPY
+// from the build context. Files whose permissions are not 0644, the heredoc
+// default, are followed by a RUN chmod. Heredocs need BuildKit, so the
+// generator requires a syntax directive in Dockerfiles that use it.
+func (d *Data) inlineFile(name string, dest ...string) (string, error) {
+	if d.inlineLookup == nil {
+		return "", fmt.Errorf("inline_file is only available when generating a version")
+	}
+	if len(dest) > 1 {
+		return "", fmt.Errorf("inline_file takes a file name and an optional destination")
+	}
+
+	target := path.Join("/usr/local/bin", path.Base(name))
+	if len(dest) == 1 {
+		target = dest[0]
+	}
+	if target == "" || strings.ContainsAny(target, " \t\n") {
+		return "", fmt.Errorf("inline_file: invalid destination %q", target)
+	}
+
+	content, mode, err := d.inlineLookup(name)
+	if err != nil {
+		return "", err
+	}
+
+	delimiter := heredocDelimiter(content)
+	var b strings.Builder
+	fmt.Fprintf(&b, "COPY <<'%s' %s\n", delimiter, target)
+	b.WriteString(content)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		b.WriteByte('\n')
+	}
+	b.WriteString(delimiter)
+	if perm := mode.Perm(); perm != 0644 {
+		fmt.Fprintf(&b, "\nRUN chmod %o %s", perm, target)
+	}
+	return b.String(), nil
+}
+
+// heredocDelimiter returns EOF, or EOF1, EOF2 and so on when content has a
+// line that would end the heredoc early.
+func heredocDelimiter(content string) string {
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(content, "\n") {
+		lines[line] = true
+	}
+	delimiter := "EOF"
+	for i := 1; lines[delimiter]; i++ {
+		delimiter = fmt.Sprintf("EOF%d", i)
+	}
+	return delimiter
+}
+
+// shellSafe matches package names and flag values that need no quoting in a
+// RUN instruction, such as curl, libssl3:arm64 or python3=3.12.3-0ubuntu2.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9._+:=@/,%^-]+$`)
+
+// aptInstall renders a RUN instruction that installs packages with apt-get
+// without recommends and removes the package lists afterwards.
+func aptInstall(args ...interface{}) (string, error) {
+	packages, flags, err := packageArgs("apt_install", args)
+	if err != nil {
+		return "", err
+	}
+	command := append([]string{"apt-get install -y --no-install-recommends"}, flags...)
+	return "RUN apt-get update \\\n    && " + strings.Join(command, " ") + " \\\n" +
+		packageLines(packages) + " \\\n    && rm -rf /var/lib/apt/lists/*", nil
+}
+
+// apkAdd renders a RUN instruction that installs packages with apk, keeping
+// no package cache in the layer.
+func apkAdd(args ...interface{}) (string, error) {
+	packages, flags, err := packageArgs("apk_add", args)
+	if err != nil {
+		return "", err
+	}
+	command := append([]string{"apk add --no-cache"}, flags...)
+	return "RUN " + strings.Join(command, " ") + " \\\n" + packageLines(packages), nil
+}
+
+// packageArgs collects the sorted, deduplicated package names of a package
+// helper's arguments, each a name or a list of names, and the flags of an
+// optional map argument: {"virtual": ".build-deps"} is --virtual .build-deps,
+// true is a bare flag and false leaves the flag out.
+func packageArgs(helper string, args []interface{}) ([]string, []string, error) {
+	seen := make(map[string]bool)
+	var packages, flags []string
+	addPackage := func(value interface{}) error {
+		name, ok := value.(string)
+		if !ok || !validShellWord(name) {
+			return fmt.Errorf("%s: %v is not a package name", helper, value)
+		}
+		if !seen[name] {
+			seen[name] = true
+			packages = append(packages, name)
+		}
+		return nil
+	}
+
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case []interface{}:
+			for _, name := range v {
+				if err := addPackage(name); err != nil {
+					return nil, nil, err
+				}
+			}
+		case []string:
+			for _, name := range v {
+				if err := addPackage(name); err != nil {
+					return nil, nil, err
+				}
+			}
+		case map[string]interface{}:
+			if flags != nil {
+				return nil, nil, fmt.Errorf("%s: takes at most one map of flags", helper)
+			}
+			var err error
+			if flags, err = packageFlags(helper, v); err != nil {
+				return nil, nil, err
+			}
+		default:
+			if err := addPackage(arg); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if len(packages) == 0 {
+		return nil, nil, fmt.Errorf("%s: no packages given", helper)
+	}
+	sort.Strings(packages)
+	return packages, flags, nil
+}
+
+func packageFlags(helper string, values map[string]interface{}) ([]string, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	flags := []string{}
+	for _, name := range names {
+		flag := "--" + strings.TrimLeft(name, "-")
+		if !shellSafe.MatchString(flag) {
+			return nil, fmt.Errorf("%s: %q is not a flag name", helper, name)
+		}
+		switch v := values[name].(type) {
+		case bool:
+			if v {
+				flags = append(flags, flag)
+			}
+		case string:
+			if !validShellWord(v) {
+				return nil, fmt.Errorf("%s: flag %s: %q is not a valid value", helper, name, v)
+			}
+			flags = append(flags, flag, shellWord(v))
+		case int:
+			flags = append(flags, flag, strconv.Itoa(v))
+		default:
+			return nil, fmt.Errorf("%s: flag %s: %v is not a string, number or boolean", helper, name, v)
+		}
+	}
+	return flags, nil
+}
+
+// validShellWord reports whether s can be passed as one argument of a RUN
+// instruction: it must be non-empty and on one line.
+func validShellWord(s string) bool {
+	return s != "" && !strings.ContainsAny(s, " \t\r\n")
+}
+
+// shellWord returns s as is when it is shell-safe and single-quoted
+// otherwise, e.g. 'python3>3.11' for apk's version constraints.
+func shellWord(s string) string {
+	if shellSafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// packageLines renders one line-continued, indented line per package.
+func packageLines(packages []string) string {
+	lines := make([]string, len(packages))
+	for i, name := range packages {
+		lines[i] = "        " + shellWord(name)
+	}
+	return strings.Join(lines, " \\\n")
+}
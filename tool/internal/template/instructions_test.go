@@ -0,0 +1,202 @@
+package template
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// renderInstructions decodes manifest YAML into a version config, as the
+// loader does, and renders the instruction helpers against it.
+func renderInstructions(t *testing.T, manifest string) (string, error) {
+	t.Helper()
+
+	var ic config.ImageConfig
+	if err := yaml.Unmarshal([]byte(manifest), &ic); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+
+	tmpl, err := template.New("Dockerfile").
+		Funcs(NewData(&ic, "myapp").newRenderState().functions()).
+		Parse("{{expose}}|{{volumes}}|{{workdir}}")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var out strings.Builder
+	err = tmpl.Execute(&out, nil)
+	return out.String(), err
+}
+
+func TestInstructionHelpers(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     string
+	}{
+		{
+			name:     "unset",
+			manifest: "version: \"1.0\"\n",
+			want:     "||",
+		},
+		{
+			name:     "mixed int and string ports",
+			manifest: "ports: [8080, \"9090/udp\", \"443\"]\nvolumes: [/data, /var/log/app]\nworkdir: /app\n",
+			want:     `EXPOSE 8080 9090/udp 443|VOLUME ["/data","/var/log/app"]|WORKDIR /app`,
+		},
+		{
+			name:     "empty lists",
+			manifest: "ports: []\nvolumes: []\nworkdir: \"\"\n",
+			want:     "||",
+		},
+		{
+			name:     "block style lists",
+			manifest: "ports:\n  - 53/tcp\n  - 53/udp\nvolumes:\n  - /srv\n",
+			want:     `EXPOSE 53/tcp 53/udp|VOLUME ["/srv"]|`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderInstructions(t, tt.manifest)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("rendered %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstructionHelpers_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		wantErr  string
+	}{
+		{name: "unknown protocol", manifest: "ports: [\"8080/sctp\"]\n", wantErr: `"8080/sctp" is not a port number`},
+		{name: "out of range", manifest: "ports: [70000]\n", wantErr: "port 70000 is out of range"},
+		{name: "float port", manifest: "ports: [80.5]\n", wantErr: "80.5 is not a port number"},
+		{name: "ports not a list", manifest: "ports: 8080\n", wantErr: "ports must be a list"},
+		{name: "volume not a path", manifest: "volumes: [1]\n", wantErr: "volumes: 1 is not a path"},
+		{name: "workdir not a path", manifest: "workdir: [a]\n", wantErr: "workdir: [a] is not a path"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := renderInstructions(t, tt.manifest)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Execute() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// renderPackages renders text with the helpers and values of the manifest.
+func renderPackages(t *testing.T, manifest, text string) (string, error) {
+	t.Helper()
+
+	var ic config.ImageConfig
+	if err := yaml.Unmarshal([]byte(manifest), &ic); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+
+	tmpl, err := template.New("Dockerfile").
+		Funcs(NewData(&ic, "myapp").newRenderState().functions()).
+		Parse(text)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var out strings.Builder
+	err = tmpl.Execute(&out, nil)
+	return out.String(), err
+}
+
+func TestPackageHelpers(t *testing.T) {
+	manifest := `
+packages: [git, curl, "ca-certificates", curl]
+build_deps: [gcc, musl-dev]
+apk_flags: {virtual: .build-deps, no-scripts: true, quiet: false}
+`
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "apt varargs",
+			text: `{{apt_install "git" "curl"}}`,
+			want: "RUN apt-get update \\\n" +
+				"    && apt-get install -y --no-install-recommends \\\n" +
+				"        curl \\\n" +
+				"        git \\\n" +
+				"    && rm -rf /var/lib/apt/lists/*",
+		},
+		{
+			name: "apt list value, sorted and deduplicated",
+			text: `{{apt_install packages "jq"}}`,
+			want: "RUN apt-get update \\\n" +
+				"    && apt-get install -y --no-install-recommends \\\n" +
+				"        ca-certificates \\\n" +
+				"        curl \\\n" +
+				"        git \\\n" +
+				"        jq \\\n" +
+				"    && rm -rf /var/lib/apt/lists/*",
+		},
+		{
+			name: "apk with flags",
+			text: `{{apk_add build_deps apk_flags}}`,
+			want: "RUN apk add --no-cache --no-scripts --virtual .build-deps \\\n" +
+				"        gcc \\\n" +
+				"        musl-dev",
+		},
+		{
+			name: "version constraints are quoted",
+			text: `{{apk_add "tzdata" "python3>3.11"}}`,
+			want: "RUN apk add --no-cache \\\n" +
+				"        'python3>3.11' \\\n" +
+				"        tzdata",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderPackages(t, manifest, tt.text)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("rendered:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPackageHelpers_Errors(t *testing.T) {
+	manifest := "empty: []\nflags: {virtual: [a]}\n"
+	tests := []struct {
+		name    string
+		text    string
+		wantErr string
+	}{
+		{name: "no packages", text: `{{apt_install empty}}`, wantErr: "apt_install: no packages given"},
+		{name: "name with a space", text: `{{apk_add "curl git"}}`, wantErr: `apk_add: curl git is not a package name`},
+		{name: "not a name", text: `{{apk_add 1}}`, wantErr: "apk_add: 1 is not a package name"},
+		{name: "bad flag value", text: `{{apk_add "curl" flags}}`, wantErr: "flag virtual: [a] is not a string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := renderPackages(t, manifest, tt.text)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Execute() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// include renders the shared partial name, <partials dir>/<name>.tmpl, with
+// the functions of the file being rendered and context as dot, e.g.
+// {{include "nonroot-user" .}}. Partials may include other partials but not
+// themselves, directly or through others.
+func (s *renderState) include(name string, context interface{}) (string, error) {
+	if len(s.templates) == 0 {
+		return "", fmt.Errorf("include is only available while rendering a template")
+	}
+	parent := s.templates[len(s.templates)-1]
+	if s.data.partialsDir == "" {
+		return "", fmt.Errorf("%s: include %q: no partials directory configured", parent, name)
+	}
+	clean := path.Clean(name)
+	if name == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("%s: include %q: partial names must be relative to the partials directory", parent, name)
+	}
+
+	partialPath := filepath.Join(s.data.partialsDir, filepath.FromSlash(clean)+".tmpl")
+	for i, included := range s.templates {
+		if included == partialPath {
+			cycle := make([]string, 0, len(s.templates)-i+1)
+			for _, p := range append(s.templates[i:], partialPath) {
+				cycle = append(cycle, filepath.Base(p))
+			}
+			return "", fmt.Errorf("%s: include %q: include cycle %s", parent, name, strings.Join(cycle, " -> "))
+		}
+	}
+
+	content, err := os.ReadFile(partialPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%s: include %q: no partial %s", parent, name, partialPath)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: include %q: %w", parent, name, err)
+	}
+
+	output, err := s.render(partialPath, string(content), context)
+	if err != nil {
+		// Not wrapped, so that Render reports errors of required calls
+		// inside the partial with the partial's path.
+		return "", fmt.Errorf("%s: include %q: %v", parent, name, err)
+	}
+	return output, nil
+}
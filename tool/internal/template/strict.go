@@ -0,0 +1,113 @@
+package template
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/suggest"
+)
+
+// builtinFunctions are the functions text/template predefines, which are not
+// part of the FuncMap a render builds.
+var builtinFunctions = map[string]bool{
+	"and": true, "call": true, "html": true, "index": true, "slice": true,
+	"js": true, "len": true, "not": true, "or": true, "print": true,
+	"printf": true, "println": true, "urlquery": true,
+	"eq": true, "ge": true, "gt": true, "le": true, "lt": true, "ne": true,
+}
+
+// undefinedNames returns the sorted identifiers content calls that are
+// neither in funcs nor predefined, usually misspelled or missing values. It
+// returns nil when content does not parse, leaving the parse error to Parse.
+func undefinedNames(name, content string, funcs template.FuncMap) []string {
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := tree.Parse(content, "", "", trees); err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, t := range trees {
+		walkIdentifiers(t.Root, func(identifier string) {
+			if _, defined := funcs[identifier]; defined || builtinFunctions[identifier] || seen[identifier] {
+				return
+			}
+			seen[identifier] = true
+			names = append(names, identifier)
+		})
+	}
+	sort.Strings(names)
+	return names
+}
+
+// walkIdentifiers calls visit for every identifier under node.
+func walkIdentifiers(node parse.Node, visit func(string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkIdentifiers(child, visit)
+		}
+	case *parse.ActionNode:
+		walkIdentifiers(n.Pipe, visit)
+	case *parse.IfNode:
+		walkBranch(&n.BranchNode, visit)
+	case *parse.RangeNode:
+		walkBranch(&n.BranchNode, visit)
+	case *parse.WithNode:
+		walkBranch(&n.BranchNode, visit)
+	case *parse.TemplateNode:
+		walkIdentifiers(n.Pipe, visit)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkIdentifiers(cmd, visit)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkIdentifiers(arg, visit)
+		}
+	case *parse.ChainNode:
+		walkIdentifiers(n.Node, visit)
+	case *parse.IdentifierNode:
+		visit(n.Ident)
+	}
+}
+
+func walkBranch(n *parse.BranchNode, visit func(string)) {
+	walkIdentifiers(n.Pipe, visit)
+	walkIdentifiers(n.List, visit)
+	walkIdentifiers(n.ElseList, visit)
+}
+
+// undefinedError describes undefined names, suggesting the closest value
+// names for each, e.g. `unknown value prot (did you mean port?)`.
+func undefinedError(names []string, values map[string]interface{}) error {
+	candidates := make([]string, 0, len(values))
+	for key := range values {
+		candidates = append(candidates, functionName(key))
+	}
+	sort.Strings(candidates)
+
+	described := make([]string, len(names))
+	for i, name := range names {
+		described[i] = name
+		if matches := suggest.Closest(name, candidates, 3); len(matches) > 0 {
+			described[i] = fmt.Sprintf("%s (did you mean %s?)", name, strings.Join(matches, ", "))
+		}
+	}
+	noun := "value"
+	if len(names) > 1 {
+		noun = "values"
+	}
+	return fmt.Errorf("unknown %s %s", noun, strings.Join(described, ", "))
+}
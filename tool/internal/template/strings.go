@@ -0,0 +1,42 @@
+package template
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// stringFunctions are string helpers with sprig's names and argument order,
+// which puts the string last so that it can be piped in:
+// {{python_version | replace "." ""}}. A value of the same name shadows the
+// helper.
+var stringFunctions = template.FuncMap{
+	"upper":      strings.ToUpper,
+	"lower":      strings.ToLower,
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       join,
+}
+
+// join joins a list value, such as a YAML sequence or the result of split,
+// formatting each element as text.
+func join(sep string, list interface{}) (string, error) {
+	switch l := list.(type) {
+	case []string:
+		return strings.Join(l, sep), nil
+	case []interface{}:
+		elements := make([]string, len(l))
+		for i, element := range l {
+			elements[i] = fmt.Sprint(element)
+		}
+		return strings.Join(elements, sep), nil
+	default:
+		return "", fmt.Errorf("join expects a list, got %T", list)
+	}
+}
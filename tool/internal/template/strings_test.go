@@ -0,0 +1,84 @@
+package template
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestRender_StringFunctions(t *testing.T) {
+	var ic config.ImageConfig
+	manifest := `
+distro: Ubuntu
+python_version: "3.13.1"
+packages: [curl, git, "ca-certificates"]
+ports: [8080, 9090]
+flavors: "slim,full"
+`
+	if err := yaml.Unmarshal([]byte(manifest), &ic); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		templateData string
+		want         string
+	}{
+		{"upper", `{{upper distro}}`, "UBUNTU"},
+		{"lower piped", `{{distro | lower}}`, "ubuntu"},
+		{"trim", `{{trim "  x  "}}`, "x"},
+		{"replace", `{{python_version | replace "." ""}}`, "3131"},
+		{"trimPrefix", `{{trimPrefix "3." python_version}}`, "13.1"},
+		{"trimSuffix", `{{python_version | trimSuffix ".1"}}`, "3.13"},
+		{"contains", `{{if contains "13" python_version}}yes{{end}}`, "yes"},
+		{"hasPrefix", `{{if hasPrefix "3." python_version}}py3{{end}}`, "py3"},
+		{"hasSuffix", `{{if hasSuffix ".0" python_version}}zero{{else}}patch{{end}}`, "patch"},
+		{"join list value", `{{join " " packages}}`, "curl git ca-certificates"},
+		{"join numbers", `{{join "," ports}}`, "8080,9090"},
+		{"split", `{{range split "," flavors}}[{{.}}]{{end}}`, "[slim][full]"},
+		{"split then join", `{{flavors | split "," | join " "}}`, "slim full"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			templatePath := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+			if err := os.WriteFile(templatePath, []byte(tt.templateData), 0644); err != nil {
+				t.Fatalf("Failed to write template file: %v", err)
+			}
+
+			got, err := Render(templatePath, NewData(&ic, "testapp"))
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender_ValueShadowsStringFunction(t *testing.T) {
+	templatePath := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+	if err := os.WriteFile(templatePath, []byte(`{{upper}}`), 0644); err != nil {
+		t.Fatalf("Failed to write template file: %v", err)
+	}
+
+	data := NewData(&config.ImageConfig{Values: map[string]interface{}{"upper": "from the manifest"}}, "testapp")
+	got, err := Render(templatePath, data)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if got != "from the manifest" {
+		t.Errorf("Render() = %q, want the value to win", got)
+	}
+}
+
+func TestJoin_NotAList(t *testing.T) {
+	if _, err := join(",", "curl"); err == nil {
+		t.Error("join() should fail on a string")
+	}
+}
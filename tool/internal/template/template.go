@@ -1,15 +1,19 @@
 package template
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
+
+	"github.com/apex/log"
 )
 
 func WriteFile(templatePath, outputPath string, data *Data) error {
-	content, err := render(templatePath, data)
+	content, err := Render(templatePath, data)
 	if err != nil {
 		return err
 	}
@@ -17,45 +21,18 @@ func WriteFile(templatePath, outputPath string, data *Data) error {
 	return os.WriteFile(outputPath, []byte(content), 0644)
 }
 
-func render(templatePath string, data *Data) (string, error) {
+// Render executes the template at templatePath against data and returns the
+// output without writing anything to disk. Each call gets its own render
+// state, so Render may be called concurrently with the same data.
+func Render(templatePath string, data *Data) (string, error) {
 	content, err := os.ReadFile(templatePath)
 	if err != nil {
 		return "", fmt.Errorf("reading template file %s: %w", templatePath, err)
 	}
 
-	tmpl := template.New(filepath.Base(templatePath))
-
-	fn := data.functions()
-	for key, value := range data.Values {
-		switch v := value.(type) {
-		case string:
-			fn[key] = func(val string) func() string {
-				return func() string { return val }
-			}(v)
-		case int:
-			fn[key] = func(val int) func() int {
-				return func() int { return val }
-			}(v)
-		case float64:
-			fn[key] = func(val float64) func() float64 {
-				return func() float64 { return val }
-			}(v)
-		case map[string]interface{}:
-			fn[key] = func(val map[string]interface{}) func() map[string]interface{} {
-				return func() map[string]interface{} { return val }
-			}(v)
-		default:
-			fn[key] = func(val interface{}) func() interface{} {
-				return func() interface{} { return val }
-			}(v)
-		}
-	}
-
-	tmpl = tmpl.Funcs(fn)
-	tmpl, err = tmpl.Parse(string(content))
-	if err != nil {
-		return "", fmt.Errorf("parsing template %s: %w", templatePath, err)
-	}
+	state := data.newRenderState()
+	state.output = strings.TrimSuffix(filepath.Base(templatePath), ".tmpl")
+	state.funcs = state.functions()
 
 	templateContext := struct {
 		*Data
@@ -64,11 +41,57 @@ func render(templatePath string, data *Data) (string, error) {
 		Data:   data,
 		Values: data.Values,
 	}
+	return state.render(templatePath, string(content), templateContext)
+}
+
+// render parses and executes content, the template at templatePath or a
+// partial it includes, with the render's functions and context as dot.
+func (s *renderState) render(templatePath, content string, context interface{}) (string, error) {
+	data := s.data
+	tmpl := template.New(filepath.Base(templatePath))
+	if undefined := undefinedNames(tmpl.Name(), content, s.funcs); len(undefined) > 0 {
+		if data.strict {
+			return "", fmt.Errorf("template %s: %w", templatePath, undefinedError(undefined, data.Values))
+		}
+		log.Warnf("template %s: %v; rendering them empty", templatePath, undefinedError(undefined, data.Values))
+		for _, name := range undefined {
+			s.funcs[name] = func() string { return "" }
+		}
+	}
+	tmpl, err := tmpl.Funcs(s.funcs).Parse(content)
+	if err != nil {
+		if hint := valueNameHint(content, data.Values); hint != "" {
+			return "", fmt.Errorf("parsing template %s: %w (%s)", templatePath, err, hint)
+		}
+		return "", fmt.Errorf("parsing template %s: %w", templatePath, err)
+	}
+
+	s.templates = append(s.templates, templatePath)
+	defer func() { s.templates = s.templates[:len(s.templates)-1] }()
 
 	var result strings.Builder
-	if err := tmpl.Execute(&result, templateContext); err != nil {
+	if err := tmpl.Execute(&result, context); err != nil {
+		var requiredErr *requiredError
+		if errors.As(err, &requiredErr) {
+			return "", fmt.Errorf("template %s: %w", templatePath, requiredErr)
+		}
 		return "", fmt.Errorf("executing template %s: %w", templatePath, err)
 	}
 
 	return result.String(), nil
 }
+
+// valueNameHint explains a parse error caused by using a value key that is
+// not a valid identifier, such as extra-packages, directly as a function. It
+// returns an empty string when content mentions no such key.
+func valueNameHint(content string, values map[string]interface{}) string {
+	var hints []string
+	for key := range values {
+		name := functionName(key)
+		if name != key && strings.Contains(content, key) {
+			hints = append(hints, fmt.Sprintf("value %q is not a valid template name; use {{%s}} or {{get %q}}", key, name, key))
+		}
+	}
+	sort.Strings(hints)
+	return strings.Join(hints, "; ")
+}
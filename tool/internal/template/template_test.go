@@ -1,11 +1,15 @@
 package template
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
+	"gopkg.in/yaml.v3"
+
 	"github.com/mberwanger/dockerfiles/tool/internal/config"
 )
 
@@ -210,9 +214,9 @@ func TestRender(t *testing.T) {
 			}
 
 			// Execute render
-			output, err := render(templatePath, tt.data)
+			output, err := Render(templatePath, tt.data)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("render() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Render() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 
@@ -234,9 +238,9 @@ func TestRender_FileNotFound(t *testing.T) {
 		Values: map[string]interface{}{},
 	}, "testapp")
 
-	_, err := render("/nonexistent/template.tmpl", data)
+	_, err := Render("/nonexistent/template.tmpl", data)
 	if err == nil {
-		t.Error("render() should return error for nonexistent template file")
+		t.Error("Render() should return error for nonexistent template file")
 	}
 }
 
@@ -254,9 +258,9 @@ func TestRender_ParseError(t *testing.T) {
 		Values: map[string]interface{}{},
 	}, "testapp")
 
-	_, err := render(templatePath, data)
+	_, err := Render(templatePath, data)
 	if err == nil {
-		t.Error("render() should return error for template with parse error")
+		t.Error("Render() should return error for template with parse error")
 	}
 }
 
@@ -274,9 +278,56 @@ func TestRender_ExecutionError(t *testing.T) {
 		Values: map[string]interface{}{},
 	}, "testapp")
 
-	_, err := render(templatePath, data)
+	_, err := Render(templatePath, data)
 	if err == nil {
-		t.Error("render() should return error for template execution error")
+		t.Error("Render() should return error for template execution error")
+	}
+}
+
+func TestRender_DefaultAndRequired(t *testing.T) {
+	values := map[string]interface{}{
+		"alpine_version": "3.21",
+		"empty":          "",
+		"port":           8080,
+		"debug":          false,
+	}
+
+	tests := []struct {
+		name         string
+		templateData string
+		want         string
+		wantErr      string
+	}{
+		{"default unset", `{{default "3.19" (get "missing")}}`, "3.19", ""},
+		{"default set", `{{default "3.19" (get "alpine_version")}}`, "3.21", ""},
+		{"default empty string", `{{get "empty" | default "fallback"}}`, "fallback", ""},
+		{"default keeps false", `{{default true (get "debug")}}`, "false", ""},
+		{"required set", `{{required "port must be set" (get "port")}}`, "8080", ""},
+		{"required unset", `{{required "port must be set" (get "missing")}}`, "", "port must be set"},
+		{"required empty string", `{{required "empty must be set" (get "empty")}}`, "", "empty must be set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			templatePath := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+			if err := os.WriteFile(templatePath, []byte(tt.templateData), 0644); err != nil {
+				t.Fatalf("Failed to write template file: %v", err)
+			}
+
+			got, err := Render(templatePath, NewData(&config.ImageConfig{Values: values}, "testapp"))
+			if tt.wantErr != "" {
+				if want := "template " + templatePath + ": " + tt.wantErr; err == nil || err.Error() != want {
+					t.Errorf("Render() error = %v, want %q", err, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
 	}
 }
 
@@ -338,9 +389,9 @@ func TestRender_ValueFunctions(t *testing.T) {
 				Values: tt.values,
 			}, "testapp")
 
-			output, err := render(templatePath, data)
+			output, err := Render(templatePath, data)
 			if err != nil {
-				t.Fatalf("render() error = %v", err)
+				t.Fatalf("Render() error = %v", err)
 			}
 
 			if !strings.Contains(output, tt.want) {
@@ -350,6 +401,239 @@ func TestRender_ValueFunctions(t *testing.T) {
 	}
 }
 
+func TestRender_YAMLValueFunctions(t *testing.T) {
+	var ic config.ImageConfig
+	manifest := `
+enable_cuda: true
+enable_debug: false
+packages: [curl, git, "ca-certificates"]
+empty_list: []
+big: 9223372036854775807
+huge: 18446744073709551615
+`
+	if err := yaml.Unmarshal([]byte(manifest), &ic); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		templateData string
+		want         string
+	}{
+		{"true flag", `{{if enable_cuda}}cuda{{else}}cpu{{end}}`, "cuda"},
+		{"false flag", `{{if enable_debug}}debug{{else}}release{{end}}`, "release"},
+		{"negated flag", `{{if not enable_debug}}quiet{{end}}`, "quiet"},
+		{"flag combined with and", `{{if and enable_cuda (not enable_debug)}}ok{{end}}`, "ok"},
+		{"flag printed", `{{enable_cuda}}`, "true"},
+		{"range list", `{{range packages}}{{.}} {{end}}`, "curl git ca-certificates "},
+		{"range list with index", `{{range $i, $p := packages}}{{$i}}={{$p}},{{end}}`, "0=curl,1=git,2=ca-certificates,"},
+		{"list length", `{{len packages}}`, "3"},
+		{"empty list is falsy", `{{if empty_list}}some{{else}}none{{end}}`, "none"},
+		{"range empty list", `{{range empty_list}}x{{else}}empty{{end}}`, "empty"},
+		{"int64", `{{big}}`, "9223372036854775807"},
+		{"uint64", `{{huge}}`, "18446744073709551615"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			templatePath := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+			if err := os.WriteFile(templatePath, []byte(tt.templateData), 0644); err != nil {
+				t.Fatalf("Failed to write template file: %v", err)
+			}
+
+			got, err := Render(templatePath, NewData(&ic, "testapp"))
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender_NonIdentifierValueKeys(t *testing.T) {
+	values := map[string]interface{}{
+		"extra-packages": "curl git",
+		"2fa":            true,
+		"taken-name":     "sanitized",
+		"taken_name":     "literal",
+	}
+
+	tests := []struct {
+		name         string
+		templateData string
+		want         string
+	}{
+		{"sanitized name", `{{extra_packages}}`, "curl git"},
+		{"get by key", `{{get "extra-packages"}}`, "curl git"},
+		{"values by key", `{{index .Values "extra-packages"}}`, "curl git"},
+		{"leading digit", `{{if _2fa}}otp{{end}}`, "otp"},
+		{"literal key keeps its name", `{{taken_name}}`, "literal"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			templatePath := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+			if err := os.WriteFile(templatePath, []byte(tt.templateData), 0644); err != nil {
+				t.Fatalf("Failed to write template file: %v", err)
+			}
+
+			got, err := Render(templatePath, NewData(&config.ImageConfig{Values: values}, "testapp"))
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("direct use is explained", func(t *testing.T) {
+		templatePath := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+		if err := os.WriteFile(templatePath, []byte("RUN apt-get install {{extra-packages}}\n"), 0644); err != nil {
+			t.Fatalf("Failed to write template file: %v", err)
+		}
+
+		_, err := Render(templatePath, NewData(&config.ImageConfig{Values: values}, "testapp"))
+		want := `value "extra-packages" is not a valid template name; use {{extra_packages}} or {{get "extra-packages"}}`
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Render() error = %v, want it to contain %q", err, want)
+		}
+	})
+}
+
+func TestRender_UnknownValues(t *testing.T) {
+	values := map[string]interface{}{"port": 8080, "python_version": "3.13"}
+
+	tests := []struct {
+		name         string
+		templateData string
+		wantErr      string
+	}{
+		{"misspelled value", `EXPOSE {{prot}}`, "unknown value prot (did you mean port?)"},
+		{"several names", `{{if debug}}{{pyhton_version | upper}}{{end}}`, "unknown values debug, pyhton_version (did you mean python_version?)"},
+		{"inside a define", `{{define "x"}}{{prot}}{{end}}{{template "x"}}`, "unknown value prot (did you mean port?)"},
+		{"known names", `{{port}} {{printf "%s" python_version}} {{len (split "." python_version)}}`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			templatePath := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+			if err := os.WriteFile(templatePath, []byte(tt.templateData), 0644); err != nil {
+				t.Fatalf("Failed to write template file: %v", err)
+			}
+
+			_, err := Render(templatePath, NewData(&config.ImageConfig{Values: values}, "testapp"))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Render() error = %v", err)
+				}
+				return
+			}
+			want := fmt.Sprintf("template %s: %s", templatePath, tt.wantErr)
+			if err == nil || err.Error() != want {
+				t.Errorf("Render() error = %v, want %q", err, want)
+			}
+		})
+	}
+
+	t.Run("lenient", func(t *testing.T) {
+		templatePath := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+		if err := os.WriteFile(templatePath, []byte("EXPOSE {{port}}{{prot}}{{if debug}} debug{{end}}"), 0644); err != nil {
+			t.Fatalf("Failed to write template file: %v", err)
+		}
+
+		data := NewData(&config.ImageConfig{Values: values}, "testapp")
+		data.SetStrict(false)
+		got, err := Render(templatePath, data)
+		if err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		if got != "EXPOSE 8080" {
+			t.Errorf("Render() = %q, want %q", got, "EXPOSE 8080")
+		}
+	})
+}
+
+func TestRender_Include(t *testing.T) {
+	partialsDir := t.TempDir()
+	partials := map[string]string{
+		"nonroot-user.tmpl": "RUN useradd --uid {{uid}} app\n{{include \"tini\" .}}USER app\n",
+		"tini.tmpl":         "{{if once \"tini\"}}RUN apk add --no-cache tini\n{{end}}",
+		"self.tmpl":         "{{include \"loop/a\" .}}",
+		"loop/a.tmpl":       "{{include \"self\" .}}",
+		"required.tmpl":     "{{required \"uid must be set\" (get \"missing\")}}",
+	}
+	for name, content := range partials {
+		partialPath := filepath.Join(partialsDir, name)
+		if err := os.MkdirAll(filepath.Dir(partialPath), 0755); err != nil {
+			t.Fatalf("Failed to create partials directory: %v", err)
+		}
+		if err := os.WriteFile(partialPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write partial: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		templateData string
+		want         string
+		wantErr      string
+	}{
+		{
+			name:         "nested partials share values and once guards",
+			templateData: "FROM alpine\n{{include \"tini\" .}}{{include \"nonroot-user\" .}}",
+			want:         "FROM alpine\nRUN apk add --no-cache tini\nRUN useradd --uid 1000 app\nUSER app\n",
+		},
+		{
+			name:         "missing partial",
+			templateData: `{{include "nonroot-usr" .}}`,
+			wantErr:      "Dockerfile.tmpl: include \"nonroot-usr\": no partial " + filepath.Join(partialsDir, "nonroot-usr.tmpl"),
+		},
+		{
+			name:         "cycle",
+			templateData: `{{include "self" .}}`,
+			wantErr:      "include cycle self.tmpl -> a.tmpl -> self.tmpl",
+		},
+		{
+			name:         "escaping the partials directory",
+			templateData: `{{include "../secret" .}}`,
+			wantErr:      "partial names must be relative to the partials directory",
+		},
+		{
+			name:         "required value inside a partial",
+			templateData: `{{include "required" .}}`,
+			wantErr:      "template " + filepath.Join(partialsDir, "required.tmpl") + ": uid must be set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			templatePath := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+			if err := os.WriteFile(templatePath, []byte(tt.templateData), 0644); err != nil {
+				t.Fatalf("Failed to write template file: %v", err)
+			}
+
+			data := NewData(&config.ImageConfig{Values: map[string]interface{}{"uid": 1000}}, "testapp")
+			data.SetPartialsDir(partialsDir)
+			got, err := Render(templatePath, data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Render() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestRender_ComplexDockerfile(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -385,9 +669,9 @@ CMD ["./{{image_name}}"]
 		},
 	}, "myservice")
 
-	output, err := render(templatePath, data)
+	output, err := Render(templatePath, data)
 	if err != nil {
-		t.Fatalf("render() error = %v", err)
+		t.Fatalf("Render() error = %v", err)
 	}
 
 	expectedParts := []string{
@@ -455,9 +739,9 @@ func TestRender_WithRegistryArg(t *testing.T) {
 		},
 	}, "testapp")
 
-	output, err := render(templatePath, data)
+	output, err := Render(templatePath, data)
 	if err != nil {
-		t.Fatalf("render() error = %v", err)
+		t.Fatalf("Render() error = %v", err)
 	}
 
 	if !strings.Contains(output, "ARG REGISTRY=my-registry.io") {
@@ -467,3 +751,180 @@ func TestRender_WithRegistryArg(t *testing.T) {
 		t.Error("Output should contain FROM with registry variable")
 	}
 }
+
+func TestRender_Once(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{
+			name:     "repeated guard renders once",
+			template: `{{if once "ca"}}CA{{end}}|{{if once "ca"}}CA{{end}}|{{if once "tini"}}TINI{{end}}`,
+			want:     "CA||TINI",
+		},
+		{
+			name: "partials sharing a sub-partial",
+			template: `{{define "ca"}}{{if once "ca-certs"}}COPY certs /certs
+{{end}}{{end}}{{define "java"}}{{template "ca"}}RUN java
+{{end}}{{define "node"}}{{template "ca"}}RUN node
+{{end}}{{template "java"}}{{template "node"}}`,
+			want: "COPY certs /certs\nRUN java\nRUN node\n",
+		},
+		{
+			name:     "nested guards",
+			template: `{{range $i := .Values.items}}{{if once "outer"}}O{{if once "inner"}}I{{end}}{{end}}{{if once "inner"}}i{{end}}{{$i}}{{end}}`,
+			want:     "OI1 2 3",
+		},
+		{
+			name:     "guard in an unexecuted branch is not used up",
+			template: `{{if false}}{{if once "x"}}A{{end}}{{end}}{{if once "x"}}B{{end}}`,
+			want:     "B",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			templatePath := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+			if err := os.WriteFile(templatePath, []byte(tt.template), 0644); err != nil {
+				t.Fatalf("Failed to write template file: %v", err)
+			}
+			data := NewData(&config.ImageConfig{Values: map[string]interface{}{"items": []interface{}{"1", " 2", " 3"}}}, "testapp")
+
+			got, err := Render(templatePath, data)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+
+			// Guards are per output file, so rendering again starts afresh.
+			again, err := Render(templatePath, data)
+			if err != nil || again != got {
+				t.Errorf("second Render() = %q, %v; want %q", again, err, got)
+			}
+		})
+	}
+
+	templatePath := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+	if err := os.WriteFile(templatePath, []byte(`{{if once ""}}x{{end}}`), 0644); err != nil {
+		t.Fatalf("Failed to write template file: %v", err)
+	}
+	if _, err := Render(templatePath, NewData(&config.ImageConfig{}, "testapp")); err == nil || !strings.Contains(err.Error(), "non-empty name") {
+		t.Errorf("Render() error = %v, want an empty guard name to fail", err)
+	}
+}
+
+func TestRender_Concurrent(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	templatePath := filepath.Join(tmpDir, "Dockerfile.tmpl")
+	templateData := "{{generation_message}}\n{{from_image \"base_image\"}}\nFROM {{from_image \"builder\"}} AS build\nENV VERSION={{version}}\n"
+	if err := os.WriteFile(templatePath, []byte(templateData), 0644); err != nil {
+		t.Fatalf("Failed to write template file: %v", err)
+	}
+
+	data := NewData(&config.ImageConfig{
+		BaseImage: &config.BaseImage{Name: "core:noble"},
+		Values: map[string]interface{}{
+			"registry": "my-registry.io",
+			"builder":  "golang:1.25",
+			"version":  "1.0",
+		},
+	}, "testapp")
+
+	want, err := Render(templatePath, data)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if strings.Count(want, "ARG REGISTRY=") != 1 {
+		t.Fatalf("Render() should emit ARG REGISTRY once, got:\n%s", want)
+	}
+
+	const workers = 16
+	outputs := make([]string, workers)
+	errs := make([]error, workers)
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outputs[i], errs[i] = Render(templatePath, data)
+		}()
+	}
+	wg.Wait()
+
+	for i := range workers {
+		if errs[i] != nil {
+			t.Fatalf("Render() in goroutine %d error = %v", i, errs[i])
+		}
+		if outputs[i] != want {
+			t.Errorf("Render() in goroutine %d = %q, want %q", i, outputs[i], want)
+		}
+	}
+}
+
+func TestRender_RegistryArgInEveryFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	data := NewData(&config.ImageConfig{
+		BaseImage: &config.BaseImage{Name: "core:noble"},
+		Values:    map[string]interface{}{"registry": "my-registry.io"},
+	}, "testapp")
+
+	for _, name := range []string{"Dockerfile.tmpl", "Dockerfile.slim.tmpl"} {
+		templatePath := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(templatePath, []byte("{{from_image \"base_image\"}}\n"), 0644); err != nil {
+			t.Fatalf("Failed to write template file: %v", err)
+		}
+
+		got, err := Render(templatePath, data)
+		if err != nil {
+			t.Fatalf("Render(%s) error = %v", name, err)
+		}
+		if want := "ARG REGISTRY=my-registry.io\nFROM ${REGISTRY}/core:noble\n"; got != want {
+			t.Errorf("Render(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestRender_GenerationMessageStyles(t *testing.T) {
+	data := NewData(&config.ImageConfig{}, "testapp")
+	data.SetHeaderStyles(config.HeaderStyles{".js": config.HeaderSlash, ".css": config.HeaderBlock})
+	data.SetInputsHash("abc123")
+
+	tests := []struct {
+		file         string
+		templateData string
+		want         string
+	}{
+		{"Dockerfile", "{{generation_message}}", "# GENERATED FILE, DO NOT MODIFY!\n#\n# To update"},
+		{"app.js", "{{generation_message}}", "// GENERATED FILE, DO NOT MODIFY!\n//\n// To update"},
+		{"site.css", "{{generation_message}}", "/*\n * GENERATED FILE, DO NOT MODIFY!\n *\n * To update"},
+		{"logback.xml", "{{generation_message}}", "<!--\n  GENERATED FILE, DO NOT MODIFY!\n\n  To update"},
+		{"config.json", "{{generation_message}}{}", "{}"},
+		{"notes.txt", `{{generation_message_for ".js"}}`, "// GENERATED FILE, DO NOT MODIFY!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			templatePath := filepath.Join(t.TempDir(), tt.file+".tmpl")
+			if err := os.WriteFile(templatePath, []byte(tt.templateData), 0644); err != nil {
+				t.Fatalf("Failed to write template file: %v", err)
+			}
+
+			got, err := Render(templatePath, data)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if !strings.HasPrefix(got, tt.want) {
+				t.Errorf("Render() = %q, want it to start with %q", got, tt.want)
+			}
+			if tt.file != "config.json" && !strings.Contains(got, "inputs-sha256: abc123") {
+				t.Errorf("Render() = %q, want the inputs hash recorded", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package ui
+
+import (
+	"strings"
+)
+
+// Diff colors a unified diff: file headers bold, hunk headers cyan, added
+// lines green and removed lines red. Without color it is returned as is.
+func (r *Renderer) Diff(diff string) string {
+	if !r.Color || diff == "" {
+		return diff
+	}
+
+	lines := strings.SplitAfter(diff, "\n")
+	var b strings.Builder
+	for _, line := range lines {
+		text := strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(text, "+++ "), strings.HasPrefix(text, "--- "):
+			text = r.style(fileStyle, text)
+		case strings.HasPrefix(text, "@@"):
+			text = r.style(hunkStyle, text)
+		case strings.HasPrefix(text, "+"):
+			text = r.style(addedStyle, text)
+		case strings.HasPrefix(text, "-"):
+			text = r.style(removedStyle, text)
+		}
+		b.WriteString(text)
+		if strings.HasSuffix(line, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// Changes lists added entries as "+ entry" and removed ones as "- entry",
+// colored like diff lines.
+func (r *Renderer) Changes(added, removed []string) string {
+	var b strings.Builder
+	for _, entry := range added {
+		b.WriteString(r.style(addedStyle, "+ "+entry) + "\n")
+	}
+	for _, entry := range removed {
+		b.WriteString(r.style(removedStyle, "- "+entry) + "\n")
+	}
+	return b.String()
+}
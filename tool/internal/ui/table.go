@@ -0,0 +1,115 @@
+package ui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss/v2"
+)
+
+// columnGap separates table columns.
+const columnGap = "  "
+
+// Table is a set of rows rendered with aligned columns.
+type Table struct {
+	// Headers, when set, are rendered above a rule.
+	Headers []string
+	Rows    [][]string
+	// Indent prefixes every line.
+	Indent string
+	// MaxWidth, when positive, limits each line's width by truncating the
+	// last column.
+	MaxWidth int
+}
+
+// Table renders t. Trailing whitespace is never emitted, so the last column
+// is not padded.
+func (r *Renderer) Table(t Table) string {
+	columns := len(t.Headers)
+	for _, row := range t.Rows {
+		columns = max(columns, len(row))
+	}
+	if columns == 0 {
+		return ""
+	}
+
+	widths := make([]int, columns)
+	measure := func(row []string) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], lipgloss.Width(cell))
+		}
+	}
+	measure(t.Headers)
+	for _, row := range t.Rows {
+		measure(row)
+	}
+
+	if t.MaxWidth > 0 {
+		fixed := lipgloss.Width(t.Indent) + len(columnGap)*(columns-1)
+		for _, width := range widths[:columns-1] {
+			fixed += width
+		}
+		widths[columns-1] = max(min(widths[columns-1], t.MaxWidth-fixed), 1)
+	}
+
+	var b strings.Builder
+	if len(t.Headers) > 0 {
+		b.WriteString(r.row(t, widths, t.Headers, true))
+		rule := make([]string, columns)
+		for i, width := range widths {
+			rule[i] = strings.Repeat(r.ruleChar(), width)
+		}
+		b.WriteString(r.row(t, widths, rule, false))
+	}
+	for _, row := range t.Rows {
+		b.WriteString(r.row(t, widths, row, false))
+	}
+	return b.String()
+}
+
+func (r *Renderer) row(t Table, widths []int, cells []string, header bool) string {
+	var b strings.Builder
+	b.WriteString(t.Indent)
+	for i := range widths {
+		cell := ""
+		if i < len(cells) {
+			cell = r.truncate(cells[i], widths[i])
+		}
+		if header {
+			b.WriteString(r.style(headerStyle, cell))
+		} else {
+			b.WriteString(cell)
+		}
+		if i < len(widths)-1 {
+			b.WriteString(strings.Repeat(" ", widths[i]-lipgloss.Width(cell)))
+			b.WriteString(columnGap)
+		}
+	}
+	return strings.TrimRight(b.String(), " ") + "\n"
+}
+
+func (r *Renderer) ruleChar() string {
+	if r.Color {
+		return "─"
+	}
+	return "-"
+}
+
+// truncate shortens cell to width, marking the cut with an ellipsis.
+func (r *Renderer) truncate(cell string, width int) string {
+	if lipgloss.Width(cell) <= width {
+		return cell
+	}
+	ellipsis := "..."
+	if r.Color {
+		ellipsis = "…"
+	}
+	if width <= lipgloss.Width(ellipsis) {
+		return string([]rune(cell)[:width])
+	}
+
+	runes := []rune(cell)
+	for len(runes) > 0 && lipgloss.Width(string(runes))+lipgloss.Width(ellipsis) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + ellipsis
+}
@@ -0,0 +1,51 @@
+// Package ui renders human-readable command output: aligned tables and
+// colored diffs. Output is colored only when writing to a terminal and
+// NO_COLOR is unset; otherwise it is plain ASCII.
+package ui
+
+import (
+	"io"
+	"os"
+
+	"github.com/charmbracelet/lipgloss/v2"
+)
+
+// Renderer renders tables and diffs for one output stream.
+type Renderer struct {
+	// Color enables ANSI styling and Unicode rules.
+	Color bool
+}
+
+// NewRenderer returns a Renderer for w, coloring only terminals and only
+// when NO_COLOR is unset.
+func NewRenderer(w io.Writer) *Renderer {
+	return &Renderer{Color: isTerminal(w) && os.Getenv("NO_COLOR") == ""}
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+var (
+	headerStyle  = lipgloss.NewStyle().Bold(true)
+	addedStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	removedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	hunkStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("6"))
+	fileStyle    = lipgloss.NewStyle().Bold(true)
+)
+
+// style applies s when color is enabled.
+func (r *Renderer) style(s lipgloss.Style, text string) string {
+	if !r.Color || text == "" {
+		return text
+	}
+	return s.Render(text)
+}
@@ -0,0 +1,107 @@
+package ui
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// compareGolden compares got with testdata/name, rewriting it first when the
+// test runs with -update.
+func compareGolden(t *testing.T, name, got string) {
+	t.Helper()
+	goldenPath := filepath.Join("testdata", name)
+
+	if *updateGolden {
+		if err := os.WriteFile(goldenPath, []byte(got), 0644); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+	}
+
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("Failed to read golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal([]byte(got), want) {
+		t.Errorf("output does not match %s (run with -update to accept)\ngot:\n%q\nwant:\n%q", goldenPath, got, want)
+	}
+}
+
+func TestNewRenderer(t *testing.T) {
+	var buf bytes.Buffer
+	if NewRenderer(&buf).Color {
+		t.Error("a buffer is not a terminal and should not be colored")
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatalf("CreateTemp() error = %v", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	if NewRenderer(f).Color {
+		t.Error("a regular file is not a terminal and should not be colored")
+	}
+}
+
+func TestTable(t *testing.T) {
+	table := Table{
+		Headers: []string{"IMAGE", "VERSION", "PROBLEM"},
+		Rows: [][]string{
+			{"python", "3.12", `keys "python-version" and "python_version" differ only by case or separator`},
+			{"core", "", "image has no versions"},
+			{"static", "v3", "base_image source scratch cannot name another image (alpine:3.20)"},
+		},
+		MaxWidth: 60,
+	}
+
+	for _, tt := range []struct {
+		golden string
+		color  bool
+	}{
+		{"table.plain.golden", false},
+		{"table.color.golden", true},
+	} {
+		t.Run(tt.golden, func(t *testing.T) {
+			compareGolden(t, tt.golden, (&Renderer{Color: tt.color}).Table(table))
+		})
+	}
+}
+
+func TestTable_NoHeaders(t *testing.T) {
+	got := (&Renderer{}).Table(Table{
+		Indent: "  ",
+		Rows:   [][]string{{"1", "python:3.13"}, {"12", "app:v1"}, {"3"}},
+	})
+	want := "  1   python:3.13\n  12  app:v1\n  3\n"
+	if got != want {
+		t.Errorf("Table() =\n%q\nwant\n%q", got, want)
+	}
+
+	if got := (&Renderer{}).Table(Table{}); got != "" {
+		t.Errorf("empty Table() = %q, want nothing", got)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	diff := "--- a/3.13/Dockerfile\n+++ b/3.13/Dockerfile\n@@ -1,2 +1,2 @@\n-FROM python:3.12\n+FROM python:3.13\n RUN true\n"
+
+	if got := (&Renderer{}).Diff(diff); got != diff {
+		t.Errorf("plain Diff() should be unchanged, got %q", got)
+	}
+	compareGolden(t, "diff.color.golden", (&Renderer{Color: true}).Diff(diff))
+}
+
+func TestChanges(t *testing.T) {
+	added, removed := []string{"Build app:v2"}, []string{"Build app:v1"}
+
+	if got, want := (&Renderer{}).Changes(added, removed), "+ Build app:v2\n- Build app:v1\n"; got != want {
+		t.Errorf("plain Changes() = %q, want %q", got, want)
+	}
+	compareGolden(t, "changes.color.golden", (&Renderer{Color: true}).Changes(added, removed))
+}
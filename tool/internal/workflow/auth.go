@@ -0,0 +1,213 @@
+package workflow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+)
+
+// Step is a single `uses` step rendered into a job.
+type Step struct {
+	Name string
+	// If is the step's condition, empty to always run it.
+	If   string
+	Uses string
+	With []Input
+}
+
+// Input is one entry of a step's `with` block. Inputs are kept ordered so the
+// rendered workflow is stable.
+type Input struct {
+	Key   string
+	Value string
+}
+
+// Permission is one entry of a job's `permissions` block.
+type Permission struct {
+	Scope  string
+	Access string
+}
+
+// authProvider renders the login for one registry.
+type authProvider interface {
+	Steps(registry string, auth config.RegistryAuth) ([]Step, error)
+	Permissions() []Permission
+}
+
+const (
+	AuthGHCR        = "ghcr"
+	AuthBasicSecret = "basic-secret"
+	AuthECR         = "ecr"
+)
+
+var authProviders = map[string]authProvider{
+	AuthGHCR:        ghcrAuth{},
+	AuthBasicSecret: basicSecretAuth{},
+	AuthECR:         ecrAuth{},
+}
+
+const (
+	dockerLoginAction    = "docker/login-action@5e57cd118135c172c3672efd75eb46360885c0ef # v3.6.0"
+	awsCredentialsAction = "aws-actions/configure-aws-credentials@e3dd6a429d7300a6a4c196c26e071d42e0343502 # v4.0.2"
+	amazonECRLoginAction = "aws-actions/amazon-ecr-login@062b18b96a7aff071d4dc91bc00c4c1a7945b076 # v2.0.1"
+)
+
+// ghcrAuth logs in to the GitHub Container Registry with the workflow token.
+type ghcrAuth struct{}
+
+func (ghcrAuth) Steps(registry string, _ config.RegistryAuth) ([]Step, error) {
+	return []Step{{
+		Name: fmt.Sprintf("Login to %s", registry),
+		Uses: dockerLoginAction,
+		With: []Input{
+			{"registry", registry},
+			{"username", "${{ github.actor }}"},
+			{"password", "${{ secrets.GITHUB_TOKEN }}"},
+		},
+	}}, nil
+}
+
+func (ghcrAuth) Permissions() []Permission {
+	return []Permission{{"packages", "write"}}
+}
+
+// basicSecretAuth logs in with a username and password stored as repository
+// secrets, e.g. a Harbor robot account.
+type basicSecretAuth struct{}
+
+func (basicSecretAuth) Steps(registry string, auth config.RegistryAuth) ([]Step, error) {
+	if auth.UsernameSecret == "" || auth.PasswordSecret == "" {
+		return nil, fmt.Errorf("%s auth requires username_secret and password_secret", AuthBasicSecret)
+	}
+	return []Step{{
+		Name: fmt.Sprintf("Login to %s", registry),
+		Uses: dockerLoginAction,
+		With: []Input{
+			{"registry", registry},
+			{"username", fmt.Sprintf("${{ secrets.%s }}", auth.UsernameSecret)},
+			{"password", fmt.Sprintf("${{ secrets.%s }}", auth.PasswordSecret)},
+		},
+	}}, nil
+}
+
+func (basicSecretAuth) Permissions() []Permission {
+	return nil
+}
+
+// ecrAuth assumes an IAM role through GitHub OIDC and logs in to ECR.
+type ecrAuth struct{}
+
+func (ecrAuth) Steps(registry string, auth config.RegistryAuth) ([]Step, error) {
+	if auth.Role == "" || auth.Region == "" {
+		return nil, fmt.Errorf("%s auth requires role and region", AuthECR)
+	}
+	return []Step{
+		{
+			Name: fmt.Sprintf("Configure AWS credentials for %s", registry),
+			Uses: awsCredentialsAction,
+			With: []Input{
+				{"role-to-assume", auth.Role},
+				{"aws-region", auth.Region},
+			},
+		},
+		{
+			Name: fmt.Sprintf("Login to %s", registry),
+			Uses: amazonECRLoginAction,
+			With: []Input{
+				{"registries", strings.SplitN(registry, ".", 2)[0]},
+			},
+		},
+	}, nil
+}
+
+func (ecrAuth) Permissions() []Permission {
+	return []Permission{{"id-token", "write"}}
+}
+
+// applyAuth renders login steps and permissions into each job for the
+// registries it uses. Jobs are left untouched when no auth is configured, in
+// which case the build action logs in to the default registry itself.
+func applyAuth(cfg *config.Config, jobs []Job) error {
+	if cfg.Defaults.Workflow == nil || len(cfg.Defaults.Workflow.Auth) == 0 {
+		return nil
+	}
+	auths := cfg.Defaults.Workflow.Auth
+
+	for registry, auth := range auths {
+		if _, exists := authProviders[auth.Provider]; !exists {
+			return fmt.Errorf("unknown auth provider %q for registry %s (supported: %s)", auth.Provider, registry, supportedAuthProviders())
+		}
+	}
+
+	for i := range jobs {
+		registries, err := jobRegistries(cfg, jobs[i])
+		if err != nil {
+			return err
+		}
+
+		permissions := map[string]string{"contents": "read"}
+		var steps []Step
+		for _, registry := range registries {
+			auth, exists := auths[registry]
+			if !exists {
+				return fmt.Errorf("%s uses registry %s, which has no entry in defaults.workflow.auth", jobs[i].Name, registry)
+			}
+			provider := authProviders[auth.Provider]
+
+			registrySteps, err := provider.Steps(registry, auth)
+			if err != nil {
+				return fmt.Errorf("registry %s: %w", registry, err)
+			}
+			steps = append(steps, registrySteps...)
+			for _, permission := range provider.Permissions() {
+				permissions[permission.Scope] = permission.Access
+			}
+		}
+
+		jobs[i].LoginSteps = steps
+		jobs[i].Permissions = sortedPermissions(permissions)
+	}
+
+	return nil
+}
+
+// jobRegistries returns the hosts a job pushes to and pulls internal base
+// images from, which is the host of its resolved registry value.
+func jobRegistries(cfg *config.Config, job Job) ([]string, error) {
+	merged, err := generator.MergedConfig(cfg, job.ImageName, job.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	registry, _ := merged.Values["registry"].(string)
+	if registry == "" {
+		return nil, nil
+	}
+	return []string{strings.SplitN(registry, "/", 2)[0]}, nil
+}
+
+func sortedPermissions(permissions map[string]string) []Permission {
+	scopes := make([]string, 0, len(permissions))
+	for scope := range permissions {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+
+	result := make([]Permission, 0, len(scopes))
+	for _, scope := range scopes {
+		result = append(result, Permission{scope, permissions[scope]})
+	}
+	return result
+}
+
+func supportedAuthProviders() string {
+	names := make([]string, 0, len(authProviders))
+	for name := range authProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
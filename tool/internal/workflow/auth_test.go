@@ -0,0 +1,189 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestAuthProviders_Golden(t *testing.T) {
+	tests := []struct {
+		provider string
+		registry string
+		auth     config.RegistryAuth
+	}{
+		{
+			provider: AuthGHCR,
+			registry: "ghcr.io",
+			auth:     config.RegistryAuth{Provider: AuthGHCR},
+		},
+		{
+			provider: AuthBasicSecret,
+			registry: "harbor.example.com",
+			auth: config.RegistryAuth{
+				Provider:       AuthBasicSecret,
+				UsernameSecret: "HARBOR_USERNAME",
+				PasswordSecret: "HARBOR_PASSWORD",
+			},
+		},
+		{
+			provider: AuthECR,
+			registry: "123456789012.dkr.ecr.us-east-1.amazonaws.com",
+			auth: config.RegistryAuth{
+				Provider: AuthECR,
+				Role:     "arn:aws:iam::123456789012:role/ci-push",
+				Region:   "us-east-1",
+			},
+		},
+	}
+
+	tmpl := template.Must(template.New("workflow").Funcs(templateFuncs).Parse(workflowTemplate))
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			steps, err := authProviders[tt.provider].Steps(tt.registry, tt.auth)
+			if err != nil {
+				t.Fatalf("Steps() error = %v", err)
+			}
+
+			var got strings.Builder
+			if err := tmpl.ExecuteTemplate(&got, "login-steps", steps); err != nil {
+				t.Fatalf("rendering login steps: %v", err)
+			}
+
+			goldenPath := filepath.Join("testdata", "auth", tt.provider+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(goldenPath, []byte(got.String()), 0644); err != nil {
+					t.Fatalf("Failed to update golden file: %v", err)
+				}
+			}
+			want, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Fatalf("Failed to read golden file: %v", err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("login steps mismatch\ngot:\n%s\nwant:\n%s", got.String(), want)
+			}
+		})
+	}
+}
+
+func TestAuthProviders_MissingFields(t *testing.T) {
+	if _, err := authProviders[AuthBasicSecret].Steps("harbor.example.com", config.RegistryAuth{Provider: AuthBasicSecret}); err == nil {
+		t.Error("basic-secret without secrets should fail")
+	}
+	if _, err := authProviders[AuthECR].Steps("123.dkr.ecr.us-east-1.amazonaws.com", config.RegistryAuth{Provider: AuthECR, Region: "us-east-1"}); err == nil {
+		t.Error("ecr without a role should fail")
+	}
+}
+
+func TestApplyAuth(t *testing.T) {
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Registry: "ghcr.io/owner",
+			Workflow: &config.Workflow{
+				Auth: map[string]config.RegistryAuth{
+					"ghcr.io": {Provider: AuthGHCR},
+					"harbor.example.com": {
+						Provider:       AuthBasicSecret,
+						UsernameSecret: "HARBOR_USERNAME",
+						PasswordSecret: "HARBOR_PASSWORD",
+					},
+				},
+			},
+		},
+		Images: map[string]config.Image{
+			"core": {
+				Versions: map[string]*config.ImageConfig{"noble": {}},
+			},
+			"app": {
+				Defaults: &config.ImageConfig{Values: map[string]interface{}{"registry": "harbor.example.com/team"}},
+				Versions: map[string]*config.ImageConfig{"v1": {}},
+			},
+		},
+	}
+
+	jobs := []Job{
+		{ID: "core-noble", Name: "Build core:noble", ImageName: "core", Version: "noble"},
+		{ID: "app-v1", Name: "Build app:v1", ImageName: "app", Version: "v1"},
+	}
+	if err := applyAuth(cfg, jobs); err != nil {
+		t.Fatalf("applyAuth() error = %v", err)
+	}
+
+	if len(jobs[0].LoginSteps) != 1 || jobs[0].LoginSteps[0].Name != "Login to ghcr.io" {
+		t.Errorf("core:noble login steps = %+v, want a single ghcr.io login", jobs[0].LoginSteps)
+	}
+	wantPermissions := []Permission{{"contents", "read"}, {"packages", "write"}}
+	if !reflect.DeepEqual(jobs[0].Permissions, wantPermissions) {
+		t.Errorf("core:noble permissions = %v, want %v", jobs[0].Permissions, wantPermissions)
+	}
+
+	if len(jobs[1].LoginSteps) != 1 || jobs[1].LoginSteps[0].Name != "Login to harbor.example.com" {
+		t.Errorf("app:v1 login steps = %+v, want a single harbor.example.com login", jobs[1].LoginSteps)
+	}
+	if !reflect.DeepEqual(jobs[1].Permissions, []Permission{{"contents", "read"}}) {
+		t.Errorf("app:v1 permissions = %v, want contents: read only", jobs[1].Permissions)
+	}
+}
+
+func TestApplyAuth_NotConfigured(t *testing.T) {
+	cfg := &config.Config{
+		Defaults: config.Defaults{Registry: "ghcr.io/owner"},
+		Images: map[string]config.Image{
+			"core": {Versions: map[string]*config.ImageConfig{"noble": {}}},
+		},
+	}
+
+	jobs := []Job{{ID: "core-noble", Name: "Build core:noble", ImageName: "core", Version: "noble"}}
+	if err := applyAuth(cfg, jobs); err != nil {
+		t.Fatalf("applyAuth() error = %v", err)
+	}
+	if jobs[0].LoginSteps != nil || jobs[0].Permissions != nil {
+		t.Errorf("jobs should be untouched without auth config, got %+v", jobs[0])
+	}
+}
+
+func TestApplyAuth_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		auth    map[string]config.RegistryAuth
+		wantErr string
+	}{
+		{
+			name:    "unknown provider",
+			auth:    map[string]config.RegistryAuth{"ghcr.io": {Provider: "gcr"}},
+			wantErr: `unknown auth provider "gcr"`,
+		},
+		{
+			name:    "registry without auth entry",
+			auth:    map[string]config.RegistryAuth{"harbor.example.com": {Provider: AuthGHCR}},
+			wantErr: "has no entry in defaults.workflow.auth",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Defaults: config.Defaults{
+					Registry: "ghcr.io/owner",
+					Workflow: &config.Workflow{Auth: tt.auth},
+				},
+				Images: map[string]config.Image{
+					"core": {Versions: map[string]*config.ImageConfig{"noble": {}}},
+				},
+			}
+
+			jobs := []Job{{ID: "core-noble", Name: "Build core:noble", ImageName: "core", Version: "noble"}}
+			err := applyAuth(cfg, jobs)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("applyAuth() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package workflow
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+var registryRefPattern = regexp.MustCompile(`^\$\{REGISTRY\}/([^:@\s]+):([^@\s]+)(?:@\S+)?$`)
+
+// parseDockerfileDependenciesBuildkit is the AST-backed counterpart of
+// parseDockerfileDependencies. Heredoc bodies and comments are never scanned,
+// and references nested under ONBUILD are reported separately since they only
+// apply to downstream builds.
+func parseDockerfileDependenciesBuildkit(dockerfilePath string) (*dependencies, error) {
+	content, err := os.ReadFile(dockerfilePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading Dockerfile: %w", err)
+	}
+	return parseDockerfileContentBuildkit(content)
+}
+
+// parseDockerfileContentBuildkit is the parser behind
+// parseDockerfileDependenciesBuildkit.
+func parseDockerfileContentBuildkit(content []byte) (*dependencies, error) {
+	if err := checkLineLengths(content); err != nil {
+		return nil, err
+	}
+
+	if !hasInstructions(content) {
+		return &dependencies{Build: []string{}, Bases: []string{}, OnBuild: []string{}, Lines: map[string]int{}}, nil
+	}
+
+	result, err := parser.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("parsing Dockerfile: %w", err)
+	}
+
+	// Track internal stage names
+	stageNames := make(map[string]bool)
+	for _, node := range result.AST.Children {
+		if !strings.EqualFold(node.Value, "from") {
+			continue
+		}
+		args := nodeArgs(node)
+		if len(args) == 3 && strings.EqualFold(args[1], "as") {
+			stageNames[args[2]] = true
+		}
+	}
+
+	buildMap := make(map[string]int)
+	baseMap := make(map[string]int)
+	onBuildMap := make(map[string]int)
+	for _, node := range result.AST.Children {
+		switch strings.ToLower(node.Value) {
+		case "from":
+			if args := nodeArgs(node); len(args) > 0 {
+				if dep, ok := registryDependency(args[0]); ok {
+					addDependency(buildMap, dep, node.StartLine)
+					addDependency(baseMap, dep, node.StartLine)
+				}
+			}
+		case "copy":
+			addCopyDependencies(node, node.StartLine, stageNames, buildMap)
+		case "onbuild":
+			if node.Next == nil {
+				continue
+			}
+			for _, trigger := range node.Next.Children {
+				if strings.EqualFold(trigger.Value, "copy") {
+					// Triggers are parsed without positions of their own.
+					addCopyDependencies(trigger, node.StartLine, stageNames, onBuildMap)
+				}
+			}
+		}
+	}
+
+	return newDependencies(buildMap, baseMap, onBuildMap), nil
+}
+
+// addCopyDependencies records the registry image a COPY --from refers to,
+// found on line.
+func addCopyDependencies(node *parser.Node, line int, stageNames map[string]bool, depsMap map[string]int) {
+	for _, flag := range node.Flags {
+		fromRef, ok := strings.CutPrefix(flag, "--from=")
+		if !ok || stageNames[fromRef] {
+			continue
+		}
+		if dep, ok := registryDependency(fromRef); ok {
+			addDependency(depsMap, dep, line)
+		}
+	}
+}
+
+func nodeArgs(node *parser.Node) []string {
+	var args []string
+	for n := node.Next; n != nil; n = n.Next {
+		args = append(args, n.Value)
+	}
+	return args
+}
+
+func registryDependency(ref string) (string, bool) {
+	match := registryRefPattern.FindStringSubmatch(ref)
+	if match == nil {
+		return "", false
+	}
+	return fmt.Sprintf("%s:%s", match[1], match[2]), true
+}
+
+// hasInstructions reports whether content contains anything besides blank
+// lines and comments, which the BuildKit parser rejects as an error.
+func hasInstructions(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// Cache types accepted by workflow.cache.type.
+const (
+	CacheGHA      = "gha"
+	CacheRegistry = "registry"
+)
+
+// jobCache resolves the buildx --cache-from and --cache-to values of one
+// image version: the image's own cache setting, else defaults.workflow.cache.
+// The GitHub Actions cache is scoped by job ID so that parallel jobs do not
+// evict each other's layers. It returns empty strings when neither sets a
+// cache, leaving the build action's default in place.
+func jobCache(cfg *config.Config, image config.Image, imageName, version, jobID string) (from, to string, err error) {
+	cache := image.WorkflowCache(version)
+	if cache == nil && cfg.Defaults.Workflow != nil {
+		cache = cfg.Defaults.Workflow.Cache
+	}
+	if cache == nil {
+		return "", "", nil
+	}
+
+	switch cache.Type {
+	case CacheGHA:
+		from = "type=gha,scope=" + jobID
+	case CacheRegistry:
+		if cache.Ref == "" {
+			return "", "", fmt.Errorf("%s cache requires ref", CacheRegistry)
+		}
+		tmpl, err := template.New("ref").Option("missingkey=error").Parse(cache.Ref)
+		if err != nil {
+			return "", "", fmt.Errorf("parsing cache ref: %w", err)
+		}
+		var ref strings.Builder
+		data := struct{ Image, Version string }{imageName, version}
+		if err := tmpl.Execute(&ref, data); err != nil {
+			return "", "", fmt.Errorf("rendering cache ref: %w", err)
+		}
+		if strings.ContainsAny(ref.String(), ", \n") {
+			return "", "", fmt.Errorf("cache ref %q cannot contain commas or whitespace", ref.String())
+		}
+		from = "type=registry,ref=" + ref.String()
+	default:
+		return "", "", fmt.Errorf("unknown cache type %q (supported: %s, %s)", cache.Type, CacheGHA, CacheRegistry)
+	}
+	return from, from + ",mode=max", nil
+}
@@ -0,0 +1,97 @@
+package workflow
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestJobCache(t *testing.T) {
+	var cfg config.Config
+	manifest := `
+version: 1
+defaults:
+  workflow:
+    cache: {type: gha}
+images:
+  python:
+    versions:
+      "3.13": {}
+  cuda:
+    workflow:
+      cache: {type: registry, ref: "ghcr.io/acme/cache:{{.Image}}-{{.Version}}"}
+    versions:
+      "12": {}
+  broken:
+    workflow:
+      cache: {type: s3}
+    versions:
+      v1: {}
+  noref:
+    workflow:
+      cache: {type: registry}
+    versions:
+      v1: {}
+`
+	if err := yaml.Unmarshal([]byte(manifest), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	tests := []struct {
+		image, version   string
+		wantFrom, wantTo string
+		wantErr          string
+	}{
+		{image: "python", version: "3.13", wantFrom: "type=gha,scope=python-3-13", wantTo: "type=gha,scope=python-3-13,mode=max"},
+		{image: "cuda", version: "12", wantFrom: "type=registry,ref=ghcr.io/acme/cache:cuda-12", wantTo: "type=registry,ref=ghcr.io/acme/cache:cuda-12,mode=max"},
+		{image: "broken", version: "v1", wantErr: "unknown cache type"},
+		{image: "noref", version: "v1", wantErr: "requires ref"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image+":"+tt.version, func(t *testing.T) {
+			from, to, err := jobCache(&cfg, cfg.Images[tt.image], tt.image, tt.version, generateJobID(tt.image, tt.version))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("jobCache() error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("jobCache() error = %v", err)
+			}
+			if from != tt.wantFrom || to != tt.wantTo {
+				t.Errorf("jobCache() = %q, %q, want %q, %q", from, to, tt.wantFrom, tt.wantTo)
+			}
+		})
+	}
+
+	if from, to, err := jobCache(&config.Config{}, config.Image{}, "app", "v1", "app-v1"); err != nil || from != "" || to != "" {
+		t.Errorf("jobCache() = %q, %q, %v, want no cache when unset", from, to, err)
+	}
+}
+
+func TestWriteWorkflow_Cache(t *testing.T) {
+	jobs := []Job{
+		{ID: "app-v1", Name: "Build app:v1", ImageName: "app", Version: "v1", CacheFrom: "type=gha,scope=app-v1", CacheTo: "type=gha,scope=app-v1,mode=max"},
+		{ID: "tool-v1", Name: "Build tool:v1", ImageName: "tool", Version: "v1"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeWorkflowToWriter(Workflow{Jobs: jobs}, &buf); err != nil {
+		t.Fatalf("writeWorkflowToWriter() error = %v", err)
+	}
+	output := buf.String()
+
+	want := "          cache_from: \"type=gha,scope=app-v1\"\n" +
+		"          cache_to: \"type=gha,scope=app-v1,mode=max\"\n"
+	if !strings.Contains(output, want) {
+		t.Errorf("workflow should pass the cache to the build action, got:\n%s", output)
+	}
+	if strings.Count(output, "cache_from:") != 1 {
+		t.Errorf("only app-v1 should set a cache, got:\n%s", output)
+	}
+}
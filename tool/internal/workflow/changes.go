@@ -0,0 +1,126 @@
+package workflow
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// jobPaths returns the repository paths, as globs, whose changes rebuild an
+// image version: its version directory, the image's source directory, its
+// relative template directories and the manifests it is declared in.
+func jobPaths(cfg *config.Config, image config.Image, version string) []string {
+	root := imagesRoot(cfg)
+	paths := []string{
+		path.Join(root, filepath.ToSlash(image.Path), version) + "/**",
+		path.Join(root, filepath.ToSlash(image.Path), "source") + "/**",
+	}
+	for _, dir := range image.TemplateDirs {
+		if !filepath.IsAbs(dir) {
+			paths = append(paths, path.Join(root, filepath.ToSlash(dir))+"/**")
+		}
+	}
+	manifests := []string{image.Origin.File}
+	if cfg.File != image.Origin.File {
+		// An image from an included manifest also takes the root's defaults.
+		manifests = append(manifests, cfg.File)
+	}
+	for _, manifest := range manifests {
+		if manifest != "" && manifest != "<stdin>" {
+			paths = append(paths, path.Join(root, manifestPath(cfg, manifest)))
+		}
+	}
+	return paths
+}
+
+// manifestPath returns the path of a manifest relative to the root
+// manifest's directory, where included manifests live below.
+func manifestPath(cfg *config.Config, manifest string) string {
+	if cfg.File != "" && cfg.File != "<stdin>" {
+		if rel, err := filepath.Rel(filepath.Dir(cfg.File), manifest); err == nil {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.Base(manifest)
+}
+
+// Change is an image version affected by a set of changed paths.
+type Change struct {
+	Image   string `json:"image"`
+	Version string `json:"version"`
+	// Direct is set when one of the version's own inputs changed, and unset
+	// when it is affected through an image it builds on.
+	Direct bool `json:"direct"`
+}
+
+// ChangedVersions returns the versions of jobs with an input among paths,
+// which are relative to the repository root, together with every version
+// transitively built on them, in the jobs' build order. An input is matched
+// like the job's workflow path filters, so a change to one version directory
+// affects only that version, while a change to the source directory, a
+// template directory or a manifest affects the whole image. A change to the
+// shared partials affects every version.
+func ChangedVersions(cfg *config.Config, jobs []Job, paths []string) []Change {
+	partials := partialsPath(cfg)
+	var roots []string
+	direct := make(map[string]bool)
+	for _, job := range jobs {
+		patterns := job.Paths
+		if partials != "" {
+			patterns = append(append([]string(nil), patterns...), partials)
+		}
+		if matchesAny(patterns, paths) {
+			node := fmt.Sprintf("%s:%s", job.ImageName, job.Version)
+			roots = append(roots, node)
+			direct[node] = true
+		}
+	}
+
+	affected := make(map[string]bool, len(roots))
+	for _, root := range roots {
+		affected[root] = true
+	}
+	for _, reach := range graphFromJobs(jobs).TransitiveDependents(roots...) {
+		affected[reach.Node] = true
+	}
+
+	var changes []Change
+	for _, job := range jobs {
+		node := fmt.Sprintf("%s:%s", job.ImageName, job.Version)
+		if affected[node] {
+			changes = append(changes, Change{Image: job.ImageName, Version: job.Version, Direct: direct[node]})
+		}
+	}
+	return changes
+}
+
+// partialsPath returns the glob of the shared partials directory relative to
+// the repository root, or "" if it lies outside the repository.
+func partialsPath(cfg *config.Config) string {
+	dir := cfg.Defaults.Partials
+	if dir == "" {
+		dir = config.DefaultPartialsDir
+	}
+	if filepath.IsAbs(dir) {
+		return ""
+	}
+	return path.Join(imagesRoot(cfg), filepath.ToSlash(dir)) + "/**"
+}
+
+// matchesAny reports whether one of paths matches one of the patterns: a
+// directory glob ending in /** or an exact path.
+func matchesAny(patterns, paths []string) bool {
+	for _, pattern := range patterns {
+		dir, isDir := strings.CutSuffix(pattern, "/**")
+		for _, p := range paths {
+			p = path.Clean(filepath.ToSlash(p))
+			if p == pattern || (isDir && strings.HasPrefix(p, dir+"/")) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package workflow
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestJobPaths(t *testing.T) {
+	image := config.Image{Path: "lang/python", Origin: config.Origin{File: filepath.Join("images", "manifest.yaml")}}
+
+	got := jobPaths(&config.Config{}, image, "3.13")
+	want := []string{"images/lang/python/3.13/**", "images/lang/python/source/**", "images/manifest.yaml"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("jobPaths() = %q, want %q", got, want)
+	}
+
+	image.Origin.File = "<stdin>"
+	if got = jobPaths(&config.Config{}, image, "3.13"); len(got) != 2 {
+		t.Errorf("jobPaths() = %q, want no manifest path for a manifest read from stdin", got)
+	}
+
+	cfg := &config.Config{File: filepath.Join("images", "manifest.yaml")}
+	image.Origin.File = filepath.Join("images", "lang", "manifest.yaml")
+	got = jobPaths(cfg, image, "3.13")
+	want = []string{"images/lang/python/3.13/**", "images/lang/python/source/**", "images/lang/manifest.yaml", "images/manifest.yaml"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("jobPaths() = %q, want %q for an image from an included manifest", got, want)
+	}
+
+	image.Origin.File = "<stdin>"
+	image.TemplateDirs = []string{filepath.Join("templates", "debian-common")}
+	got = jobPaths(&config.Config{}, image, "3.13")
+	if want := "images/templates/debian-common/**"; got[len(got)-1] != want {
+		t.Errorf("jobPaths() = %q, want it to end with %q", got, want)
+	}
+}
+
+func TestWriteWorkflow_Changes(t *testing.T) {
+	jobs := []Job{
+		{ID: "core-v1", Name: "Build core:v1", ImageName: "core", Version: "v1", DockerfilePath: "images/core/v1/Dockerfile", Paths: []string{"images/core/v1/**", "images/manifest.yaml"}},
+		{ID: "app-v1", Name: "Build app:v1", ImageName: "app", Version: "v1", DockerfilePath: "images/app/v1/Dockerfile", Needs: []string{"core-v1"}, Paths: []string{"images/app/v1/**"}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeWorkflowToWriter(Workflow{Jobs: jobs}, &buf); err != nil {
+		t.Fatalf("writeWorkflowToWriter() error = %v", err)
+	}
+	checkWorkflowStructure(t, buf.Bytes())
+
+	var parsed struct {
+		Jobs map[string]struct {
+			If      string            `yaml:"if"`
+			Needs   []string          `yaml:"needs"`
+			Outputs map[string]string `yaml:"outputs"`
+			Steps   []struct {
+				With map[string]string `yaml:"with"`
+			} `yaml:"steps"`
+		} `yaml:"jobs"`
+	}
+	if err := yaml.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatalf("rendered workflow is not valid YAML: %v", err)
+	}
+
+	changes := parsed.Jobs["changes"]
+	if len(changes.Outputs) != 2 || changes.Outputs["app-v1"] != "${{ steps.filter.outputs.app-v1 }}" {
+		t.Errorf("changes outputs = %v, want one per build job", changes.Outputs)
+	}
+	var filters map[string][]string
+	if err := yaml.Unmarshal([]byte(changes.Steps[len(changes.Steps)-1].With["filters"]), &filters); err != nil {
+		t.Fatalf("filters are not valid YAML: %v", err)
+	}
+	if strings.Join(filters["core-v1"], ",") != "images/core/v1/**,images/manifest.yaml" {
+		t.Errorf("core-v1 filter = %q, want its paths", filters["core-v1"])
+	}
+
+	app := parsed.Jobs["app-v1"]
+	if strings.Join(app.Needs, ",") != "wait-for-ci,changes,core-v1" {
+		t.Errorf("app-v1 needs = %v", app.Needs)
+	}
+	for _, want := range []string{"!cancelled()", "needs.changes.outputs.app-v1 == 'true'", "needs.core-v1.result == 'success'", "github.event_name == 'schedule'"} {
+		if !strings.Contains(app.If, want) {
+			t.Errorf("app-v1 if = %q, want it to contain %q", app.If, want)
+		}
+	}
+}
+
+func TestChangedVersions(t *testing.T) {
+	jobs := []Job{
+		{ID: "core-v1", ImageName: "core", Version: "v1", Paths: []string{"images/core/v1/**", "images/core/source/**", "images/manifest.yaml"}},
+		{ID: "core-v2", ImageName: "core", Version: "v2", Paths: []string{"images/core/v2/**", "images/core/source/**", "images/manifest.yaml"}},
+		{ID: "app-v1", ImageName: "app", Version: "v1", Needs: []string{"core-v1"}, Paths: []string{"images/app/v1/**", "images/app/source/**", "images/manifest.yaml"}},
+		{ID: "tool-v1", ImageName: "tool", Version: "v1", Needs: []string{"app-v1"}, Paths: []string{"images/tool/v1/**", "images/tool/source/**", "images/manifest.yaml"}},
+	}
+
+	tests := []struct {
+		name  string
+		paths []string
+		want  string
+	}{
+		{"version directory", []string{"images/core/v1/Dockerfile"}, "core:v1* app:v1 tool:v1"},
+		{"other version", []string{"images/core/v2/Dockerfile"}, "core:v2*"},
+		{"source directory", []string{"images/core/source/Dockerfile.tmpl"}, "core:v1* core:v2* app:v1 tool:v1"},
+		{"leaf image", []string{"images/app/source/Dockerfile.tmpl"}, "app:v1* tool:v1"},
+		{"manifest", []string{"images/manifest.yaml"}, "core:v1* core:v2* app:v1* tool:v1*"},
+		{"partials", []string{"images/templates/partials/user.tmpl"}, "core:v1* core:v2* app:v1* tool:v1*"},
+		{"unrelated", []string{"README.md", "images/core/v10/Dockerfile", "images/manifest.yaml.bak"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, change := range ChangedVersions(&config.Config{}, jobs, tt.paths) {
+				node := change.Image + ":" + change.Version
+				if change.Direct {
+					node += "*"
+				}
+				got = append(got, node)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("ChangedVersions() = %q, want %q", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package workflow
+
+import (
+	"sort"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// RequiredChecks returns the check names GitHub reports for the generated
+// build jobs, sorted for stable output. These are the names branch protection
+// rules need to enumerate.
+func RequiredChecks(cfg *config.Config) ([]string, error) {
+	jobs, err := Jobs(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		checks = append(checks, job.Name)
+	}
+	sort.Strings(checks)
+
+	return checks, nil
+}
+
+// DiffChecks compares a previously saved list of checks against the current
+// one and returns the names that were added and removed, each sorted.
+func DiffChecks(previous, current []string) (added, removed []string) {
+	previousSet := make(map[string]bool, len(previous))
+	for _, check := range previous {
+		previousSet[check] = true
+	}
+	currentSet := make(map[string]bool, len(current))
+	for _, check := range current {
+		currentSet[check] = true
+	}
+
+	for _, check := range current {
+		if !previousSet[check] {
+			added = append(added, check)
+		}
+	}
+	for _, check := range previous {
+		if !currentSet[check] {
+			removed = append(removed, check)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
@@ -0,0 +1,79 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestRequiredChecks(t *testing.T) {
+	tmpDir := t.TempDir()
+	disabled := false
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: filepath.Join(tmpDir, "images")},
+		Images: map[string]config.Image{
+			"core": {
+				Path: "core",
+				Versions: map[string]*config.ImageConfig{
+					"noble": {},
+					"jammy": {},
+				},
+			},
+			"app": {
+				Path: "app",
+				Versions: map[string]*config.ImageConfig{
+					"v1": {},
+					"v2": {Workflow: &config.ImageWorkflow{Enabled: &disabled}},
+				},
+			},
+		},
+	}
+
+	dockerfiles := map[string]string{
+		"core/noble": "FROM ubuntu:noble\n",
+		"core/jammy": "FROM ubuntu:jammy\n",
+		"app/v1":     "FROM ${REGISTRY}/core:noble\n",
+	}
+	for path, content := range dockerfiles {
+		dockerfilePath := filepath.Join(tmpDir, "images", path, "Dockerfile")
+		if err := os.MkdirAll(filepath.Dir(dockerfilePath), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(dockerfilePath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write Dockerfile: %v", err)
+		}
+	}
+
+	checks, err := RequiredChecks(cfg)
+	if err != nil {
+		t.Fatalf("RequiredChecks() error = %v", err)
+	}
+
+	want := []string{"Build app:v1", "Build core:jammy", "Build core:noble"}
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("RequiredChecks() = %v, want %v", checks, want)
+	}
+}
+
+func TestDiffChecks(t *testing.T) {
+	previous := []string{"Build app:v1", "Build core:focal", "Build core:noble"}
+	current := []string{"Build app:v1", "Build core:noble", "Build core:plucky", "Build app:v2"}
+
+	added, removed := DiffChecks(previous, current)
+
+	if want := []string{"Build app:v2", "Build core:plucky"}; !reflect.DeepEqual(added, want) {
+		t.Errorf("added = %v, want %v", added, want)
+	}
+	if want := []string{"Build core:focal"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed = %v, want %v", removed, want)
+	}
+
+	added, removed = DiffChecks(current, current)
+	if len(added) != 0 || len(removed) != 0 {
+		t.Errorf("identical lists should have no diff, got added=%v removed=%v", added, removed)
+	}
+}
@@ -0,0 +1,88 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// DependencyMismatch is a dependency parsed from a version's generated
+// Dockerfile that its declared depends_on omits.
+type DependencyMismatch struct {
+	Image      string
+	Version    string
+	Dependency string
+	// Dockerfile and Line locate the parsed reference. For ONBUILD triggers
+	// this is the base image's Dockerfile.
+	Dockerfile string
+	Line       int
+}
+
+// Message describes the mismatch without naming the image and version.
+func (m DependencyMismatch) Message() string {
+	return fmt.Sprintf("depends_on is missing %s, used at %s:%d", m.Dependency, m.Dockerfile, m.Line)
+}
+
+func (m DependencyMismatch) String() string {
+	return fmt.Sprintf("%s:%s: %s", m.Image, m.Version, m.Message())
+}
+
+// VerifyDependencies checks that the depends_on of every version that
+// declares one lists the dependencies parsed from its Dockerfile. Declared
+// dependencies the Dockerfile does not use, e.g. runtime-only ones, are
+// allowed, and versions without depends_on are not checked.
+func VerifyDependencies(cfg *config.Config) ([]DependencyMismatch, error) {
+	return VerifyDependenciesContext(context.Background(), cfg)
+}
+
+// VerifyDependenciesContext is VerifyDependencies with cancellation, checked
+// before each Dockerfile is parsed.
+func VerifyDependenciesContext(ctx context.Context, cfg *config.Config) ([]DependencyMismatch, error) {
+	jobs, err := buildJobsFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("building jobs from config: %w", err)
+	}
+
+	parse, err := jobDependencyParser(ctx, cfg, jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := parseJobs(jobs, parse)
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatches []DependencyMismatch
+	for i, job := range jobs {
+		if job.DependsOn == nil {
+			continue
+		}
+		mismatches = append(mismatches, compareDependencies(job, parsed.edges(i))...)
+	}
+	return mismatches, nil
+}
+
+// compareDependencies reports the job's parsed edges that its declared
+// dependencies omit.
+func compareDependencies(job Job, edges []edge) []DependencyMismatch {
+	declared := make(map[string]bool)
+	for _, dep := range job.DependsOn {
+		declared[dep] = true
+	}
+
+	var mismatches []DependencyMismatch
+	for _, e := range edges {
+		if !declared[e.dep] {
+			mismatches = append(mismatches, DependencyMismatch{
+				Image:      job.ImageName,
+				Version:    job.Version,
+				Dependency: e.dep,
+				Dockerfile: e.dockerfile,
+				Line:       e.line,
+			})
+		}
+	}
+	return mismatches
+}
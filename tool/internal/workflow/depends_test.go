@@ -0,0 +1,120 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// dependsTestConfig writes one Dockerfile per image:v1 under a temporary
+// images directory.
+func dependsTestConfig(t *testing.T, parser string, dockerfiles map[string]string, dependsOn map[string][]string) *config.Config {
+	t.Helper()
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: filepath.Join(tmpDir, "images"), Workflow: &config.Workflow{Parser: parser}},
+		Images:   make(map[string]config.Image),
+	}
+	for name, content := range dockerfiles {
+		cfg.Images[name] = config.Image{
+			Path:     name,
+			Versions: map[string]*config.ImageConfig{"v1": {DependsOn: dependsOn[name]}},
+		}
+		path := filepath.Join(tmpDir, "images", name, "v1", "Dockerfile")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write Dockerfile: %v", err)
+		}
+	}
+
+	return cfg
+}
+
+func TestVerifyDependencies(t *testing.T) {
+	dockerfiles := map[string]string{
+		"core":    "FROM alpine\n",
+		"builder": "FROM alpine\n",
+		"base":    "FROM ${REGISTRY}/core:v1\nONBUILD COPY --from=${REGISTRY}/builder:v1 /app /app\n",
+		"app":     "ARG REGISTRY=test.io\n\nFROM ${REGISTRY}/base:v1\n",
+		"tool":    "FROM ${REGISTRY}/core:v1\n",
+	}
+	dependsOn := map[string][]string{
+		"app":  {"base:v1", "core:v1"},
+		"tool": {"core:v1"},
+	}
+
+	for _, parser := range []string{ParserRegex, ParserBuildkit} {
+		t.Run(parser, func(t *testing.T) {
+			cfg := dependsTestConfig(t, parser, dockerfiles, dependsOn)
+
+			mismatches, err := VerifyDependencies(cfg)
+			if err != nil {
+				t.Fatalf("VerifyDependencies() error = %v", err)
+			}
+
+			var got []string
+			for _, mismatch := range mismatches {
+				got = append(got, mismatch.String())
+			}
+			want := []string{
+				"app:v1: depends_on is missing builder:v1, used at " + filepath.Join("images", "base", "v1", "Dockerfile") + ":2",
+			}
+			if strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("VerifyDependencies() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
+
+func TestVerifyDependencies_LineNumbers(t *testing.T) {
+	dockerfiles := map[string]string{
+		"core": "FROM alpine\n",
+		"app":  "ARG REGISTRY=test.io\n\n# build stage\nFROM ${REGISTRY}/core:v1 AS build\nRUN make\n\nFROM alpine\nCOPY --from=build /out /out\nCOPY --from=${REGISTRY}/core:v1 /etc/ssl /etc/ssl\n",
+	}
+
+	for _, parser := range []string{ParserRegex, ParserBuildkit} {
+		t.Run(parser, func(t *testing.T) {
+			cfg := dependsTestConfig(t, parser, dockerfiles, map[string][]string{"app": {}})
+
+			mismatches, err := VerifyDependencies(cfg)
+			if err != nil {
+				t.Fatalf("VerifyDependencies() error = %v", err)
+			}
+			if len(mismatches) != 1 || mismatches[0].Dependency != "core:v1" || mismatches[0].Line != 4 {
+				t.Errorf("VerifyDependencies() = %+v, want core:v1 undeclared at line 4", mismatches)
+			}
+		})
+	}
+}
+
+func TestOrderJobsByDependencies_DeclaredDependencies(t *testing.T) {
+	cfg := dependsTestConfig(t, ParserRegex, map[string]string{
+		"core":  "FROM alpine\n",
+		"init":  "FROM alpine\n",
+		"other": "FROM alpine\n",
+		"app":   "FROM ${REGISTRY}/core:v1\n",
+	}, map[string][]string{"app": {"init:v1", "core:v1"}})
+
+	jobs, err := Jobs(cfg)
+	if err != nil {
+		t.Fatalf("Jobs() error = %v", err)
+	}
+
+	for _, job := range jobs {
+		if job.ImageName == "app" && strings.Join(job.Needs, ",") != "core-v1,init-v1" {
+			t.Errorf("app-v1 needs = %v, want the parsed core-v1 and the declared init-v1 once each", job.Needs)
+		}
+	}
+
+	app := cfg.Images["app"]
+	app.Versions["v1"].DependsOn = []string{"init:v2"}
+	if _, err := Jobs(cfg); err == nil || !strings.Contains(err.Error(), "Build app:v1: depends_on entry init:v2 is not a version in the manifest") {
+		t.Errorf("Jobs() error = %v, want the unknown depends_on entry reported", err)
+	}
+}
@@ -0,0 +1,48 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// Environment is the deployment environment a job pushes through. The
+// workflow template only attaches it to runs that push, so pull request
+// builds never wait on its protection rules.
+type Environment struct {
+	Name string
+	URL  string
+}
+
+// jobEnvironment resolves the environment of one image version: the image's
+// own setting, else defaults.workflow.environment. It returns nil when
+// neither names one.
+func jobEnvironment(cfg *config.Config, image config.Image, imageName, version string) (*Environment, error) {
+	env := image.WorkflowEnvironment(version)
+	if env == nil && cfg.Defaults.Workflow != nil {
+		env = cfg.Defaults.Workflow.Environment
+	}
+	if env == nil || env.Name == "" {
+		return nil, nil
+	}
+	if strings.ContainsAny(env.Name, "'\n") {
+		return nil, fmt.Errorf("environment name %q cannot contain quotes or newlines", env.Name)
+	}
+
+	result := &Environment{Name: env.Name}
+	if env.URLTemplate != "" {
+		tmpl, err := template.New("url_template").Option("missingkey=error").Parse(env.URLTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("parsing environment url_template: %w", err)
+		}
+		var url strings.Builder
+		data := struct{ Image, Version string }{imageName, version}
+		if err := tmpl.Execute(&url, data); err != nil {
+			return nil, fmt.Errorf("rendering environment url_template: %w", err)
+		}
+		result.URL = url.String()
+	}
+	return result, nil
+}
@@ -0,0 +1,105 @@
+package workflow
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestJobEnvironment(t *testing.T) {
+	var cfg config.Config
+	manifest := `
+version: 1
+defaults:
+  workflow:
+    environment:
+      name: production
+      url_template: "https://registry.example.com/{{.Image}}/tags/{{.Version}}"
+images:
+  python:
+    versions:
+      "3.13": {}
+      "3.12":
+        workflow:
+          environment: {name: legacy}
+  scratch:
+    workflow:
+      environment: {name: ""}
+    versions:
+      v1: {}
+  broken:
+    workflow:
+      environment: {name: prod, url_template: "{{.Tag}}"}
+    versions:
+      v1: {}
+`
+	if err := yaml.Unmarshal([]byte(manifest), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	tests := []struct {
+		image, version string
+		want           *Environment
+		wantErr        string
+	}{
+		{image: "python", version: "3.13", want: &Environment{Name: "production", URL: "https://registry.example.com/python/tags/3.13"}},
+		{image: "python", version: "3.12", want: &Environment{Name: "legacy"}},
+		{image: "scratch", version: "v1"},
+		{image: "broken", version: "v1", wantErr: "url_template"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image+":"+tt.version, func(t *testing.T) {
+			got, err := jobEnvironment(&cfg, cfg.Images[tt.image], tt.image, tt.version)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("jobEnvironment() error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("jobEnvironment() error = %v", err)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("jobEnvironment() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := jobEnvironment(&config.Config{}, config.Image{Workflow: &config.ImageWorkflow{Environment: &config.Environment{Name: "it's"}}}, "app", "v1"); err == nil {
+		t.Error("jobEnvironment() should reject a quote in the name")
+	}
+}
+
+func TestWriteWorkflow_Environment(t *testing.T) {
+	jobs := []Job{
+		{ID: "app-v1", Name: "Build app:v1", ImageName: "app", Version: "v1", Environment: &Environment{Name: "production", URL: "https://registry.example.com/app/v1"}},
+		{ID: "tool-v1", Name: "Build tool:v1", ImageName: "tool", Version: "v1"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeWorkflowToWriter(Workflow{Jobs: jobs}, &buf); err != nil {
+		t.Fatalf("writeWorkflowToWriter() error = %v", err)
+	}
+	output := buf.String()
+
+	// Pull request runs evaluate the name to '' and so get no environment.
+	want := "    environment:\n" +
+		"      name: \"${{ (github.event_name == 'push' || github.event_name == 'schedule') && github.ref == 'refs/heads/master' && 'production' || '' }}\"\n" +
+		"      url: \"https://registry.example.com/app/v1\"\n" +
+		"    steps:"
+	if !strings.Contains(output, want) {
+		t.Errorf("workflow should attach the environment to pushing runs only, got:\n%s", output)
+	}
+	if strings.Count(output, "environment:") != 1 {
+		t.Errorf("only app-v1 should have an environment, got:\n%s", output)
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Errorf("workflow is not valid YAML: %v", err)
+	}
+}
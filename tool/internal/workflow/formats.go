@@ -0,0 +1,66 @@
+package workflow
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//go:embed templates/gitlab.tmpl
+var gitlabTemplate string
+
+const (
+	// FormatGitHub renders a GitHub Actions workflow, the default.
+	FormatGitHub = "github"
+	// FormatGitLab renders a .gitlab-ci.yml pipeline.
+	FormatGitLab = "gitlab"
+)
+
+// Format is a CI system the build jobs can be rendered for.
+type Format struct {
+	// template renders a Workflow.
+	template string
+	// templateName labels template errors; empty for embedded templates.
+	templateName string
+	// Limits are checked before rendering, when the platform has any.
+	Limits *Limits
+}
+
+// formats maps the names accepted by generate workflow --format to their
+// templates. Every format renders the same Job slice.
+var formats = map[string]Format{
+	FormatGitHub: {template: workflowTemplate, Limits: &GitHubLimits},
+	FormatGitLab: {template: gitlabTemplate},
+}
+
+// WithTemplate returns f rendering with src, a user-supplied template, in
+// place of its embedded one. name, usually the file src was read from,
+// labels parse and execution errors. f's limits still apply.
+func (f Format) WithTemplate(name, src string) Format {
+	f.template = src
+	f.templateName = name
+	return f
+}
+
+// Formats returns the names of the supported formats, sorted.
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FormatFor returns the named format; an empty name is FormatGitHub.
+func FormatFor(name string) (Format, error) {
+	if name == "" {
+		name = FormatGitHub
+	}
+	format, exists := formats[name]
+	if !exists {
+		return Format{}, fmt.Errorf("unsupported format %q (supported: %s)", name, strings.Join(Formats(), ", "))
+	}
+	return format, nil
+}
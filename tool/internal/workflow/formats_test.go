@@ -0,0 +1,158 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestFormatFor(t *testing.T) {
+	if _, err := FormatFor(""); err != nil {
+		t.Errorf("FormatFor(\"\") error = %v, want the GitHub format", err)
+	}
+	if _, err := FormatFor(FormatGitLab); err != nil {
+		t.Errorf("FormatFor(%q) error = %v", FormatGitLab, err)
+	}
+
+	_, err := FormatFor("jenkins")
+	if err == nil || !strings.Contains(err.Error(), "supported: github, gitlab") {
+		t.Errorf("FormatFor(\"jenkins\") error = %v, want the supported formats listed", err)
+	}
+}
+
+func TestGenerateProjectsToWriter_GitLabGolden(t *testing.T) {
+	fixtureDir, err := filepath.Abs(filepath.Join("testdata", "fixture"))
+	if err != nil {
+		t.Fatalf("Failed to resolve fixture directory: %v", err)
+	}
+	oldRepositoryRoot := repositoryRoot
+	repositoryRoot = func(string) string { return fixtureDir }
+	defer func() { repositoryRoot = oldRepositoryRoot }()
+
+	cfg, err := config.Load(filepath.Join(fixtureDir, "images", "manifest.yaml"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := GenerateProjectsToWriterContext(context.Background(), []*config.Config{cfg}, formats[FormatGitLab], &buf); err != nil {
+		t.Fatalf("GenerateProjectsToWriterContext() error = %v", err)
+	}
+
+	compareGolden(t, filepath.Join("testdata", "gitlab.golden.yaml"), buf.Bytes())
+	checkPipelineStructure(t, buf.Bytes())
+}
+
+func TestWriteFormatToWriter_GitLab(t *testing.T) {
+	jobs := []Job{
+		{
+			ID:             "base-1",
+			ImageName:      "base",
+			Version:        "1",
+			DockerfilePath: "images/base/1/Dockerfile",
+			Context:        "images/base/1",
+			Prepare:        []string{"if true; then", "  echo \"$IMAGE:$VERSION\"", "fi"},
+		},
+		{
+			ID:             "app-2",
+			ImageName:      "app",
+			Version:        "2",
+			DockerfilePath: "images/it's/2/Dockerfile",
+			Context:        "images/it's/2",
+			Needs:          []string{"base-1"},
+			Platforms:      []string{"linux/amd64", "linux/arm64"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeFormatToWriter(formats[FormatGitLab], Workflow{Project: "acme: tools", Jobs: jobs}, &buf); err != nil {
+		t.Fatalf("writeFormatToWriter() error = %v", err)
+	}
+	checkPipelineStructure(t, buf.Bytes())
+
+	var parsed struct {
+		Workflow struct {
+			Name string `yaml:"name"`
+		} `yaml:"workflow"`
+		Base struct {
+			Script []string `yaml:"script"`
+		} `yaml:"base-1"`
+		App struct {
+			Image    string   `yaml:"image"`
+			Services []string `yaml:"services"`
+			Script   []string `yaml:"script"`
+		} `yaml:"app-2"`
+	}
+	if err := yaml.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatalf("rendered pipeline is not valid YAML: %v", err)
+	}
+
+	if parsed.Workflow.Name != "Build Docker Images (acme: tools)" {
+		t.Errorf("workflow name = %q", parsed.Workflow.Name)
+	}
+	if len(parsed.Base.Script) != 2 || !strings.Contains(parsed.Base.Script[0], "if true; then\n  echo \"$IMAGE:$VERSION\"\nfi\n") {
+		t.Errorf("base-1 script = %q, want the prepare commands kept together before the build", parsed.Base.Script)
+	}
+	if !strings.HasPrefix(parsed.App.Image, "docker:") || len(parsed.App.Services) != 1 {
+		t.Errorf("app-2 image = %q, services = %v, want a buildx build for its platforms", parsed.App.Image, parsed.App.Services)
+	}
+	build := strings.Join(parsed.App.Script, "\n")
+	for _, want := range []string{"--platform 'linux/amd64,linux/arm64'", `--file 'images/it'\''s/2/Dockerfile'`} {
+		if !strings.Contains(build, want) {
+			t.Errorf("app-2 script does not contain %s:\n%s", want, build)
+		}
+	}
+}
+
+// checkPipelineStructure parses a rendered GitLab pipeline and checks that
+// every build job is in a declared stage, has a script and only needs jobs
+// that exist.
+func checkPipelineStructure(t *testing.T, content []byte) {
+	t.Helper()
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal(content, &parsed); err != nil {
+		t.Fatalf("rendered pipeline is not valid YAML: %v", err)
+	}
+
+	stages, _ := parsed["stages"].([]interface{})
+	if len(stages) == 0 {
+		t.Fatalf("pipeline declares no stages")
+	}
+	jobs := 0
+	for id, value := range parsed {
+		if id == "stages" || id == "workflow" {
+			continue
+		}
+		job, ok := value.(map[string]interface{})
+		if !ok {
+			t.Errorf("job %s is %T, want a mapping", id, value)
+			continue
+		}
+		jobs++
+		if job["stage"] != stages[0] {
+			t.Errorf("job %s is in stage %v, want %v", id, job["stage"], stages[0])
+		}
+		if script, _ := job["script"].([]interface{}); len(script) == 0 {
+			t.Errorf("job %s has no script", id)
+		}
+		needs, ok := job["needs"].([]interface{})
+		if !ok {
+			t.Errorf("job %s has no needs list, so it would wait for the whole stage", id)
+		}
+		for _, need := range needs {
+			if _, exists := parsed[need.(string)]; !exists {
+				t.Errorf("job %s needs unknown job %v", id, need)
+			}
+		}
+	}
+	if jobs == 0 {
+		t.Fatalf("pipeline has no jobs")
+	}
+}
@@ -0,0 +1,151 @@
+package workflow
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// compareGolden compares got with the golden file at goldenPath, rewriting it
+// first when the test runs with -update.
+func compareGolden(t *testing.T, goldenPath string, got []byte) {
+	t.Helper()
+
+	if *updateGolden {
+		if err := os.WriteFile(goldenPath, got, 0644); err != nil {
+			t.Fatalf("Failed to update golden file: %v", err)
+		}
+	}
+
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("Failed to read golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output does not match %s (run with -update to accept)\ngot:\n%s", goldenPath, got)
+	}
+}
+
+func TestGenerateToWriter_Golden(t *testing.T) {
+	tests := []struct {
+		name   string
+		golden string
+		auth   map[string]config.RegistryAuth
+		push   *config.Push
+	}{
+		{
+			name:   "default login",
+			golden: "workflow.golden.yaml",
+		},
+		{
+			name:   "auth providers",
+			golden: "workflow-auth.golden.yaml",
+			auth:   map[string]config.RegistryAuth{"ghcr.io": {Provider: AuthGHCR}},
+		},
+		{
+			name:   "push",
+			golden: "workflow-push.golden.yaml",
+			push:   &config.Push{Enabled: true, Registry: "harbor.example.com/team", UsernameSecret: "HARBOR_USER", PasswordSecret: "HARBOR_TOKEN"},
+		},
+	}
+
+	fixtureDir, err := filepath.Abs(filepath.Join("testdata", "fixture"))
+	if err != nil {
+		t.Fatalf("Failed to resolve fixture directory: %v", err)
+	}
+	goldenDir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatalf("Failed to resolve testdata directory: %v", err)
+	}
+
+	// The fixture sits inside this repository, whose root would otherwise
+	// prefix every path in the golden files.
+	oldRepositoryRoot := repositoryRoot
+	repositoryRoot = func(string) string { return fixtureDir }
+	defer func() { repositoryRoot = oldRepositoryRoot }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.Load(filepath.Join(fixtureDir, "images", "manifest.yaml"))
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if tt.auth != nil {
+				cfg.Defaults.Workflow = &config.Workflow{Auth: tt.auth}
+			}
+			cfg.Defaults.Push = tt.push
+
+			var buf bytes.Buffer
+			if err := GenerateToWriter(cfg, &buf); err != nil {
+				t.Fatalf("GenerateToWriter() error = %v", err)
+			}
+
+			compareGolden(t, filepath.Join(goldenDir, tt.golden), buf.Bytes())
+			checkWorkflowStructure(t, buf.Bytes())
+		})
+	}
+}
+
+// checkWorkflowStructure parses a rendered workflow and checks the structure
+// GitHub requires of it: every job runs somewhere, has steps, and only needs
+// jobs that exist.
+func checkWorkflowStructure(t *testing.T, content []byte) {
+	t.Helper()
+
+	var parsed struct {
+		Name string                 `yaml:"name"`
+		On   map[string]interface{} `yaml:"on"`
+		Jobs map[string]struct {
+			RunsOn string                   `yaml:"runs-on"`
+			Needs  []string                 `yaml:"needs"`
+			Steps  []map[string]interface{} `yaml:"steps"`
+		} `yaml:"jobs"`
+	}
+	if err := yaml.Unmarshal(content, &parsed); err != nil {
+		t.Fatalf("rendered workflow is not valid YAML: %v", err)
+	}
+
+	if parsed.Name == "" || len(parsed.On) == 0 || len(parsed.Jobs) == 0 {
+		t.Fatalf("workflow is missing name, triggers or jobs")
+	}
+	for id, job := range parsed.Jobs {
+		if job.RunsOn == "" {
+			t.Errorf("job %s has no runs-on", id)
+		}
+		if len(job.Steps) == 0 {
+			t.Errorf("job %s has no steps", id)
+		}
+		for _, need := range job.Needs {
+			if _, exists := parsed.Jobs[need]; !exists {
+				t.Errorf("job %s needs unknown job %s", id, need)
+			}
+		}
+		for _, step := range job.Steps {
+			_, uses := step["uses"]
+			_, run := step["run"]
+			if uses == run {
+				t.Errorf("job %s has a step with neither or both of uses and run: %v", id, step)
+			}
+			// Unquoted tags such as 3.20 would reach the action as the number 3.2.
+			if with, ok := step["with"].(map[string]interface{}); ok {
+				if tag, exists := with["image_tag"]; exists {
+					if _, isString := tag.(string); !isString {
+						t.Errorf("job %s passes image_tag %v as %T, want a string", id, tag, tag)
+					}
+				}
+			}
+		}
+		if strings.ContainsAny(id, ":.+") {
+			t.Errorf("job ID %s was not sanitized", id)
+		}
+	}
+}
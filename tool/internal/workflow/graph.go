@@ -0,0 +1,195 @@
+package workflow
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// Graph is the image dependency DAG keyed by "image:version". Edges run from
+// a dependency to the images built on top of it.
+type Graph struct {
+	Nodes        []string
+	dependencies map[string][]string
+	dependents   map[string][]string
+}
+
+// Reach is a node reached while walking the graph together with the length of
+// the shortest path to it.
+type Reach struct {
+	Node  string `json:"node"`
+	Depth int    `json:"depth"`
+}
+
+// BuildGraph derives the dependency graph from the same jobs used to render
+// the workflow.
+func BuildGraph(cfg *config.Config) (*Graph, error) {
+	jobs, err := Jobs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return graphFromJobs(jobs), nil
+}
+
+func graphFromJobs(jobs []Job) *Graph {
+	g := &Graph{
+		dependencies: make(map[string][]string),
+		dependents:   make(map[string][]string),
+	}
+
+	nodeByID := make(map[string]string, len(jobs))
+	for _, job := range jobs {
+		node := fmt.Sprintf("%s:%s", job.ImageName, job.Version)
+		nodeByID[job.ID] = node
+		g.Nodes = append(g.Nodes, node)
+	}
+	sort.Strings(g.Nodes)
+
+	for _, job := range jobs {
+		node := nodeByID[job.ID]
+		for _, need := range job.Needs {
+			dep := nodeByID[need]
+			g.dependencies[node] = append(g.dependencies[node], dep)
+			g.dependents[dep] = append(g.dependents[dep], node)
+		}
+	}
+	for _, edges := range []map[string][]string{g.dependencies, g.dependents} {
+		for node := range edges {
+			sort.Strings(edges[node])
+		}
+	}
+
+	return g
+}
+
+// Has reports whether node is part of the graph.
+func (g *Graph) Has(node string) bool {
+	i := sort.SearchStrings(g.Nodes, node)
+	return i < len(g.Nodes) && g.Nodes[i] == node
+}
+
+// Dependencies returns the direct dependencies of node.
+func (g *Graph) Dependencies(node string) []string {
+	return g.dependencies[node]
+}
+
+// Dependents returns the direct dependents of node.
+func (g *Graph) Dependents(node string) []string {
+	return g.dependents[node]
+}
+
+// TransitiveDependents returns every node that directly or indirectly builds
+// on one of roots, excluding the roots themselves.
+func (g *Graph) TransitiveDependents(roots ...string) []Reach {
+	return g.walk(g.dependents, roots)
+}
+
+// TransitiveDependencies returns every node that one of roots directly or
+// indirectly builds on, excluding the roots themselves.
+func (g *Graph) TransitiveDependencies(roots ...string) []Reach {
+	return g.walk(g.dependencies, roots)
+}
+
+// walk performs a breadth-first traversal so each node is reported at its
+// shortest distance from any root. Results are ordered by depth, then name.
+func (g *Graph) walk(edges map[string][]string, roots []string) []Reach {
+	depth := make(map[string]int)
+	for _, root := range roots {
+		depth[root] = 0
+	}
+
+	queue := append([]string(nil), roots...)
+	var reached []Reach
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, next := range edges[node] {
+			if _, seen := depth[next]; seen {
+				continue
+			}
+			depth[next] = depth[node] + 1
+			reached = append(reached, Reach{Node: next, Depth: depth[next]})
+			queue = append(queue, next)
+		}
+	}
+
+	sort.Slice(reached, func(i, j int) bool {
+		if reached[i].Depth != reached[j].Depth {
+			return reached[i].Depth < reached[j].Depth
+		}
+		return reached[i].Node < reached[j].Node
+	})
+	return reached
+}
+
+// Subgraph returns the part of the graph reachable from roots: the roots,
+// everything built on them and the edges between those nodes.
+func (g *Graph) Subgraph(roots ...string) *Graph {
+	keep := make(map[string]bool, len(roots))
+	for _, root := range roots {
+		keep[root] = true
+	}
+	for _, reach := range g.TransitiveDependents(roots...) {
+		keep[reach.Node] = true
+	}
+
+	sub := &Graph{
+		dependencies: make(map[string][]string),
+		dependents:   make(map[string][]string),
+	}
+	for _, node := range g.Nodes {
+		if !keep[node] {
+			continue
+		}
+		sub.Nodes = append(sub.Nodes, node)
+		for _, dep := range g.dependencies[node] {
+			if keep[dep] {
+				sub.dependencies[node] = append(sub.dependencies[node], dep)
+				sub.dependents[dep] = append(sub.dependents[dep], node)
+			}
+		}
+	}
+	for node := range sub.dependents {
+		sort.Strings(sub.dependents[node])
+	}
+	return sub
+}
+
+// DOT renders the graph in Graphviz DOT, with edges from each dependency to
+// its dependents. Nodes without edges are listed too.
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph images {\n  rankdir=LR;\n")
+	for _, node := range g.Nodes {
+		fmt.Fprintf(&b, "  %s;\n", strconv.Quote(node))
+	}
+	for _, node := range g.Nodes {
+		for _, dependent := range g.dependents[node] {
+			fmt.Fprintf(&b, "  %s -> %s;\n", strconv.Quote(node), strconv.Quote(dependent))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the graph as a Mermaid flowchart, with edges from each
+// dependency to its dependents. Mermaid IDs cannot contain ":" or ".", so
+// nodes get numbered IDs labelled with their name.
+func (g *Graph) Mermaid() string {
+	ids := make(map[string]string, len(g.Nodes))
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for i, node := range g.Nodes {
+		ids[node] = fmt.Sprintf("n%d", i)
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", ids[node], strings.ReplaceAll(node, `"`, "#quot;"))
+	}
+	for _, node := range g.Nodes {
+		for _, dependent := range g.dependents[node] {
+			fmt.Fprintf(&b, "  %s --> %s\n", ids[node], ids[dependent])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,113 @@
+package workflow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testGraph() *Graph {
+	// core:v1 <- python:3.12 <- app:v1
+	//         <- golang:1.25 <- app:v1
+	// tini:v1 (isolated)
+	return graphFromJobs([]Job{
+		{ID: "core-v1", ImageName: "core", Version: "v1"},
+		{ID: "python-3-12", ImageName: "python", Version: "3.12", Needs: []string{"core-v1"}},
+		{ID: "golang-1-25", ImageName: "golang", Version: "1.25", Needs: []string{"core-v1"}},
+		{ID: "app-v1", ImageName: "app", Version: "v1", Needs: []string{"python-3-12", "golang-1-25"}},
+		{ID: "tini-v1", ImageName: "tini", Version: "v1"},
+	})
+}
+
+func TestGraph_Nodes(t *testing.T) {
+	g := testGraph()
+
+	want := []string{"app:v1", "core:v1", "golang:1.25", "python:3.12", "tini:v1"}
+	if !reflect.DeepEqual(g.Nodes, want) {
+		t.Errorf("Nodes = %v, want %v", g.Nodes, want)
+	}
+	if !g.Has("tini:v1") || g.Has("tini:v2") {
+		t.Error("Has() returned unexpected result")
+	}
+	if want := []string{"golang:1.25", "python:3.12"}; !reflect.DeepEqual(g.Dependencies("app:v1"), want) {
+		t.Errorf("Dependencies(app:v1) = %v, want %v", g.Dependencies("app:v1"), want)
+	}
+}
+
+func TestGraph_TransitiveDependents(t *testing.T) {
+	g := testGraph()
+
+	got := g.TransitiveDependents("core:v1")
+	want := []Reach{
+		{Node: "golang:1.25", Depth: 1},
+		{Node: "python:3.12", Depth: 1},
+		{Node: "app:v1", Depth: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TransitiveDependents(core:v1) = %v, want %v", got, want)
+	}
+
+	if got := g.TransitiveDependents("tini:v1"); len(got) != 0 {
+		t.Errorf("TransitiveDependents(tini:v1) = %v, want none", got)
+	}
+}
+
+func TestGraph_TransitiveDependencies(t *testing.T) {
+	g := testGraph()
+
+	got := g.TransitiveDependencies("app:v1")
+	want := []Reach{
+		{Node: "golang:1.25", Depth: 1},
+		{Node: "python:3.12", Depth: 1},
+		{Node: "core:v1", Depth: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TransitiveDependencies(app:v1) = %v, want %v", got, want)
+	}
+}
+
+func TestGraph_Subgraph(t *testing.T) {
+	sub := testGraph().Subgraph("python:3.12")
+
+	if want := []string{"app:v1", "python:3.12"}; !reflect.DeepEqual(sub.Nodes, want) {
+		t.Errorf("Nodes = %v, want %v", sub.Nodes, want)
+	}
+	if want := []string{"python:3.12"}; !reflect.DeepEqual(sub.Dependencies("app:v1"), want) {
+		t.Errorf("Dependencies(app:v1) = %v, want %v without the edge from outside", sub.Dependencies("app:v1"), want)
+	}
+}
+
+func TestGraph_DOT(t *testing.T) {
+	want := `digraph images {
+  rankdir=LR;
+  "app:v1";
+  "core:v1";
+  "golang:1.25";
+  "python:3.12";
+  "tini:v1";
+  "core:v1" -> "golang:1.25";
+  "core:v1" -> "python:3.12";
+  "golang:1.25" -> "app:v1";
+  "python:3.12" -> "app:v1";
+}
+`
+	if got := testGraph().DOT(); got != want {
+		t.Errorf("DOT() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestGraph_Mermaid(t *testing.T) {
+	want := `flowchart LR
+  n0["app:v1"]
+  n1["core:v1"]
+  n2["golang:1.25"]
+  n3["python:3.12"]
+  n4["tini:v1"]
+  n1 --> n2
+  n1 --> n3
+  n2 --> n0
+  n3 --> n0
+`
+	if got := testGraph().Mermaid(); got != want {
+		t.Errorf("Mermaid() =\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+package workflow
+
+import (
+	"fmt"
+
+	"github.com/apex/log"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// Limits are the caps a CI platform puts on a single workflow.
+type Limits struct {
+	Platform string
+	// MaxJobs is the most jobs one workflow may contain.
+	MaxJobs int
+}
+
+// GitHubLimits are the GitHub Actions limits the rendered workflow must
+// stay within.
+var GitHubLimits = Limits{Platform: "GitHub Actions", MaxJobs: 256}
+
+// fixedJobs counts the jobs the workflow template adds around the build
+// jobs: wait-for-ci, changes and notify.
+const fixedJobs = 3
+
+// defaultJobWarningPercent is the share of MaxJobs at which generation starts
+// warning when defaults.workflow.job_warning_threshold is unset.
+const defaultJobWarningPercent = 80
+
+// splitAdvice is appended to limit messages.
+const splitAdvice = "split the images into several projects and render them with generate workflow --split, or opt images out with workflow.enabled: false"
+
+// checkJobLimits fails when a workflow with the given build jobs exceeds
+// limits, and warns once it reaches the warning threshold of cfgs.
+func checkJobLimits(buildJobs int, limits Limits, cfgs ...*config.Config) error {
+	total := buildJobs + fixedJobs
+	if total > limits.MaxJobs {
+		return fmt.Errorf("workflow has %d jobs, more than the %d %s allows; %s", total, limits.MaxJobs, limits.Platform, splitAdvice)
+	}
+	if threshold := jobWarningThreshold(limits, cfgs); total >= threshold {
+		log.Warnf("workflow has %d of the %d jobs %s allows; %s before reaching the limit", total, limits.MaxJobs, limits.Platform, splitAdvice)
+	}
+	return nil
+}
+
+// jobWarningThreshold returns the lowest job_warning_threshold set by cfgs,
+// or defaultJobWarningPercent of limits.MaxJobs.
+func jobWarningThreshold(limits Limits, cfgs []*config.Config) int {
+	threshold := 0
+	for _, cfg := range cfgs {
+		if workflow := cfg.Defaults.Workflow; workflow != nil && workflow.JobWarningThreshold > 0 {
+			if threshold == 0 || workflow.JobWarningThreshold < threshold {
+				threshold = workflow.JobWarningThreshold
+			}
+		}
+	}
+	if threshold == 0 {
+		threshold = limits.MaxJobs * defaultJobWarningPercent / 100
+	}
+	return threshold
+}
@@ -0,0 +1,49 @@
+package workflow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestCheckJobLimits(t *testing.T) {
+	cfg := &config.Config{}
+
+	if err := checkJobLimits(GitHubLimits.MaxJobs-fixedJobs, GitHubLimits, cfg); err != nil {
+		t.Errorf("checkJobLimits() at the limit error = %v", err)
+	}
+
+	err := checkJobLimits(GitHubLimits.MaxJobs-fixedJobs+1, GitHubLimits, cfg)
+	if err == nil {
+		t.Fatal("checkJobLimits() should fail above the limit")
+	}
+	for _, want := range []string{"257 jobs", "256 GitHub Actions allows", "--split"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("checkJobLimits() error = %q, want it to mention %q", err, want)
+		}
+	}
+}
+
+func TestJobWarningThreshold(t *testing.T) {
+	withThreshold := func(n int) *config.Config {
+		return &config.Config{Defaults: config.Defaults{Workflow: &config.Workflow{JobWarningThreshold: n}}}
+	}
+
+	tests := []struct {
+		name string
+		cfgs []*config.Config
+		want int
+	}{
+		{name: "default", cfgs: []*config.Config{{}}, want: 204},
+		{name: "configured", cfgs: []*config.Config{withThreshold(100)}, want: 100},
+		{name: "lowest project wins", cfgs: []*config.Config{withThreshold(150), {}, withThreshold(120)}, want: 120},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jobWarningThreshold(GitHubLimits, tt.cfgs); got != tt.want {
+				t.Errorf("jobWarningThreshold() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
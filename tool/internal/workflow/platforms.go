@@ -0,0 +1,86 @@
+package workflow
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// defaultPlatform is what a job without platforms builds on the runner.
+const defaultPlatform = "linux/amd64"
+
+var platformPattern = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
+
+// jobPlatforms resolves the platforms of one image version: the image's own
+// setting, else defaults.workflow.platforms. It returns nil when neither sets
+// any, so the job builds for the runner's platform as before.
+func jobPlatforms(cfg *config.Config, image config.Image, version string) ([]string, error) {
+	platforms := image.WorkflowPlatforms(version)
+	if platforms == nil && cfg.Defaults.Workflow != nil {
+		platforms = cfg.Defaults.Workflow.Platforms
+	}
+	if len(platforms) == 0 {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(platforms))
+	for _, platform := range platforms {
+		if !platformPattern.MatchString(platform) {
+			return nil, fmt.Errorf("invalid platform %q (want os/arch, e.g. linux/arm64)", platform)
+		}
+		if !seen[platform] {
+			seen[platform] = true
+			result = append(result, platform)
+		}
+	}
+	return result, nil
+}
+
+// PlatformList joins the job's platforms as buildx expects them.
+func (j Job) PlatformList() string {
+	return strings.Join(j.Platforms, ",")
+}
+
+// AliasList joins the job's aliases for the dockerfile action.
+func (j Job) AliasList() string {
+	return strings.Join(j.Aliases, ",")
+}
+
+// missingPlatforms describes the jobs that build for a platform one of their
+// needs does not, whose builds would pull a base image that is never
+// published for it. A single job builds every platform of its image, so its
+// dependents already wait for all of them.
+func missingPlatforms(jobs []Job) []string {
+	built := make(map[string]map[string]bool, len(jobs))
+	names := make(map[string]string, len(jobs))
+	for _, job := range jobs {
+		names[job.ID] = job.Name
+		built[job.ID] = make(map[string]bool)
+		for _, platform := range platformsOf(job) {
+			built[job.ID][platform] = true
+		}
+	}
+
+	var problems []string
+	for _, job := range jobs {
+		for _, need := range job.Needs {
+			for _, platform := range platformsOf(job) {
+				if !built[need][platform] {
+					problems = append(problems, fmt.Sprintf("%s builds %s, which its dependency %s does not", job.Name, platform, strings.TrimPrefix(names[need], "Build ")))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// platformsOf returns the platforms a job builds.
+func platformsOf(job Job) []string {
+	if len(job.Platforms) == 0 {
+		return []string{defaultPlatform}
+	}
+	return job.Platforms
+}
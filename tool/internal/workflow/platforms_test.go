@@ -0,0 +1,81 @@
+package workflow
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestJobPlatforms(t *testing.T) {
+	cfg := &config.Config{Defaults: config.Defaults{Workflow: &config.Workflow{Platforms: []string{"linux/amd64", "linux/arm64", "linux/amd64"}}}}
+	image := config.Image{Versions: map[string]*config.ImageConfig{
+		"v1": {},
+		"v2": {Workflow: &config.ImageWorkflow{Platforms: []string{"linux/arm/v7"}}},
+		"v3": {Workflow: &config.ImageWorkflow{Platforms: []string{"arm64"}}},
+	}}
+
+	if got, err := jobPlatforms(cfg, image, "v1"); err != nil || strings.Join(got, ",") != "linux/amd64,linux/arm64" {
+		t.Errorf("jobPlatforms(v1) = %q, %v, want the deduplicated defaults", got, err)
+	}
+	if got, err := jobPlatforms(cfg, image, "v2"); err != nil || strings.Join(got, ",") != "linux/arm/v7" {
+		t.Errorf("jobPlatforms(v2) = %q, %v, want the version's own", got, err)
+	}
+	if _, err := jobPlatforms(cfg, image, "v3"); err == nil {
+		t.Error("jobPlatforms(v3) should reject a platform without an OS")
+	}
+	if got, err := jobPlatforms(&config.Config{}, image, "v1"); err != nil || got != nil {
+		t.Errorf("jobPlatforms() = %q, %v, want nil without platforms", got, err)
+	}
+}
+
+func TestMissingPlatforms(t *testing.T) {
+	jobs := []Job{
+		{ID: "core-v1", Name: "Build core:v1", Platforms: []string{"linux/amd64", "linux/arm64"}},
+		{ID: "legacy-v1", Name: "Build legacy:v1"},
+		{ID: "app-v1", Name: "Build app:v1", Needs: []string{"core-v1"}, Platforms: []string{"linux/arm64"}},
+		{ID: "tool-v1", Name: "Build tool:v1", Needs: []string{"core-v1", "legacy-v1"}, Platforms: []string{"linux/amd64", "linux/arm64"}},
+	}
+
+	got := missingPlatforms(jobs)
+	want := []string{"Build tool:v1 builds linux/arm64, which its dependency legacy:v1 does not"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("missingPlatforms() = %q, want %q", got, want)
+	}
+}
+
+func TestWriteWorkflow_Platforms(t *testing.T) {
+	jobs := []Job{
+		{ID: "core-v1", Name: "Build core:v1", ImageName: "core", Version: "v1", DockerfilePath: "images/core/v1/Dockerfile", Platforms: []string{"linux/amd64", "linux/arm64"}},
+		{ID: "app-v1", Name: "Build app:v1", ImageName: "app", Version: "v1", DockerfilePath: "images/app/v1/Dockerfile", Needs: []string{"core-v1"}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeWorkflowToWriter(Workflow{Jobs: jobs}, &buf); err != nil {
+		t.Fatalf("writeWorkflowToWriter() error = %v", err)
+	}
+
+	var parsed struct {
+		Jobs map[string]struct {
+			Steps []struct {
+				With map[string]string `yaml:"with"`
+			} `yaml:"steps"`
+		} `yaml:"jobs"`
+	}
+	if err := yaml.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatalf("workflow is not valid YAML: %v", err)
+	}
+	platforms := func(id string) string {
+		steps := parsed.Jobs[id].Steps
+		return steps[len(steps)-1].With["platforms"]
+	}
+	if got := platforms("core-v1"); got != "linux/amd64,linux/arm64" {
+		t.Errorf("core-v1 platforms = %q, want both platforms", got)
+	}
+	if got := platforms("app-v1"); got != "" {
+		t.Errorf("app-v1 platforms = %q, want none without platforms", got)
+	}
+}
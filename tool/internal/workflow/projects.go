@@ -0,0 +1,134 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// ProjectJobsContext returns the jobs of several independent manifests for a
+// single combined workflow. Each project is ordered within its own dependency
+// graph and never depends on another. With more than one project, job IDs and
+// names are prefixed with the project name so that they cannot collide.
+func ProjectJobsContext(ctx context.Context, cfgs []*config.Config) ([]Job, error) {
+	if len(cfgs) == 1 {
+		return JobsContext(ctx, cfgs[0])
+	}
+
+	seen := make(map[string]bool)
+	ids := newJobIDs()
+	var jobs []Job
+	for _, cfg := range cfgs {
+		project := cfg.ProjectName()
+		if project == "" {
+			return nil, fmt.Errorf("every manifest needs a project name when generating several together")
+		}
+		if seen[project] {
+			return nil, fmt.Errorf("project %s is used by more than one manifest; set a distinct project key", project)
+		}
+		seen[project] = true
+
+		projectJobs, err := JobsContext(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("project %s: %w", project, err)
+		}
+		projectJobs, err = namespaceJobs(project, projectJobs, ids)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, projectJobs...)
+	}
+	return jobs, nil
+}
+
+// namespaceJobs prefixes the IDs, names and needs of one project's jobs,
+// recording the prefixed IDs in taken, which holds those of the projects
+// before it.
+func namespaceJobs(project string, jobs []Job, taken jobIDs) ([]Job, error) {
+	ids := make(map[string]string, len(jobs))
+	for i := range jobs {
+		id := generateJobID(project+"-"+jobs[i].ImageName, jobs[i].Version)
+		if err := taken.add(id, fmt.Sprintf("%s/%s:%s", project, jobs[i].ImageName, jobs[i].Version)); err != nil {
+			return nil, err
+		}
+		ids[jobs[i].ID] = id
+		jobs[i].ID = id
+		jobs[i].Name = fmt.Sprintf("Build %s/%s:%s", project, jobs[i].ImageName, jobs[i].Version)
+	}
+	for i := range jobs {
+		for j, need := range jobs[i].Needs {
+			jobs[i].Needs[j] = ids[need]
+		}
+	}
+	return jobs, nil
+}
+
+// GenerateProjectsToWriterContext writes one workflow in format with the jobs
+// of every project.
+func GenerateProjectsToWriterContext(ctx context.Context, cfgs []*config.Config, format Format, w io.Writer) error {
+	jobs, err := ProjectJobsContext(ctx, cfgs)
+	if err != nil {
+		return err
+	}
+
+	if format.Limits != nil {
+		if err := checkJobLimits(len(jobs), *format.Limits, cfgs...); err != nil {
+			return err
+		}
+	}
+
+	limitParallel(jobs, maxParallel(cfgs...))
+	if err := writeFormatToWriter(format, Workflow{Jobs: jobs}, w); err != nil {
+		return fmt.Errorf("writing workflow: %w", err)
+	}
+	return nil
+}
+
+// DefaultOutputPath is where the repository keeps the generated workflow.
+const DefaultOutputPath = ".github/workflows/dockerfiles.yaml"
+
+// OutputPaths returns every file a workflow for cfgs may have been written to
+// under outputPath: the combined workflow and, with several projects, each
+// project's --split workflow.
+func OutputPaths(cfgs []*config.Config, outputPath string) []string {
+	paths := []string{outputPath}
+	if len(cfgs) > 1 {
+		for _, cfg := range cfgs {
+			paths = append(paths, SplitWorkflowPath(outputPath, cfg.ProjectName()))
+		}
+	}
+	return paths
+}
+
+// SplitWorkflowPath returns where a project's workflow goes when projects are
+// written to separate files: the output path with the project name inserted
+// before its extension, e.g. dockerfiles-toolchains.yaml.
+func SplitWorkflowPath(outputPath, project string) string {
+	ext := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, ext) + "-" + project + ext
+}
+
+// GenerateProjectToWriterContext writes cfg's workflow in format on its own,
+// named after its project.
+func GenerateProjectToWriterContext(ctx context.Context, cfg *config.Config, format Format, w io.Writer) error {
+	jobs, err := JobsContext(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	if format.Limits != nil {
+		if err := checkJobLimits(len(jobs), *format.Limits, cfg); err != nil {
+			return fmt.Errorf("project %s: %w", cfg.ProjectName(), err)
+		}
+	}
+
+	limitParallel(jobs, maxParallel(cfg))
+	if err := writeFormatToWriter(format, Workflow{Project: cfg.ProjectName(), Jobs: jobs}, w); err != nil {
+		return fmt.Errorf("writing workflow: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// writeProject writes a manifest and its generated Dockerfiles under
+// dir/project and loads it.
+func writeProject(t *testing.T, dir, project, manifest string, dockerfiles map[string]string) *config.Config {
+	t.Helper()
+	root := filepath.Join(dir, project)
+	files := map[string]string{"manifest.yaml": manifest}
+	for path, content := range dockerfiles {
+		files[filepath.Join(path, "Dockerfile")] = content
+	}
+	for path, content := range files {
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	cfg, err := config.Load(filepath.Join(root, "manifest.yaml"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return cfg
+}
+
+func TestProjectJobsContext(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// Both projects define core:v1; only their own copy may satisfy app.
+	manifest := "version: 1\nimages:\n  core:\n    path: core\n    versions:\n      v1: {}\n  app:\n    path: app\n    versions:\n      v1: {}\n"
+	toolchains := writeProject(t, tmpDir, "toolchains", manifest, map[string]string{
+		"core/v1": "FROM alpine\n",
+		"app/v1":  "FROM ${REGISTRY}/core:v1\n",
+	})
+	runtimes := writeProject(t, tmpDir, "runtimes", manifest, map[string]string{
+		"core/v1": "FROM alpine\n",
+		"app/v1":  "FROM alpine\n",
+	})
+
+	jobs, err := ProjectJobsContext(context.Background(), []*config.Config{toolchains, runtimes})
+	if err != nil {
+		t.Fatalf("ProjectJobsContext() error = %v", err)
+	}
+
+	var got []string
+	for _, job := range jobs {
+		got = append(got, job.ID+" ["+strings.Join(job.Needs, ",")+"] "+job.Name+" "+job.DockerfilePath)
+	}
+	want := []string{
+		"toolchains-core-v1 [] Build toolchains/core:v1 toolchains/core/v1/Dockerfile",
+		"toolchains-app-v1 [toolchains-core-v1] Build toolchains/app:v1 toolchains/app/v1/Dockerfile",
+		"runtimes-app-v1 [] Build runtimes/app:v1 runtimes/app/v1/Dockerfile",
+		"runtimes-core-v1 [] Build runtimes/core:v1 runtimes/core/v1/Dockerfile",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ProjectJobsContext() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A single project keeps its unprefixed jobs.
+	single, err := ProjectJobsContext(context.Background(), []*config.Config{toolchains})
+	if err != nil {
+		t.Fatalf("ProjectJobsContext() error = %v", err)
+	}
+	if single[0].ID != "core-v1" {
+		t.Errorf("single project job ID = %s, want core-v1", single[0].ID)
+	}
+
+	if _, err := ProjectJobsContext(context.Background(), []*config.Config{toolchains, toolchains}); err == nil {
+		t.Error("ProjectJobsContext() should reject two manifests with the same project name")
+	}
+
+	var buf bytes.Buffer
+	if err := GenerateProjectToWriterContext(context.Background(), runtimes, formats[FormatGitHub], &buf); err != nil {
+		t.Fatalf("GenerateProjectToWriterContext() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "name: \"Build Docker Images (runtimes)\"\n") {
+		t.Errorf("split workflow should be named after its project:\n%s", buf.String())
+	}
+}
+
+func TestProjectJobsContext_JobIDCollision(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// tool/core-app:v1 and tool-core/app:v1 are both prefixed to tool-core-app-v1.
+	tool := writeProject(t, tmpDir, "tool", "version: 1\nimages:\n  core-app:\n    path: core-app\n    versions:\n      v1: {}\n", map[string]string{
+		"core-app/v1": "FROM alpine\n",
+	})
+	toolCore := writeProject(t, tmpDir, "tool-core", "version: 1\nimages:\n  app:\n    path: app\n    versions:\n      v1: {}\n", map[string]string{
+		"app/v1": "FROM alpine\n",
+	})
+
+	_, err := ProjectJobsContext(context.Background(), []*config.Config{tool, toolCore})
+	if err == nil || !strings.Contains(err.Error(), "tool/core-app:v1 and tool-core/app:v1") {
+		t.Errorf("ProjectJobsContext() error = %v, want the colliding versions named", err)
+	}
+}
+
+func TestSplitWorkflowPath(t *testing.T) {
+	if got := SplitWorkflowPath(".github/workflows/dockerfiles.yaml", "runtimes"); got != ".github/workflows/dockerfiles-runtimes.yaml" {
+		t.Errorf("SplitWorkflowPath() = %s", got)
+	}
+}
+
+func TestOutputPaths(t *testing.T) {
+	single := []*config.Config{{Project: "toolchains"}}
+	if got := OutputPaths(single, DefaultOutputPath); strings.Join(got, ",") != DefaultOutputPath {
+		t.Errorf("OutputPaths() = %v, want only %s", got, DefaultOutputPath)
+	}
+
+	several := []*config.Config{{Project: "toolchains"}, {Project: "runtimes"}}
+	want := []string{"wf/dockerfiles.yaml", "wf/dockerfiles-toolchains.yaml", "wf/dockerfiles-runtimes.yaml"}
+	if got := OutputPaths(several, "wf/dockerfiles.yaml"); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("OutputPaths() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// pushCondition is true for the runs that push: pushes and scheduled runs on
+// master. The template attaches the deployment environment and sets the push
+// input with the same test, so no run pushes without the environment's
+// protection rules.
+const pushCondition = "(github.event_name == 'push' || github.event_name == 'schedule') && github.ref == 'refs/heads/master'"
+
+// Push is the registry a job pushes its image to.
+type Push struct {
+	// Host is the registry host, e.g. ghcr.io.
+	Host string
+	// Repository is the configured registry, e.g. ghcr.io/acme.
+	Repository string
+	// Tag is the pushed reference, <registry>/<image>:<version>.
+	Tag string
+}
+
+// applyPush sets each job's Push and adds the login to the push registry to
+// its steps, run only when the job pushes. Jobs are left untouched unless
+// defaults.push is enabled. A registry host with an entry in
+// defaults.workflow.auth is logged in to with that provider, and must run
+// after applyAuth so jobs already logged in to it are not logged in twice.
+func applyPush(cfg *config.Config, jobs []Job) error {
+	push := cfg.Defaults.Push
+	if push == nil || !push.Enabled {
+		return nil
+	}
+	if push.Registry == "" {
+		return fmt.Errorf("defaults.push requires registry")
+	}
+	repository := strings.TrimSuffix(push.Registry, "/")
+	host := strings.SplitN(repository, "/", 2)[0]
+
+	var login []Step
+	var permissions []Permission
+	if auth, exists := cfg.Defaults.Workflow.AuthFor(host); exists {
+		provider := authProviders[auth.Provider]
+		steps, err := provider.Steps(host, auth)
+		if err != nil {
+			return fmt.Errorf("registry %s: %w", host, err)
+		}
+		login, permissions = steps, provider.Permissions()
+	} else {
+		if push.UsernameSecret == "" || push.PasswordSecret == "" {
+			return fmt.Errorf("defaults.push requires username_secret and password_secret, or an entry for %s in defaults.workflow.auth", host)
+		}
+		login = []Step{{
+			Name: fmt.Sprintf("Login to %s", host),
+			Uses: dockerLoginAction,
+			With: []Input{
+				{"registry", host},
+				{"username", fmt.Sprintf("${{ secrets.%s }}", push.UsernameSecret)},
+				{"password", fmt.Sprintf("${{ secrets.%s }}", push.PasswordSecret)},
+			},
+		}}
+	}
+
+	for i := range jobs {
+		if !hasStep(jobs[i].LoginSteps, login) {
+			for _, step := range login {
+				step.If = pushCondition
+				jobs[i].LoginSteps = append(jobs[i].LoginSteps, step)
+			}
+			jobs[i].Permissions = mergePermissions(jobs[i].Permissions, permissions)
+		}
+		jobs[i].Push = &Push{
+			Host:       host,
+			Repository: repository,
+			Tag:        fmt.Sprintf("%s/%s:%s", repository, jobs[i].ImageName, jobs[i].Version),
+		}
+	}
+	return nil
+}
+
+// hasStep reports whether steps already contains the first of login, i.e.
+// applyAuth logged the job in to the push registry for pulling.
+func hasStep(steps, login []Step) bool {
+	for _, step := range steps {
+		if len(login) > 0 && step.Name == login[0].Name {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePermissions adds extra to a job's permissions, keeping them sorted.
+// Without either the job keeps the workflow's permissions.
+func mergePermissions(permissions, extra []Permission) []Permission {
+	if len(extra) == 0 {
+		return permissions
+	}
+	merged := map[string]string{"contents": "read"}
+	for _, permission := range append(permissions, extra...) {
+		merged[permission.Scope] = permission.Access
+	}
+	return sortedPermissions(merged)
+}
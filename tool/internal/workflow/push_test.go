@@ -0,0 +1,161 @@
+package workflow
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestApplyPush(t *testing.T) {
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Push: &config.Push{Enabled: true, Registry: "harbor.example.com/team/", UsernameSecret: "HARBOR_USER", PasswordSecret: "HARBOR_TOKEN"},
+		},
+	}
+	jobs := []Job{{ID: "app-v1", Name: "Build app:v1", ImageName: "app", Version: "v1"}}
+	if err := applyPush(cfg, jobs); err != nil {
+		t.Fatalf("applyPush() error = %v", err)
+	}
+
+	want := &Push{Host: "harbor.example.com", Repository: "harbor.example.com/team", Tag: "harbor.example.com/team/app:v1"}
+	if !reflect.DeepEqual(jobs[0].Push, want) {
+		t.Errorf("Push = %+v, want %+v", jobs[0].Push, want)
+	}
+	if len(jobs[0].LoginSteps) != 1 || jobs[0].LoginSteps[0].If != pushCondition {
+		t.Fatalf("login steps = %+v, want one login run only when the job pushes", jobs[0].LoginSteps)
+	}
+	if got := jobs[0].LoginSteps[0].With[1].Value; got != "${{ secrets.HARBOR_USER }}" {
+		t.Errorf("username = %q, want the secret", got)
+	}
+}
+
+func TestApplyPush_Auth(t *testing.T) {
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Push:     &config.Push{Enabled: true, Registry: "ghcr.io/acme"},
+			Workflow: &config.Workflow{Auth: map[string]config.RegistryAuth{"ghcr.io": {Provider: AuthGHCR}}},
+		},
+	}
+	jobs := []Job{
+		{ID: "core-noble", ImageName: "core", Version: "noble"},
+		{ID: "app-v1", ImageName: "app", Version: "v1", LoginSteps: []Step{{Name: "Login to ghcr.io"}}},
+	}
+	if err := applyPush(cfg, jobs); err != nil {
+		t.Fatalf("applyPush() error = %v", err)
+	}
+
+	if len(jobs[0].LoginSteps) != 1 || jobs[0].LoginSteps[0].Uses != dockerLoginAction {
+		t.Errorf("core:noble login steps = %+v, want the ghcr provider's login", jobs[0].LoginSteps)
+	}
+	if want := []Permission{{"contents", "read"}, {"packages", "write"}}; !reflect.DeepEqual(jobs[0].Permissions, want) {
+		t.Errorf("core:noble permissions = %v, want %v", jobs[0].Permissions, want)
+	}
+	if len(jobs[1].LoginSteps) != 1 || jobs[1].LoginSteps[0].If != "" {
+		t.Errorf("app:v1 login steps = %+v, want the existing login only", jobs[1].LoginSteps)
+	}
+}
+
+func TestApplyPush_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		push    *config.Push
+		wantErr string
+	}{
+		{name: "no registry", push: &config.Push{Enabled: true}, wantErr: "requires registry"},
+		{name: "no secrets", push: &config.Push{Enabled: true, Registry: "harbor.example.com/team"}, wantErr: "username_secret and password_secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Defaults: config.Defaults{Push: tt.push}}
+			err := applyPush(cfg, []Job{{ID: "app-v1"}})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("applyPush() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyPush_Disabled(t *testing.T) {
+	cfg := &config.Config{Defaults: config.Defaults{Push: &config.Push{Registry: "harbor.example.com/team"}}}
+	jobs := []Job{{ID: "app-v1"}}
+	if err := applyPush(cfg, jobs); err != nil {
+		t.Fatalf("applyPush() error = %v", err)
+	}
+	if jobs[0].Push != nil || jobs[0].LoginSteps != nil {
+		t.Errorf("jobs should be untouched with push disabled, got %+v", jobs[0])
+	}
+}
+
+// TestWriteWorkflow_PushEnvironment checks that every run that logs in to push
+// or pushes also has the deployment environment attached, so that pushes
+// always wait on its protection rules.
+func TestWriteWorkflow_PushEnvironment(t *testing.T) {
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Push: &config.Push{Enabled: true, Registry: "harbor.example.com/team", UsernameSecret: "HARBOR_USER", PasswordSecret: "HARBOR_TOKEN"},
+		},
+	}
+	environment := &Environment{Name: "production"}
+	jobs := []Job{
+		{ID: "app-v1", Name: "Build app:v1", ImageName: "app", Version: "v1", Environment: environment},
+		{ID: "tool-v1", Name: "Build tool:v1", ImageName: "tool", Version: "v1", Environment: environment},
+	}
+	if err := applyPush(cfg, jobs[:1]); err != nil {
+		t.Fatalf("applyPush() error = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeWorkflowToWriter(Workflow{Jobs: jobs}, &buf); err != nil {
+		t.Fatalf("writeWorkflowToWriter() error = %v", err)
+	}
+	var parsed struct {
+		Jobs map[string]struct {
+			Environment struct {
+				Name string `yaml:"name"`
+			} `yaml:"environment"`
+			Steps []struct {
+				Name string            `yaml:"name"`
+				If   string            `yaml:"if"`
+				Uses string            `yaml:"uses"`
+				With map[string]string `yaml:"with"`
+			} `yaml:"steps"`
+		} `yaml:"jobs"`
+	}
+	if err := yaml.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatalf("workflow is not valid YAML: %v", err)
+	}
+
+	for _, job := range jobs {
+		rendered := parsed.Jobs[job.ID]
+		condition, ok := strings.CutPrefix(rendered.Environment.Name, "${{ ")
+		condition, found := strings.CutSuffix(condition, " && 'production' || '' }}")
+		if !ok || !found {
+			t.Fatalf("%s: environment name = %q, want it attached conditionally", job.ID, rendered.Environment.Name)
+		}
+		if want := "(github.event_name == 'push' || github.event_name == 'schedule') && github.ref == 'refs/heads/master'"; condition != want {
+			t.Errorf("%s: environment condition = %q, want pushes and scheduled runs on master only", job.ID, condition)
+		}
+
+		pushes := false
+		for _, step := range rendered.Steps {
+			if step.Uses == dockerLoginAction && step.If != condition {
+				t.Errorf("%s: %s runs if %q, want the environment's condition %q", job.ID, step.Name, step.If, condition)
+			}
+			if push, ok := step.With["push"]; ok {
+				pushes = true
+				if push != "${{ "+condition+" }}" {
+					t.Errorf("%s: push = %q, want the environment's condition %q", job.ID, push, condition)
+				}
+			}
+		}
+		if !pushes {
+			t.Errorf("%s: no step sets push", job.ID)
+		}
+	}
+}
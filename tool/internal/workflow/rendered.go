@@ -0,0 +1,91 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/generator"
+)
+
+// Dependency sources select where job dependencies are parsed from.
+const (
+	// DependenciesRendered renders each version's Dockerfile in memory from
+	// the templates, so that no generated file needs to exist yet.
+	DependenciesRendered = "rendered"
+	// DependenciesDockerfiles reads the Dockerfiles in the version
+	// directories.
+	DependenciesDockerfiles = "dockerfiles"
+)
+
+// contentParser returns the internal image:version references of a
+// Dockerfile's content.
+type contentParser func(content []byte) (*dependencies, error)
+
+func contentParserFor(cfg *config.Config) (contentParser, error) {
+	name := ""
+	if cfg.Defaults.Workflow != nil {
+		name = cfg.Defaults.Workflow.Parser
+	}
+
+	switch name {
+	case "", ParserRegex:
+		return parseDockerfileContent, nil
+	case ParserBuildkit:
+		return parseDockerfileContentBuildkit, nil
+	default:
+		return nil, fmt.Errorf("unknown dependency parser %q (supported: %s, %s)", name, ParserRegex, ParserBuildkit)
+	}
+}
+
+// jobDependencyParser returns the parser for jobs, which were built from
+// cfg. Relative Dockerfile paths are read from cfg's repository, and parsing
+// fails once ctx is done. Unless cfg selects DependenciesDockerfiles, each
+// job's Dockerfile is rendered from its image's templates; images without
+// templates, and configs not loaded from a manifest, have their Dockerfiles
+// read from disk.
+func jobDependencyParser(ctx context.Context, cfg *config.Config, jobs []Job) (dependencyParser, error) {
+	parseFile, err := dependencyParserFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	parseFile = resolvedFrom(cfg, parseFile)
+
+	source := ""
+	if cfg.Defaults.Workflow != nil {
+		source = cfg.Defaults.Workflow.Dependencies
+	}
+	switch source {
+	case DependenciesDockerfiles:
+		return withContext(ctx, parseFile), nil
+	case "", DependenciesRendered:
+		if cfg.Defaults.BasePath == "" {
+			return withContext(ctx, parseFile), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown dependency source %q (supported: %s, %s)", source, DependenciesRendered, DependenciesDockerfiles)
+	}
+
+	parseContent, err := contentParserFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]Job, len(jobs))
+	for _, job := range jobs {
+		byPath[job.DockerfilePath] = job
+	}
+	return withContext(ctx, func(dockerfilePath string) (*dependencies, error) {
+		job, exists := byPath[dockerfilePath]
+		if !exists {
+			return parseFile(dockerfilePath)
+		}
+		content, rendered, err := generator.RenderFile(ctx, cfg, job.ImageName, job.Version, "Dockerfile")
+		if err != nil {
+			return nil, err
+		}
+		if !rendered {
+			return parseFile(dockerfilePath)
+		}
+		return parseContent(content)
+	}), nil
+}
@@ -0,0 +1,77 @@
+package workflow
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// renderedTestConfig writes templates for core and app and a hand-maintained
+// Dockerfile for legacy, but no generated version directories.
+func renderedTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	imagesDir := filepath.Join(t.TempDir(), "images")
+	files := map[string]string{
+		"core/source/Dockerfile.tmpl": "FROM alpine\n",
+		"app/source/Dockerfile.tmpl":  "ARG REGISTRY={{registry}}\nFROM ${REGISTRY}/core:v1\n",
+		"legacy/v1/Dockerfile":        "FROM ${REGISTRY}/app:v1\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(imagesDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: imagesDir, Registry: "test.io"},
+		Images:   make(map[string]config.Image),
+	}
+	for _, name := range []string{"core", "app", "legacy"} {
+		cfg.Images[name] = config.Image{Path: name, Versions: map[string]*config.ImageConfig{"v1": {}}}
+	}
+	return cfg
+}
+
+func TestJobsContext_RenderedDependencies(t *testing.T) {
+	cfg := renderedTestConfig(t)
+
+	jobs, err := JobsContext(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("JobsContext() error = %v", err)
+	}
+	var got []string
+	for _, job := range jobs {
+		got = append(got, job.ID+" ["+strings.Join(job.Needs, ",")+"]")
+	}
+	want := "core-v1 [] app-v1 [core-v1] legacy-v1 [app-v1]"
+	if strings.Join(got, " ") != want {
+		t.Errorf("jobs = %s, want %s", strings.Join(got, " "), want)
+	}
+
+	if _, err := os.Stat(filepath.Join(cfg.Defaults.BasePath, "app", "v1")); !os.IsNotExist(err) {
+		t.Error("rendering dependencies should not write version directories")
+	}
+}
+
+func TestJobsContext_DockerfileDependencies(t *testing.T) {
+	cfg := renderedTestConfig(t)
+	cfg.Defaults.Workflow = &config.Workflow{Dependencies: DependenciesDockerfiles}
+
+	_, err := JobsContext(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "reading Dockerfile") {
+		t.Errorf("JobsContext() error = %v, want the missing Dockerfile reported", err)
+	}
+
+	cfg.Defaults.Workflow = &config.Workflow{Dependencies: "guess"}
+	if _, err := JobsContext(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "unknown dependency source") {
+		t.Errorf("JobsContext() error = %v, want an unknown dependency source", err)
+	}
+}
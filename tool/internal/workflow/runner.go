@@ -0,0 +1,37 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// jobRunner resolves the runner labels and timeout of one image version: the
+// image's own settings, else defaults.workflow.runs_on and timeout_minutes.
+// Nil labels run on ubuntu-latest and a zero timeout keeps GitHub's default.
+func jobRunner(cfg *config.Config, image config.Image, version string) ([]string, int, error) {
+	runsOn := image.WorkflowRunsOn(version)
+	timeout := 0
+	if t := image.WorkflowTimeout(version); t != nil {
+		timeout = *t
+	} else if cfg.Defaults.Workflow != nil {
+		timeout = cfg.Defaults.Workflow.TimeoutMinutes
+	}
+	if runsOn == nil && cfg.Defaults.Workflow != nil {
+		runsOn = cfg.Defaults.Workflow.RunsOn
+	}
+
+	for _, label := range runsOn {
+		if strings.TrimSpace(label) == "" {
+			return nil, 0, fmt.Errorf("runs_on has an empty runner label")
+		}
+	}
+	if timeout < 0 {
+		return nil, 0, fmt.Errorf("timeout_minutes must not be negative, got %d", timeout)
+	}
+	if len(runsOn) == 0 {
+		runsOn = nil
+	}
+	return runsOn, timeout, nil
+}
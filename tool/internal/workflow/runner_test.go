@@ -0,0 +1,93 @@
+package workflow
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestJobRunner(t *testing.T) {
+	var cfg config.Config
+	manifest := `
+version: 1
+defaults:
+  workflow:
+    runs_on: [ubuntu-24.04]
+    timeout_minutes: 60
+images:
+  python:
+    versions:
+      "3.13": {}
+  cuda:
+    workflow:
+      runs_on: [self-hosted, gpu]
+      timeout_minutes: 120
+    versions:
+      "12": {}
+      "11":
+        workflow:
+          timeout_minutes: 0
+`
+	if err := yaml.Unmarshal([]byte(manifest), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	tests := []struct {
+		image, version string
+		wantRunsOn     []string
+		wantTimeout    int
+	}{
+		{image: "python", version: "3.13", wantRunsOn: []string{"ubuntu-24.04"}, wantTimeout: 60},
+		{image: "cuda", version: "12", wantRunsOn: []string{"self-hosted", "gpu"}, wantTimeout: 120},
+		{image: "cuda", version: "11", wantRunsOn: []string{"self-hosted", "gpu"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image+":"+tt.version, func(t *testing.T) {
+			runsOn, timeout, err := jobRunner(&cfg, cfg.Images[tt.image], tt.version)
+			if err != nil {
+				t.Fatalf("jobRunner() error = %v", err)
+			}
+			if !reflect.DeepEqual(runsOn, tt.wantRunsOn) || timeout != tt.wantTimeout {
+				t.Errorf("jobRunner() = %v, %d, want %v, %d", runsOn, timeout, tt.wantRunsOn, tt.wantTimeout)
+			}
+		})
+	}
+
+	if runsOn, timeout, err := jobRunner(&config.Config{}, config.Image{}, "v1"); err != nil || runsOn != nil || timeout != 0 {
+		t.Errorf("jobRunner() = %v, %d, %v, want the defaults when unset", runsOn, timeout, err)
+	}
+	if _, _, err := jobRunner(&config.Config{}, config.Image{Workflow: &config.ImageWorkflow{RunsOn: config.RunnerLabels{" "}}}, "v1"); err == nil {
+		t.Error("jobRunner() should reject an empty runner label")
+	}
+}
+
+func TestWriteWorkflow_Runner(t *testing.T) {
+	jobs := []Job{
+		{ID: "cuda-12", Name: "Build cuda:12", ImageName: "cuda", Version: "12", RunsOn: []string{"self-hosted", "gpu"}, TimeoutMinutes: 120},
+		{ID: "tool-v1", Name: "Build tool:v1", ImageName: "tool", Version: "v1"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeWorkflowToWriter(Workflow{Jobs: jobs}, &buf); err != nil {
+		t.Fatalf("writeWorkflowToWriter() error = %v", err)
+	}
+	output := buf.String()
+
+	want := "    name: \"Build cuda:12\"\n" +
+		"    runs-on: [\"self-hosted\", \"gpu\"]\n" +
+		"    timeout-minutes: 120\n"
+	if !strings.Contains(output, want) {
+		t.Errorf("workflow should render the job's runner and timeout, got:\n%s", output)
+	}
+	if strings.Count(output, "timeout-minutes:") != 1 {
+		t.Errorf("only cuda-12 should set a timeout, got:\n%s", output)
+	}
+	if !strings.Contains(output, "    name: \"Build tool:v1\"\n    runs-on: ubuntu-latest\n") {
+		t.Errorf("jobs without runs_on should keep ubuntu-latest, got:\n%s", output)
+	}
+}
@@ -0,0 +1,82 @@
+package workflow
+
+import (
+	"slices"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+// maxParallel returns the smallest defaults.workflow.max_parallel of cfgs,
+// or 0 when none limits how many build jobs run at once.
+func maxParallel(cfgs ...*config.Config) int {
+	limit := 0
+	for _, cfg := range cfgs {
+		if cfg.Defaults.Workflow == nil || cfg.Defaults.Workflow.MaxParallel <= 0 {
+			continue
+		}
+		if limit == 0 || cfg.Defaults.Workflow.MaxParallel < limit {
+			limit = cfg.Defaults.Workflow.MaxParallel
+		}
+	}
+	return limit
+}
+
+// limitParallel groups jobs into waves of at most n, so that no more than n
+// build jobs run at once, and makes each wave wait for the one before it
+// through After. Waves follow the topological levels of the Needs graph, the
+// longest chain of needs leading to a job, with the jobs of a level split in
+// job order. A job is therefore only ever delayed, never started before its
+// dependencies, and the same jobs always form the same waves. n <= 0 leaves
+// jobs untouched.
+func limitParallel(jobs []Job, n int) {
+	if n <= 0 {
+		return
+	}
+
+	index := make(map[string]int, len(jobs))
+	for i, job := range jobs {
+		index[job.ID] = i
+	}
+	levels := make([]int, len(jobs))
+	for i := range levels {
+		levels[i] = -1
+	}
+	var level func(i int) int
+	level = func(i int) int {
+		if levels[i] >= 0 {
+			return levels[i]
+		}
+		levels[i] = 0
+		for _, need := range jobs[i].Needs {
+			if j, exists := index[need]; exists {
+				levels[i] = max(levels[i], level(j)+1)
+			}
+		}
+		return levels[i]
+	}
+
+	var byLevel [][]int
+	for i := range jobs {
+		l := level(i)
+		for len(byLevel) <= l {
+			byLevel = append(byLevel, nil)
+		}
+		byLevel[l] = append(byLevel[l], i)
+	}
+
+	var previous []int
+	for _, members := range byLevel {
+		for start := 0; start < len(members); start += n {
+			wave := members[start:min(start+n, len(members))]
+			for _, i := range wave {
+				jobs[i].After = nil
+				for _, p := range previous {
+					if !slices.Contains(jobs[i].Needs, jobs[p].ID) {
+						jobs[i].After = append(jobs[i].After, jobs[p].ID)
+					}
+				}
+			}
+			previous = wave
+		}
+	}
+}
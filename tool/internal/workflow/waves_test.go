@@ -0,0 +1,82 @@
+package workflow
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mberwanger/dockerfiles/tool/internal/config"
+)
+
+func TestLimitParallel(t *testing.T) {
+	jobs := func() []Job {
+		return []Job{
+			{ID: "a"},
+			{ID: "b"},
+			{ID: "d", Needs: []string{"a"}},
+			{ID: "c"},
+			{ID: "e", Needs: []string{"d"}},
+		}
+	}
+
+	got := jobs()
+	limitParallel(got, 2)
+	want := map[string][]string{
+		"a": nil,
+		"b": nil,
+		"c": {"a", "b"},
+		"d": {"c"},
+		"e": nil,
+	}
+	for _, job := range got {
+		if !reflect.DeepEqual(job.After, want[job.ID]) {
+			t.Errorf("%s.After = %v, want %v", job.ID, job.After, want[job.ID])
+		}
+	}
+
+	again := jobs()
+	limitParallel(again, 2)
+	if !reflect.DeepEqual(got, again) {
+		t.Errorf("limitParallel() is not deterministic: %+v, then %+v", got, again)
+	}
+
+	unlimited := jobs()
+	limitParallel(unlimited, 0)
+	if !reflect.DeepEqual(unlimited, jobs()) {
+		t.Errorf("limitParallel(0) changed the jobs: %+v", unlimited)
+	}
+}
+
+func TestMaxParallel(t *testing.T) {
+	limited := func(n int) *config.Config {
+		return &config.Config{Defaults: config.Defaults{Workflow: &config.Workflow{MaxParallel: n}}}
+	}
+	if got := maxParallel(&config.Config{}, limited(0)); got != 0 {
+		t.Errorf("maxParallel() = %d, want 0 without a limit", got)
+	}
+	if got := maxParallel(limited(20), &config.Config{}, limited(5)); got != 5 {
+		t.Errorf("maxParallel() = %d, want the smallest limit", got)
+	}
+}
+
+func TestWriteWorkflow_After(t *testing.T) {
+	jobs := []Job{
+		{ID: "a", Name: "Build a:v1", ImageName: "a", Version: "v1"},
+		{ID: "b", Name: "Build b:v1", ImageName: "b", Version: "v1", Needs: []string{"a"}},
+		{ID: "c", Name: "Build c:v1", ImageName: "c", Version: "v1", After: []string{"a"}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeWorkflowToWriter(Workflow{Jobs: jobs}, &buf); err != nil {
+		t.Fatalf("writeWorkflowToWriter() error = %v", err)
+	}
+	output := buf.String()
+
+	if !strings.Contains(output, "    needs: [wait-for-ci, changes, a]\n    if: ${{ !cancelled() && needs.wait-for-ci.result != 'failure' && needs.changes.result != 'failure' && (") {
+		t.Errorf("c should wait for a without failing along with it, got:\n%s", output)
+	}
+	if strings.Count(output, "!cancelled() && !contains(needs.*.result, 'failure')") != 2 {
+		t.Errorf("jobs without After should keep their condition, got:\n%s", output)
+	}
+}
@@ -1,6 +1,8 @@
 package workflow
 
 import (
+	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
 	"io"
@@ -8,40 +10,94 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
+	"github.com/apex/log"
+
 	"github.com/mberwanger/dockerfiles/tool/internal/config"
 )
 
 //go:embed templates/workflow.tmpl
 var workflowTemplate string
 
+// Workflow is the data every workflow template, embedded or supplied with
+// generate workflow --template, is executed with. Templates can also call
+// quote, which renders a string as a YAML scalar, and shellquote, which
+// renders it as a shell word.
 type Workflow struct {
+	// Project is set when each project's workflow is written to its own file.
+	Project string
+	// Jobs are the build jobs in dependency order.
 	Jobs []Job
 }
 
+// Job builds one image version.
 type Job struct {
-	ID             string
-	Name           string
-	ImageName      string
-	Version        string
+	// ID is the job's key in the workflow, unique and YAML-safe.
+	ID string
+	// Name is the displayed job name, e.g. "Build core:noble".
+	Name      string
+	ImageName string
+	Version   string
+	// DockerfilePath and Context are relative to the repository root.
 	DockerfilePath string
-	Needs          []string
+	Context        string
+	// Prepare are the shell lines run before the build.
+	Prepare []string
+	// DependsOn are the image:version references the Dockerfile builds on,
+	// and Needs the IDs of the jobs building them.
+	DependsOn []string
+	Needs     []string
+	// After are the IDs of jobs this one waits for only to limit how many
+	// run at once, set by defaults.workflow.max_parallel. Unlike Needs,
+	// their failure does not skip it.
+	After []string
+	// LoginSteps log in to the registries the job pulls from and pushes
+	// to, and Permissions are the token scopes they need.
+	LoginSteps  []Step
+	Permissions []Permission
+	// Environment is the deployment environment pushes go through, if any.
+	Environment *Environment
+	// Push is where the job pushes with defaults.push, nil without it.
+	Push *Push
+	// CacheFrom and CacheTo are the buildx cache arguments, e.g.
+	// "type=gha,scope=core-noble"; empty keeps the build action's default.
+	CacheFrom string
+	CacheTo   string
+	// RunsOn are the job's runner labels; empty runs on ubuntu-latest.
+	RunsOn []string
+	// TimeoutMinutes bounds the job; 0 keeps GitHub's default.
+	TimeoutMinutes int
+	// Platforms are the platforms the job builds, all in one buildx
+	// invocation; empty builds the runner's platform.
+	Platforms []string
+	// Aliases are extra tags, e.g. latest, pushed along with Version.
+	Aliases []string
+	// Paths are the repository paths, as globs, whose changes rebuild the
+	// image when the workflow runs for a push or pull request.
+	Paths []string
 }
 
 func Generate(cfg *config.Config, outputPath string) error {
-	jobs, err := buildJobsFromConfig(cfg)
+	return GenerateContext(context.Background(), cfg, outputPath)
+}
+
+// GenerateContext is Generate with cancellation, checked before each
+// Dockerfile is parsed for dependencies.
+func GenerateContext(ctx context.Context, cfg *config.Config, outputPath string) error {
+	orderedJobs, err := JobsContext(ctx, cfg)
 	if err != nil {
-		return fmt.Errorf("building jobs from config: %w", err)
+		return err
 	}
 
-	orderedJobs, err := orderJobsByDependencies(jobs)
-	if err != nil {
-		return fmt.Errorf("ordering jobs by dependencies: %w", err)
+	if err := checkJobLimits(len(orderedJobs), GitHubLimits, cfg); err != nil {
+		return err
 	}
 
-	if err := writeWorkflow(orderedJobs, outputPath); err != nil {
+	limitParallel(orderedJobs, maxParallel(cfg))
+	if err := writeWorkflow(Workflow{Jobs: orderedJobs}, outputPath); err != nil {
 		return fmt.Errorf("writing workflow: %w", err)
 	}
 
@@ -49,23 +105,67 @@ func Generate(cfg *config.Config, outputPath string) error {
 }
 
 func GenerateToWriter(cfg *config.Config, w io.Writer) error {
-	jobs, err := buildJobsFromConfig(cfg)
+	return GenerateToWriterContext(context.Background(), cfg, w)
+}
+
+// GenerateToWriterContext is GenerateToWriter with cancellation.
+func GenerateToWriterContext(ctx context.Context, cfg *config.Config, w io.Writer) error {
+	orderedJobs, err := JobsContext(ctx, cfg)
 	if err != nil {
-		return fmt.Errorf("building jobs from config: %w", err)
+		return err
 	}
 
-	orderedJobs, err := orderJobsByDependencies(jobs)
-	if err != nil {
-		return fmt.Errorf("ordering jobs by dependencies: %w", err)
+	if err := checkJobLimits(len(orderedJobs), GitHubLimits, cfg); err != nil {
+		return err
 	}
 
-	if err := writeWorkflowToWriter(orderedJobs, w); err != nil {
+	limitParallel(orderedJobs, maxParallel(cfg))
+	if err := writeWorkflowToWriter(Workflow{Jobs: orderedJobs}, w); err != nil {
 		return fmt.Errorf("writing workflow: %w", err)
 	}
 
 	return nil
 }
 
+// Jobs returns the build jobs for cfg in dependency order, exactly as they are
+// handed to the workflow template.
+func Jobs(cfg *config.Config) ([]Job, error) {
+	return JobsContext(context.Background(), cfg)
+}
+
+// JobsContext is Jobs with cancellation, checked before each Dockerfile is
+// parsed.
+func JobsContext(ctx context.Context, cfg *config.Config) ([]Job, error) {
+	jobs, err := buildJobsFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("building jobs from config: %w", err)
+	}
+
+	parse, err := jobDependencyParser(ctx, cfg, jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	orderedJobs, err := orderJobsByDependencies(jobs, parse, externalImages(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("ordering jobs by dependencies: %w", err)
+	}
+
+	if err := applyAuth(cfg, orderedJobs); err != nil {
+		return nil, fmt.Errorf("configuring registry auth: %w", err)
+	}
+
+	if err := applyPush(cfg, orderedJobs); err != nil {
+		return nil, fmt.Errorf("configuring push: %w", err)
+	}
+
+	for _, problem := range missingPlatforms(orderedJobs) {
+		log.Warn(problem)
+	}
+
+	return orderedJobs, nil
+}
+
 func buildJobsFromConfig(cfg *config.Config) ([]Job, error) {
 	var jobs []Job
 
@@ -76,25 +176,58 @@ func buildJobsFromConfig(cfg *config.Config) ([]Job, error) {
 	}
 	sort.Strings(imageNames)
 
+	root := imagesRoot(cfg)
+	ids := newJobIDs()
 	for _, imageName := range imageNames {
 		image := cfg.Images[imageName]
 
-		// Sort versions for deterministic ordering
-		versions := make([]string, 0, len(image.Versions))
-		for version := range image.Versions {
-			versions = append(versions, version)
-		}
-		sort.Strings(versions)
+		// Output versions are sorted for deterministic ordering and include
+		// variants, which get their own jobs under the suffixed tag.
+		for _, output := range image.OutputVersions() {
+			version := output.Name
+			if !image.VersionEnabled(version) {
+				log.Debugf("skipping workflow job for %s:%s (disabled)", imageName, version)
+				continue
+			}
+			if !image.WorkflowEnabled(version) {
+				log.Debugf("skipping workflow job for %s:%s (workflow disabled)", imageName, version)
+				continue
+			}
 
-		for _, version := range versions {
-			dockerfilePath := filepath.Join("images", image.Path, version, "Dockerfile")
+			contextDir := filepath.Join(root, image.Path, version)
 
 			job := Job{
 				ID:             generateJobID(imageName, version),
 				Name:           fmt.Sprintf("Build %s:%s", imageName, version),
 				ImageName:      imageName,
 				Version:        version,
-				DockerfilePath: dockerfilePath,
+				DockerfilePath: filepath.Join(contextDir, "Dockerfile"),
+				Context:        contextDir,
+				Prepare:        scriptLines(image.WorkflowPrepare(version)),
+				Paths:          jobPaths(cfg, image, version),
+				Aliases:        image.VersionAliases(version),
+			}
+			if err := ids.add(job.ID, imageName+":"+version); err != nil {
+				return nil, err
+			}
+			if dependsOn, declared := image.DeclaredDependencies(version); declared {
+				job.DependsOn = append([]string{}, dependsOn...)
+			}
+			environment, err := jobEnvironment(cfg, image, imageName, version)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%s: %w", imageName, version, err)
+			}
+			job.Environment = environment
+			platforms, err := jobPlatforms(cfg, image, version)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%s: %w", imageName, version, err)
+			}
+			job.Platforms = platforms
+			if job.RunsOn, job.TimeoutMinutes, err = jobRunner(cfg, image, version); err != nil {
+				return nil, fmt.Errorf("%s:%s: %w", imageName, version, err)
+			}
+			if job.CacheFrom, job.CacheTo, err = jobCache(cfg, image, imageName, version, job.ID); err != nil {
+				return nil, fmt.Errorf("%s:%s: %w", imageName, version, err)
 			}
 
 			jobs = append(jobs, job)
@@ -104,25 +237,219 @@ func buildJobsFromConfig(cfg *config.Config) ([]Job, error) {
 	return jobs, nil
 }
 
-func orderJobsByDependencies(jobs []Job) ([]Job, error) {
-	jobMap := make(map[string]*Job)
-	for i := range jobs {
-		key := fmt.Sprintf("%s:%s", jobs[i].ImageName, jobs[i].Version)
-		jobMap[key] = &jobs[i]
+// imagesRoot returns the directory image paths are resolved against in the
+// workflow: the manifest's directory relative to the repository root, so that
+// the workflow is the same whichever directory it is generated from. Configs
+// not loaded from a manifest use "images".
+func imagesRoot(cfg *config.Config) string {
+	if cfg.Defaults.BasePath == "" {
+		return "images"
+	}
+	root, err := filepath.Rel(repositoryRoot(cfg.Defaults.BasePath), cfg.Defaults.BasePath)
+	if err != nil {
+		return "images"
+	}
+	return filepath.ToSlash(root)
+}
+
+// repositoryRoot returns the nearest directory at or above basePath that
+// holds a .git entry, or else basePath's parent, which is where the
+// manifest's directory conventionally lives.
+var repositoryRoot = func(basePath string) string {
+	for dir := basePath; ; {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return filepath.Dir(basePath)
+		}
+		dir = parent
+	}
+}
+
+// resolvedFrom makes parse read relative Dockerfile paths, which are relative
+// to the repository root, from cfg's repository instead of the working
+// directory.
+func resolvedFrom(cfg *config.Config, parse dependencyParser) dependencyParser {
+	if cfg.Defaults.BasePath == "" {
+		return parse
+	}
+	root := repositoryRoot(cfg.Defaults.BasePath)
+	return func(dockerfilePath string) (*dependencies, error) {
+		if !filepath.IsAbs(dockerfilePath) {
+			dockerfilePath = filepath.Join(root, dockerfilePath)
+		}
+		return parse(dockerfilePath)
+	}
+}
+
+// scriptLines splits multi-line commands so that each line can be indented
+// into the step's run block.
+func scriptLines(commands []string) []string {
+	var lines []string
+	for _, command := range commands {
+		lines = append(lines, strings.Split(strings.TrimRight(command, "\n"), "\n")...)
+	}
+	return lines
+}
+
+// dependencies are the internal image:version references of a Dockerfile.
+// References inside ONBUILD triggers are not needed to build the Dockerfile
+// itself; they run, and so are needed, when another image is built FROM it.
+// Lines holds the line of the first reference to each dependency.
+type dependencies struct {
+	Build   []string
+	Bases   []string
+	OnBuild []string
+	Lines   map[string]int
+}
+
+// dependencyParser returns the internal image:version references of a Dockerfile.
+type dependencyParser func(dockerfilePath string) (*dependencies, error)
+
+const (
+	ParserRegex    = "regex"
+	ParserBuildkit = "buildkit"
+)
+
+// withContext makes parse fail with the context's error once ctx is done.
+func withContext(ctx context.Context, parse dependencyParser) dependencyParser {
+	return func(dockerfilePath string) (*dependencies, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return parse(dockerfilePath)
 	}
+}
+
+func dependencyParserFor(cfg *config.Config) (dependencyParser, error) {
+	name := ""
+	if cfg.Defaults.Workflow != nil {
+		name = cfg.Defaults.Workflow.Parser
+	}
+
+	switch name {
+	case "", ParserRegex:
+		return parseDockerfileDependencies, nil
+	case ParserBuildkit:
+		return parseDockerfileDependenciesBuildkit, nil
+	default:
+		return nil, fmt.Errorf("unknown dependency parser %q (supported: %s, %s)", name, ParserRegex, ParserBuildkit)
+	}
+}
+
+// externalImages returns the image:version keys that are in the manifest but
+// get no job here, describing why: disabled versions, and versions templated
+// here but built elsewhere, i.e. with workflow generation disabled.
+func externalImages(cfg *config.Config) map[string]string {
+	external := make(map[string]string)
+	for imageName, image := range cfg.Images {
+		for _, output := range image.OutputVersions() {
+			key := fmt.Sprintf("%s:%s", imageName, output.Name)
+			if !image.VersionEnabled(output.Name) {
+				external[key] = "disabled"
+			} else if !image.WorkflowEnabled(output.Name) {
+				external[key] = "externally built"
+			}
+		}
+	}
+	return external
+}
 
+// parsedJobs holds the parsed dependencies of every job, indexed by the
+// job's image:version key.
+type parsedJobs struct {
+	jobs   []Job
+	deps   []*dependencies
+	byName map[string]int
+}
+
+func parseJobs(jobs []Job, parse dependencyParser) (*parsedJobs, error) {
+	p := &parsedJobs{jobs: jobs, deps: make([]*dependencies, len(jobs)), byName: make(map[string]int)}
 	for i := range jobs {
-		deps, err := parseDockerfileDependencies(jobs[i].DockerfilePath)
+		p.byName[fmt.Sprintf("%s:%s", jobs[i].ImageName, jobs[i].Version)] = i
+
+		deps, err := parse(jobs[i].DockerfilePath)
 		if err != nil {
 			return nil, fmt.Errorf("parsing dependencies for %s: %w", jobs[i].Name, err)
 		}
+		p.deps[i] = deps
+	}
+	return p, nil
+}
 
-		var needs []string
+// edge is a dependency parsed from a Dockerfile, with the file and line it
+// was found on.
+type edge struct {
+	dep        string
+	dockerfile string
+	line       int
+}
+
+// edges returns the sorted, deduplicated dependencies parsed for job i,
+// including the ONBUILD triggers of its base images, which run as part of
+// its build.
+func (p *parsedJobs) edges(i int) []edge {
+	seen := make(map[string]bool)
+	var edges []edge
+	add := func(deps []string, from int) {
 		for _, dep := range deps {
-			if depJob, exists := jobMap[dep]; exists {
-				needs = append(needs, depJob.ID)
+			if !seen[dep] {
+				seen[dep] = true
+				edges = append(edges, edge{dep: dep, dockerfile: p.jobs[from].DockerfilePath, line: p.deps[from].Lines[dep]})
+			}
+		}
+	}
+
+	add(p.deps[i].Build, i)
+	for _, base := range p.deps[i].Bases {
+		if baseIndex, exists := p.byName[base]; exists {
+			add(p.deps[baseIndex].OnBuild, baseIndex)
+		}
+	}
+	sort.Slice(edges, func(a, b int) bool { return edges[a].dep < edges[b].dep })
+	return edges
+}
+
+// orderJobsByDependencies sets each job's needs and sorts the jobs so that
+// dependencies come first. A job's declared depends_on is merged with the
+// dependencies parsed from its Dockerfile, and must only name versions in the
+// manifest, i.e. jobs or external images.
+func orderJobsByDependencies(jobs []Job, parse dependencyParser, external map[string]string) ([]Job, error) {
+	parsed, err := parseJobs(jobs, parse)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range jobs {
+		for _, dep := range jobs[i].DependsOn {
+			if _, exists := parsed.byName[dep]; exists {
+				continue
+			}
+			if _, exists := external[dep]; !exists {
+				return nil, fmt.Errorf("%s: depends_on entry %s is not a version in the manifest", jobs[i].Name, dep)
+			}
+		}
+
+		deps := append([]string(nil), jobs[i].DependsOn...)
+		for _, e := range parsed.edges(i) {
+			deps = append(deps, e.dep)
+		}
+		sort.Strings(deps)
+
+		var needs []string
+		for j, dep := range deps {
+			if j > 0 && deps[j-1] == dep {
+				continue
+			}
+			if depIndex, exists := parsed.byName[dep]; exists {
+				needs = append(needs, jobs[depIndex].ID)
+			} else if reason, exists := external[dep]; exists {
+				log.Warnf("%s depends on %s, which is %s; omitting it from needs", jobs[i].Name, dep, reason)
 			}
 		}
+		sort.Strings(needs)
 		jobs[i].Needs = needs
 	}
 
@@ -134,58 +461,134 @@ func orderJobsByDependencies(jobs []Job) ([]Job, error) {
 	return sorted, nil
 }
 
-func parseDockerfileDependencies(dockerfilePath string) ([]string, error) {
+// MaxDockerfileLineLength bounds the length of a single Dockerfile line the
+// dependency parsers accept, so that a malformed or hostile file fails with
+// an explicit error instead of exhausting memory.
+const MaxDockerfileLineLength = 64 << 10
+
+// Instructions and the AS of a stage name are case-insensitive, as in Docker.
+var (
+	fromPattern           = regexp.MustCompile(`^\s*(?i:FROM)\s+(?:--\S+\s+)*\$\{REGISTRY\}/([^:@\s]+):([^@\s]+)`)
+	copyFromPattern       = regexp.MustCompile(`^\s*(?i:COPY)\s+.*--from=([^\s]+)`)
+	onBuildTriggerPattern = regexp.MustCompile(`^\s*(?i:ONBUILD)\s+(.*)`)
+	stageNamePattern      = regexp.MustCompile(`^\s*(?i:FROM)\s+.*\s+(?i:AS)\s+([^\s]+)`)
+	registryPattern       = regexp.MustCompile(`\$\{REGISTRY\}/([^:@\s]+):([^@\s]+)`)
+)
+
+func parseDockerfileDependencies(dockerfilePath string) (*dependencies, error) {
 	content, err := os.ReadFile(dockerfilePath)
 	if err != nil {
 		return nil, fmt.Errorf("reading Dockerfile: %w", err)
 	}
+	return parseDockerfileContent(content)
+}
 
-	depsMap := make(map[string]bool)
-	lines := strings.Split(string(content), "\n")
+// parseDockerfileContent is the line-based parser behind
+// parseDockerfileDependencies.
+func parseDockerfileContent(content []byte) (*dependencies, error) {
+	if err := checkLineLengths(content); err != nil {
+		return nil, err
+	}
 
-	fromPattern := regexp.MustCompile(`^\s*FROM\s+\$\{REGISTRY\}/([^:\s]+):([^\s]+)`)
-	copyFromPattern := regexp.MustCompile(`^\s*COPY\s+.*--from=([^\s]+)`)
+	buildMap := make(map[string]int)
+	baseMap := make(map[string]int)
+	onBuildMap := make(map[string]int)
+	lines := strings.Split(string(content), "\n")
 
-	// Track internal stage names
+	// Track internal stage names, which Docker matches case-insensitively
 	stageNames := make(map[string]bool)
 	for _, line := range lines {
-		if match := regexp.MustCompile(`^\s*FROM\s+.*\s+AS\s+([^\s]+)`).FindStringSubmatch(line); match != nil {
-			stageNames[match[1]] = true
+		if match := stageNamePattern.FindStringSubmatch(line); match != nil {
+			stageNames[strings.ToLower(match[1])] = true
 		}
 	}
 
-	for _, line := range lines {
-		if match := fromPattern.FindStringSubmatch(line); match != nil {
+	for i, line := range lines {
+		lineNumber := i + 1
+		// ONBUILD triggers run in downstream builds, not this one.
+		depsMap := buildMap
+		if match := onBuildTriggerPattern.FindStringSubmatch(line); match != nil {
+			line = match[1]
+			depsMap = onBuildMap
+		} else if match := fromPattern.FindStringSubmatch(line); match != nil {
 			imageName := match[1]
 			version := match[2]
 			dep := fmt.Sprintf("%s:%s", imageName, version)
-			depsMap[dep] = true
+			addDependency(buildMap, dep, lineNumber)
+			addDependency(baseMap, dep, lineNumber)
 		}
 
 		if match := copyFromPattern.FindStringSubmatch(line); match != nil {
 			fromRef := match[1]
 			// Skip if it's an internal stage reference
-			if !stageNames[fromRef] {
+			if !stageNames[strings.ToLower(fromRef)] {
 				// Try to parse as ${REGISTRY}/image:version
-				if registryMatch := regexp.MustCompile(`\$\{REGISTRY\}/([^:\s]+):([^\s]+)`).FindStringSubmatch(fromRef); registryMatch != nil {
+				if registryMatch := registryPattern.FindStringSubmatch(fromRef); registryMatch != nil {
 					imageName := registryMatch[1]
 					version := registryMatch[2]
 					dep := fmt.Sprintf("%s:%s", imageName, version)
-					depsMap[dep] = true
+					addDependency(depsMap, dep, lineNumber)
 				}
 			}
 		}
 	}
 
+	return newDependencies(buildMap, baseMap, onBuildMap), nil
+}
+
+// checkLineLengths rejects content with a line longer than
+// MaxDockerfileLineLength.
+func checkLineLengths(content []byte) error {
+	for lineNumber := 1; len(content) > 0; lineNumber++ {
+		end := bytes.IndexByte(content, '\n')
+		if end < 0 {
+			end = len(content)
+		}
+		if end > MaxDockerfileLineLength {
+			return fmt.Errorf("line %d is %d bytes long, more than the %d allowed", lineNumber, end, MaxDockerfileLineLength)
+		}
+		content = content[min(end+1, len(content)):]
+	}
+	return nil
+}
+
+// addDependency records dep at line unless an earlier line already
+// referenced it.
+func addDependency(depsMap map[string]int, dep string, line int) {
+	if _, exists := depsMap[dep]; !exists {
+		depsMap[dep] = line
+	}
+}
+
+func newDependencies(buildMap, baseMap, onBuildMap map[string]int) *dependencies {
+	lines := make(map[string]int)
+	for _, depsMap := range []map[string]int{buildMap, onBuildMap} {
+		for dep, line := range depsMap {
+			if existing, exists := lines[dep]; !exists || line < existing {
+				lines[dep] = line
+			}
+		}
+	}
+	return &dependencies{
+		Build:   sortedDependencies(buildMap),
+		Bases:   sortedDependencies(baseMap),
+		OnBuild: sortedDependencies(onBuildMap),
+		Lines:   lines,
+	}
+}
+
+func sortedDependencies(depsMap map[string]int) []string {
 	deps := make([]string, 0, len(depsMap))
 	for dep := range depsMap {
 		deps = append(deps, dep)
 	}
 	sort.Strings(deps)
-
-	return deps, nil
+	return deps
 }
 
+// topologicalSort orders jobs so that each comes after the jobs it needs.
+// Jobs are visited in job ID order and needs in the order given, so the
+// result depends only on the jobs and not on the order they are passed in.
 func topologicalSort(jobs []Job) ([]Job, error) {
 	var sorted []Job
 	visited := make(map[string]bool)
@@ -222,9 +625,14 @@ func topologicalSort(jobs []Job) ([]Job, error) {
 		return nil
 	}
 
-	// Visit all jobs
+	// Visit all jobs, tie-breaking by ID
+	ids := make([]string, 0, len(jobs))
 	for _, job := range jobs {
-		if err := visit(job.ID); err != nil {
+		ids = append(ids, job.ID)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := visit(id); err != nil {
 			return nil, err
 		}
 	}
@@ -232,6 +640,31 @@ func topologicalSort(jobs []Job) ([]Job, error) {
 	return sorted, nil
 }
 
+// jobIDs records what each job ID of a workflow was generated for. IDs only
+// keep letters, digits, dashes and underscores, so different versions such
+// as my.app:v1 and my-app:v1 can map to the same one, and GitHub rejects a
+// workflow with duplicate job keys.
+type jobIDs map[string]string
+
+// newJobIDs returns jobIDs holding the jobs every workflow has besides the
+// builds.
+func newJobIDs() jobIDs {
+	return jobIDs{
+		"wait-for-ci": "the wait-for-ci job",
+		"changes":     "the changes job",
+		"notify":      "the notify job",
+	}
+}
+
+// add records id for ref, failing if it is already taken.
+func (ids jobIDs) add(id, ref string) error {
+	if other, exists := ids[id]; exists {
+		return fmt.Errorf("%s and %s both have the workflow job ID %s; rename one of them", other, ref, id)
+	}
+	ids[id] = ref
+	return nil
+}
+
 func generateJobID(imageName, version string) string {
 	id := fmt.Sprintf("%s-%s", imageName, version)
 	id = regexp.MustCompile(`[^a-zA-Z0-9_-]`).ReplaceAllString(id, "-")
@@ -245,29 +678,80 @@ func generateJobID(imageName, version string) string {
 	return id
 }
 
-func writeWorkflow(jobs []Job, outputPath string) error {
-	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
-		return fmt.Errorf("creating output directory: %w", err)
+// writeWorkflow renders data and writes it to outputPath only once rendering
+// succeeded, so a failure leaves the previous workflow in place.
+func writeWorkflow(data Workflow, outputPath string) error {
+	var buf bytes.Buffer
+	if err := writeWorkflowToWriter(data, &buf); err != nil {
+		return err
 	}
+	return WriteFile(outputPath, buf.Bytes())
+}
 
-	file, err := os.Create(outputPath)
+// WriteFile writes a rendered workflow to path, creating its directory. The
+// content goes to a temporary file next to path that is renamed into place,
+// so a failed write never leaves path half-written.
+func WriteFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
 	if err != nil {
-		return fmt.Errorf("creating output file: %w", err)
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return err
 	}
-	defer func() {
-		_ = file.Close()
-	}()
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
 
-	return writeWorkflowToWriter(jobs, file)
+// templateFuncs are the helpers of the workflow templates. Every string that
+// comes from the manifest goes through quote, so that colons, leading dashes,
+// "#" and the like cannot change the YAML's structure, or through shellquote
+// inside a script.
+var templateFuncs = template.FuncMap{
+	"quote":      yamlQuote,
+	"shellquote": shellQuote,
+	// pushCondition is the expression true for the runs that push.
+	"pushCondition": func() string { return pushCondition },
 }
 
-func writeWorkflowToWriter(jobs []Job, w io.Writer) error {
-	tmpl, err := template.New("workflow").Parse(workflowTemplate)
+// yamlQuote returns s as a double-quoted YAML scalar. Go's escapes are a
+// subset of YAML's, so the scalar parses back to s.
+func yamlQuote(s string) string {
+	return strconv.Quote(s)
+}
+
+// shellQuote returns s as a single-quoted POSIX shell word, for manifest
+// strings that end up in scripts rather than in YAML values.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func writeWorkflowToWriter(data Workflow, w io.Writer) error {
+	return writeFormatToWriter(formats[FormatGitHub], data, w)
+}
+
+// writeFormatToWriter renders data with format's template.
+func writeFormatToWriter(format Format, data Workflow, w io.Writer) error {
+	name := format.templateName
+	if name == "" {
+		name = "workflow"
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(format.template)
 	if err != nil {
 		return fmt.Errorf("parsing template: %w", err)
 	}
 
-	data := Workflow{Jobs: jobs}
 	if err := tmpl.Execute(w, data); err != nil {
 		return fmt.Errorf("executing template: %w", err)
 	}
@@ -2,18 +2,25 @@ package workflow
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"gopkg.in/yaml.v3"
+
 	"github.com/mberwanger/dockerfiles/tool/internal/config"
+	"github.com/mberwanger/dockerfiles/tool/internal/template"
 )
 
 func TestGenerate(t *testing.T) {
 	tmpDir := t.TempDir()
 
 	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: filepath.Join(tmpDir, "images")},
 		Images: map[string]config.Image{
 			"app1": {
 				Path: "app1",
@@ -45,17 +52,6 @@ func TestGenerate(t *testing.T) {
 
 	outputPath := filepath.Join(tmpDir, "workflow.yaml")
 
-	// Change to tmpDir so relative paths work
-	oldWd, _ := os.Getwd()
-	defer func() {
-		if err := os.Chdir(oldWd); err != nil {
-			t.Errorf("Failed to restore working directory: %v", err)
-		}
-	}()
-	if err := os.Chdir(tmpDir); err != nil {
-		t.Fatalf("Failed to change directory: %v", err)
-	}
-
 	if err := Generate(cfg, outputPath); err != nil {
 		t.Fatalf("Generate() error = %v", err)
 	}
@@ -84,6 +80,7 @@ func TestGenerateToWriter(t *testing.T) {
 	tmpDir := t.TempDir()
 
 	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: filepath.Join(tmpDir, "images")},
 		Images: map[string]config.Image{
 			"myapp": {
 				Path: "myapp",
@@ -103,17 +100,6 @@ func TestGenerateToWriter(t *testing.T) {
 		t.Fatalf("Failed to write Dockerfile: %v", err)
 	}
 
-	// Change to tmpDir
-	oldWd, _ := os.Getwd()
-	defer func() {
-		if err := os.Chdir(oldWd); err != nil {
-			t.Errorf("Failed to restore working directory: %v", err)
-		}
-	}()
-	if err := os.Chdir(tmpDir); err != nil {
-		t.Fatalf("Failed to change directory: %v", err)
-	}
-
 	var buf bytes.Buffer
 	if err := GenerateToWriter(cfg, &buf); err != nil {
 		t.Fatalf("GenerateToWriter() error = %v", err)
@@ -128,6 +114,107 @@ func TestGenerateToWriter(t *testing.T) {
 	}
 }
 
+func TestGenerateToWriter_Deterministic(t *testing.T) {
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: filepath.Join(tmpDir, "images")},
+		Images:   map[string]config.Image{},
+	}
+	dockerfiles := map[string]string{
+		"core":  "FROM ubuntu\n",
+		"tools": "FROM ubuntu\n",
+		"app":   "FROM ${REGISTRY}/tools:v1 AS tools\nFROM ${REGISTRY}/core:v1\nCOPY --from=tools / /\n",
+		"web":   "FROM ${REGISTRY}/core:v1\nCOPY --from=${REGISTRY}/tools:v1 / /\n",
+		"zeta":  "FROM ${REGISTRY}/web:v1\nCOPY --from=${REGISTRY}/app:v1 / /\n",
+	}
+	for name, content := range dockerfiles {
+		cfg.Images[name] = config.Image{Path: name, Versions: map[string]*config.ImageConfig{"v1": {}}}
+		path := filepath.Join(tmpDir, "images", name, "v1", "Dockerfile")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write Dockerfile: %v", err)
+		}
+	}
+
+	var first bytes.Buffer
+	if err := GenerateToWriter(cfg, &first); err != nil {
+		t.Fatalf("GenerateToWriter() error = %v", err)
+	}
+	for range 10 {
+		var again bytes.Buffer
+		if err := GenerateToWriter(cfg, &again); err != nil {
+			t.Fatalf("GenerateToWriter() error = %v", err)
+		}
+		if !bytes.Equal(first.Bytes(), again.Bytes()) {
+			t.Fatalf("GenerateToWriter() output differs between runs:\n%s\n---\n%s", first.String(), again.String())
+		}
+	}
+	if !strings.Contains(first.String(), "needs: [wait-for-ci, changes, app-v1, web-v1]") {
+		t.Errorf("zeta should need app-v1 and web-v1 in ID order:\n%s", first.String())
+	}
+}
+
+func TestFormat_WithTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{BasePath: filepath.Join(tmpDir, "images")},
+		Images: map[string]config.Image{
+			"myapp": {
+				Path:     "myapp",
+				Versions: map[string]*config.ImageConfig{"v1.0": {}},
+			},
+		},
+	}
+	dockerfilePath := filepath.Join(tmpDir, "images/myapp/v1.0/Dockerfile")
+	if err := os.MkdirAll(filepath.Dir(dockerfilePath), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(dockerfilePath, []byte("FROM ubuntu\n"), 0644); err != nil {
+		t.Fatalf("Failed to write Dockerfile: %v", err)
+	}
+
+	var buf bytes.Buffer
+	tmplSrc := "{{range .Jobs}}{{.ID}}: kaniko {{quote .DockerfilePath}}\n{{end}}"
+	if err := GenerateProjectToWriterContext(context.Background(), cfg, formats[FormatGitHub].WithTemplate("", tmplSrc), &buf); err != nil {
+		t.Fatalf("GenerateProjectToWriterContext() error = %v", err)
+	}
+	if want := "myapp-v1-0: kaniko \"images/myapp/v1.0/Dockerfile\"\n"; buf.String() != want {
+		t.Errorf("GenerateProjectToWriterContext() = %q, want %q", buf.String(), want)
+	}
+
+	format := formats[FormatGitHub].WithTemplate("ci/workflow.tmpl", "{{range .Jobs}}")
+	err := writeFormatToWriter(format, Workflow{}, &buf)
+	if err == nil || !strings.Contains(err.Error(), "ci/workflow.tmpl") {
+		t.Errorf("writeFormatToWriter() error = %v, want the template file named", err)
+	}
+}
+
+func TestJobsContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cfg := &config.Config{
+		Images: map[string]config.Image{
+			"core": {Path: "core", Versions: map[string]*config.ImageConfig{"noble": {}}},
+		},
+	}
+
+	if _, err := JobsContext(ctx, cfg); !errors.Is(err, context.Canceled) {
+		t.Errorf("JobsContext() error = %v, want context.Canceled", err)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "workflow.yaml")
+	if err := GenerateContext(ctx, cfg, outputPath); !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateContext() error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Error("GenerateContext() should not write the workflow after cancellation")
+	}
+}
+
 func TestBuildJobsFromConfig(t *testing.T) {
 	cfg := &config.Config{
 		Images: map[string]config.Image{
@@ -182,6 +269,223 @@ func TestBuildJobsFromConfig(t *testing.T) {
 	}
 }
 
+func TestBuildJobsFromConfig_WorkflowDisabled(t *testing.T) {
+	disabled := false
+	cfg := &config.Config{
+		Images: map[string]config.Image{
+			"docs": {
+				Path:     "docs",
+				Workflow: &config.ImageWorkflow{Enabled: &disabled},
+				Versions: map[string]*config.ImageConfig{
+					"v1": {},
+				},
+			},
+			"app": {
+				Path: "app",
+				Versions: map[string]*config.ImageConfig{
+					"v1": {},
+					"v2": {Workflow: &config.ImageWorkflow{Enabled: &disabled}},
+				},
+			},
+		},
+	}
+
+	jobs, err := buildJobsFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("buildJobsFromConfig() error = %v", err)
+	}
+
+	if len(jobs) != 1 || jobs[0].ID != "app-v1" {
+		t.Errorf("Expected only app-v1 job, got %+v", jobs)
+	}
+
+	external := externalImages(cfg)
+	if external["docs:v1"] != "externally built" || external["app:v2"] != "externally built" || external["app:v1"] != "" {
+		t.Errorf("externalImages() = %v", external)
+	}
+}
+
+func TestBuildJobsFromConfig_Disabled(t *testing.T) {
+	disabled := false
+	cfg := &config.Config{
+		Images: map[string]config.Image{
+			"legacy": {
+				Path:     "legacy",
+				Enabled:  &disabled,
+				Versions: map[string]*config.ImageConfig{"v1": {}},
+			},
+			"app": {
+				Path: "app",
+				Versions: map[string]*config.ImageConfig{
+					"v1": {},
+					"v2": {Enabled: &disabled},
+				},
+			},
+		},
+	}
+
+	jobs, err := buildJobsFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("buildJobsFromConfig() error = %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != "app-v1" {
+		t.Errorf("Expected only app-v1 job, got %+v", jobs)
+	}
+
+	external := externalImages(cfg)
+	if external["legacy:v1"] != "disabled" || external["app:v2"] != "disabled" || external["app:v1"] != "" {
+		t.Errorf("externalImages() = %v", external)
+	}
+}
+
+func TestBuildJobsFromConfig_JobIDCollision(t *testing.T) {
+	cfg := &config.Config{
+		Images: map[string]config.Image{
+			"my.app": {Path: "my.app", Versions: map[string]*config.ImageConfig{"v1": {}}},
+			"my-app": {Path: "my-app", Versions: map[string]*config.ImageConfig{"v1": {}}},
+		},
+	}
+
+	_, err := buildJobsFromConfig(cfg)
+	if err == nil {
+		t.Fatal("buildJobsFromConfig() should reject two versions with the same job ID")
+	}
+	for _, want := range []string{"my-app:v1", "my.app:v1", "my-app-v1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("buildJobsFromConfig() error = %v, want it to name %s", err, want)
+		}
+	}
+
+	cfg.Images = map[string]config.Image{
+		"wait-for": {Path: "wait-for", Versions: map[string]*config.ImageConfig{"ci": {}}},
+	}
+	if _, err := buildJobsFromConfig(cfg); err == nil || !strings.Contains(err.Error(), "the wait-for-ci job") {
+		t.Errorf("buildJobsFromConfig() error = %v, want a clash with the wait-for-ci job", err)
+	}
+}
+
+func TestBuildJobsFromConfig_Variants(t *testing.T) {
+	cfg := &config.Config{
+		Images: map[string]config.Image{
+			"python": {
+				Path: "lang/python",
+				Versions: map[string]*config.ImageConfig{
+					"3.12": {Variants: map[string]*config.Variant{"slim": {}}},
+				},
+			},
+		},
+	}
+
+	jobs, err := buildJobsFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("buildJobsFromConfig() error = %v", err)
+	}
+
+	if len(jobs) != 2 {
+		t.Fatalf("Expected a job for the version and one for the variant, got %+v", jobs)
+	}
+	variant := jobs[1]
+	if variant.ID != "python-3-12-slim" || variant.Version != "3.12-slim" {
+		t.Errorf("variant job = %+v", variant)
+	}
+	if variant.DockerfilePath != filepath.Join("images", "lang/python", "3.12-slim", "Dockerfile") {
+		t.Errorf("variant DockerfilePath = %s", variant.DockerfilePath)
+	}
+}
+
+func TestImagesRoot(t *testing.T) {
+	tmpDir := t.TempDir()
+	nested := filepath.Join(tmpDir, "repo", "ci", "images")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	// Without a repository the manifest directory's parent is the root.
+	if got := imagesRoot(&config.Config{Defaults: config.Defaults{BasePath: nested}}); got != "images" {
+		t.Errorf("imagesRoot() = %q outside a repository, want images", got)
+	}
+
+	if err := os.Mkdir(filepath.Join(tmpDir, "repo", ".git"), 0755); err != nil {
+		t.Fatalf("Failed to create .git: %v", err)
+	}
+	if got := imagesRoot(&config.Config{Defaults: config.Defaults{BasePath: nested}}); got != "ci/images" {
+		t.Errorf("imagesRoot() = %q, want ci/images", got)
+	}
+	if got := imagesRoot(&config.Config{}); got != "images" {
+		t.Errorf("imagesRoot() = %q without a base path, want images", got)
+	}
+}
+
+func TestOrderJobsByDependencies_ExternalDependency(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	appPath := filepath.Join(tmpDir, "images/app/v1/Dockerfile")
+	if err := os.MkdirAll(filepath.Dir(appPath), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(appPath, []byte("FROM ${REGISTRY}/docs:v1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write Dockerfile: %v", err)
+	}
+
+	jobs := []Job{
+		{ID: "app-v1", Name: "Build app:v1", ImageName: "app", Version: "v1", DockerfilePath: appPath},
+	}
+
+	ordered, err := orderJobsByDependencies(jobs, parseDockerfileDependencies, map[string]string{"docs:v1": "externally built"})
+	if err != nil {
+		t.Fatalf("orderJobsByDependencies() error = %v", err)
+	}
+
+	if len(ordered[0].Needs) != 0 {
+		t.Errorf("Externally built dependency should be pruned from needs, got %v", ordered[0].Needs)
+	}
+}
+
+func TestOrderJobsByDependencies_OnBuild(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	dockerfiles := map[string]string{
+		"builder": "FROM alpine\n",
+		"base":    "FROM alpine\nONBUILD COPY --from=${REGISTRY}/builder:v1 /app /app\n",
+		"app":     "ARG REGISTRY=test.io\nFROM ${REGISTRY}/base:v1\n",
+	}
+	var jobs []Job
+	for _, name := range []string{"app", "base", "builder"} {
+		path := filepath.Join(tmpDir, "images", name, "v1", "Dockerfile")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(dockerfiles[name]), 0644); err != nil {
+			t.Fatalf("Failed to write Dockerfile: %v", err)
+		}
+		jobs = append(jobs, Job{ID: name + "-v1", Name: "Build " + name + ":v1", ImageName: name, Version: "v1", DockerfilePath: path})
+	}
+
+	for parserName, parse := range map[string]dependencyParser{
+		ParserRegex:    parseDockerfileDependencies,
+		ParserBuildkit: parseDockerfileDependenciesBuildkit,
+	} {
+		t.Run(parserName, func(t *testing.T) {
+			ordered, err := orderJobsByDependencies(append([]Job(nil), jobs...), parse, nil)
+			if err != nil {
+				t.Fatalf("orderJobsByDependencies() error = %v", err)
+			}
+
+			needs := make(map[string]string)
+			for _, job := range ordered {
+				needs[job.ID] = strings.Join(job.Needs, ",")
+			}
+			// The trigger runs when app builds FROM base, not when base builds.
+			if needs["base-v1"] != "" {
+				t.Errorf("base-v1 needs = %q, want none", needs["base-v1"])
+			}
+			if needs["app-v1"] != "base-v1,builder-v1" {
+				t.Errorf("app-v1 needs = %q, want base-v1,builder-v1", needs["app-v1"])
+			}
+		})
+	}
+}
+
 func TestOrderJobsByDependencies(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -222,7 +526,7 @@ func TestOrderJobsByDependencies(t *testing.T) {
 		},
 	}
 
-	ordered, err := orderJobsByDependencies(jobs)
+	ordered, err := orderJobsByDependencies(jobs, parseDockerfileDependencies, nil)
 	if err != nil {
 		t.Fatalf("orderJobsByDependencies() error = %v", err)
 	}
@@ -280,10 +584,11 @@ func TestParseDockerfileDependencies(t *testing.T) {
 	tmpDir := t.TempDir()
 
 	tests := []struct {
-		name       string
-		dockerfile string
-		wantDeps   []string
-		wantErr    bool
+		name        string
+		dockerfile  string
+		wantDeps    []string
+		wantOnBuild []string
+		wantErr     bool
 	}{
 		{
 			name:       "no dependencies",
@@ -297,6 +602,43 @@ func TestParseDockerfileDependencies(t *testing.T) {
 			wantDeps:   []string{"base:v1"},
 			wantErr:    false,
 		},
+		{
+			name:       "digest pinned dependency",
+			dockerfile: "ARG REGISTRY=test.io\nFROM ${REGISTRY}/base:v1@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef\nCOPY --from=${REGISTRY}/builder:v2@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef /app /app\n",
+			wantDeps:   []string{"base:v1", "builder:v2"},
+			wantErr:    false,
+		},
+		{
+			name:       "platform flag",
+			dockerfile: "ARG REGISTRY=test.io\nFROM --platform=$BUILDPLATFORM ${REGISTRY}/base:v1 AS build\nCOPY --from=build /app /app\n",
+			wantDeps:   []string{"base:v1"},
+			wantErr:    false,
+		},
+		{
+			name:       "stage name is not part of the version",
+			dockerfile: "ARG REGISTRY=test.io\nFROM ${REGISTRY}/base:v1 AS build\nFROM ${REGISTRY}/base:v1\tas\trun\n",
+			wantDeps:   []string{"base:v1"},
+			wantErr:    false,
+		},
+		{
+			name:       "several flags",
+			dockerfile: "ARG REGISTRY=test.io\nFROM --platform=$TARGETPLATFORM --network=none ${REGISTRY}/base:v1 AS build\n",
+			wantDeps:   []string{"base:v1"},
+			wantErr:    false,
+		},
+		{
+			name:        "lowercase instructions",
+			dockerfile:  "arg REGISTRY=test.io\nfrom --platform=$BUILDPLATFORM ${REGISTRY}/builder:v2 as build\nfrom ${REGISTRY}/base:v1\ncopy --from=build /app /app\ncopy --from=${REGISTRY}/tools:v3 /bin /bin\nonbuild copy --from=${REGISTRY}/lib:v4 /lib /lib\n",
+			wantDeps:    []string{"base:v1", "builder:v2", "tools:v3"},
+			wantOnBuild: []string{"lib:v4"},
+			wantErr:     false,
+		},
+		{
+			name:       "mixed case instructions",
+			dockerfile: "ARG REGISTRY=test.io\nFrom ${REGISTRY}/base:v1 As Build\nCopy --from=Build /app /app\n",
+			wantDeps:   []string{"base:v1"},
+			wantErr:    false,
+		},
 		{
 			name: "multiple dependencies",
 			dockerfile: `ARG REGISTRY=test.io
@@ -325,6 +667,125 @@ COPY --from=${REGISTRY}/base:v1 /lib /lib
 			wantDeps: []string{"base:v1"},
 			wantErr:  false,
 		},
+		{
+			name: "scratch and external bases are not dependencies",
+			dockerfile: `ARG REGISTRY=test.io
+FROM ${REGISTRY}/builder:v1 AS build
+FROM mcr.microsoft.com/windows/nanoserver:ltsc2022 AS windows
+FROM scratch
+COPY --from=build /app /app
+`,
+			wantDeps: []string{"builder:v1"},
+			wantErr:  false,
+		},
+		{
+			name: "onbuild references belong to downstream builds",
+			dockerfile: `ARG REGISTRY=test.io
+FROM ${REGISTRY}/base:v1
+ONBUILD COPY --from=${REGISTRY}/builder:v2 /app /app
+`,
+			wantDeps:    []string{"base:v1"},
+			wantOnBuild: []string{"builder:v2"},
+			wantErr:     false,
+		},
+	}
+
+	parsers := map[string]dependencyParser{
+		ParserRegex:    parseDockerfileDependencies,
+		ParserBuildkit: parseDockerfileDependenciesBuildkit,
+	}
+
+	for parserName, parse := range parsers {
+		for _, tt := range tests {
+			t.Run(parserName+"/"+tt.name, func(t *testing.T) {
+				dockerfilePath := filepath.Join(tmpDir, parserName, tt.name, "Dockerfile")
+				if err := os.MkdirAll(filepath.Dir(dockerfilePath), 0755); err != nil {
+					t.Fatalf("Failed to create directory: %v", err)
+				}
+				if err := os.WriteFile(dockerfilePath, []byte(tt.dockerfile), 0644); err != nil {
+					t.Fatalf("Failed to write Dockerfile: %v", err)
+				}
+
+				deps, err := parse(dockerfilePath)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("parse() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+
+				if err != nil {
+					return
+				}
+
+				if len(deps.Build) != len(tt.wantDeps) {
+					t.Errorf("Got %d dependencies, want %d", len(deps.Build), len(tt.wantDeps))
+					t.Errorf("Got: %v, want: %v", deps.Build, tt.wantDeps)
+					return
+				}
+
+				for i, dep := range deps.Build {
+					if dep != tt.wantDeps[i] {
+						t.Errorf("Dependency %d = %s, want %s", i, dep, tt.wantDeps[i])
+					}
+				}
+
+				if strings.Join(deps.OnBuild, ",") != strings.Join(tt.wantOnBuild, ",") {
+					t.Errorf("OnBuild dependencies = %v, want %v", deps.OnBuild, tt.wantOnBuild)
+				}
+			})
+		}
+	}
+}
+
+func TestParseDockerfileDependenciesBuildkit(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	tests := []struct {
+		name       string
+		dockerfile string
+		wantDeps   []string
+	}{
+		{
+			name: "heredoc body is not scanned",
+			dockerfile: `FROM ${REGISTRY}/base:v1
+RUN <<EOF
+FROM ${REGISTRY}/ignored:v1
+EOF
+`,
+			wantDeps: []string{"base:v1"},
+		},
+		{
+			name: "onbuild instructions are not build dependencies",
+			dockerfile: `FROM ${REGISTRY}/base:v1
+ONBUILD COPY --from=${REGISTRY}/builder:v2 /app /app
+`,
+			wantDeps: []string{"base:v1"},
+		},
+		{
+			name: "digest pinned references",
+			dockerfile: `FROM ${REGISTRY}/base:v1@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef AS build
+COPY --from=${REGISTRY}/builder:v2@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef /app /app
+`,
+			wantDeps: []string{"base:v1", "builder:v2"},
+		},
+		{
+			name: "platform flag",
+			dockerfile: `FROM --platform=$BUILDPLATFORM ${REGISTRY}/base:v1 AS build
+COPY --from=build /app /app
+`,
+			wantDeps: []string{"base:v1"},
+		},
+		{
+			name: "comments are ignored",
+			dockerfile: `# FROM ${REGISTRY}/commented:v1
+FROM ${REGISTRY}/base:v1
+`,
+			wantDeps: []string{"base:v1"},
+		},
+		{
+			name:       "comments only",
+			dockerfile: "# nothing to see here\n",
+			wantDeps:   []string{},
+		},
 	}
 
 	for _, tt := range tests {
@@ -337,27 +798,78 @@ COPY --from=${REGISTRY}/base:v1 /lib /lib
 				t.Fatalf("Failed to write Dockerfile: %v", err)
 			}
 
-			deps, err := parseDockerfileDependencies(dockerfilePath)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("parseDockerfileDependencies() error = %v, wantErr %v", err, tt.wantErr)
-				return
+			deps, err := parseDockerfileDependenciesBuildkit(dockerfilePath)
+			if err != nil {
+				t.Fatalf("parseDockerfileDependenciesBuildkit() error = %v", err)
 			}
 
-			if len(deps) != len(tt.wantDeps) {
-				t.Errorf("Got %d dependencies, want %d", len(deps), len(tt.wantDeps))
-				t.Errorf("Got: %v, want: %v", deps, tt.wantDeps)
-				return
+			if strings.Join(deps.Build, ",") != strings.Join(tt.wantDeps, ",") {
+				t.Errorf("Got: %v, want: %v", deps.Build, tt.wantDeps)
 			}
+		})
+	}
+}
 
-			for i, dep := range deps {
-				if dep != tt.wantDeps[i] {
-					t.Errorf("Dependency %d = %s, want %s", i, dep, tt.wantDeps[i])
-				}
+func TestDependencyParserFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		parser  string
+		wantErr bool
+	}{
+		{name: "default", parser: "", wantErr: false},
+		{name: "regex", parser: ParserRegex, wantErr: false},
+		{name: "buildkit", parser: ParserBuildkit, wantErr: false},
+		{name: "unknown", parser: "antlr", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Defaults: config.Defaults{
+					Workflow: &config.Workflow{Parser: tt.parser},
+				},
+			}
+
+			parse, err := dependencyParserFor(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dependencyParserFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && parse == nil {
+				t.Error("dependencyParserFor() returned nil parser")
 			}
 		})
 	}
 }
 
+func TestParseDockerfileContent_TemplateHelpers(t *testing.T) {
+	templatePath := filepath.Join(t.TempDir(), "Dockerfile.tmpl")
+	content := "{{from_image \"base:v1\" \"platform=$BUILDPLATFORM\"}}\n{{copy_from \"builder_image\" \"/out\" \"/usr/local/bin/\"}}\n"
+	if err := os.WriteFile(templatePath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+	data := template.NewData(&config.ImageConfig{Values: map[string]interface{}{
+		"registry":      "test.io",
+		"builder_image": &config.BaseImage{Name: "builder:v2"},
+	}}, "app")
+	rendered, err := template.Render(templatePath, data)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	for name, parse := range map[string]func([]byte) (*dependencies, error){
+		ParserRegex:    parseDockerfileContent,
+		ParserBuildkit: parseDockerfileContentBuildkit,
+	} {
+		deps, err := parse([]byte(rendered))
+		if err != nil {
+			t.Fatalf("%s: parse error = %v", name, err)
+		}
+		if got := strings.Join(deps.Build, ","); got != "base:v1,builder:v2" {
+			t.Errorf("%s: dependencies of\n%s\n= %s, want base:v1,builder:v2", name, rendered, got)
+		}
+	}
+}
+
 func TestParseDockerfileDependencies_FileNotFound(t *testing.T) {
 	_, err := parseDockerfileDependencies("/nonexistent/Dockerfile")
 	if err == nil {
@@ -447,6 +959,33 @@ func TestTopologicalSort(t *testing.T) {
 	}
 }
 
+func TestTopologicalSort_Stable(t *testing.T) {
+	jobs := []Job{
+		{ID: "d", Needs: []string{"b", "c"}},
+		{ID: "c", Needs: []string{"a"}},
+		{ID: "e"},
+		{ID: "b", Needs: []string{"a"}},
+		{ID: "a"},
+	}
+	want := "a b c d e"
+
+	for range 10 {
+		shuffled := append([]Job(nil), jobs...)
+		rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		sorted, err := topologicalSort(shuffled)
+		if err != nil {
+			t.Fatalf("topologicalSort() error = %v", err)
+		}
+		var ids []string
+		for _, job := range sorted {
+			ids = append(ids, job.ID)
+		}
+		if got := strings.Join(ids, " "); got != want {
+			t.Fatalf("topologicalSort() = %s, want %s", got, want)
+		}
+	}
+}
+
 func TestGenerateJobID(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -517,7 +1056,7 @@ func TestWriteWorkflow(t *testing.T) {
 		},
 	}
 
-	if err := writeWorkflow(jobs, outputPath); err != nil {
+	if err := writeWorkflow(Workflow{Jobs: jobs}, outputPath); err != nil {
 		t.Fatalf("writeWorkflow() error = %v", err)
 	}
 
@@ -541,6 +1080,31 @@ func TestWriteWorkflow(t *testing.T) {
 	}
 }
 
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "workflow.yaml")
+	if err := os.WriteFile(outputPath, []byte("old\n"), 0600); err != nil {
+		t.Fatalf("Failed to write workflow: %v", err)
+	}
+
+	if err := WriteFile(outputPath, []byte("new\n")); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil || string(content) != "new\n" {
+		t.Errorf("workflow = %q, %v; want it replaced", content, err)
+	}
+	if info, err := os.Stat(outputPath); err != nil {
+		t.Errorf("Stat() error = %v", err)
+	} else if info.Mode().Perm() != 0644 {
+		t.Errorf("workflow mode = %v, want 0644", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("directory holds %v, %v; want only the workflow, no temporary files", entries, err)
+	}
+}
+
 func TestWriteWorkflowToWriter(t *testing.T) {
 	jobs := []Job{
 		{
@@ -562,7 +1126,7 @@ func TestWriteWorkflowToWriter(t *testing.T) {
 	}
 
 	var buf bytes.Buffer
-	if err := writeWorkflowToWriter(jobs, &buf); err != nil {
+	if err := writeWorkflowToWriter(Workflow{Jobs: jobs}, &buf); err != nil {
 		t.Fatalf("writeWorkflowToWriter() error = %v", err)
 	}
 
@@ -578,6 +1142,90 @@ func TestWriteWorkflowToWriter(t *testing.T) {
 	}
 }
 
+func TestWriteWorkflowToWriter_HostileStrings(t *testing.T) {
+	hostile := []string{
+		"8.0:alpine",
+		"- leading dash",
+		"tag #1",
+		"ünïcode ✓ 日本",
+		`"quoted" \ back`,
+		"key: value",
+		"{flow, [seq]}",
+		"*alias &anchor !tag",
+	}
+
+	for _, value := range hostile {
+		t.Run(value, func(t *testing.T) {
+			job := Job{
+				ID:             generateJobID("app", value),
+				Name:           "Build app:" + value,
+				ImageName:      value,
+				Version:        value,
+				DockerfilePath: "images/" + value + "/Dockerfile",
+				Context:        "images/" + value,
+				Prepare:        []string{"true"},
+				LoginSteps:     []Step{{Name: value, Uses: "docker/login-action@v3", With: []Input{{Key: "registry", Value: value}}}},
+				Environment:    &Environment{Name: value, URL: "https://example.com/" + value},
+			}
+
+			var buf bytes.Buffer
+			if err := writeWorkflowToWriter(Workflow{Project: value, Jobs: []Job{job}}, &buf); err != nil {
+				t.Fatalf("writeWorkflowToWriter() error = %v", err)
+			}
+
+			var parsed struct {
+				Name string `yaml:"name"`
+				Jobs map[string]struct {
+					Name        string            `yaml:"name"`
+					Environment map[string]string `yaml:"environment"`
+					Steps       []struct {
+						Name             string            `yaml:"name"`
+						WorkingDirectory string            `yaml:"working-directory"`
+						Env              map[string]string `yaml:"env"`
+						With             map[string]string `yaml:"with"`
+					} `yaml:"steps"`
+				} `yaml:"jobs"`
+			}
+			if err := yaml.Unmarshal(buf.Bytes(), &parsed); err != nil {
+				t.Fatalf("workflow is not valid YAML: %v\n%s", err, buf.String())
+			}
+
+			got, exists := parsed.Jobs[job.ID]
+			if !exists {
+				t.Fatalf("job %s missing from workflow:\n%s", job.ID, buf.String())
+			}
+			steps := got.Steps
+			if len(steps) != 4 {
+				t.Fatalf("got %d steps, want checkout, prepare, login and build", len(steps))
+			}
+			prepare, login, build := steps[1], steps[2], steps[3]
+			checks := map[string][2]string{
+				"workflow name":     {parsed.Name, "Build Docker Images (" + value + ")"},
+				"job name":          {got.Name, job.Name},
+				"environment url":   {got.Environment["url"], job.Environment.URL},
+				"prepare name":      {prepare.Name, "Prepare " + value + ":" + value},
+				"working-directory": {prepare.WorkingDirectory, job.Context},
+				"IMAGE":             {prepare.Env["IMAGE"], value},
+				"VERSION":           {prepare.Env["VERSION"], value},
+				"login name":        {login.Name, value},
+				"login registry":    {login.With["registry"], value},
+				"build name":        {build.Name, "Build " + value + ":" + value},
+				"dockerfile_path":   {build.With["dockerfile_path"], job.DockerfilePath},
+				"image_name":        {build.With["image_name"], value},
+				"image_tag":         {build.With["image_tag"], value},
+			}
+			for field, check := range checks {
+				if check[0] != check[1] {
+					t.Errorf("%s = %q, want %q", field, check[0], check[1])
+				}
+			}
+			if !strings.Contains(got.Environment["name"], "'"+value+"'") {
+				t.Errorf("environment name = %q, want it to contain %q", got.Environment["name"], value)
+			}
+		})
+	}
+}
+
 func TestBuildJobsFromConfig_EmptyConfig(t *testing.T) {
 	cfg := &config.Config{
 		Images: map[string]config.Image{},
@@ -613,7 +1261,7 @@ func TestOrderJobsByDependencies_NoDependencies(t *testing.T) {
 		{ID: "job3", DockerfilePath: filepath.Join(tmpDir, "Dockerfile/3")},
 	}
 
-	ordered, err := orderJobsByDependencies(jobs)
+	ordered, err := orderJobsByDependencies(jobs, parseDockerfileDependencies, nil)
 	if err != nil {
 		t.Fatalf("orderJobsByDependencies() error = %v", err)
 	}
@@ -629,3 +1277,36 @@ func TestOrderJobsByDependencies_NoDependencies(t *testing.T) {
 		}
 	}
 }
+
+func TestParseDockerfileContent_LongLine(t *testing.T) {
+	content := []byte("FROM alpine\nRUN echo " + strings.Repeat("x", MaxDockerfileLineLength) + "\n")
+	for name, parse := range map[string]func([]byte) (*dependencies, error){
+		ParserRegex:    parseDockerfileContent,
+		ParserBuildkit: parseDockerfileContentBuildkit,
+	} {
+		if _, err := parse(content); err == nil || !strings.Contains(err.Error(), "line 2 is") {
+			t.Errorf("%s parser error = %v, want line 2 rejected", name, err)
+		}
+	}
+}
+
+func FuzzParseDockerfileContent(f *testing.F) {
+	f.Add([]byte("ARG REGISTRY=test.io\nFROM ${REGISTRY}/core:noble AS build\nCOPY --from=build /a /b\nCOPY --from=${REGISTRY}/tini:v1 /tini /tini\n"))
+	f.Add([]byte("FROM ${REGISTRY}/base:v1\nONBUILD COPY --from=${REGISTRY}/builder:v1 /app /app\n"))
+	f.Add([]byte("FROM alpine\nRUN <<EOF\nFROM ${REGISTRY}/fake:v1\nEOF\n"))
+	f.Add([]byte("FROM \\\n  ${REGISTRY}/core:v1\n# comment\n\x00\xff"))
+
+	f.Fuzz(func(t *testing.T, content []byte) {
+		for _, parse := range []func([]byte) (*dependencies, error){parseDockerfileContent, parseDockerfileContentBuildkit} {
+			deps, err := parse(content)
+			if err != nil {
+				continue
+			}
+			for _, dep := range deps.Build {
+				if _, ok := deps.Lines[dep]; !ok {
+					t.Errorf("dependency %s has no line", dep)
+				}
+			}
+		}
+	})
+}